@@ -24,10 +24,52 @@ interface DocumentUploadProps {
 
 const UPLOAD_ENDPOINT = import.meta.env.VITE_UPLOAD_ENDPOINT || '/api/upload';
 const UPLOAD_TIMEOUT = 120000; // 2 minutes timeout
+const STATUS_POLL_INTERVAL = 2000; // 2 seconds between status checks
+const PROCESSING_TIMEOUT = 600000; // 10 minutes for OCR and analysis
 // const MAX_RETRIES = 3;
 // const BASE_RETRY_DELAY = 1000; // 1 second
 const MAX_CONCURRENT_UPLOADS = 3; // Limit concurrent uploads
 
+// Processing state reported by GET /api/documents/:id/status
+interface DocumentStatus {
+  id: string;
+  status: 'queued' | 'uploaded' | 'ocr_done' | 'analyzed' | 'failed';
+  error?: string;
+  risk_score?: number;
+}
+
+// Human readable labels for the processing stages
+const STATUS_LABELS: Record<DocumentStatus['status'], string> = {
+  queued: 'Queued for processing...',
+  uploaded: 'Extracting text...',
+  ocr_done: 'Checking compliance...',
+  analyzed: 'Analysis complete',
+  failed: 'Processing failed',
+};
+
+// Polls the status URL until the document is analyzed or has failed
+const waitForProcessing = async (
+  statusURL: string,
+  onStatus: (status: DocumentStatus) => void
+): Promise<DocumentStatus> => {
+  const deadline = Date.now() + PROCESSING_TIMEOUT;
+  while (Date.now() < deadline) {
+    const response = await fetch(statusURL);
+    if (!response.ok) {
+      const errorText = await response.text();
+      throw new Error(`Failed to check processing status: ${errorText || response.statusText}`);
+    }
+
+    const status: DocumentStatus = await response.json();
+    onStatus(status);
+    if (status.status === 'analyzed' || status.status === 'failed') {
+      return status;
+    }
+    await new Promise(resolve => setTimeout(resolve, STATUS_POLL_INTERVAL));
+  }
+  throw new Error('Processing is taking longer than expected. Check the dashboard later.');
+};
+
 // Singleton upload queue manager
 class UploadQueueManager {
   private static instance: UploadQueueManager;
@@ -85,6 +127,7 @@ const DocumentUpload: React.FC<DocumentUploadProps> = ({
   const [selectedFile, setSelectedFile] = useState<File | null>(null);
   const [uploadResponse, setUploadResponse] = useState<UploadResponse>({});
   const [isLoading, setIsLoading] = useState(false);
+  const [processingStatus, setProcessingStatus] = useState<DocumentStatus['status'] | null>(null);
   const fileInputRef = useRef<HTMLInputElement>(null);
   const uploadQueueManager = UploadQueueManager.getInstance();
 
@@ -150,6 +193,7 @@ const DocumentUpload: React.FC<DocumentUploadProps> = ({
     formData.append('file', selectedFile);
 
     setIsLoading(true);
+    setProcessingStatus(null);
     setUploadResponse({});
 
     try {
@@ -201,28 +245,37 @@ const DocumentUpload: React.FC<DocumentUploadProps> = ({
           }
 
           const result = await response.json();
-          console.log('Full upload response:', result);
-          console.log('Response keys:', Object.keys(result));
-          
+          console.log('Upload accepted:', result);
+
           // Comprehensive error checking
-          if (!result.fileURL) {
-            console.error('Missing fileURL in upload response', result);
+          if (!result.fileID || !result.statusURL) {
+            console.error('Missing fileID or statusURL in upload response', result);
             setUploadResponse({ 
-              error: 'Upload failed: No file URL received' 
+              error: 'Upload failed: No document ID received' 
             });
             return;
           }
 
+          // The server processes the document in the background; wait for it to finish
+          const finalStatus = await waitForProcessing(result.statusURL, status => setProcessingStatus(status.status));
+          if (finalStatus.status === 'failed') {
+            throw new Error(`Processing failed: ${finalStatus.error || 'unknown error'}`);
+          }
+
+          const documentResponse = await fetch(`/api/documents/${result.fileID}`);
+          if (!documentResponse.ok) {
+            const errorText = await documentResponse.text();
+            throw new Error(`Failed to fetch the analyzed document: ${errorText || documentResponse.statusText}`);
+          }
+          const analyzedDocument = await documentResponse.json();
+          console.log('Analyzed document:', analyzedDocument);
+
           const uploadResult: UploadResponse = {
             fileUrl: result.fileURL,
             fileId: result.fileID,
-            ocrText: result.ocrText || 'No text extracted',
-            complianceResults: result.applicable_rules || [], // Use applicable_rules from the new backend response
-
-            // complianceResults: typeof result.complianceResults === 'string' 
-            //   ? result.complianceResults 
-            //   : JSON.stringify(result.complianceResults, null, 2),
-            riskScore: result.riskScore,
+            ocrText: analyzedDocument.ocr_text || 'No text extracted',
+            complianceResults: JSON.stringify(analyzedDocument.parsed_data || [], null, 2),
+            riskScore: analyzedDocument.risk_score,
             fileName: selectedFile.name,
             fileType: selectedFile.type,
             fileSize: selectedFile.size
@@ -262,6 +315,7 @@ const DocumentUpload: React.FC<DocumentUploadProps> = ({
     } finally {
       console.log('Upload process completed');
       setIsLoading(false);
+      setProcessingStatus(null);
     }
   }, [selectedFile, onUpload]);
 
@@ -357,7 +411,7 @@ const DocumentUpload: React.FC<DocumentUploadProps> = ({
             {isLoading ? (
               <>
                 <div className="animate-spin h-5 w-5 border-2 border-white border-t-transparent rounded-full mr-2"></div>
-                <span>Processing Document...</span>
+                <span>{processingStatus ? STATUS_LABELS[processingStatus] : 'Uploading Document...'}</span>
               </>
             ) : (
               <>
@@ -0,0 +1,76 @@
+// Command reconcile cross-checks the documents table, blob store and search
+// index and reports orphans and mismatches. With --fix it deletes orphans and
+// reindexes missing entries. It exits with status 1 when issues remain.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Itish41/LegalEagle/config"
+	"github.com/Itish41/LegalEagle/initializers"
+	service "github.com/Itish41/LegalEagle/service"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "delete orphans and reindex missing entries")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	if err := initializers.LoadEnv(); err != nil {
+		log.Fatalf("[CRITICAL] Failed to load env: %s", err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("[CRITICAL] %s", err)
+	}
+	if err := initializers.ConnectDB(cfg.Database); err != nil {
+		log.Fatalf("[CRITICAL] Failed to initialize database connection: %s", err)
+	}
+
+	// Keep SQL logs off stdout so the report can be piped
+	db := initializers.DB.Session(&gorm.Session{
+		Logger: logger.New(log.New(os.Stderr, "\r\n", log.LstdFlags), logger.Config{LogLevel: logger.Warn}),
+	})
+
+	docService, err := service.NewDocumentService(db, cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize document service: %s", err)
+	}
+
+	report, err := docService.Reconcile(context.Background(), *fix)
+	if err != nil {
+		log.Fatalf("Reconciliation failed: %s", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatalf("Failed to encode report: %s", err)
+		}
+	} else {
+		fmt.Printf("Checked %d documents, %d blobs and %d index entries\n", report.Documents, report.Blobs, report.IndexEntries)
+		for _, issue := range report.Issues {
+			status := "found"
+			if issue.Fixed {
+				status = "fixed"
+			} else if issue.FixError != "" {
+				status = "fix failed: " + issue.FixError
+			}
+			fmt.Printf("%-20s document=%s key=%s  %s (%s)\n", issue.Kind, issue.DocumentID, issue.Key, issue.Detail, status)
+		}
+		fmt.Printf("%d issues, %d unfixed\n", len(report.Issues), report.Unfixed())
+	}
+
+	if report.Unfixed() > 0 {
+		os.Exit(1)
+	}
+}
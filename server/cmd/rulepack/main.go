@@ -0,0 +1,177 @@
+// Command rulepack exports the compliance rules as a YAML or JSON rule pack,
+// imports a pack from a file or the built-in starter packs, and lists the
+// starter packs. Import exits with status 1 when the pack is rejected.
+//
+//	rulepack export [-format yaml|json] [-o rules.yaml]
+//	rulepack import [-mode merge|replace] [-dry-run] [-json] (-starter nda-basics | rules.yaml)
+//	rulepack starters
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Itish41/LegalEagle/config"
+	"github.com/Itish41/LegalEagle/initializers"
+	service "github.com/Itish41/LegalEagle/service"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const usage = `usage:
+  rulepack export [-format yaml|json] [-o file]
+  rulepack import [-mode merge|replace] [-dry-run] [-json] (-starter name | file)
+  rulepack starters`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "export":
+		export(os.Args[2:])
+	case "import":
+		importPack(os.Args[2:])
+	case "starters":
+		starters()
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// documentService connects to the database configured in the environment
+func documentService() *service.DocumentService {
+	if err := initializers.LoadEnv(); err != nil {
+		log.Fatalf("[CRITICAL] Failed to load env: %s", err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("[CRITICAL] %s", err)
+	}
+	if err := initializers.ConnectDB(cfg.Database); err != nil {
+		log.Fatalf("[CRITICAL] Failed to initialize database connection: %s", err)
+	}
+
+	// Keep SQL logs off stdout so the output can be piped
+	db := initializers.DB.Session(&gorm.Session{
+		Logger: logger.New(log.New(os.Stderr, "\r\n", log.LstdFlags), logger.Config{LogLevel: logger.Warn}),
+	})
+	docService, err := service.NewDocumentService(db, cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize document service: %s", err)
+	}
+	return docService
+}
+
+// export writes the active rules as a pack to stdout or a file
+func export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", service.RulePackFormatYAML, "pack format, yaml or json")
+	output := flags.String("o", "", "write the pack to this file instead of stdout")
+	flags.Parse(args)
+
+	pack, err := documentService().ExportRulePack()
+	if err != nil {
+		log.Fatalf("Export failed: %s", err)
+	}
+	data, err := service.EncodeRulePack(pack, *format)
+	if err != nil {
+		log.Fatalf("Export failed: %s", err)
+	}
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		log.Fatalf("Failed to write %s: %s", *output, err)
+	}
+	log.Printf("Exported %d rules to %s", len(pack.Rules), *output)
+}
+
+// importPack imports a pack file or starter pack and prints the report
+func importPack(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	mode := flags.String("mode", service.RuleImportMerge, "merge keeps existing rules on a name conflict, replace overwrites them")
+	dryRun := flags.Bool("dry-run", false, "report what would change without saving")
+	starter := flags.String("starter", "", "import a built-in starter pack instead of a file")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	var pack *service.RulePack
+	var err error
+	switch {
+	case *starter != "":
+		pack, err = service.StarterRulePack(*starter)
+	case flags.NArg() == 1:
+		var data []byte
+		data, err = os.ReadFile(flags.Arg(0))
+		if err == nil {
+			pack, err = service.ParseRulePack(data)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Failed to load rule pack: %s", err)
+	}
+
+	report, err := documentService().ImportRulePack(context.Background(), pack, service.RuleImportOptions{Mode: *mode, DryRun: *dryRun})
+	if err != nil && !errors.Is(err, service.ErrRulePackRejected) {
+		log.Fatalf("Import failed: %s", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatalf("Failed to encode report: %s", err)
+		}
+	} else {
+		for _, name := range report.Created {
+			fmt.Printf("%-10s %s\n", "created", name)
+		}
+		for _, name := range report.Unchanged {
+			fmt.Printf("%-10s %s\n", "unchanged", name)
+		}
+		for _, conflict := range report.Conflicts {
+			fmt.Printf("%-10s %s (%s)\n", "conflict", conflict.Name, conflict.Resolution)
+			for _, change := range conflict.Changes {
+				fmt.Printf("           %s: %q -> %q\n", change.Field, change.From, change.To)
+			}
+		}
+		for _, problem := range report.Invalid {
+			fmt.Printf("%-10s %s: %s\n", "invalid", problem.Name, problem.Error)
+		}
+		fmt.Printf("%d created, %d replaced, %d unchanged, %d conflicts, %d invalid", len(report.Created), len(report.Replaced), len(report.Unchanged), len(report.Conflicts), len(report.Invalid))
+		if err != nil {
+			fmt.Print(" (rejected, nothing saved)")
+		} else if report.DryRun {
+			fmt.Print(" (dry run, nothing saved)")
+		}
+		fmt.Println()
+	}
+
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// starters lists the built-in starter packs
+func starters() {
+	packs, err := service.StarterRulePacks()
+	if err != nil {
+		log.Fatalf("Failed to list starter packs: %s", err)
+	}
+	for _, pack := range packs {
+		fmt.Printf("%-24s %2d rules  %s\n", pack.Name, pack.Rules, pack.Description)
+	}
+}
@@ -0,0 +1,441 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting the server needs. It is loaded once at startup
+// and passed to the packages that need it.
+type Config struct {
+	Port       string           `yaml:"port"`
+	Database   DatabaseConfig   `yaml:"database"`
+	Storage    StorageConfig    `yaml:"storage"`
+	Search     SearchConfig     `yaml:"search"`
+	OCR        OCRConfig        `yaml:"ocr"`
+	LLM        LLMConfig        `yaml:"llm"`
+	SMTP       SMTPConfig       `yaml:"smtp"`
+	Processing ProcessingConfig `yaml:"processing"`
+	Reconcile  ReconcileConfig  `yaml:"reconcile"`
+	Policy     PolicyConfig     `yaml:"policy"`
+	WASM       WASMConfig       `yaml:"wasm"`
+	Fallback   FallbackConfig   `yaml:"fallback"`
+}
+
+// DatabaseConfig holds the Postgres connection settings
+type DatabaseConfig struct {
+	URL string `yaml:"url"`
+}
+
+// StorageConfig selects where original uploads are stored
+type StorageConfig struct {
+	Backend    string   `yaml:"backend"`
+	LocalDir   string   `yaml:"local_dir"`
+	PublicRead bool     `yaml:"public_read"`
+	S3         S3Config `yaml:"s3"`
+}
+
+// S3Config holds the S3-compatible bucket settings
+type S3Config struct {
+	Region    string `yaml:"region"`
+	Endpoint  string `yaml:"endpoint"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	Bucket    string `yaml:"bucket"`
+}
+
+// SearchConfig selects the full-text search backend
+type SearchConfig struct {
+	Backend             string `yaml:"backend"`
+	ElasticsearchURL    string `yaml:"elasticsearch_url"`
+	ElasticsearchAPIKey string `yaml:"elasticsearch_api_key"`
+	ElasticsearchIndex  string `yaml:"elasticsearch_index"`
+}
+
+// OCRConfig selects the text extraction provider
+type OCRConfig struct {
+	Provider       string `yaml:"provider"`
+	OCRSpaceAPIKey string `yaml:"ocrspace_api_key"`
+}
+
+// LLMConfig selects the LLM provider and the model used per call site
+type LLMConfig struct {
+	Provider     string            `yaml:"provider"`
+	BaseURL      string            `yaml:"base_url"`
+	APIKey       string            `yaml:"api_key"`
+	DefaultModel string            `yaml:"default_model"`
+	Models       map[string]string `yaml:"models"`
+	// ChunkMaxChars splits longer documents into parts that are judged separately.
+	ChunkMaxChars int `yaml:"chunk_max_chars"`
+	// ChunkConcurrency is how many parts of one document are judged at once.
+	ChunkConcurrency int `yaml:"chunk_concurrency"`
+}
+
+// SMTPConfig holds the mail settings used for action item notifications
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// ProcessingConfig sizes the background document processing pipeline
+type ProcessingConfig struct {
+	Workers   int `yaml:"workers"`
+	QueueSize int `yaml:"queue_size"`
+	// ShutdownTimeout is how long jobs in progress may finish on shutdown before they are cancelled.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// ReconcileConfig schedules the consistency check between the database, blob store and search index
+type ReconcileConfig struct {
+	// Interval between runs; zero disables the scheduled job.
+	Interval time.Duration `yaml:"interval"`
+	// Fix deletes orphans and reindexes missing entries instead of only reporting them.
+	Fix bool `yaml:"fix"`
+}
+
+// PolicyConfig locates the Rego policy bundles evaluated by rules of type rego
+type PolicyConfig struct {
+	// Dir holds one bundle per subdirectory or .tar.gz file; empty disables Rego rules.
+	Dir string `yaml:"dir"`
+}
+
+// WASMConfig sandboxes the uploaded modules evaluated by rules of type wasm
+type WASMConfig struct {
+	// MemoryLimitMB caps the linear memory of one module instance.
+	MemoryLimitMB int `yaml:"memory_limit_mb"`
+	// Timeout bounds one evaluation; rules may ask for less with params.timeout_ms.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// FallbackConfig tunes the local analyzer that judges llm_judged rules when the LLM cannot be used
+type FallbackConfig struct {
+	// KeywordRatio is the share of a rule's keywords that must appear unless the rule sets params.min_matches.
+	KeywordRatio float64 `yaml:"keyword_ratio"`
+	// MinPatternMatches is how often a rule's pattern must match unless the rule sets params.min_pattern_matches.
+	MinPatternMatches int `yaml:"min_pattern_matches"`
+}
+
+// Enabled reports whether notification emails can be sent
+func (s SMTPConfig) Enabled() bool {
+	return s.Username != "" || s.Password != ""
+}
+
+// Sender returns the From address, defaulting to the SMTP username
+func (s SMTPConfig) Sender() string {
+	if s.From != "" {
+		return s.From
+	}
+	return s.Username
+}
+
+// Default returns the settings used when neither the config file nor the
+// environment sets a value
+func Default() *Config {
+	return &Config{
+		Port:       "8000",
+		Storage:    StorageConfig{Backend: "s3", LocalDir: "storage"},
+		Search:     SearchConfig{ElasticsearchIndex: "documents"},
+		LLM:        LLMConfig{Provider: "groq", Models: map[string]string{}, ChunkMaxChars: 12000, ChunkConcurrency: 3},
+		SMTP:       SMTPConfig{Host: "smtp.gmail.com", Port: "587"},
+		Processing: ProcessingConfig{Workers: 4, QueueSize: 100, ShutdownTimeout: 30 * time.Second},
+		Reconcile:  ReconcileConfig{Interval: 24 * time.Hour},
+		WASM:       WASMConfig{MemoryLimitMB: 64, Timeout: 2 * time.Second},
+		Fallback:   FallbackConfig{KeywordRatio: 0.5, MinPatternMatches: 1},
+	}
+}
+
+// setting binds environment variables to a Config field. The first variable
+// that is set wins; each can also be read from a file named by <NAME>_FILE.
+type setting struct {
+	envs []string
+	set  func(value string) error
+}
+
+// str assigns the value to a string field
+func str(field *string) func(string) error {
+	return func(value string) error {
+		*field = value
+		return nil
+	}
+}
+
+// boolean parses the value into a bool field
+func boolean(field *bool) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*field = parsed
+		return nil
+	}
+}
+
+// integer parses the value into an int field
+func integer(field *int) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*field = parsed
+		return nil
+	}
+}
+
+// number parses the value into a float64 field
+func number(field *float64) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*field = parsed
+		return nil
+	}
+}
+
+// duration parses the value into a time.Duration field
+func duration(field *time.Duration) func(string) error {
+	return func(value string) error {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*field = parsed
+		return nil
+	}
+}
+
+// model sets the model used for one LLM call site
+func model(models map[string]string, callSite string) func(string) error {
+	return func(value string) error {
+		models[callSite] = value
+		return nil
+	}
+}
+
+// settings lists the environment variables read into c
+func (c *Config) settings() []setting {
+	if c.LLM.Models == nil {
+		c.LLM.Models = map[string]string{}
+	}
+	return []setting{
+		{[]string{"PORT"}, str(&c.Port)},
+		{[]string{"DIRECT_URL"}, str(&c.Database.URL)},
+
+		{[]string{"STORAGE_BACKEND"}, str(&c.Storage.Backend)},
+		{[]string{"LOCAL_STORAGE_DIR"}, str(&c.Storage.LocalDir)},
+		{[]string{"STORAGE_PUBLIC_READ"}, boolean(&c.Storage.PublicRead)},
+		{[]string{"SUPABASE_REGION"}, str(&c.Storage.S3.Region)},
+		{[]string{"SUPABASE_S3_ENDPOINT"}, str(&c.Storage.S3.Endpoint)},
+		{[]string{"SUPABASE_ACCESS_KEY"}, str(&c.Storage.S3.AccessKey)},
+		{[]string{"SUPABASE_SECRET_KEY"}, str(&c.Storage.S3.SecretKey)},
+		{[]string{"SUPABASE_BUCKET"}, str(&c.Storage.S3.Bucket)},
+
+		{[]string{"SEARCH_BACKEND"}, str(&c.Search.Backend)},
+		{[]string{"ELASTICSEARCH_URL"}, str(&c.Search.ElasticsearchURL)},
+		{[]string{"ELASTICSEARCH_API_KEY"}, str(&c.Search.ElasticsearchAPIKey)},
+		{[]string{"ELASTICSEARCH_INDEX"}, str(&c.Search.ElasticsearchIndex)},
+
+		{[]string{"OCR_PROVIDER"}, str(&c.OCR.Provider)},
+		{[]string{"OCR_SPACE_API_KEY", "VITE_OCR_SPACE_API_KEY"}, str(&c.OCR.OCRSpaceAPIKey)},
+
+		{[]string{"LLM_PROVIDER"}, str(&c.LLM.Provider)},
+		{[]string{"LLM_BASE_URL"}, str(&c.LLM.BaseURL)},
+		{[]string{"LLM_API_KEY", "GROQ_API_KEY", "VITE_GROQ_API_KEY"}, str(&c.LLM.APIKey)},
+		{[]string{"LLM_MODEL"}, str(&c.LLM.DefaultModel)},
+		{[]string{"LLM_MODEL_RULE_DETECTION"}, model(c.LLM.Models, "rule_detection")},
+		{[]string{"LLM_MODEL_BATCH_RULE_DETECTION"}, model(c.LLM.Models, "batch_rule_detection")},
+		{[]string{"LLM_MODEL_RULE_COMPLIANCE"}, model(c.LLM.Models, "rule_compliance")},
+		{[]string{"LLM_CHUNK_MAX_CHARS"}, integer(&c.LLM.ChunkMaxChars)},
+		{[]string{"LLM_CHUNK_CONCURRENCY"}, integer(&c.LLM.ChunkConcurrency)},
+
+		{[]string{"SMTP_HOST"}, str(&c.SMTP.Host)},
+		{[]string{"SMTP_PORT"}, str(&c.SMTP.Port)},
+		{[]string{"SMTP_USERNAME", "GMAIL_EMAIL"}, str(&c.SMTP.Username)},
+		{[]string{"SMTP_PASSWORD", "GMAIL_PASSWORD"}, str(&c.SMTP.Password)},
+		{[]string{"SMTP_FROM"}, str(&c.SMTP.From)},
+
+		{[]string{"PROCESSING_WORKERS"}, integer(&c.Processing.Workers)},
+		{[]string{"PROCESSING_QUEUE_SIZE"}, integer(&c.Processing.QueueSize)},
+		{[]string{"PROCESSING_SHUTDOWN_TIMEOUT"}, duration(&c.Processing.ShutdownTimeout)},
+
+		{[]string{"RECONCILE_INTERVAL"}, duration(&c.Reconcile.Interval)},
+		{[]string{"RECONCILE_FIX"}, boolean(&c.Reconcile.Fix)},
+		{[]string{"POLICY_DIR"}, str(&c.Policy.Dir)},
+		{[]string{"WASM_MEMORY_LIMIT_MB"}, integer(&c.WASM.MemoryLimitMB)},
+		{[]string{"WASM_TIMEOUT"}, duration(&c.WASM.Timeout)},
+		{[]string{"FALLBACK_KEYWORD_RATIO"}, number(&c.Fallback.KeywordRatio)},
+		{[]string{"FALLBACK_MIN_PATTERN_MATCHES"}, integer(&c.Fallback.MinPatternMatches)},
+	}
+}
+
+// lookup returns the value of the first set variable, reading <NAME>_FILE
+// when the variable itself is empty
+func lookup(envs []string) (string, string, error) {
+	for _, name := range envs {
+		if value := os.Getenv(name); value != "" {
+			return value, name, nil
+		}
+		if path := os.Getenv(name + "_FILE"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return "", name + "_FILE", fmt.Errorf("failed to read secret file: %w", err)
+			}
+			return strings.TrimRight(string(data), "\r\n"), name + "_FILE", nil
+		}
+	}
+	return "", "", nil
+}
+
+// Load builds the configuration from the defaults, the YAML file named by
+// CONFIG_FILE and the environment, in increasing order of precedence, and
+// validates the result
+func Load() (*Config, error) {
+	cfg := Default()
+	var problems []string
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	for _, s := range cfg.settings() {
+		value, source, err := lookup(s.envs)
+		if err == nil && value != "" {
+			err = s.set(value)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", source, err))
+		}
+	}
+
+	cfg.normalize()
+	problems = append(problems, cfg.problems()...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// normalize lowercases the backend and provider names
+func (c *Config) normalize() {
+	for _, name := range []*string{&c.Storage.Backend, &c.Search.Backend, &c.OCR.Provider, &c.LLM.Provider} {
+		*name = strings.ToLower(strings.TrimSpace(*name))
+	}
+}
+
+// ValidationError lists every invalid or missing setting
+type ValidationError struct {
+	Problems []string
+}
+
+// Error joins all problems into one message
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(e.Problems, "; "))
+}
+
+// Validate checks that every setting required by the selected backends is present
+func (c *Config) Validate() error {
+	c.normalize()
+	if problems := c.problems(); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// problems returns a description of every invalid or missing setting
+func (c *Config) problems() []string {
+	var problems []string
+	require := func(value, name, env string) {
+		if value == "" {
+			problems = append(problems, fmt.Sprintf("%s is required (set %s)", name, env))
+		}
+	}
+	oneOf := func(value, name string, allowed ...string) bool {
+		for _, a := range allowed {
+			if value == a {
+				return true
+			}
+		}
+		problems = append(problems, fmt.Sprintf("%s %q is not one of %s", name, value, strings.Join(allowed, ", ")))
+		return false
+	}
+
+	require(c.Port, "port", "PORT")
+	require(c.Database.URL, "database.url", "DIRECT_URL")
+
+	if oneOf(c.Storage.Backend, "storage.backend", "s3", "local") && c.Storage.Backend == "s3" {
+		require(c.Storage.S3.Region, "storage.s3.region", "SUPABASE_REGION")
+		require(c.Storage.S3.Endpoint, "storage.s3.endpoint", "SUPABASE_S3_ENDPOINT")
+		require(c.Storage.S3.AccessKey, "storage.s3.access_key", "SUPABASE_ACCESS_KEY")
+		require(c.Storage.S3.SecretKey, "storage.s3.secret_key", "SUPABASE_SECRET_KEY")
+		require(c.Storage.S3.Bucket, "storage.s3.bucket", "SUPABASE_BUCKET")
+	}
+
+	if oneOf(c.Search.Backend, "search.backend", "", "elasticsearch", "postgres") &&
+		(c.Search.Backend == "elasticsearch" || (c.Search.Backend == "" && c.Search.ElasticsearchAPIKey != "")) {
+		require(c.Search.ElasticsearchURL, "search.elasticsearch_url", "ELASTICSEARCH_URL")
+		require(c.Search.ElasticsearchAPIKey, "search.elasticsearch_api_key", "ELASTICSEARCH_API_KEY")
+	}
+
+	if oneOf(c.OCR.Provider, "ocr.provider", "", "local", "ocrspace") && c.OCR.Provider == "ocrspace" {
+		require(c.OCR.OCRSpaceAPIKey, "ocr.ocrspace_api_key", "OCR_SPACE_API_KEY")
+	}
+
+	if oneOf(c.LLM.Provider, "llm.provider", "groq", "openai", "ollama", "fake") &&
+		(c.LLM.Provider == "groq" || c.LLM.Provider == "openai") {
+		require(c.LLM.APIKey, "llm.api_key", "LLM_API_KEY")
+	}
+
+	if c.SMTP.Enabled() {
+		require(c.SMTP.Host, "smtp.host", "SMTP_HOST")
+		require(c.SMTP.Port, "smtp.port", "SMTP_PORT")
+		require(c.SMTP.Username, "smtp.username", "SMTP_USERNAME")
+		require(c.SMTP.Password, "smtp.password", "SMTP_PASSWORD")
+	}
+
+	if c.LLM.ChunkMaxChars < 1000 {
+		problems = append(problems, "llm.chunk_max_chars must be at least 1000 (set LLM_CHUNK_MAX_CHARS)")
+	}
+	if c.LLM.ChunkConcurrency < 1 {
+		problems = append(problems, "llm.chunk_concurrency must be at least 1 (set LLM_CHUNK_CONCURRENCY)")
+	}
+	if c.Processing.Workers < 1 {
+		problems = append(problems, "processing.workers must be at least 1 (set PROCESSING_WORKERS)")
+	}
+	if c.Processing.QueueSize < 1 {
+		problems = append(problems, "processing.queue_size must be at least 1 (set PROCESSING_QUEUE_SIZE)")
+	}
+	if c.Processing.ShutdownTimeout < 0 {
+		problems = append(problems, "processing.shutdown_timeout must not be negative (set PROCESSING_SHUTDOWN_TIMEOUT)")
+	}
+	if c.WASM.MemoryLimitMB < 1 || c.WASM.MemoryLimitMB > 4096 {
+		problems = append(problems, "wasm.memory_limit_mb must be between 1 and 4096 (set WASM_MEMORY_LIMIT_MB)")
+	}
+	if c.WASM.Timeout <= 0 {
+		problems = append(problems, "wasm.timeout must be positive (set WASM_TIMEOUT)")
+	}
+	if c.Fallback.KeywordRatio <= 0 || c.Fallback.KeywordRatio > 1 {
+		problems = append(problems, "fallback.keyword_ratio must be greater than 0 and at most 1 (set FALLBACK_KEYWORD_RATIO)")
+	}
+	if c.Fallback.MinPatternMatches < 1 {
+		problems = append(problems, "fallback.min_pattern_matches must be at least 1 (set FALLBACK_MIN_PATTERN_MATCHES)")
+	}
+
+	return problems
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clearEnv unsets every variable Load reads so the host environment does not leak in
+func clearEnv(t *testing.T) {
+	for _, s := range Default().settings() {
+		for _, name := range s.envs {
+			t.Setenv(name, "")
+			t.Setenv(name+"_FILE", "")
+		}
+	}
+	t.Setenv("CONFIG_FILE", "")
+}
+
+func TestLoad_ReportsAllMissingSettings(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("SEARCH_BACKEND", "elasticsearch")
+	t.Setenv("ELASTICSEARCH_URL", "")
+	t.Setenv("OCR_PROVIDER", "OCRSpace")
+	t.Setenv("SMTP_USERNAME", "alerts@example.com")
+
+	_, err := Load()
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+
+	for _, want := range []string{
+		"DIRECT_URL", "SUPABASE_REGION", "SUPABASE_S3_ENDPOINT", "SUPABASE_ACCESS_KEY",
+		"SUPABASE_SECRET_KEY", "SUPABASE_BUCKET", "ELASTICSEARCH_URL", "ELASTICSEARCH_API_KEY",
+		"OCR_SPACE_API_KEY", "LLM_API_KEY", "SMTP_PASSWORD",
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+	assert.Len(t, validationErr.Problems, 11)
+}
+
+func TestLoad_FilePrecedence(t *testing.T) {
+	clearEnv(t)
+	dir := t.TempDir()
+
+	configFile := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+port: "9000"
+database:
+  url: postgres://from-file
+storage:
+  backend: local
+  local_dir: /var/lib/legaleagle
+llm:
+  provider: ollama
+  models:
+    rule_compliance: llama3
+`), 0o600))
+	secretFile := filepath.Join(dir, "smtp_password")
+	require.NoError(t, os.WriteFile(secretFile, []byte("app-password\n"), 0o600))
+
+	t.Setenv("CONFIG_FILE", configFile)
+	t.Setenv("PORT", "8080")
+	t.Setenv("SMTP_USERNAME", "alerts@example.com")
+	t.Setenv("SMTP_PASSWORD_FILE", secretFile)
+	t.Setenv("LLM_MODEL_RULE_DETECTION", "llama3:8b")
+
+	cfg, err := Load()
+	require.NoError(t, err)
+
+	assert.Equal(t, "8080", cfg.Port, "environment overrides the file")
+	assert.Equal(t, "postgres://from-file", cfg.Database.URL)
+	assert.Equal(t, "/var/lib/legaleagle", cfg.Storage.LocalDir)
+	assert.Equal(t, "app-password", cfg.SMTP.Password)
+	assert.Equal(t, "alerts@example.com", cfg.SMTP.Sender())
+	assert.Equal(t, "smtp.gmail.com", cfg.SMTP.Host, "defaults fill unset values")
+	assert.Equal(t, map[string]string{"rule_compliance": "llama3", "rule_detection": "llama3:8b"}, cfg.LLM.Models)
+}
+
+func TestLoad_InvalidValues(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("DIRECT_URL", "postgres://db")
+	t.Setenv("STORAGE_BACKEND", "ftp")
+	t.Setenv("STORAGE_PUBLIC_READ", "sometimes")
+	t.Setenv("LLM_PROVIDER", "fake")
+	t.Setenv("SMTP_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv("FALLBACK_KEYWORD_RATIO", "1.5")
+	t.Setenv("LLM_CHUNK_CONCURRENCY", "0")
+
+	_, err := Load()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `storage.backend "ftp"`)
+	assert.Contains(t, err.Error(), "STORAGE_PUBLIC_READ: invalid boolean")
+	assert.Contains(t, err.Error(), "SMTP_PASSWORD_FILE: failed to read secret file")
+	assert.Contains(t, err.Error(), "fallback.keyword_ratio must be greater than 0 and at most 1")
+	assert.Contains(t, err.Error(), "llm.chunk_concurrency must be at least 1")
+}
@@ -1,7 +1,10 @@
 package controller
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/Itish41/LegalEagle/models"
 	service "github.com/Itish41/LegalEagle/service"
@@ -19,13 +22,125 @@ func (c *DocumentController) AddComplianceRule(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := c.service.AddComplianceRule(&rule); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := c.service.AddComplianceRule(ctx.Request.Context(), &rule); err != nil {
+		respondRuleError(ctx, err)
 		return
 	}
 	ctx.JSON(http.StatusCreated, rule)
 }
 
+// ruleErrorStatus maps rule service errors to HTTP status codes
+func ruleErrorStatus(err error) int {
+	var inUse *service.RuleInUseError
+	var failed *service.RuleTestFailedError
+	switch {
+	case errors.Is(err, service.ErrRuleNotFound), errors.Is(err, service.ErrRuleVersionNotFound), errors.Is(err, service.ErrDocumentNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrRuleNameTaken), errors.As(err, &inUse):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrRuleNameRequired), errors.Is(err, service.ErrUnknownRuleType), errors.Is(err, service.ErrInvalidRule),
+		errors.Is(err, service.ErrRuleTestInputRequired), errors.Is(err, service.ErrTooManyRuleTestDocuments):
+		return http.StatusBadRequest
+	case errors.As(err, &failed):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// respondRuleError writes a rule error, listing the validation issues or
+// failing test cases of a definition and telling the client how to confirm a
+// change to a rule in use
+func respondRuleError(ctx *gin.Context, err error) {
+	var inUse *service.RuleInUseError
+	var failed *service.RuleTestFailedError
+	var invalid *service.RuleValidationError
+	if errors.As(err, &invalid) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":  err.Error(),
+			"issues": invalid.Issues,
+		})
+		return
+	}
+	if errors.As(err, &failed) {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":             err.Error(),
+			"failed_test_cases": failed.Failures,
+		})
+		return
+	}
+	if errors.As(err, &inUse) {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error":             err.Error(),
+			"open_action_items": inUse.OpenActionItems,
+			"confirm":           "repeat the request with ?confirm=true",
+		})
+		return
+	}
+	ctx.JSON(ruleErrorStatus(err), gin.H{"error": err.Error()})
+}
+
+// GetComplianceRule returns one rule, including archived rules
+func (c *DocumentController) GetComplianceRule(ctx *gin.Context) {
+	rule, err := c.service.GetComplianceRule(ctx.Param("id"))
+	if err != nil {
+		respondRuleError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, rule)
+}
+
+// UpdateComplianceRule replaces a rule's definition
+func (c *DocumentController) UpdateComplianceRule(ctx *gin.Context) {
+	var rule models.ComplianceRule
+	if err := ctx.ShouldBindJSON(&rule); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updated, err := c.service.UpdateComplianceRule(ctx.Request.Context(), ctx.Param("id"), rule, ctx.Query("confirm") == "true")
+	if err != nil {
+		respondRuleError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, updated)
+}
+
+// DeleteComplianceRule archives a rule; past results keep referring to it
+func (c *DocumentController) DeleteComplianceRule(ctx *gin.Context) {
+	if err := c.service.ArchiveComplianceRule(ctx.Param("id"), ctx.Query("confirm") == "true"); err != nil {
+		respondRuleError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Compliance rule archived successfully"})
+}
+
+// GetRuleVersions lists every recorded definition of a rule
+func (c *DocumentController) GetRuleVersions(ctx *gin.Context) {
+	versions, err := c.service.GetRuleVersions(ctx.Param("id"))
+	if err != nil {
+		respondRuleError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, versions)
+}
+
+// DiffRuleVersions compares the versions given by the from and to query parameters
+func (c *DocumentController) DiffRuleVersions(ctx *gin.Context) {
+	from, errFrom := strconv.Atoi(ctx.Query("from"))
+	to, errTo := strconv.Atoi(ctx.Query("to"))
+	if errFrom != nil || errTo != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be version numbers"})
+		return
+	}
+
+	diff, err := c.service.DiffRuleVersions(ctx.Param("id"), from, to)
+	if err != nil {
+		respondRuleError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, diff)
+}
+
 // GetAllComplianceRules retrieves all compliance rules from the database
 func (c *DocumentController) GetAllComplianceRules(ctx *gin.Context) {
 	rules, err := c.service.GetAllComplianceRules()
@@ -55,3 +170,149 @@ func (c *DocumentController) GetComplianceRulesByNames(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, rules)
 }
+
+// GetLLMUsage returns the LLM token usage accumulated per call site
+func (c *DocumentController) GetLLMUsage(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"usage": c.service.LLMUsage()})
+}
+
+// UploadRuleModule stores a WebAssembly module for rules of type wasm and
+// returns the digest to use as their pattern
+func (c *DocumentController) UploadRuleModule(ctx *gin.Context) {
+	file, _, err := ctx.Request.FormFile("module")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get module from request"})
+		return
+	}
+	defer file.Close()
+
+	body, err := io.ReadAll(io.LimitReader(file, service.MaxWASMModuleSize+1))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read module"})
+		return
+	}
+	digest, err := c.service.UploadRuleModule(ctx.Request.Context(), body)
+	switch {
+	case errors.Is(err, service.ErrInvalidWASMModule):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrWASMRulesDisabled):
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusCreated, gin.H{"module": digest, "size": len(body)})
+	}
+}
+
+// TestComplianceRule dry-runs a proposed rule against text or existing
+// documents and reports what it would flag, without saving anything
+func (c *DocumentController) TestComplianceRule(ctx *gin.Context) {
+	var req service.RuleTestRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	report, err := c.service.TestComplianceRule(ctx.Request.Context(), req)
+	if err != nil {
+		respondRuleError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
+}
+
+// LintComplianceRules audits the stored rules and lists their problems
+func (c *DocumentController) LintComplianceRules(ctx *gin.Context) {
+	report, err := c.service.LintComplianceRules()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
+}
+
+// maxRulePackSize bounds the body of a rule pack import
+const maxRulePackSize = 4 << 20
+
+// ExportComplianceRules downloads the active rules as a rule pack in YAML
+// (the default) or JSON
+func (c *DocumentController) ExportComplianceRules(ctx *gin.Context) {
+	format := ctx.DefaultQuery("format", service.RulePackFormatYAML)
+	pack, err := c.service.ExportRulePack()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	data, err := service.EncodeRulePack(pack, format)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	contentType := "application/yaml"
+	if format == service.RulePackFormatJSON {
+		contentType = "application/json"
+	}
+	ctx.Header("Content-Disposition", "attachment; filename=rules."+format)
+	ctx.Data(http.StatusOK, contentType, data)
+}
+
+// ImportComplianceRules imports a rule pack sent as the request body, or the
+// starter pack named by ?starter=. ?mode=merge|replace decides what happens to
+// rules whose name already exists and ?dry_run=true reports without saving.
+func (c *DocumentController) ImportComplianceRules(ctx *gin.Context) {
+	dryRun, _ := strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+	opts := service.RuleImportOptions{Mode: ctx.DefaultQuery("mode", service.RuleImportMerge), DryRun: dryRun}
+
+	var pack *service.RulePack
+	var err error
+	if starter := ctx.Query("starter"); starter != "" {
+		pack, err = service.StarterRulePack(starter)
+	} else {
+		body, readErr := io.ReadAll(io.LimitReader(ctx.Request.Body, maxRulePackSize+1))
+		if readErr != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read rule pack"})
+			return
+		}
+		if len(body) > maxRulePackSize {
+			ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "rule pack is too large"})
+			return
+		}
+		pack, err = service.ParseRulePack(body)
+	}
+	if err != nil {
+		respondRulePackError(ctx, err)
+		return
+	}
+
+	report, err := c.service.ImportRulePack(ctx.Request.Context(), pack, opts)
+	if errors.Is(err, service.ErrRulePackRejected) {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "report": report})
+		return
+	}
+	if err != nil {
+		respondRulePackError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
+}
+
+// respondRulePackError maps rule pack errors to HTTP status codes
+func respondRulePackError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrRulePackNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidRulePack):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// GetStarterRulePacks lists the rule packs shipped with the server
+func (c *DocumentController) GetStarterRulePacks(ctx *gin.Context) {
+	packs, err := service.StarterRulePacks()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"packs": packs})
+}
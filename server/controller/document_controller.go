@@ -1,9 +1,13 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
+	model "github.com/Itish41/LegalEagle/models"
 	service "github.com/Itish41/LegalEagle/service"
 
 	"github.com/gin-gonic/gin"
@@ -28,21 +32,58 @@ func (c *DocumentController) UploadDocument(ctx *gin.Context) {
 	}
 	defer file.Close()
 
-	ocrText, fileID, fileURL, complianceResults, riskScore, err := c.service.UploadAndProcessDocument(file, header) // Update service to return these
+	// Optional comma-separated tags, matched against rule scopes
+	var tags []string
+	if value := ctx.PostForm("tags"); value != "" {
+		tags = strings.Split(value, ",")
+	}
+
+	doc, err := c.service.EnqueueDocument(file, header, tags...)
+	if errors.Is(err, service.ErrProcessingQueueFull) {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"message":           "Document uploaded and processed successfully",
-		"ocrText":           ocrText,
-		"fileID":            fileID,
-		"fileURL":           fileURL,
-		"complianceResults": complianceResults, // Optional
-		"riskScore":         riskScore,
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"message":   "Document uploaded and queued for processing",
+		"fileID":    doc.ID,
+		"fileURL":   doc.OriginalURL,
+		"status":    doc.ProcessingStatus,
+		"statusURL": fmt.Sprintf("/api/documents/%s/status", doc.ID),
 	})
 }
 
+// GetDocumentStatus reports how far a document has progressed through processing
+func (c *DocumentController) GetDocumentStatus(ctx *gin.Context) {
+	doc, err := c.service.GetDocumentStatus(ctx.Param("id"))
+	if errors.Is(err, service.ErrDocumentNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"id":         doc.ID,
+		"title":      doc.Title,
+		"status":     doc.ProcessingStatus,
+		"created_at": doc.CreatedAt,
+		"updated_at": doc.UpdatedAt,
+	}
+	if doc.ProcessingError != "" {
+		response["error"] = doc.ProcessingError
+	}
+	if doc.ProcessingStatus == model.DocumentStatusAnalyzed {
+		response["risk_score"] = doc.RiskScore
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
 // GetAllDocuments retrieves all documents from the database
 func (dc *DocumentController) GetAllDocuments(c *gin.Context) {
 	log.Println("DocumentController: Fetching all documents")
@@ -91,3 +132,132 @@ func (c *DocumentController) SearchDocuments(ctx *gin.Context) {
 		"results": results,
 	})
 }
+
+// DownloadDocument redirects to a short-lived URL for the original file, or
+// streams it when the store cannot presign or ?stream=true is given
+func (c *DocumentController) DownloadDocument(ctx *gin.Context) {
+	documentID := ctx.Param("id")
+	stream := ctx.Query("stream") == "true"
+
+	download, err := c.service.GetDocumentDownload(ctx.Request.Context(), documentID, stream)
+	if errors.Is(err, service.ErrDocumentNotFound) || errors.Is(err, service.ErrBlobNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error downloading document %s: %v", documentID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if download.URL != "" {
+		ctx.Redirect(http.StatusTemporaryRedirect, download.URL)
+		return
+	}
+	defer download.Body.Close()
+
+	ctx.DataFromReader(http.StatusOK, download.Size, download.ContentType, download.Body, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", download.Filename),
+	})
+}
+
+// documentErrorStatus maps document service errors to HTTP status codes
+func documentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrDocumentNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrDocumentBusy), errors.Is(err, service.ErrNothingToReprocess):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrEmptyTitle):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrProcessingQueueFull):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GetDocument returns one document with its rule results and action items
+func (c *DocumentController) GetDocument(ctx *gin.Context) {
+	doc, err := c.service.GetDocument(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(documentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, doc)
+}
+
+// GetDocumentEvidence returns the passages behind each of a document's rule results
+func (c *DocumentController) GetDocumentEvidence(ctx *gin.Context) {
+	evidence, err := c.service.GetDocumentEvidence(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(documentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"document_id": ctx.Param("id"), "results": evidence})
+}
+
+// UpdateDocumentRequest is the body of PATCH /documents/:id
+type UpdateDocumentRequest struct {
+	Title *string   `json:"title"`
+	Tags  *[]string `json:"tags"`
+}
+
+// UpdateDocument changes a document's metadata
+func (c *DocumentController) UpdateDocument(ctx *gin.Context) {
+	var req UpdateDocumentRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Title == nil && req.Tags == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update; supported fields: title, tags"})
+		return
+	}
+
+	var doc *model.Document
+	var err error
+	if req.Title != nil {
+		if doc, err = c.service.UpdateDocumentTitle(ctx.Request.Context(), ctx.Param("id"), *req.Title); err != nil {
+			ctx.JSON(documentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Tags != nil {
+		if doc, err = c.service.UpdateDocumentTags(ctx.Request.Context(), ctx.Param("id"), *req.Tags); err != nil {
+			ctx.JSON(documentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    "Document updated successfully",
+		"id":         doc.ID,
+		"title":      doc.Title,
+		"tags":       service.DocumentTags(doc.Tags),
+		"updated_at": doc.UpdatedAt,
+	})
+}
+
+// DeleteDocument removes a document and everything derived from it
+func (c *DocumentController) DeleteDocument(ctx *gin.Context) {
+	if err := c.service.DeleteDocument(ctx.Request.Context(), ctx.Param("id")); err != nil {
+		ctx.JSON(documentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Document deleted successfully"})
+}
+
+// ReprocessDocument queues a fresh compliance analysis of a document
+func (c *DocumentController) ReprocessDocument(ctx *gin.Context) {
+	doc, err := c.service.ReprocessDocument(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(documentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"message":   "Document queued for reprocessing",
+		"fileID":    doc.ID,
+		"status":    doc.ProcessingStatus,
+		"statusURL": fmt.Sprintf("/api/documents/%s/status", doc.ID),
+	})
+}
@@ -0,0 +1,4 @@
+-- Record which OCR provider produced each document's text
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS ocr_provider VARCHAR(50);
+
+CREATE INDEX IF NOT EXISTS idx_documents_ocr_provider ON documents(ocr_provider);
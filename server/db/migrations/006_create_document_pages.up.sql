@@ -0,0 +1,16 @@
+-- Create document_pages table holding the per-page OCR text of each document
+CREATE TABLE IF NOT EXISTS document_pages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    document_id UUID REFERENCES documents(id) ON DELETE CASCADE,
+    page_number INTEGER NOT NULL,
+    text TEXT,
+    confidence FLOAT,
+    start_offset INTEGER,
+    end_offset INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (document_id, page_number)
+);
+
+-- Create indexes for performance and foreign key relationships
+CREATE INDEX IF NOT EXISTS idx_document_pages_document_id ON document_pages(document_id);
+CREATE INDEX IF NOT EXISTS idx_document_pages_confidence ON document_pages(confidence);
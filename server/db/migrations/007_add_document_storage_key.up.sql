@@ -0,0 +1,4 @@
+-- Record the blob store key of each document's original file
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS storage_key TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_documents_storage_key ON documents(storage_key);
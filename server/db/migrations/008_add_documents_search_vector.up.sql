@@ -0,0 +1,9 @@
+-- Full-text search over documents for deployments without Elasticsearch.
+-- Titles rank above body text; the column is kept up to date by Postgres.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(ocr_text, '')), 'B')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_documents_search_vector ON documents USING GIN (search_vector);
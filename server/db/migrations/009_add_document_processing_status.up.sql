@@ -0,0 +1,7 @@
+-- Track where each document is in the asynchronous processing pipeline.
+-- Documents uploaded before the pipeline existed were fully processed.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS processing_status VARCHAR(20) NOT NULL DEFAULT 'analyzed';
+ALTER TABLE documents ALTER COLUMN processing_status SET DEFAULT 'queued';
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS processing_error TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_documents_processing_status ON documents(processing_status);
@@ -0,0 +1,16 @@
+-- Rules are archived instead of deleted so past results keep their rule
+ALTER TABLE compliance_rules ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE compliance_rules ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE;
+
+-- Archive duplicate names, keeping the oldest rule of each name active
+UPDATE compliance_rules SET archived_at = CURRENT_TIMESTAMP
+WHERE id IN (
+    SELECT id FROM (
+        SELECT id, row_number() OVER (PARTITION BY lower(name) ORDER BY created_at, id) AS rn
+        FROM compliance_rules
+    ) ranked
+    WHERE rn > 1
+);
+
+CREATE INDEX IF NOT EXISTS idx_compliance_rules_archived_at ON compliance_rules(archived_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_compliance_rules_active_name ON compliance_rules(lower(name)) WHERE archived_at IS NULL;
@@ -0,0 +1,31 @@
+-- Every change to a rule's definition is recorded as a new version so results
+-- can point at the exact definition that produced them
+CREATE TABLE IF NOT EXISTS compliance_rule_versions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    rule_id UUID NOT NULL REFERENCES compliance_rules(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT,
+    pattern TEXT,
+    severity VARCHAR(20),
+    effective_from TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (rule_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_compliance_rule_versions_effective_from ON compliance_rule_versions(rule_id, effective_from);
+
+-- Existing rules start at version 1 with their current definition
+INSERT INTO compliance_rule_versions (rule_id, version, name, description, pattern, severity, effective_from)
+SELECT r.id, 1, r.name, r.description, r.pattern, r.severity, COALESCE(r.created_at, CURRENT_TIMESTAMP)
+FROM compliance_rules r
+WHERE NOT EXISTS (SELECT 1 FROM compliance_rule_versions v WHERE v.rule_id = r.id);
+
+ALTER TABLE document_rule_results ADD COLUMN IF NOT EXISTS rule_version_id UUID REFERENCES compliance_rule_versions(id);
+
+-- Past results can only be attributed to the definition that exists today
+UPDATE document_rule_results res SET rule_version_id = v.id
+FROM compliance_rule_versions v
+WHERE v.rule_id = res.rule_id AND v.version = 1 AND res.rule_version_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_document_rule_results_rule_version_id ON document_rule_results(rule_version_id);
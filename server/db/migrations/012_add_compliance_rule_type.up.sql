@@ -0,0 +1,8 @@
+-- Rules name the evaluator that checks them. Existing rules keep being judged by the LLM.
+ALTER TABLE compliance_rules ADD COLUMN IF NOT EXISTS type VARCHAR(40) NOT NULL DEFAULT 'llm_judged';
+ALTER TABLE compliance_rules ADD COLUMN IF NOT EXISTS params JSONB;
+
+ALTER TABLE compliance_rule_versions ADD COLUMN IF NOT EXISTS type VARCHAR(40) NOT NULL DEFAULT 'llm_judged';
+ALTER TABLE compliance_rule_versions ADD COLUMN IF NOT EXISTS params JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_compliance_rules_type ON compliance_rules(type);
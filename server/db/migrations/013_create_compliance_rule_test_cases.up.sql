@@ -0,0 +1,11 @@
+-- Sample texts with the outcome their rule must produce; they run whenever the rule is saved
+CREATE TABLE IF NOT EXISTS compliance_rule_test_cases (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    rule_id UUID NOT NULL REFERENCES compliance_rules(id) ON DELETE CASCADE,
+    name TEXT,
+    text TEXT NOT NULL,
+    expect VARCHAR(10) NOT NULL CHECK (expect IN ('pass', 'fail')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_compliance_rule_test_cases_rule_id ON compliance_rule_test_cases(rule_id);
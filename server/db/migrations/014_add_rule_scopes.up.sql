@@ -0,0 +1,6 @@
+-- Rules can be limited to document types, jurisdictions and tags. Rules without a scope apply to every document.
+ALTER TABLE compliance_rules ADD COLUMN IF NOT EXISTS scope JSONB;
+ALTER TABLE compliance_rule_versions ADD COLUMN IF NOT EXISTS scope JSONB;
+
+-- Tags given to a document at upload, matched against rule scopes.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS tags JSONB;
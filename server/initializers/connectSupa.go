@@ -3,8 +3,8 @@ package initializers
 import (
 	"fmt"
 	"log"
-	"os"
 
+	"github.com/Itish41/LegalEagle/config"
 	_ "github.com/lib/pq"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -13,13 +13,13 @@ import (
 
 var DB *gorm.DB // automigrate is also using this var
 
-func ConnectDB() error {
+// ConnectDB opens the Postgres connection described by cfg
+func ConnectDB(cfg config.DatabaseConfig) error {
 	log.Println("Connecting to database")
 
-	dsn := os.Getenv("DIRECT_URL")
+	dsn := cfg.URL
 	if dsn == "" {
-		log.Println("DIRECT_URL variable not loading...")
-		return fmt.Errorf("env variable DIRECT_URL is empty")
+		return fmt.Errorf("database URL is empty")
 	}
 
 	var err error
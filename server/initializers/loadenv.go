@@ -1,15 +1,23 @@
 package initializers
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/joho/godotenv"
 )
 
+// LoadEnv loads variables from .env into the environment. A missing file is
+// not an error, since the settings can also come from CONFIG_FILE or the real environment.
 func LoadEnv() error {
 	log.Println("Loading env file")
 	err := godotenv.Load() // using the joho library to load variables from the .env file
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("No .env file found, using the process environment")
+		return nil
+	}
 	if err != nil {
 		log.Println("env not loading")
 		return fmt.Errorf("env not loading")
@@ -3,7 +3,6 @@ package initializers
 import (
 	"fmt"
 	"log"
-	"os"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
@@ -13,11 +12,6 @@ import (
 func Migrate() error {
 	log.Println("Starting database migration...")
 
-	dsn := os.Getenv("DIRECT_URL")
-	if dsn == "" {
-		return fmt.Errorf("DIRECT_URL environment variable not set")
-	}
-
 	sqlDB, err := DB.DB()
 	if err != nil {
 		return fmt.Errorf("error getting underlying *sql.DB: %w", err)
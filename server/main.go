@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/Itish41/LegalEagle/config"
 	controller "github.com/Itish41/LegalEagle/controller"
 	"github.com/Itish41/LegalEagle/initializers"
 	middleware "github.com/Itish41/LegalEagle/middleware"
@@ -13,11 +18,18 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// cfg is the validated configuration loaded at startup
+var cfg *config.Config
+
 func init() {
 	if err := initializers.LoadEnv(); err != nil {
 		log.Fatalf("[CRITICAL] Failed to load env: %s", err)
 	}
-	if err := initializers.ConnectDB(); err != nil {
+	var err error
+	if cfg, err = config.Load(); err != nil {
+		log.Fatalf("[CRITICAL] %s", err)
+	}
+	if err := initializers.ConnectDB(cfg.Database); err != nil {
 		log.Fatalf("[CRITICAL] Failed to initialize database connection: %s", err)
 	}
 	if err := initializers.Migrate(); err != nil {
@@ -26,16 +38,27 @@ func init() {
 }
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8000"
-	}
+	port := cfg.Port
 
-	docService, err := service.NewDocumentService(initializers.DB)
+	docService, err := service.NewDocumentService(initializers.DB, cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize document service: %s", err)
 	}
 
+	// Background work stops when the server is asked to shut down
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Process uploads in the background, picking up documents interrupted by a restart
+	docService.StartWorkers(ctx)
+	go func() {
+		if err := docService.ResumeProcessing(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("Failed to resume document processing: %s", err)
+		}
+	}()
+
+	docService.StartReconciler(ctx, cfg.Reconcile.Interval, cfg.Reconcile.Fix)
+
 	docController := controller.NewDocumentController(docService)
 
 	router := gin.Default()
@@ -55,6 +78,18 @@ func main() {
 	api.POST("/rules", middleware.StrictRateLimiter.Limit(), docController.AddComplianceRule)
 	api.GET("/rules", docController.GetAllComplianceRules)
 	api.POST("/rules/by-names", docController.GetComplianceRulesByNames)
+	api.GET("/rules/lint", docController.LintComplianceRules)
+	api.GET("/rules/export", docController.ExportComplianceRules)
+	api.POST("/rules/import", middleware.StrictRateLimiter.Limit(), docController.ImportComplianceRules)
+	api.GET("/rules/packs", docController.GetStarterRulePacks)
+	api.POST("/rules/test", docController.TestComplianceRule)
+	api.POST("/rules/modules", middleware.StrictRateLimiter.Limit(), docController.UploadRuleModule)
+	api.GET("/rules/:id", docController.GetComplianceRule)
+	api.PUT("/rules/:id", docController.UpdateComplianceRule)
+	api.DELETE("/rules/:id", middleware.StrictRateLimiter.Limit(), docController.DeleteComplianceRule)
+	api.GET("/rules/:id/versions", docController.GetRuleVersions)
+	api.GET("/rules/:id/versions/diff", docController.DiffRuleVersions)
+	api.GET("/llm/usage", docController.GetLLMUsage)
 
 	api.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
@@ -63,9 +98,35 @@ func main() {
 	api.POST("/action-update/:id", docController.AssignActionItem)
 	api.GET("/search", docController.SearchDocuments)
 	api.GET("/dashboard", docController.GetAllDocuments)
+	api.GET("/documents/:id", docController.GetDocument)
+	api.PATCH("/documents/:id", docController.UpdateDocument)
+	api.DELETE("/documents/:id", middleware.StrictRateLimiter.Limit(), docController.DeleteDocument)
+	api.POST("/documents/:id/reprocess", middleware.StrictRateLimiter.Limit(), docController.ReprocessDocument)
+	api.GET("/documents/:id/download", docController.DownloadDocument)
+	api.GET("/documents/:id/status", docController.GetDocumentStatus)
+	api.GET("/documents/:id/evidence", docController.GetDocumentEvidence)
 	api.GET("/action-items", docController.GetPendingActionItemsWithTitles)
 	api.PUT("/action-items/:id/complete", middleware.StrictRateLimiter.Limit(), docController.CompleteActionItem)
 
-	log.Printf("Server running on port %s...", port)
-	router.Run(":" + port)
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	go func() {
+		log.Printf("Server running on port %s...", port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %s", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down...")
+
+	// Finish the requests and processing jobs in flight, cancelling what remains after the timeout
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Processing.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown: %s", err)
+	}
+	if err := docService.StopWorkers(shutdownCtx); err != nil {
+		log.Printf("Document processing stopped before all jobs finished: %s", err)
+	}
 }
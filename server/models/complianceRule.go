@@ -3,9 +3,30 @@ package models
 import (
 	"time"
 
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
+// Rule types select how a rule is evaluated against a document's text.
+const (
+	// RuleTypeRegexMustMatch fails when Pattern does not match the text.
+	RuleTypeRegexMustMatch = "regex_must_match"
+	// RuleTypeRegexMustNotMatch fails when Pattern matches the text.
+	RuleTypeRegexMustNotMatch = "regex_must_not_match"
+	// RuleTypeRequiredKeywords fails when fewer than the required number of keywords appear.
+	RuleTypeRequiredKeywords = "required_keywords"
+	// RuleTypeRequiredSection fails when no section headed by Pattern has content.
+	RuleTypeRequiredSection = "required_section"
+	// RuleTypeCEL fails when the CEL expression in Pattern evaluates to false over the document's facts.
+	RuleTypeCEL = "cel"
+	// RuleTypeRego fails when the Rego policy decision named by Pattern rejects the document.
+	RuleTypeRego = "rego"
+	// RuleTypeWASM runs the uploaded WebAssembly module whose digest is in Pattern.
+	RuleTypeWASM = "wasm"
+	// RuleTypeLLMJudged asks the LLM whether the document meets the rule's description.
+	RuleTypeLLMJudged = "llm_judged"
+)
+
 // ComplianceRule defines a rule for checking document compliance.
 type ComplianceRule struct {
 	// ID is a unique identifier for the rule, stored as a UUID in the database.
@@ -21,17 +42,50 @@ type ComplianceRule struct {
 	// Pattern is the regex or keyword pattern for the rule, indexed as a keyword.
 	Pattern string `elastic:"type:keyword"`
 
+	// Type selects the evaluator for the rule (see the RuleType constants), indexed as a keyword.
+	Type string `gorm:"not null;default:llm_judged" elastic:"type:keyword"`
+
+	// Params is a JSONB field with type-specific settings (e.g., keywords and thresholds), indexed as an object.
+	Params datatypes.JSON `elastic:"type:object"`
+
 	// Severity indicates the rule's importance (e.g., 'low', 'medium', 'high'), indexed as a keyword.
 	Severity string `elastic:"type:keyword"`
 
+	// Scope is a JSONB field limiting the documents the rule applies to by document_types,
+	// jurisdictions and tags; a rule without a scope applies to every document.
+	Scope datatypes.JSON `json:"scope,omitempty" elastic:"type:object"`
+
 	// CreatedAt tracks when the rule was created, indexed as a date.
 	CreatedAt time.Time `elastic:"type:date"`
 
+	// UpdatedAt tracks when the rule was last edited, indexed as a date.
+	UpdatedAt time.Time `elastic:"type:date"`
+
+	// ArchivedAt is set when the rule is deleted. Archived rules are hidden from
+	// queries but stay referenced by the results they produced.
+	ArchivedAt gorm.DeletedAt `gorm:"column:archived_at;index" json:"archived_at,omitempty" elastic:"type:date"`
+
+	// TestCases are the rule's stored positive and negative examples; they are
+	// only loaded for a single rule.
+	TestCases []ComplianceRuleTestCase `gorm:"foreignKey:RuleID" json:"test_cases,omitempty" elastic:"-"`
+
+	// Warnings lists the lint findings that did not prevent the rule from being saved.
+	Warnings []RuleIssue `gorm:"-" json:"warnings,omitempty" elastic:"-"`
+
 	// SearchContent is a computed field for full-text search, combining Name and Description.
 	// It's not stored in the database but is indexed in Elasticsearch.
 	SearchContent string `gorm:"-" elastic:"type:text,analyzer:standard"`
 }
 
+// RuleIssue is a problem found when validating or linting a rule. Issues at
+// level "error" prevent the rule from being saved; "warning" issues do not.
+type RuleIssue struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
 // BeforeSave is a GORM hook to populate SearchContent before saving to Elasticsearch.
 func (r *ComplianceRule) BeforeSave(tx *gorm.DB) error {
 	// Combine Name and Description for full-text search.
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// ComplianceRuleTestCase is a sample text together with the outcome its rule
+// must produce on it. A rule's test cases run whenever the rule is saved.
+type ComplianceRuleTestCase struct {
+	// ID is a unique identifier for the test case, stored as a UUID in the database.
+	ID string `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" elastic:"type:keyword"`
+
+	// RuleID references the rule under test, indexed as a keyword.
+	RuleID string `gorm:"type:uuid" json:"rule_id" elastic:"type:keyword"`
+
+	// Name describes what the case covers, indexed as text.
+	Name string `elastic:"type:text,analyzer:standard"`
+
+	// Text is the document text the rule is evaluated against.
+	Text string `gorm:"not null" elastic:"type:text"`
+
+	// Expect is the outcome the rule must produce: "pass" for a positive case, "fail" for a negative one.
+	Expect string `gorm:"not null" elastic:"type:keyword"`
+
+	// CreatedAt tracks when the test case was added, indexed as a date.
+	CreatedAt time.Time `elastic:"type:date"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// ComplianceRuleVersion is an immutable snapshot of a compliance rule's definition.
+// A new version is recorded whenever the rule's definition changes.
+type ComplianceRuleVersion struct {
+	// ID is a unique identifier for the version, stored as a UUID in the database.
+	ID string `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" elastic:"type:keyword"`
+
+	// RuleID references the rule this version belongs to, indexed as a keyword.
+	RuleID string `gorm:"type:uuid" elastic:"type:keyword"`
+
+	// Version numbers the rule's definitions from 1, indexed as an integer.
+	Version int `elastic:"type:integer"`
+
+	// Name, Description, Type, Params, Pattern, Severity and Scope are the rule's definition as of this version.
+	Name        string         `elastic:"type:text,analyzer:standard"`
+	Description string         `elastic:"type:text,analyzer:standard"`
+	Type        string         `elastic:"type:keyword"`
+	Params      datatypes.JSON `elastic:"type:object"`
+	Pattern     string         `elastic:"type:keyword"`
+	Severity    string         `elastic:"type:keyword"`
+	Scope       datatypes.JSON `json:"scope,omitempty" elastic:"type:object"`
+
+	// EffectiveFrom is when this version replaced the previous one, indexed as a date.
+	EffectiveFrom time.Time `elastic:"type:date"`
+
+	// CreatedAt tracks when the version was recorded, indexed as a date.
+	CreatedAt time.Time `elastic:"type:date"`
+}
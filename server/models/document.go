@@ -7,6 +7,15 @@ import (
 	"gorm.io/gorm"
 )
 
+// Processing statuses of a document as it moves through the upload pipeline
+const (
+	DocumentStatusQueued   = "queued"
+	DocumentStatusUploaded = "uploaded"
+	DocumentStatusOCRDone  = "ocr_done"
+	DocumentStatusAnalyzed = "analyzed"
+	DocumentStatusFailed   = "failed"
+)
+
 // Document represents a legal document with fields for database and search indexing.
 type Document struct {
 	// ID is a unique identifier for the document, stored as a UUID in the database.
@@ -19,18 +28,33 @@ type Document struct {
 	// FileType indicates the type of the file (e.g., "pdf", "docx"), indexed as a keyword.
 	FileType string `elastic:"type:keyword"`
 
-	// OriginalURL is the S3 URL where the original file is stored, indexed as a keyword.
+	// OriginalURL is the API URL the original file can be downloaded from, indexed as a keyword.
 	OriginalURL string `elastic:"type:keyword"`
 
+	// StorageKey is the blob store key of the original file, indexed as a keyword.
+	StorageKey string `elastic:"type:keyword"`
+
 	// OcrText contains the text extracted via OCR, indexed as text for full-text search.
 	OcrText string `elastic:"type:text,analyzer:standard"`
 
+	// OcrProvider records which OCR provider produced OcrText (e.g., "local", "ocrspace"), indexed as a keyword.
+	OcrProvider string `elastic:"type:keyword"`
+
+	// Tags is a JSONB array of labels given at upload (e.g., "vendor", "hr"), matched against rule scopes.
+	Tags datatypes.JSON `elastic:"type:keyword"`
+
 	// ParsedData is a JSONB field for structured data (e.g., clauses), indexed as an object.
 	ParsedData datatypes.JSON `elastic:"type:object"`
 
 	// RiskScore is a calculated score for compliance risk, indexed as a float.
 	RiskScore float64 `elastic:"type:float"`
 
+	// ProcessingStatus is the pipeline stage the document has completed, indexed as a keyword.
+	ProcessingStatus string `elastic:"type:keyword"`
+
+	// ProcessingError explains why processing failed, when ProcessingStatus is "failed".
+	ProcessingError string `elastic:"type:text"`
+
 	// CreatedAt and UpdatedAt track when the document was created and last updated, indexed as dates.
 	CreatedAt time.Time `elastic:"type:date"`
 	UpdatedAt time.Time `elastic:"type:date"`
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// DocumentPage stores the extracted text of one page of a document.
+type DocumentPage struct {
+	// ID is a unique identifier for the page, stored as a UUID in the database.
+	ID string `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" elastic:"type:keyword"`
+
+	// DocumentID references the document the page belongs to, indexed as a keyword.
+	DocumentID string `gorm:"type:uuid" elastic:"type:keyword"`
+
+	// PageNumber is the 1-based position of the page in the document, indexed as an integer.
+	PageNumber int `elastic:"type:integer"`
+
+	// Text is the text extracted from this page, indexed as text for full-text search.
+	Text string `elastic:"type:text,analyzer:standard"`
+
+	// Confidence is the OCR confidence for this page between 0 and 1, indexed as a float.
+	Confidence float64 `elastic:"type:float"`
+
+	// StartOffset and EndOffset locate the page inside Document.OcrText.
+	StartOffset int `elastic:"type:integer"`
+	EndOffset   int `elastic:"type:integer"`
+
+	// CreatedAt tracks when the page was stored, indexed as a date.
+	CreatedAt time.Time `elastic:"type:date"`
+}
@@ -19,6 +19,9 @@ type DocumentRuleResult struct {
 	// RuleID references the compliance rule applied, indexed as a keyword.
 	RuleID string `gorm:"type:uuid" elastic:"type:keyword"`
 
+	// RuleVersionID references the exact rule definition that produced the result, indexed as a keyword.
+	RuleVersionID string `gorm:"type:uuid" elastic:"type:keyword"`
+
 	// Status indicates whether the document passed or failed the rule (e.g., 'pass', 'fail'), indexed as a keyword.
 	Status string `elastic:"type:keyword"`
 
@@ -11,10 +11,15 @@ import (
 
 	model "github.com/Itish41/LegalEagle/models"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
-// CreateActionItems generates action items for failed compliance rules
-func (s *DocumentService) CreateActionItems(doc model.Document) error {
+// CreateActionItems records a DocumentRuleResult for every rule in the
+// analysis, including those out of the document's scope, and an action item
+// for each failed rule and for each indeterminate one, which needs a manual
+// review. All writes go through tx so they commit or roll back with the
+// document.
+func (s *DocumentService) CreateActionItems(tx *gorm.DB, doc model.Document) error {
 	var results []map[string]interface{}
 	if err := json.Unmarshal([]byte(doc.ParsedData), &results); err != nil {
 		log.Printf("Error unmarshaling parsed_data: %v", err)
@@ -23,61 +28,88 @@ func (s *DocumentService) CreateActionItems(doc model.Document) error {
 
 	for _, result := range results {
 		status, ok := result["status"].(string)
-		if !ok || status != "fail" {
-			continue // Skip non-failed rules
+		if !ok {
+			log.Printf("Missing status in compliance result with %d fields", len(result))
+			continue
 		}
 
 		ruleName, ok := result["rule_name"].(string)
 		if !ok {
-			log.Printf("Missing rule_name in compliance result: %+v", result)
+			log.Printf("Missing rule_name in compliance result with %d fields", len(result))
 			continue
 		}
-		log.Printf("Processing failed rule: %s", ruleName)
 
 		var rule model.ComplianceRule
-		if err := s.db.Where("name = ?", ruleName).First(&rule).Error; err != nil {
+		query := tx.Where("name = ?", ruleName)
+		if ruleID, ok := result["rule_id"].(string); ok && ruleID != "" {
+			query = tx.Where("id = ?", ruleID) // Survives renames since the analysis
+		}
+		if err := query.First(&rule).Error; err != nil {
 			log.Printf("Rule %s not found in compliance_rules: %v", ruleName, err)
 			continue
 		}
 
 		if rule.ID == "" {
-			log.Printf("Invalid RuleID for %s; skipping action item creation", ruleName)
+			log.Printf("Invalid RuleID for %s; skipping its result", ruleName)
 			continue
 		}
 
-		explanation, _ := result["explanation"].(string)
-		severity, _ := result["severity"].(string)
-		action := model.ActionItem{
-			DocumentID:  doc.ID,
-			RuleID:      rule.ID,
-			Description: fmt.Sprintf("Address %s non-compliance: %s", ruleName, explanation),
-			Priority:    strings.Title(strings.ToLower(severity)), // Use severity from parsed_data
-			Status:      "pending",
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
-			// AssignedTo is intentionally left empty
-			DueDate: time.Now().AddDate(0, 1, 0), // Default due date: 1 month from now
+		if status == RuleStatusFail || status == RuleStatusIndeterminate {
+			log.Printf("Processing %s rule: %s", status, ruleName)
+			explanation, _ := result["explanation"].(string)
+			severity, _ := result["severity"].(string)
+			description := fmt.Sprintf("Address %s non-compliance: %s", ruleName, explanation)
+			if status == RuleStatusIndeterminate {
+				// Nobody knows whether the rule holds, so a person has to check
+				description = fmt.Sprintf("Review %s manually, it could not be evaluated: %s", ruleName, explanation)
+			}
+			action := model.ActionItem{
+				DocumentID:  doc.ID,
+				RuleID:      rule.ID,
+				Description: description,
+				Priority:    strings.Title(strings.ToLower(severity)), // Use severity from parsed_data
+				Status:      "pending",
+				CreatedAt:   time.Now(),
+				UpdatedAt:   time.Now(),
+				// AssignedTo is intentionally left empty
+				DueDate: time.Now().AddDate(0, 1, 0), // Default due date: 1 month from now
+			}
+
+			// Use Omit to skip the AssignedTo field
+			if err := tx.Omit("AssignedTo").Create(&action).Error; err != nil {
+				log.Printf("Error creating action item: %v", err)
+				return err
+			}
+			log.Printf("Action item created for %s rule %s, document %s", status, ruleName, doc.ID)
 		}
 
-		// Use Omit to skip the AssignedTo field
-		if err := s.db.Omit("AssignedTo").Create(&action).Error; err != nil {
-			log.Printf("Error creating action item: %v", err)
-			return err
+		versionID, _ := result["rule_version_id"].(string)
+		if versionID == "" {
+			// Analyzed before rules were versioned
+			versions, err := s.currentRuleVersions(tx, []model.ComplianceRule{rule})
+			if err != nil {
+				return err
+			}
+			versionID = versions[rule.ID].ID
 		}
-		log.Printf("Action item created: %s for document %s", action.Description, doc.ID)
 
 		docResult := model.DocumentRuleResult{
-			DocumentID: doc.ID,
-			RuleID:     rule.ID,
-			Status:     "fail",
-			Details:    datatypes.JSON(marshalResult(result)),
-			CreatedAt:  time.Now(),
+			DocumentID:    doc.ID,
+			RuleID:        rule.ID,
+			RuleVersionID: versionID,
+			Status:        status,
+			Details:       datatypes.JSON(marshalResult(result)),
+			CreatedAt:     time.Now(),
 		}
-		if err := s.db.Create(&docResult).Error; err != nil {
+		create := tx
+		if versionID == "" {
+			create = tx.Omit("RuleVersionID") // Leave it NULL for a rule that has no version
+		}
+		if err := create.Create(&docResult).Error; err != nil {
 			log.Printf("Error creating document rule result: %v", err)
 			return err
 		}
-		log.Printf("Document rule result created for rule %s, document %s", ruleName, doc.ID)
+		log.Printf("Document rule result %s created for rule %s, document %s", status, ruleName, doc.ID)
 	}
 	return nil
 }
@@ -110,12 +142,11 @@ func (s *DocumentService) AssignAndNotifyActionItem(actionID string, email strin
 	}
 	log.Printf("[AssignAndNotifyActionItem] Updated AssignedTo to %s for action item %s", email, actionID)
 
-	// Gmail SMTP configuration.
-	// Replace these with environment variables or secure config values in production.
-	from := "itish.srivastava@think41.com" // your Gmail address
-	password := "reuppqgmshnkqsoj"         // your Gmail app-specific password
-	smtpHost := "smtp.gmail.com"
-	smtpPort := "587"
+	if !s.smtp.Enabled() {
+		log.Printf("[AssignAndNotifyActionItem] SMTP is not configured; skipping email for action item %s", actionID)
+		return nil
+	}
+	from := s.smtp.Sender()
 
 	/// Prepare the email content.
 	subject := fmt.Sprintf("Action Item Assigned: %s", action.Description)
@@ -144,10 +175,10 @@ func (s *DocumentService) AssignAndNotifyActionItem(actionID string, email strin
 		body)
 
 	// Set up authentication.
-	auth := smtp.PlainAuth("", from, password, smtpHost)
+	auth := smtp.PlainAuth("", s.smtp.Username, s.smtp.Password, s.smtp.Host)
 
 	// Send the email.
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{email}, message)
+	err := smtp.SendMail(s.smtp.Host+":"+s.smtp.Port, auth, from, []string{email}, message)
 	if err != nil {
 		log.Printf("[AssignAndNotifyActionItem] Error sending email for action item %s: %v", actionID, err)
 		return err
@@ -347,6 +378,6 @@ func extractRuleName(explanation string) string {
 	}
 
 	// Final fallback
-	log.Printf("Could not extract rule name from explanation: %s", explanation)
+	log.Printf("Could not extract rule name from a %d character explanation", len(explanation))
 	return "Unknown Rule"
 }
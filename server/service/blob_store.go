@@ -0,0 +1,336 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Itish41/LegalEagle/config"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Storage backend names accepted by STORAGE_BACKEND
+const (
+	StorageBackendS3    = "s3"
+	StorageBackendLocal = "local"
+)
+
+var (
+	// ErrBlobNotFound is returned when no object exists under a key
+	ErrBlobNotFound = errors.New("blob not found")
+	// ErrPresignNotSupported is returned by stores that cannot hand out direct URLs
+	ErrPresignNotSupported = errors.New("presigned URLs are not supported by this store")
+)
+
+// BlobInfo describes a stored object
+type BlobInfo struct {
+	Key         string
+	ContentType string
+	Size        int64
+}
+
+// BlobStore keeps the original uploaded files
+type BlobStore interface {
+	// Put stores body under key, replacing any existing object.
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+	// Get opens the object stored under key; the caller closes the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error)
+	// Delete removes the object; deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// PresignGet returns a URL that allows downloading key until ttl expires.
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+	// List returns the keys of all objects whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// documentBlobPrefix is the key prefix shared by all document originals
+const documentBlobPrefix = "documents/"
+
+// unsafeKeyChars matches characters we do not allow in object key file names
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// documentBlobKey builds the object key for a document's original file
+func documentBlobKey(documentID, filename string) string {
+	name := unsafeKeyChars.ReplaceAllString(filepath.Base(filename), "_")
+	if name == "" || name == "." || name == "_" {
+		name = "original"
+	}
+	return fmt.Sprintf("%s%s/%s", documentBlobPrefix, documentID, name)
+}
+
+// documentIDFromBlobKey returns the document ID a key was derived from, if any
+func documentIDFromBlobKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, documentBlobPrefix)
+	if !ok {
+		return "", false
+	}
+	id, _, ok := strings.Cut(rest, "/")
+	return id, ok && id != ""
+}
+
+// S3BlobStore stores objects in an S3-compatible bucket such as Supabase Storage
+type S3BlobStore struct {
+	client *s3.S3
+	bucket string
+	// Public uploads objects with a public-read ACL; objects are private otherwise.
+	Public bool
+}
+
+// S3BlobStoreConfig holds the connection settings for S3BlobStore
+type S3BlobStoreConfig struct {
+	Region    string
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Public    bool
+}
+
+// NewS3BlobStore connects to the bucket described by cfg
+func NewS3BlobStore(cfg S3BlobStoreConfig) (*S3BlobStore, error) {
+	if cfg.Region == "" || cfg.Endpoint == "" || cfg.AccessKey == "" || cfg.SecretKey == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("missing required S3 storage configuration")
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String(cfg.Region),
+		Endpoint:         aws.String(cfg.Endpoint),
+		DisableSSL:       aws.Bool(false), // Changed to false for most cloud providers
+		Credentials:      credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+
+	return &S3BlobStore{client: s3.New(sess), bucket: cfg.Bucket, Public: cfg.Public}, nil
+}
+
+// Put uploads body to the bucket
+func (b *S3BlobStore) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	acl := s3.ObjectCannedACLPrivate
+	if b.Public {
+		acl = s3.ObjectCannedACLPublicRead
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+		ACL:    aws.String(acl),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	if _, err := b.client.PutObjectWithContext(ctx, input); err != nil {
+		return fmt.Errorf("failed to upload %s to S3: %w", key, err)
+	}
+	return nil
+}
+
+// Get downloads an object from the bucket
+func (b *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error) {
+	out, err := b.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil, ErrBlobNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to download %s from S3: %w", key, err)
+	}
+
+	return out.Body, &BlobInfo{
+		Key:         key,
+		ContentType: aws.StringValue(out.ContentType),
+		Size:        aws.Int64Value(out.ContentLength),
+	}, nil
+}
+
+// Delete removes an object from the bucket
+func (b *S3BlobStore) Delete(ctx context.Context, key string) error {
+	_, err := b.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s from S3: %w", key, err)
+	}
+	return nil
+}
+
+// PresignGet returns a short-lived signed download URL
+func (b *S3BlobStore) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	req, _ := b.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	req.SetContext(ctx)
+	url, err := req.Presign(ttl)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign %s: %w", key, err)
+	}
+	return url, nil
+}
+
+// List pages through the bucket listing
+func (b *S3BlobStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := b.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s in S3: %w", prefix, err)
+	}
+	return keys, nil
+}
+
+// LocalBlobStore stores objects as files below a root directory
+type LocalBlobStore struct {
+	root string
+}
+
+// NewLocalBlobStore creates the root directory if needed
+func NewLocalBlobStore(root string) (*LocalBlobStore, error) {
+	if root == "" {
+		root = "storage"
+	}
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory %s: %w", root, err)
+	}
+	return &LocalBlobStore{root: root}, nil
+}
+
+// path maps a key to a file below root, rejecting keys that escape it
+func (b *LocalBlobStore) path(key string) (string, error) {
+	// Cleaning an absolute path resolves every ".." inside it
+	cleaned := filepath.Clean("/" + key)
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(b.root, filepath.FromSlash(cleaned)), nil
+}
+
+// Put writes body to disk
+func (b *LocalBlobStore) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", key, err)
+	}
+	if err := os.WriteFile(path, body, 0o640); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get opens the file stored under key
+func (b *LocalBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", key, err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return f, &BlobInfo{Key: key, ContentType: contentType, Size: stat.Size()}, nil
+}
+
+// Delete removes the file stored under key
+func (b *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// PresignGet is not supported; files are streamed through the API instead
+func (b *LocalBlobStore) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+// List walks the root directory for files whose key starts with prefix
+func (b *LocalBlobStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(b.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(b.root, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+	}
+	return keys, nil
+}
+
+// NewBlobStore builds the store selected by the storage configuration
+func NewBlobStore(cfg config.StorageConfig) (BlobStore, error) {
+	switch backend := strings.ToLower(cfg.Backend); backend {
+	case "", StorageBackendS3:
+		return NewS3BlobStore(S3BlobStoreConfig{
+			Region:    cfg.S3.Region,
+			Endpoint:  cfg.S3.Endpoint,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			Bucket:    cfg.S3.Bucket,
+			Public:    cfg.PublicRead,
+		})
+	case StorageBackendLocal:
+		log.Printf("Storing documents on local disk in %q", cfg.LocalDir)
+		return NewLocalBlobStore(cfg.LocalDir)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
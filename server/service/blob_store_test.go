@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentBlobKey(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"contract.pdf", "documents/doc-1/contract.pdf"},
+		{"Master Services (v2).pdf", "documents/doc-1/Master_Services_v2_.pdf"},
+		{"../../etc/passwd", "documents/doc-1/passwd"},
+		{"", "documents/doc-1/original"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			assert.Equal(t, tt.want, documentBlobKey("doc-1", tt.filename))
+		})
+	}
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store, err := NewLocalBlobStore(root)
+	require.NoError(t, err)
+
+	key := documentBlobKey("doc-1", "contract.pdf")
+	require.NoError(t, store.Put(ctx, key, []byte("%PDF-1.4"), "application/pdf"))
+	assert.FileExists(t, filepath.Join(root, "documents", "doc-1", "contract.pdf"))
+
+	body, info, err := store.Get(ctx, key)
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "%PDF-1.4", string(data))
+	assert.Equal(t, "application/pdf", info.ContentType)
+	assert.Equal(t, int64(8), info.Size)
+
+	require.NoError(t, store.Put(ctx, "exports/report.csv", []byte("csv"), "text/csv"))
+	keys, err := store.List(ctx, documentBlobPrefix)
+	require.NoError(t, err)
+	assert.Equal(t, []string{key}, keys)
+
+	_, err = store.PresignGet(ctx, key, time.Minute)
+	assert.ErrorIs(t, err, ErrPresignNotSupported)
+
+	require.NoError(t, store.Delete(ctx, key))
+	require.NoError(t, store.Delete(ctx, key), "deleting a missing key is not an error")
+	_, _, err = store.Get(ctx, key)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
+
+func TestLocalBlobStore_StaysInsideRoot(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	store, err := NewLocalBlobStore(filepath.Join(parent, "blobs"))
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "../../escaped.txt", []byte("data"), ""))
+	assert.NoFileExists(t, filepath.Join(parent, "escaped.txt"))
+	assert.FileExists(t, filepath.Join(parent, "blobs", "escaped.txt"))
+	assert.Error(t, store.Put(ctx, "/", []byte("data"), ""))
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	model "github.com/Itish41/LegalEagle/models"
+)
+
+// Chunking used when the configuration does not set it
+const (
+	defaultChunkMaxChars    = 12000
+	defaultChunkConcurrency = 3
+)
+
+// ChunkOptions controls how long documents are split for LLM analysis
+type ChunkOptions struct {
+	// MaxChars is the longest part sent in one request
+	MaxChars int
+	// Concurrency is how many parts of one document are judged at once
+	Concurrency int
+}
+
+// NewChunkOptions returns chunk options, using the defaults for values that are not positive
+func NewChunkOptions(maxChars, concurrency int) ChunkOptions {
+	if maxChars < 1 {
+		maxChars = defaultChunkMaxChars
+	}
+	if concurrency < 1 {
+		concurrency = defaultChunkConcurrency
+	}
+	return ChunkOptions{MaxChars: maxChars, Concurrency: concurrency}
+}
+
+// TextChunk is a part of a document's text and where it starts and ends in it
+type TextChunk struct {
+	Start int
+	End   int
+	Text  string
+}
+
+// clauseStart matches a line that opens a clause, e.g. "12.", "4.2 Term", "Section 3" or "ARTICLE IV"
+var clauseStart = regexp.MustCompile(`(?im)^[ \t]*(?:(?:section|article|clause)\s+[0-9ivxlc]+|\d+(?:\.\d+)*[.)]?\s)`)
+
+// chunkBoundaries returns the offsets where a chunk may start: after a page or
+// paragraph break, or at the start of a clause
+func chunkBoundaries(text string) []int {
+	var boundaries []int
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], pageSeparator)
+		if i < 0 {
+			break
+		}
+		offset += i + len(pageSeparator)
+		boundaries = append(boundaries, offset)
+	}
+	for _, loc := range clauseStart.FindAllStringIndex(text, -1) {
+		boundaries = append(boundaries, loc[0])
+	}
+	sort.Ints(boundaries)
+	return boundaries
+}
+
+// splitIntoChunks splits text into parts of at most maxChars bytes, cutting at
+// the last page or clause boundary that fits, else at a space
+func splitIntoChunks(text string, maxChars int) []TextChunk {
+	if len(text) <= maxChars {
+		return []TextChunk{{Start: 0, End: len(text), Text: text}}
+	}
+
+	boundaries := chunkBoundaries(text)
+	var chunks []TextChunk
+	for start := 0; start < len(text); {
+		end := len(text)
+		if end-start > maxChars {
+			limit := start + maxChars
+			// The last boundary after start that keeps the chunk within maxChars
+			i := sort.SearchInts(boundaries, limit+1) - 1
+			switch {
+			case i >= 0 && boundaries[i] > start:
+				end = boundaries[i]
+			case strings.LastIndexAny(text[start:limit], " \n\t") > 0:
+				end = start + strings.LastIndexAny(text[start:limit], " \n\t") + 1
+			default:
+				end = limit
+				for end > start+1 && !utf8.RuneStart(text[end]) {
+					end--
+				}
+			}
+		}
+		if strings.TrimSpace(text[start:end]) != "" {
+			chunks = append(chunks, TextChunk{Start: start, End: end, Text: text[start:end]})
+		}
+		start = end
+	}
+	return chunks
+}
+
+// locateQuote finds a quote in text, ignoring case and differences in whitespace
+func locateQuote(text, quote string) (TextSpan, bool) {
+	words := strings.Fields(quote)
+	if len(words) == 0 {
+		return TextSpan{}, false
+	}
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+	loc := regexp.MustCompile(`(?i)` + strings.Join(words, `\s+`)).FindStringIndex(text)
+	if loc == nil {
+		return TextSpan{}, false
+	}
+	return TextSpan{Start: loc[0], End: loc[1], Text: text[loc[0]:loc[1]]}, true
+}
+
+// detectViolatedRulesInChunks judges the chunks of a document concurrently,
+// waiting for the rate limiter before each request, and merges the verdicts: a
+// rule is violated when any chunk violates it or when every chunk is missing
+// the clause it requires, and its spans are the union of those found in each
+// chunk. Chunks whose reply stays invalid are reported in
+// Invalid; if any chunk cannot be judged for another reason, neither can the
+// document.
+func detectViolatedRulesInChunks(ctx context.Context, llm LLMClient, limiter *RateLimiter, chunks []TextChunk, rules []model.ComplianceRule, concurrency int) (*ruleDetection, error) {
+	if llm == nil {
+		return nil, errors.New("no LLM client configured")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	detections := make([]*ruleDetection, len(chunks))
+	errs := make([]error, len(chunks))
+	invalid := make([]error, len(chunks))
+	sem := make(chan struct{}, max(1, concurrency))
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := limiter.Wait(ctx, "groq_api_call"); err != nil {
+				errs[i] = fmt.Errorf("part %d of %d: %w", i+1, len(chunks), err)
+				return
+			}
+			detection, err := detectViolatedRules(ctx, llm, chunk.Text, i+1, len(chunks), rules)
+			detections[i] = detection
+			if errors.Is(err, ErrInvalidLLMOutput) {
+				invalid[i] = fmt.Errorf("part %d of %d: %w", i+1, len(chunks), err)
+				return
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("part %d of %d: %w", i+1, len(chunks), err)
+				cancel()
+				return
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Report the failure that stopped the others rather than their cancellation
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	merged := &ruleDetection{Spans: map[string][]TextSpan{}, Invalid: errors.Join(invalid...)}
+	for i, detection := range detections {
+		if merged.Model == "" {
+			merged.Model = detection.Model
+		}
+		merged.Usage.Requests += detection.Usage.Requests
+		merged.Usage.PromptTokens += detection.Usage.PromptTokens
+		merged.Usage.CompletionTokens += detection.Usage.CompletionTokens
+		merged.Usage.TotalTokens += detection.Usage.TotalTokens
+		for name, spans := range detection.Spans {
+			for _, span := range spans {
+				span.Start += chunks[i].Start
+				span.End += chunks[i].Start
+				merged.Spans[name] = append(merged.Spans[name], span)
+			}
+		}
+	}
+	for _, rule := range rules {
+		violated, missing := false, 0
+		for _, detection := range detections {
+			violated = violated || contains(detection.Violated, rule.Name)
+			if contains(detection.Missing, rule.Name) {
+				missing++
+			}
+		}
+		// A required clause only needs to appear in one part
+		if violated || missing == len(chunks) {
+			merged.Violated = append(merged.Violated, rule.Name)
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// longAgreement builds a document of numbered clauses over several pages
+func longAgreement() string {
+	filler := strings.Repeat("The parties shall act in good faith and keep records. ", 6)
+	pages := []string{
+		"1. Definitions\n" + filler + "\n2. Scope\n" + filler,
+		"3. Payment\n" + filler + "\n4. Termination\nEither party may terminate this Agreement at any time without notice.\n" + filler,
+		"5. Governing Law\n" + filler,
+	}
+	return newOCRResult("local", pages, make([]float64, len(pages))).Text
+}
+
+func TestSplitIntoChunks(t *testing.T) {
+	text := longAgreement()
+	assert.Len(t, splitIntoChunks(text, len(text)), 1, "short documents are sent whole")
+
+	chunks := splitIntoChunks(text, 500)
+	require.Greater(t, len(chunks), 2)
+	for i, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk.Text), 500)
+		assert.Equal(t, text[chunk.Start:chunk.End], chunk.Text)
+		if i > 0 {
+			assert.Equal(t, chunks[i-1].End, chunk.Start, "chunks cover the text without gaps")
+			assert.Regexp(t, `^\d\. `, chunk.Text, "chunks start at a clause or page")
+		}
+	}
+	assert.Equal(t, len(text), chunks[len(chunks)-1].End)
+
+	words := strings.Repeat("word ", 100)
+	for _, chunk := range splitIntoChunks(words, 42) {
+		assert.LessOrEqual(t, len(chunk.Text), 42)
+		assert.True(t, strings.HasPrefix(chunk.Text, "word"), "without boundaries chunks are cut at a space")
+	}
+}
+
+func TestDetectViolatedRulesInChunks(t *testing.T) {
+	text := longAgreement()
+	chunks := splitIntoChunks(text, 500)
+	rules := []model.ComplianceRule{{ID: "r1", Name: "No Termination For Convenience"}, {ID: "r2", Name: "Payment Terms"}}
+
+	var active, peak int32
+	llm := NewFakeLLMClient()
+	llm.Handler = func(req LLMRequest) (string, error) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		prompt := req.Messages[0].Content
+		assert.Contains(t, prompt, "of a longer document")
+		if strings.Contains(prompt, "Either party may terminate") {
+			return `{"violated_rules": ["No Termination For Convenience"], "evidence": {"No Termination For Convenience": "may terminate this agreement   at any time"}}`, nil
+		}
+		return `{"violated_rules": []}`, nil
+	}
+
+	detection, err := detectViolatedRulesInChunks(context.Background(), llm, NewRateLimiter(100, time.Minute), chunks, rules, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"No Termination For Convenience"}, detection.Violated, "a rule fails when any part violates it")
+	assert.Equal(t, len(chunks), detection.Usage.Requests)
+	assert.Len(t, llm.Requests, len(chunks))
+	assert.LessOrEqual(t, peak, int32(2))
+
+	spans := detection.Spans["No Termination For Convenience"]
+	require.Len(t, spans, 1)
+	assert.Equal(t, "may terminate this Agreement at any time", text[spans[0].Start:spans[0].End], "spans are offsets into the whole text")
+
+	// One part that cannot be judged means the document cannot be either
+	llm.Handler = func(req LLMRequest) (string, error) {
+		if strings.Contains(req.Messages[0].Content, "Governing Law") {
+			return "", errors.New("context length exceeded")
+		}
+		return `{"violated_rules": []}`, nil
+	}
+	_, err = detectViolatedRulesInChunks(context.Background(), llm, NewRateLimiter(100, time.Minute), chunks, rules, 2)
+	assert.ErrorContains(t, err, "context length exceeded")
+	assert.ErrorContains(t, err, "part ")
+}
+
+func TestDetectViolatedRulesInChunks_RequiredClause(t *testing.T) {
+	text := longAgreement()
+	chunks := splitIntoChunks(text, 500)[:3]
+	rules := []model.ComplianceRule{{ID: "r1", Name: "Confidentiality Clause", Description: "The agreement must contain a confidentiality clause"}}
+
+	// No part contains the clause
+	llm := NewFakeLLMClient()
+	llm.Handler = func(req LLMRequest) (string, error) {
+		assert.Contains(t, req.Messages[0].Content, "missing_rules")
+		return `{"violated_rules": [], "missing_rules": ["Confidentiality Clause"]}`, nil
+	}
+	detection, err := detectViolatedRulesInChunks(context.Background(), llm, NewRateLimiter(100, time.Minute), chunks, rules, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Confidentiality Clause"}, detection.Violated, "a clause missing from every part is missing from the document")
+
+	// One part contains it
+	llm.Handler = func(req LLMRequest) (string, error) {
+		if strings.Contains(req.Messages[0].Content, "part 2 of 3") {
+			return `{"violated_rules": []}`, nil
+		}
+		return `{"violated_rules": [], "missing_rules": ["Confidentiality Clause"]}`, nil
+	}
+	detection, err = detectViolatedRulesInChunks(context.Background(), llm, NewRateLimiter(100, time.Minute), chunks, rules, 2)
+	require.NoError(t, err)
+	assert.Empty(t, detection.Violated, "a clause found in one part is present")
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+	limiter := NewRateLimiter(1, 50*time.Millisecond)
+	require.NoError(t, limiter.Wait(context.Background(), "k"))
+
+	start := time.Now()
+	require.NoError(t, limiter.Wait(context.Background(), "k"))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond, "the second request waits for the next window")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, limiter.Wait(ctx, "k"), context.Canceled)
+}
@@ -1,21 +1,18 @@
 package services
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"os"
-	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	// "github.com/Itish41/LegalEagle/models
 	model "github.com/Itish41/LegalEagle/models"
+	"gorm.io/gorm"
 )
 
 // RateLimiter struct to manage API call rate limiting
@@ -53,19 +50,50 @@ func (rl *RateLimiter) Allow(key string) bool {
 	return rl.requestCount[key] <= rl.limit
 }
 
+// Wait blocks until a request is allowed, or the context ends
+func (rl *RateLimiter) Wait(ctx context.Context, key string) error {
+	for {
+		rl.mu.Lock()
+		if time.Since(rl.lastReset) > rl.window {
+			rl.requestCount = make(map[string]int)
+			rl.lastReset = time.Now()
+		}
+		if rl.requestCount[key] < rl.limit {
+			rl.requestCount[key]++
+			rl.mu.Unlock()
+			return nil
+		}
+		wait := rl.window - time.Since(rl.lastReset)
+		rl.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
 // Global rate limiters for different operations
 var (
 	groqRateLimiter = NewRateLimiter(50, 1*time.Minute)  // 50 Groq API calls per minute
 	ruleRateLimiter = NewRateLimiter(100, 1*time.Minute) // 100 rule-related operations per minute
 )
 
-func (s *DocumentService) AddComplianceRule(rule *model.ComplianceRule) error {
+// AddComplianceRule creates a rule; names must be unique among active rules
+func (s *DocumentService) AddComplianceRule(ctx context.Context, rule *model.ComplianceRule) error {
 	// Rate limit rule additions
 	if !ruleRateLimiter.Allow("rule_addition") {
 		return fmt.Errorf("rate limit exceeded for rule additions")
 	}
 
-	if err := s.db.Create(rule).Error; err != nil {
+	tested, err := s.testRuleChange(ctx, "", *rule)
+	if err == nil {
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			return s.createComplianceRule(ctx, tx, rule, tested)
+		})
+	}
+	if err != nil {
 		log.Printf("Error saving compliance rule: %v", err)
 		return err
 	}
@@ -73,43 +101,136 @@ func (s *DocumentService) AddComplianceRule(rule *model.ComplianceRule) error {
 	return nil
 }
 
-// DetermineApplicableRules uses Groq to suggest relevant rules
-func (s *DocumentService) DetermineApplicableRules(ocrText string) ([]string, error) {
-	// Rate limit Groq API calls
-	if !groqRateLimiter.Allow("groq_api_call") {
-		log.Println("Rate limit exceeded for Groq API calls locally")
-		return s.fallbackRuleExtraction(ocrText, nil), nil
+// createComplianceRule checks a new rule's name, definition and test cases and
+// stores it together with its first version. tested are the cases
+// testRuleChange already ran for it.
+func (s *DocumentService) createComplianceRule(ctx context.Context, tx *gorm.DB, rule *model.ComplianceRule, tested []model.ComplianceRuleTestCase) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	rule.Type = normalizeRuleType(rule.Type)
+	rule.Severity = normalizeSeverity(rule.Severity)
+	rule.ArchivedAt = gorm.DeletedAt{}
+	if err := s.checkRuleName(tx, rule.Name, ""); err != nil {
+		return err
+	}
+	warnings, err := s.checkRuleDefinition(*rule)
+	if err != nil {
+		return err
+	}
+	rule.Warnings = warnings
+	if err := checkRuleTestCases(rule.TestCases); err != nil {
+		return err
+	}
+	if err := s.retestRule(ctx, *rule, rule.TestCases, tested); err != nil {
+		return err
 	}
 
-	// Fetch all rules from the database
-	allRules, err := s.GetAllComplianceRules()
+	cases := rule.TestCases
+	if err := tx.Omit("TestCases").Create(rule).Error; err != nil {
+		return err
+	}
+	stored, err := s.replaceRuleTestCases(tx, rule.ID, cases)
 	if err != nil {
-		log.Printf("ERROR retrieving compliance rules: %v", err)
-		return nil, err
+		return err
+	}
+	rule.TestCases = stored
+	_, err = s.recordRuleVersion(tx, *rule, rule.CreatedAt)
+	return err
+}
+
+// PromptVersionRuleDetection identifies the rule detection prompt in stored
+// results; change it whenever the prompt changes
+const PromptVersionRuleDetection = "rule_detection/v4"
+
+// ruleDetection is the LLM's verdict on a document or one part of it
+type ruleDetection struct {
+	Violated []string
+	// Missing lists the rules requiring a clause this part does not contain.
+	// Only parts of a longer document report them.
+	Missing []string
+	// Spans locates the passages the LLM quoted for violated rules, by rule name
+	Spans map[string][]TextSpan
+	// Invalid explains why parts of the document could not be judged
+	Invalid error
+	Model   string
+	Usage   LLMUsage
+}
+
+// ruleDetectionReply is the reply the rule detection prompt asks for
+type ruleDetectionReply struct {
+	ViolatedRules []string          `json:"violated_rules"`
+	MissingRules  []string          `json:"missing_rules,omitempty"`
+	Evidence      map[string]string `json:"evidence,omitempty"`
+}
+
+// ruleDetectionSchema describes a ruleDetectionReply naming only the given rules
+func ruleDetectionSchema(ruleNames []string) *LLMSchema {
+	return &LLMSchema{Name: "rule_detection", Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"violated_rules": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "enum": ruleNames}},
+			"missing_rules":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "enum": ruleNames}},
+			"evidence":       map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}},
+		},
+		"required":             []string{"violated_rules"},
+		"additionalProperties": false,
+	}}
+}
+
+// validateRuleDetectionReply checks what ruleDetectionSchema requires. Rule
+// names the prompt did not list are dropped later rather than repaired, so a
+// model naming other rules cannot change their outcome.
+func validateRuleDetectionReply(reply ruleDetectionReply) error {
+	if reply.ViolatedRules == nil {
+		return errors.New("violated_rules is required")
+	}
+	for i, name := range reply.ViolatedRules {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("violated_rules[%d] is empty", i)
+		}
+	}
+	for i, name := range reply.MissingRules {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("missing_rules[%d] is empty", i)
+		}
+	}
+	return nil
+}
+
+// ruleDetectionMaxTokens leaves room in the answer for a quote per rule
+func ruleDetectionMaxTokens(rules int) int {
+	return min(250+50*rules, 2000)
+}
+
+// detectViolatedRules asks the LLM which of the rules a text violates. When the
+// text is part of a longer document, part and parts say which one it is. A
+// reply that stays invalid after repairs returns ErrInvalidLLMOutput with a
+// detection holding only the model and usage.
+func detectViolatedRules(ctx context.Context, llm LLMClient, ocrText string, part, parts int, rules []model.ComplianceRule) (*ruleDetection, error) {
+	if llm == nil {
+		return nil, errors.New("no LLM client configured")
 	}
-	log.Printf("Retrieved %d compliance rules from database", len(allRules))
 
 	// Build rule details and names
 	var ruleDetails []string
-	ruleNames := make([]string, len(allRules))
-	for i, rule := range allRules {
+	ruleNames := make([]string, len(rules))
+	for i, rule := range rules {
 		ruleDetails = append(ruleDetails, fmt.Sprintf("%s: %s (Pattern: %s)", rule.Name, rule.Description, rule.Pattern))
 		ruleNames[i] = rule.Name
 	}
-	log.Println("Rule details for Groq: ", ruleDetails)
 
-	// Validate Groq API Key
-	groqAPIKey := os.Getenv("VITE_GROQ_API_KEY")
-	if groqAPIKey == "" {
-		log.Println("ERROR: VITE_GROQ_API_KEY environment variable is not set")
-		return nil, fmt.Errorf("VITE_GROQ_API_KEY environment variable is not set")
+	// A part cannot show that a clause is missing from the whole document, so
+	// parts report missing clauses separately and the caller decides
+	partNote, missingFormat := "", ""
+	if parts > 1 {
+		partNote = fmt.Sprintf("\n    The text is part %d of %d of a longer document. Only report a rule as violated when this part contains text that violates it. When a rule requires a clause that this part does not contain, list the rule in \"missing_rules\" instead; another part may contain it.\n", part, parts)
+		missingFormat = "\n        \"missing_rules\": [\"Rule3\", ...],"
 	}
 
 	// Construct prompt
 	prompt := fmt.Sprintf(`
     Analyze the following document text and determine which legal compliance rules from this list are violated:
     %s
-
+%s
     Document Text:
     %s
 
@@ -119,180 +240,53 @@ func (s *DocumentService) DetermineApplicableRules(ocrText string) ([]string, er
     3. Return a JSON object with a "violated_rules" array containing only the names of violated rules.
     4. If no rules are violated, return an empty array.
     5. Ensure rule names match exactly as provided.
+    6. For each violated rule, add to "evidence" a short quote copied exactly from the text that shows the violation, if there is one.
 
     Response Format:
     {
-        "violated_rules": ["Rule1", "Rule2", ...]
+        "violated_rules": ["Rule1", "Rule2", ...],%s
+        "evidence": {"Rule1": "quoted text", ...}
     }
-    `, strings.Join(ruleDetails, "\n"), ocrText)
-	log.Printf("Groq API Prompt: %s", prompt)
-
-	// Prepare request body
-	reqBody, err := json.Marshal(map[string]interface{}{
-		"messages": []map[string]string{
-			{"role": "user", "content": prompt},
-		},
-		"model":       "llama-3.3-70b-versatile",
-		"temperature": 0.7,
-		"max_tokens":  250,
-		"response_format": map[string]string{
-			"type": "json_object",
-		},
-	})
-	if err != nil {
-		log.Printf("ERROR creating request body: %v", err)
-		return s.fallbackRuleExtraction(ocrText, ruleNames), nil
-	}
-
-	// Retry logic for rate limiting
-	const maxRetries = 3
-	var resp *http.Response
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		req, err := http.NewRequest("POST", "https://api.groq.com/openai/v1/chat/completions", bytes.NewBuffer(reqBody))
-		if err != nil {
-			log.Printf("ERROR creating Groq request: %v", err)
-			return nil, fmt.Errorf("failed to create Groq request: %w", err)
-		}
-		req.Header.Set("Authorization", "Bearer "+groqAPIKey)
-		req.Header.Set("Content-Type", "application/json")
-
-		client := &http.Client{Timeout: 30 * time.Second}
-		resp, err = client.Do(req)
-		if err == nil && resp.StatusCode != 429 { // 429 is Too Many Requests
-			break
-		}
-		if err != nil {
-			log.Printf("ERROR sending Groq request (attempt %d): %v", attempt+1, err)
-		} else if resp.StatusCode == 429 {
-			log.Printf("Rate limit hit (attempt %d), status: %s", attempt+1, resp.Status)
-			resp.Body.Close()
-		}
-		if attempt < maxRetries-1 {
-			waitTime := time.Duration(10*(attempt+1)) * time.Second // Exponential backoff: 10s, 20s, 30s
-			log.Printf("Retrying in %v...", waitTime)
-			time.Sleep(waitTime)
-		}
+    `, strings.Join(ruleDetails, "\n"), partNote, ocrText, missingFormat)
+	// The prompt holds the document text, so only its size is logged
+	log.Printf("[detectViolatedRules] Judging %d rules against part %d of %d (%d characters)", len(rules), part, parts, len(ocrText))
+
+	ruleResponse, resp, err := completeStructured(ctx, llm, LLMRequest{
+		CallSite:    LLMCallSiteRuleDetection,
+		Messages:    []LLMMessage{{Role: "user", Content: prompt}},
+		Temperature: 0.7,
+		MaxTokens:   ruleDetectionMaxTokens(len(rules)),
+	}, ruleDetectionSchema(ruleNames), validateRuleDetectionReply)
+	if errors.Is(err, ErrInvalidLLMOutput) {
+		return &ruleDetection{Model: resp.Model, Usage: resp.Usage}, err
 	}
-
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Non-200 status code: %d, status: %s", resp.StatusCode, resp.Status)
-		return s.fallbackRuleExtraction(ocrText, ruleNames), nil
-	}
-
-	defer resp.Body.Close()
-
-	// Read and parse response
-	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("ERROR reading Groq response: %v", err)
-		return s.fallbackRuleExtraction(ocrText, ruleNames), nil
+		return nil, fmt.Errorf("rule detection request failed: %w", err)
 	}
-	log.Printf("Groq API Raw Response: %s", string(body))
+	// Evidence in the reply quotes the document, so it is not logged either
+	log.Printf("[detectViolatedRules] Part %d of %d: %d rules reported as violated, %d as missing", part, parts, len(ruleResponse.ViolatedRules), len(ruleResponse.MissingRules))
 
-	var result struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		log.Printf("ERROR parsing Groq response structure: %v", err)
-		return s.fallbackRuleExtraction(ocrText, ruleNames), nil
-	}
-
-	var ruleResponse struct {
-		ViolatedRules []string `json:"violated_rules"`
-	}
-	if len(result.Choices) > 0 {
-		if err := json.Unmarshal([]byte(result.Choices[0].Message.Content), &ruleResponse); err != nil {
-			log.Printf("ERROR parsing violated rules from content: %v", err)
-			return s.fallbackRuleExtraction(ocrText, ruleNames), nil
-		}
-	}
-
-	violatedRules := ruleResponse.ViolatedRules
-	if len(violatedRules) == 0 {
-		log.Println("No rules violated according to Groq")
-	} else {
-		// Validate rules exist in database
-		validRules := make([]string, 0, len(violatedRules))
-		for _, rule := range violatedRules {
-			if contains(ruleNames, rule) {
-				validRules = append(validRules, rule)
-			} else {
-				log.Printf("WARNING: Suggested violated rule '%s' not found in database rules", rule)
-			}
+	// Keep only the rules that were asked about
+	detection := &ruleDetection{Violated: make([]string, 0, len(ruleResponse.ViolatedRules)), Spans: map[string][]TextSpan{}, Model: resp.Model, Usage: resp.Usage}
+	for _, rule := range ruleResponse.ViolatedRules {
+		if !contains(ruleNames, rule) {
+			log.Printf("WARNING: Suggested violated rule '%s' not found in database rules", rule)
+			continue
 		}
-		violatedRules = validRules
-		if len(violatedRules) == 0 {
-			log.Println("No valid violated rules found after validation")
+		detection.Violated = append(detection.Violated, rule)
+		if span, ok := locateQuote(ocrText, ruleResponse.Evidence[rule]); ok {
+			detection.Spans[rule] = []TextSpan{span}
 		}
 	}
 
-	log.Printf("Determined Violated Rules: %v", violatedRules)
-	return violatedRules, nil
-}
-
-// Helper function for fallback rule extraction
-func (s *DocumentService) fallbackRuleExtraction(ocrText string, ruleNames []string) []string {
-	if ocrText == "" {
-		return []string{}
-	}
-
-	// Normalize text for extremely flexible matching
-	ocrLower := strings.ToLower(ocrText)
-	ocrLower = strings.ReplaceAll(ocrLower, "-", " ")
-	ocrLower = strings.ReplaceAll(ocrLower, "_", " ")
-	ocrLower = regexp.MustCompile(`[^a-z0-9\s]`).ReplaceAllString(ocrLower, "")
-
-	// Extremely broad rule matching criteria
-	ruleMatchers := map[string][]string{
-		"Confidentiality Marking": {
-			"confidential", "private", "restricted", "secret", "sensitive",
-		},
-		"NDA Check": {
-			"nda", "non-disclosure", "confidential", "agreement", "secret",
-		},
-		"Signature Requirement": {
-			"sign", "signature", "date", "signed", "execute", "approval",
-		},
-		"Data Protection Clause": {
-			"data", "privacy", "protection", "personal", "information", "secure",
-		},
-		"Liability Clause Requirement": {
-			"liability", "responsibility", "limit", "clause", "legal", "risk",
-		},
-		"Payment Terms Specification": {
-			"payment", "due", "terms", "money", "cost", "invoice", "charge",
-		},
-	}
-
-	violated := []string{}
-
-	for ruleName, matchTerms := range ruleMatchers {
-		matchCount := 0
-		for _, term := range matchTerms {
-			if strings.Contains(ocrLower, term) {
-				matchCount++
-			}
-		}
-
-		// Extremely low threshold for matching
-		if matchCount > 0 {
-			log.Printf("Rule %s matched with %d terms", ruleName, matchCount)
-			violated = append(violated, ruleName)
+	for _, rule := range ruleResponse.MissingRules {
+		if contains(ruleNames, rule) && parts > 1 {
+			detection.Missing = append(detection.Missing, rule)
 		}
 	}
 
-	if len(violated) == 0 {
-		log.Println("No rules matched in extremely lax check")
-	} else {
-		log.Printf("Lax rule matching found violations: %v", violated)
-	}
-
-	return violated
+	log.Printf("Determined Violated Rules: %v", detection.Violated)
+	return detection, nil
 }
 
 // Helper function to remove duplicate strings
@@ -308,30 +302,8 @@ func removeDuplicates(slice []string) []string {
 	return result
 }
 
-// fuzzyContains checks if the text contains a minimum number of keywords
-func fuzzyContains(text string, keywords ...string) bool {
-	matchCount := 0
-	for _, keyword := range keywords {
-		if strings.Contains(text, keyword) {
-			matchCount++
-		}
-	}
-	// Require at least half of the keywords to match
-	return matchCount >= len(keywords)/2+1
-}
-
-// fuzzyContainsAny checks if the text contains ANY of the given keywords
-func fuzzyContainsAny(text string, keywords ...string) bool {
-	for _, keyword := range keywords {
-		if strings.Contains(text, keyword) {
-			return true
-		}
-	}
-	return false
-}
-
 // DetermineApplicableRulesBatch processes multiple documents in batches
-func (s *DocumentService) DetermineApplicableRulesBatch(documents []string, batchSize int) (map[string][]string, error) {
+func (s *DocumentService) DetermineApplicableRulesBatch(ctx context.Context, documents []string, batchSize int) (map[string][]string, error) {
 	// Validate input
 	if len(documents) == 0 {
 		return nil, fmt.Errorf("no documents provided for batch processing")
@@ -350,18 +322,12 @@ func (s *DocumentService) DetermineApplicableRulesBatch(documents []string, batc
 	}
 	log.Printf("Retrieved %d compliance rules from database", len(allRules))
 
-	// Prepare rule names for Groq
+	// Prepare rule names for the LLM
 	ruleNames := make([]string, len(allRules))
 	for _, rule := range allRules {
 		ruleNames = append(ruleNames, rule.Name)
 	}
 
-	// Validate Groq API Key
-	groqAPIKey := os.Getenv("VITE_GROQ_API_KEY")
-	if groqAPIKey == "" {
-		return nil, fmt.Errorf("VITE_GROQ_API_KEY environment variable is not set")
-	}
-
 	// Process documents in batches
 	results := make(map[string][]string)
 	var mu sync.Mutex
@@ -377,8 +343,8 @@ func (s *DocumentService) DetermineApplicableRulesBatch(documents []string, batc
 		// Prepare batch request
 		batchRequest := prepareBatchComplianceRequest(batchDocuments, ruleNames)
 
-		// Send batch request to Groq
-		batchResponse, err := sendBatchComplianceRequest(batchRequest, groqAPIKey)
+		// Send batch request to the LLM
+		batchResponse, err := s.sendBatchComplianceRequest(ctx, batchRequest)
 		if err != nil {
 			log.Printf("Error in batch compliance request: %v", err)
 			continue
@@ -403,7 +369,7 @@ func (s *DocumentService) DetermineApplicableRulesBatch(documents []string, batc
 	return results, nil
 }
 
-// prepareBatchComplianceRequest creates a batch request for Groq
+// prepareBatchComplianceRequest creates a batch request for the LLM
 func prepareBatchComplianceRequest(documents []string, ruleNames []string) BatchComplianceRequest {
 	batchDocuments := make([]DocumentComplianceCheck, len(documents))
 	for i, doc := range documents {
@@ -419,8 +385,8 @@ func prepareBatchComplianceRequest(documents []string, ruleNames []string) Batch
 	}
 }
 
-// sendBatchComplianceRequest sends a batch request to Groq and processes the response
-func sendBatchComplianceRequest(batchRequest BatchComplianceRequest, apiKey string) (*BatchComplianceResponse, error) {
+// sendBatchComplianceRequest sends a batch request to the LLM and processes the response
+func (s *DocumentService) sendBatchComplianceRequest(ctx context.Context, batchRequest BatchComplianceRequest) (*BatchComplianceResponse, error) {
 	// Construct the detailed, structured prompt
 	promptTemplate := `
 	For each document, analyze the text and suggest the most relevant legal compliance rules from this list:
@@ -443,90 +409,29 @@ func sendBatchComplianceRequest(batchRequest BatchComplianceRequest, apiKey stri
 	}
 	`
 
-	// Prepare request body
-	reqBody, err := json.Marshal(map[string]interface{}{
-		"messages": []map[string]string{
+	resp, err := s.llm.Complete(ctx, LLMRequest{
+		CallSite: LLMCallSiteBatchRuleDetection,
+		Messages: []LLMMessage{
 			{
-				"role":    "user",
-				"content": fmt.Sprintf(promptTemplate, strings.Join(batchRequest.RuleNames, "\n")), // Use batchRequest.RuleNames
+				Role:    "user",
+				Content: fmt.Sprintf(promptTemplate, strings.Join(batchRequest.RuleNames, "\n")), // Use batchRequest.RuleNames
 			},
 		},
-		"model":       "llama-3.3-70b-versatile",
-		"temperature": 0.7,
-		"max_tokens":  500,
-		"response_format": map[string]string{
-			"type": "json_object",
-		},
+		Temperature:  0.7,
+		MaxTokens:    500,
+		JSONResponse: true,
+		Timeout:      60 * time.Second, // Increased timeout for batch processing
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request body: %w", err)
-	}
-
-	// Send request to Groq
-	req, err := http.NewRequest("POST", "https://api.groq.com/openai/v1/chat/completions", bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Groq request: %w", err)
-	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{
-		Timeout: 60 * time.Second, // Increased timeout for batch processing
-		Transport: &http.Transport{
-			MaxIdleConns:        10,
-			IdleConnTimeout:     60 * time.Second,
-			DisableCompression:  true,
-			TLSHandshakeTimeout: 15 * time.Second,
-		},
-	}
-
-	// Execute request with retries
-	var resp *http.Response
-	for attempt := 0; attempt < 3; attempt++ {
-		resp, err = client.Do(req)
-		if err == nil {
-			break
-		}
-		log.Printf("Groq API request attempt %d failed: %v", attempt+1, err)
-		time.Sleep(time.Duration(attempt+1) * time.Second) // Exponential backoff
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to send Groq API request after 3 attempts: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("non-200 status code: %d, response: %s", resp.StatusCode, string(body))
-	}
-
-	// Read and parse response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read Groq response: %w", err)
-	}
-	log.Printf("Groq API Batch Response: %s", string(body))
-
-	// Parse the response
-	var result struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse Groq response structure: %w", err)
+		return nil, fmt.Errorf("batch compliance request failed: %w", err)
 	}
+	// The reply describes the documents, so only its size is logged
+	log.Printf("LLM Batch Response: %d characters", len(resp.Content))
 
 	// Parse batch results
 	var batchResponse BatchComplianceResponse
-	if len(result.Choices) > 0 {
-		if err := json.Unmarshal([]byte(result.Choices[0].Message.Content), &batchResponse); err != nil {
-			return nil, fmt.Errorf("failed to parse batch results: %w", err)
-		}
+	if err := json.Unmarshal([]byte(resp.Content), &batchResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse batch results: %w", err)
 	}
 
 	return &batchResponse, nil
@@ -553,80 +458,78 @@ func sliceContains(slice []string, item string) bool {
 	return false
 }
 
-func (s *DocumentService) CheckRuleCompliance(ocrText, ruleName, rulePattern string) (map[string]interface{}, error) {
-	// Rate limit the compliance check
-	if !ruleRateLimiter.Allow("rule_compliance_check") {
-		return nil, fmt.Errorf("rate limit exceeded for rule compliance check")
+// ruleComplianceReply is the detailed verdict CheckRuleCompliance asks for
+type ruleComplianceReply struct {
+	Status               string                 `json:"status"`
+	ConfidenceScore      *float64               `json:"confidence_score"`
+	Explanation          string                 `json:"explanation"`
+	NonComplianceDetails map[string]interface{} `json:"non_compliance_details,omitempty"`
+}
+
+// ruleComplianceSchema describes a ruleComplianceReply
+var ruleComplianceSchema = &LLMSchema{Name: "rule_compliance", Schema: map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"status":                 map[string]interface{}{"type": "string", "enum": []string{RuleStatusPass, RuleStatusFail}},
+		"confidence_score":       map[string]interface{}{"type": "number", "minimum": 0, "maximum": 100},
+		"explanation":            map[string]interface{}{"type": "string"},
+		"non_compliance_details": map[string]interface{}{"type": "object"},
+	},
+	"required":             []string{"status", "confidence_score", "explanation"},
+	"additionalProperties": false,
+}}
+
+// validateRuleComplianceReply checks what ruleComplianceSchema requires
+func validateRuleComplianceReply(reply ruleComplianceReply) error {
+	if reply.Status != RuleStatusPass && reply.Status != RuleStatusFail {
+		return fmt.Errorf("status must be %q or %q, got %q", RuleStatusPass, RuleStatusFail, reply.Status)
+	}
+	if reply.ConfidenceScore == nil {
+		return errors.New("confidence_score is required")
+	}
+	if *reply.ConfidenceScore < 0 || *reply.ConfidenceScore > 100 {
+		return fmt.Errorf("confidence_score must be between 0 and 100, got %v", *reply.ConfidenceScore)
+	}
+	if strings.TrimSpace(reply.Explanation) == "" {
+		return errors.New("explanation is required")
+	}
+	return nil
+}
+
+// CheckRuleCompliance asks the LLM for a detailed verdict on one rule, giving
+// it the result of the rule's own evaluator as a starting point. Cancelling ctx
+// stops the wait for the rate limiter and the LLM request.
+func (s *DocumentService) CheckRuleCompliance(ctx context.Context, ocrText string, rule model.ComplianceRule) (map[string]interface{}, error) {
+	// Rate limit the compliance check, waiting for a slot rather than failing
+	if err := ruleRateLimiter.Wait(ctx, "rule_compliance_check"); err != nil {
+		return nil, err
 	}
 
 	// Validate input parameters
 	if ocrText == "" {
 		return nil, fmt.Errorf("empty OCR text provided")
 	}
-	if ruleName == "" {
+	if rule.Name == "" {
 		return nil, fmt.Errorf("empty rule name provided")
 	}
+	ruleName, rulePattern := rule.Name, rule.Pattern
 
-	// Custom pattern matching for specific rules
-	var complianceCheck bool
-	log.Printf("COMPLIANCE DEBUG - Processing Rule: '%s', Rule Pattern: '%s'", ruleName, rulePattern)
-
-	switch ruleName {
-	case "Confidentiality Marking":
-		// Detailed logging and multiple check methods
-		log.Printf("COMPLIANCE DEBUG - Initial OCR Text: '%s'", ocrText)
-
-		// Check exact match
-		exactMatch := strings.Contains(ocrText, "Confidential")
-
-		// Case-insensitive match
-		caseInsensitiveMatch := strings.Contains(strings.ToLower(ocrText), strings.ToLower("Confidential"))
-
-		// Case-insensitive regex match
-		regexCaseInsensitiveMatch, _ := regexp.MatchString(`(?i)confidential`, ocrText)
-
-		// Regex match with original pattern
-		regexMatch, _ := regexp.MatchString(`Confidential`, ocrText)
-
-		// Determine compliance check
-		complianceCheck = exactMatch || caseInsensitiveMatch || regexCaseInsensitiveMatch || regexMatch
-
-		log.Printf("COMPLIANCE DEBUG - Confidentiality Marking Rule Checks: "+
-			"Exact Match='%v', "+
-			"Case-Insensitive String Match='%v', "+
-			"Case-Insensitive Regex Match='%v', "+
-			"Regex Match='%v', "+
-			"Final Compliance Check=%v",
-			exactMatch,
-			caseInsensitiveMatch,
-			regexCaseInsensitiveMatch,
-			regexMatch,
-			complianceCheck)
-	case "Signature Requirement":
-		log.Printf("COMPLIANCE DEBUG - Processing Signature Requirement Rule")
-		complianceCheck, _ = regexp.MatchString(`(?i)(signature|signed).*date`, ocrText)
-		log.Printf("COMPLIANCE DEBUG - Signature Requirement Check: %v", complianceCheck)
-	default:
-		log.Printf("COMPLIANCE DEBUG - Using Default Rule Pattern Matching")
-		complianceCheck, _ = regexp.MatchString(rulePattern, ocrText)
-		log.Printf("COMPLIANCE DEBUG - Default Rule Check: %v", complianceCheck)
-	}
-
-	log.Printf("COMPLIANCE DEBUG - Final Compliance Check for Rule '%s': %v", ruleName, complianceCheck)
-
-	// Prepare Groq API request payload
-	requestPayload := struct {
-		Messages []struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"messages"`
-		Model       string  `json:"model"`
-		Temperature float64 `json:"temperature"`
-	}{
-		Messages: []struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		}{
+	// Rules judged by the LLM have no local verdict; use the local analyzer's
+	var outcome RuleOutcome
+	if normalizeRuleType(rule.Type) == model.RuleTypeLLMJudged {
+		outcome = s.localAnalyzer().Analyze(rule, ocrText, localReasonInitialCheck)
+	} else {
+		outcomes, err := s.evaluateRules(ctx, RuleEvaluationRequest{Text: ocrText}, []model.ComplianceRule{rule})
+		if err != nil {
+			return nil, err
+		}
+		outcome = outcomes[rule.ID]
+	}
+	complianceCheck := outcome.Status == RuleStatusPass
+
+	reply, _, err := completeStructured(ctx, s.llm, LLMRequest{
+		CallSite: LLMCallSiteRuleCompliance,
+		Messages: []LLMMessage{
 			{
 				Role:    "system",
 				Content: "You are an advanced compliance rule analyzer with expertise in legal document validation.",
@@ -640,120 +543,45 @@ Rule Pattern: %s
 Initial Compliance Check: %v
 
 Document Text:
-%s`, ruleName, ruleName, rulePattern, complianceCheck, ocrText),
+%s
+
+Reply with a JSON object with "status" ("pass" or "fail"), "confidence_score" (0 to 100),
+"explanation" and, when the document fails, "non_compliance_details".`, ruleName, ruleName, rulePattern, complianceCheck, ocrText),
 			},
 		},
-		Model:       "mixtral-8x7b-32768",
 		Temperature: 0.8,
+		Timeout:     45 * time.Second,
+	}, ruleComplianceSchema, validateRuleComplianceReply)
+	if errors.Is(err, ErrInvalidLLMOutput) {
+		log.Printf("Compliance check for rule '%s' is indeterminate: %v", ruleName, err)
+		return map[string]interface{}{
+			"rule_name":   ruleName,
+			"status":      RuleStatusIndeterminate,
+			"explanation": fmt.Sprintf("The '%s' rule could not be judged: %v.", ruleName, err),
+		}, nil
 	}
-
-	// Serialize payload
-	payloadBytes, err := json.Marshal(requestPayload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to serialize request payload: %w", err)
+		return nil, fmt.Errorf("compliance check request failed: %w", err)
 	}
 
-	// Create HTTP request with context and timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.groq.com/openai/v1/chat/completions", bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Groq API request: %w", err)
+	complianceResponse := map[string]interface{}{
+		"rule_name":        ruleName,
+		"status":           reply.Status,
+		"confidence_score": *reply.ConfidenceScore,
+		"explanation":      reply.Explanation,
 	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("GROQ_API_KEY")))
-	req.Header.Set("Content-Type", "application/json")
-
-	// Use a custom HTTP client with timeout
-	client := &http.Client{
-		Timeout: 45 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        10,
-			IdleConnTimeout:     45 * time.Second,
-			DisableCompression:  true,
-			TLSHandshakeTimeout: 15 * time.Second,
-		},
+	if len(reply.NonComplianceDetails) > 0 {
+		complianceResponse["non_compliance_details"] = reply.NonComplianceDetails
 	}
 
-	// Execute request with retries
-	var resp *http.Response
-	for attempt := 0; attempt < 3; attempt++ {
-		resp, err = client.Do(req)
-		if err == nil {
-			break
-		}
-		log.Printf("Groq API request attempt %d failed: %v", attempt+1, err)
-		time.Sleep(time.Duration(attempt+1) * time.Second) // Exponential backoff
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to send Groq API request after 3 attempts: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("non-200 status code: %d, response: %s", resp.StatusCode, string(body))
-	}
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read Groq response: %w", err)
-	}
-
-	// Parse Groq API response
-	var result struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse Groq response structure: %w", err)
-	}
-
-	// Validate response content
-	if len(result.Choices) == 0 || result.Choices[0].Message.Content == "" {
-		return nil, fmt.Errorf("no compliance analysis returned from Groq")
-	}
-
-	// Parse compliance response
-	var complianceResponse map[string]interface{}
-	if err := json.Unmarshal([]byte(result.Choices[0].Message.Content), &complianceResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse compliance response JSON: %w", err)
-	}
-
-	// Normalize status for backward compatibility
-	status, _ := complianceResponse["status"].(string)
-	switch status {
-	case "partial_pass", "soft_fail":
-		complianceResponse["status"] = "fail"
-	case "pass":
-		// Keep as is
-	default:
-		complianceResponse["status"] = "fail"
-	}
-
-	// Enrich response with rule name
-	complianceResponse["rule_name"] = ruleName
-
 	// Log the result with more context
-	confidenceScore, _ := complianceResponse["confidence_score"].(float64)
 	log.Printf("Detailed Compliance Check for Rule '%s': Status=%s, Confidence=%.2f%%",
 		ruleName,
-		complianceResponse["status"],
-		confidenceScore)
+		reply.Status,
+		*reply.ConfidenceScore)
 
-	// Log non-compliance details if available
-	if nonComplianceDetails, ok := complianceResponse["non_compliance_details"].(map[string]interface{}); ok {
-		for ruleName, details := range nonComplianceDetails {
-			log.Printf("Non-Compliance Details for %s: %+v", ruleName, details)
-		}
-	}
+	// Non-compliance details quote the document, so only their count is logged
+	log.Printf("Non-Compliance Details for %s: %d entries", ruleName, len(reply.NonComplianceDetails))
 
 	return complianceResponse, nil
 }
@@ -764,7 +592,12 @@ func (s *DocumentService) GetAllComplianceRules() ([]model.ComplianceRule, error
 	if !ruleRateLimiter.Allow("rule_retrieval") {
 		return nil, fmt.Errorf("rate limit exceeded for rule retrieval")
 	}
+	return s.loadComplianceRules()
+}
 
+// loadComplianceRules reads every compliance rule without the request rate
+// limit, for background processing that must not fail under load
+func (s *DocumentService) loadComplianceRules() ([]model.ComplianceRule, error) {
 	var rules []model.ComplianceRule
 	result := s.db.Find(&rules)
 	if result.Error != nil {
@@ -796,11 +629,6 @@ func (s *DocumentService) GetComplianceRulesByNames(ruleNames []string) ([]model
 
 // CalculateRiskScore computes a score based on failed rules and their severity
 func (s *DocumentService) CalculateRiskScore(results []map[string]interface{}, rules []model.ComplianceRule) float64 {
-	// Rate limit risk score calculation
-	if !ruleRateLimiter.Allow("risk_score_calculation") {
-		return 0.0
-	}
-
 	log.Printf("Calculating Risk Score. Number of results: %d", len(results))
 
 	severityWeights := map[string]float64{
@@ -817,7 +645,8 @@ func (s *DocumentService) CalculateRiskScore(results []map[string]interface{}, r
 	}
 
 	for i, result := range results {
-		log.Printf("Processing result %d: %+v", i, result)
+		// Results carry explanations and evidence from the document, so they are not logged
+		log.Printf("Processing result %d of %d", i+1, len(results))
 
 		status, ok := result["status"].(string)
 		if !ok {
@@ -843,7 +672,7 @@ func (s *DocumentService) CalculateRiskScore(results []map[string]interface{}, r
 				ruleSeverity := rule.Severity
 				log.Printf("Failed rule %s with severity: %s", ruleName, ruleSeverity)
 
-				weight, exists := severityWeights[ruleSeverity]
+				weight, exists := severityWeights[strings.ToLower(strings.TrimSpace(ruleSeverity))]
 				if !exists {
 					log.Printf("WARNING: Unknown severity level: %s", ruleSeverity)
 					weight = 1.0 // Default to low risk
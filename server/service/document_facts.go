@@ -0,0 +1,126 @@
+package services
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Document types recognised by classifyDocument
+const (
+	DocumentTypeNDA        = "nda"
+	DocumentTypeEmployment = "employment"
+	DocumentTypeLease      = "lease"
+	DocumentTypeServices   = "services"
+	DocumentTypePurchase   = "purchase"
+	DocumentTypeLicense    = "license"
+	DocumentTypeOther      = "other"
+)
+
+// documentTypeSignals are the phrases that point to each document type
+var documentTypeSignals = []struct {
+	docType string
+	phrases []string
+}{
+	{DocumentTypeNDA, []string{"non-disclosure", "nondisclosure", "confidentiality agreement", "confidential information"}},
+	{DocumentTypeEmployment, []string{"employment agreement", "employee", "employer", "salary"}},
+	{DocumentTypeLease, []string{"lease", "landlord", "tenant", "premises"}},
+	{DocumentTypeServices, []string{"services agreement", "statement of work", "service provider", "deliverables"}},
+	{DocumentTypePurchase, []string{"purchase agreement", "buyer", "seller", "purchase price"}},
+	{DocumentTypeLicense, []string{"license agreement", "licensor", "licensee", "royalt"}},
+}
+
+// classifyDocument guesses the kind of agreement from the phrases it uses
+func classifyDocument(text string) string {
+	lower := strings.ToLower(text)
+	best, bestScore := DocumentTypeOther, 0
+	for _, signal := range documentTypeSignals {
+		score := 0
+		for _, phrase := range signal.phrases {
+			score += strings.Count(lower, phrase)
+		}
+		if score > bestScore {
+			best, bestScore = signal.docType, score
+		}
+	}
+	return best
+}
+
+var (
+	governingLawPattern = regexp.MustCompile(`(?i)governed by(?: and construed in accordance with)?,? the laws? of (?:the )?((?:State of |Republic of )?[A-Z][A-Za-z]*(?: [A-Z][A-Za-z]*)*)`)
+	paymentDaysPattern  = regexp.MustCompile(`(?i)(?:payment|invoice|pay|paid)[^.]{0,80}?\b(?:within|net)\s+([a-z-]+|\d+)\s*(?:\((\d+)\)\s*)?(?:calendar |business )?days`)
+	netTermsPattern     = regexp.MustCompile(`(?i)\bnet[\s-]?(\d{1,3})\b`)
+	datePattern         = regexp.MustCompile(`(?i)\b(?:\d{1,2}(?:st|nd|rd|th)? (?:january|february|march|april|may|june|july|august|september|october|november|december),? \d{4}|(?:january|february|march|april|may|june|july|august|september|october|november|december) \d{1,2}(?:st|nd|rd|th)?,? \d{4}|\d{4}-\d{2}-\d{2}|\d{1,2}/\d{1,2}/\d{2,4})\b`)
+	amountPattern       = regexp.MustCompile(`(?i)(?:[$€£₹]\s?\d[\d,]*(?:\.\d+)?|\b\d[\d,]*(?:\.\d+)?\s?(?:USD|EUR|GBP|INR|dollars)\b)`)
+	emailPattern        = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+// numberWords spells out the day counts that commonly appear in payment terms
+var numberWords = map[string]int{
+	"seven": 7, "ten": 10, "fourteen": 14, "fifteen": 15, "twenty": 20, "twenty-one": 21,
+	"thirty": 30, "forty-five": 45, "sixty": 60, "ninety": 90, "one hundred twenty": 120,
+}
+
+// parseDayCount reads a day count written as digits or words
+func parseDayCount(word, digits string) (int, bool) {
+	if digits != "" {
+		word = digits
+	}
+	if n, err := strconv.Atoi(word); err == nil {
+		return n, true
+	}
+	n, ok := numberWords[strings.ToLower(word)]
+	return n, ok
+}
+
+// extractEntities pulls the values rules most often test out of the text.
+// Keys are only present when a value was found.
+func extractEntities(text string) map[string]interface{} {
+	entities := map[string]interface{}{
+		"dates":   uniqueMatches(datePattern, text),
+		"amounts": uniqueMatches(amountPattern, text),
+		"emails":  uniqueMatches(emailPattern, text),
+	}
+
+	if m := governingLawPattern.FindStringSubmatch(text); m != nil {
+		entities["governing_law"] = strings.TrimSpace(m[1])
+	}
+
+	if m := paymentDaysPattern.FindStringSubmatch(text); m != nil {
+		if days, ok := parseDayCount(m[1], m[2]); ok {
+			entities["payment_terms_days"] = int64(days)
+		}
+	} else if m := netTermsPattern.FindStringSubmatch(text); m != nil {
+		if days, ok := parseDayCount(m[1], ""); ok {
+			entities["payment_terms_days"] = int64(days)
+		}
+	}
+	return entities
+}
+
+// uniqueMatches returns the distinct matches of re in order of appearance
+func uniqueMatches(re *regexp.Regexp, text string) []string {
+	matches := []string{}
+	for _, match := range re.FindAllString(text, -1) {
+		if !contains(matches, match) {
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+// documentFacts builds the values expression rules are evaluated against
+func documentFacts(req RuleEvaluationRequest) map[string]interface{} {
+	outcomes := make(map[string]string, len(req.Outcomes))
+	for name, outcome := range req.Outcomes {
+		outcomes[name] = outcome.Status
+	}
+	return map[string]interface{}{
+		"text":          req.Text,
+		"title":         req.Title,
+		"document_type": classifyDocument(req.Text),
+		"page_count":    int64(req.PageCount),
+		"entities":      extractEntities(req.Text),
+		"rules":         outcomes,
+	}
+}
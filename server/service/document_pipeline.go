@@ -0,0 +1,506 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+	"time"
+
+	model "github.com/Itish41/LegalEagle/models"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// ErrProcessingQueueFull is returned when an upload cannot be queued because every worker is busy
+var ErrProcessingQueueFull = errors.New("document processing queue is full, try again later")
+
+// unfinishedStatuses are the stages a document can be resumed from after a restart
+var unfinishedStatuses = []string{model.DocumentStatusQueued, model.DocumentStatusUploaded, model.DocumentStatusOCRDone}
+
+// processingJob is a document waiting for a pipeline worker
+type processingJob struct {
+	documentID  string
+	filename    string
+	contentType string
+	// fileBytes holds a fresh upload until it is stored; resumed jobs read the file back from the blob store.
+	fileBytes []byte
+}
+
+// EnqueueDocument records the upload as a queued document and hands it to the
+// worker pool. It returns as soon as the document row exists. Tags are matched
+// against rule scopes when the document is analyzed.
+func (s *DocumentService) EnqueueDocument(file multipart.File, header *multipart.FileHeader, tags ...string) (*model.Document, error) {
+	log.Printf("File details: Name=%s, Size=%d", header.Filename, header.Size)
+
+	fileBytes, err := io.ReadAll(file)
+	if err != nil {
+		log.Printf("ERROR reading file: %v", err)
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	fileName := filepath.Base(header.Filename)
+	fileType := filepath.Ext(fileName)
+	title := strings.TrimSuffix(fileName, fileType)
+	if fileType != "" {
+		fileType = fileType[1:] // Remove the leading dot
+	}
+
+	tagsJSON, err := json.Marshal(normalizeTags(tags))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tags: %w", err)
+	}
+
+	docID := uuid.NewString()
+	doc := model.Document{
+		ID:               docID,
+		Title:            title,
+		FileType:         fileType,
+		OriginalURL:      documentDownloadURL(docID),
+		Tags:             datatypes.JSON(tagsJSON),
+		ProcessingStatus: model.DocumentStatusQueued,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+	if err := s.db.Create(&doc).Error; err != nil {
+		log.Printf("ERROR saving queued document: %v", err)
+		return nil, fmt.Errorf("failed to save to database: %w", err)
+	}
+
+	job := processingJob{
+		documentID:  docID,
+		filename:    header.Filename,
+		contentType: header.Header.Get("Content-Type"),
+		fileBytes:   fileBytes,
+	}
+	select {
+	case s.jobs <- job:
+		log.Printf("Document %s queued for processing", docID)
+		return &doc, nil
+	default:
+		if err := s.db.Delete(&model.Document{}, "id = ?", docID).Error; err != nil {
+			log.Printf("ERROR removing unqueued document %s: %v", docID, err)
+		}
+		return nil, ErrProcessingQueueFull
+	}
+}
+
+// StartWorkers runs the configured number of pipeline workers until ctx is
+// cancelled or StopWorkers is called. A job in progress is not interrupted by
+// ctx; StopWorkers decides how long it may run.
+func (s *DocumentService) StartWorkers(ctx context.Context) {
+	ctx, s.stopWorkers = context.WithCancel(ctx)
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	s.cancelJobs = cancelJobs
+
+	for i := 0; i < s.workers; i++ {
+		s.workersDone.Add(1)
+		go func() {
+			defer s.workersDone.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-s.jobs:
+					s.processDocument(jobCtx, job)
+				}
+			}
+		}()
+	}
+	log.Printf("Started %d document processing workers", s.workers)
+}
+
+// StopWorkers stops the workers taking new jobs and waits for the jobs in
+// progress to finish. When ctx is done first, those jobs are cancelled: their
+// documents keep the stage they reached and are resumed on the next start.
+func (s *DocumentService) StopWorkers(ctx context.Context) error {
+	if s.stopWorkers == nil {
+		return nil
+	}
+	s.stopWorkers()
+
+	done := make(chan struct{})
+	go func() {
+		s.workersDone.Wait()
+		close(done)
+	}()
+	defer s.cancelJobs()
+	select {
+	case <-done:
+		log.Println("Document processing workers stopped")
+		return nil
+	case <-ctx.Done():
+		log.Println("Cancelling document processing jobs still in progress")
+		s.cancelJobs()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// ResumeProcessing queues every document whose processing was interrupted,
+// e.g. by a restart. It blocks until all of them are queued or ctx is cancelled.
+func (s *DocumentService) ResumeProcessing(ctx context.Context) error {
+	var ids []string
+	if err := s.db.Model(&model.Document{}).Where("processing_status IN ?", unfinishedStatuses).Pluck("id", &ids).Error; err != nil {
+		return fmt.Errorf("failed to fetch unfinished documents: %w", err)
+	}
+	if len(ids) > 0 {
+		log.Printf("Resuming processing of %d documents", len(ids))
+	}
+
+	for _, id := range ids {
+		select {
+		case s.jobs <- processingJob{documentID: id}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// processDocument runs the remaining pipeline stages for one document. When a
+// stage fails, the side effects of the earlier stages are undone and only the
+// failed document row is kept so its status can be reported.
+func (s *DocumentService) processDocument(ctx context.Context, job processingJob) {
+	var doc model.Document
+	if err := s.db.First(&doc, "id = ?", job.documentID).Error; err != nil {
+		log.Printf("[processDocument] Error fetching document %s: %v", job.documentID, err)
+		return
+	}
+
+	// A document analyzed before is being reprocessed; a failure must not discard its file and text
+	reprocessing := len(doc.ParsedData) > 0
+
+	var undo compensationStack
+	if err := s.runPipeline(ctx, &doc, job, &undo); err != nil {
+		if ctx.Err() != nil {
+			// Shutting down: keep the stage reached so the document is resumed on the next start
+			log.Printf("[processDocument] Processing document %s interrupted after stage %q: %v", doc.ID, doc.ProcessingStatus, err)
+			return
+		}
+		log.Printf("[processDocument] Processing document %s failed after stage %q: %v", doc.ID, doc.ProcessingStatus, err)
+		if reprocessing {
+			if err := s.updateDocument(s.db, doc.ID, map[string]interface{}{
+				"processing_status": model.DocumentStatusFailed,
+				"processing_error":  err.Error(),
+			}); err != nil {
+				log.Printf("[processDocument] Error marking document %s as failed: %v", doc.ID, err)
+			}
+			return
+		}
+		if err := undo.run(ctx); err != nil {
+			log.Printf("[processDocument] Error compensating document %s: %v", doc.ID, err)
+		}
+		if err := s.markDocumentFailed(doc.ID, err); err != nil {
+			log.Printf("[processDocument] Error marking document %s as failed: %v", doc.ID, err)
+		}
+		return
+	}
+	log.Printf("[processDocument] Document %s processed successfully", doc.ID)
+}
+
+// markDocumentFailed records why a document failed after its stored file was
+// removed. Text already extracted is kept so the analysis can be re-run.
+func (s *DocumentService) markDocumentFailed(documentID string, cause error) error {
+	return s.updateDocument(s.db, documentID, map[string]interface{}{
+		"storage_key":       "",
+		"parsed_data":       nil,
+		"risk_score":        0,
+		"processing_status": model.DocumentStatusFailed,
+		"processing_error":  cause.Error(),
+	})
+}
+
+// runPipeline moves doc through the upload, OCR and analysis stages, starting
+// after the last stage it completed. Every external side effect is pushed onto
+// undo so a later failure can remove it.
+func (s *DocumentService) runPipeline(ctx context.Context, doc *model.Document, job processingJob, undo *compensationStack) error {
+	fileBytes := job.fileBytes
+
+	// Side effects of stages completed before a restart
+	if doc.StorageKey != "" {
+		undo.pushBlobDelete(s.blobs, doc.StorageKey)
+	}
+	if doc.ProcessingStatus == model.DocumentStatusOCRDone {
+		undo.pushSearchDelete(s.search, doc.ID)
+	}
+
+	// Stage 1: store the original file
+	if doc.ProcessingStatus == model.DocumentStatusQueued {
+		if fileBytes == nil {
+			return errors.New("processing was interrupted before the file was stored; please upload it again")
+		}
+		storageKey := documentBlobKey(doc.ID, job.filename)
+		if err := s.blobs.Put(ctx, storageKey, fileBytes, job.contentType); err != nil {
+			return fmt.Errorf("failed to upload file to storage: %w", err)
+		}
+		undo.pushBlobDelete(s.blobs, storageKey)
+
+		if err := s.updateDocument(s.db, doc.ID, map[string]interface{}{
+			"storage_key":       storageKey,
+			"processing_status": model.DocumentStatusUploaded,
+		}); err != nil {
+			return fmt.Errorf("failed to record upload: %w", err)
+		}
+		doc.StorageKey = storageKey
+		doc.ProcessingStatus = model.DocumentStatusUploaded
+		log.Printf("File stored under key %s", storageKey)
+	}
+
+	// Stage 2: extract text, preferring the embedded text layer over remote OCR
+	if doc.ProcessingStatus == model.DocumentStatusUploaded {
+		if fileBytes == nil {
+			var err error
+			if fileBytes, err = s.readBlob(ctx, doc.StorageKey); err != nil {
+				return err
+			}
+		}
+
+		ocrResult, err := s.ocr.ExtractText(fileBytes, doc.Title+"."+doc.FileType)
+		if err != nil {
+			return fmt.Errorf("failed to extract text with %s: %w", s.ocr.Name(), err)
+		}
+		log.Printf("OCR Text extracted by %s: %d characters", ocrResult.Provider, len(ocrResult.Text))
+
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			if err := s.updateDocument(tx, doc.ID, map[string]interface{}{
+				"ocr_text":          ocrResult.Text,
+				"ocr_provider":      ocrResult.Provider,
+				"processing_status": model.DocumentStatusOCRDone,
+			}); err != nil {
+				return err
+			}
+			return s.saveDocumentPages(tx, doc.ID, ocrResult.Pages)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to save extracted text: %w", err)
+		}
+		doc.OcrText = ocrResult.Text
+		doc.OcrProvider = ocrResult.Provider
+		doc.ProcessingStatus = model.DocumentStatusOCRDone
+		if lowPages := ocrResult.LowConfidencePages(LowOCRConfidenceThreshold); len(lowPages) > 0 {
+			log.Printf("Document %s has low OCR confidence on pages %v", doc.ID, lowPages)
+		}
+
+		undo.pushSearchDelete(s.search, doc.ID)
+		s.indexDocument(SearchDocument{ID: doc.ID, Title: doc.Title, FileURL: doc.OriginalURL, OcrText: doc.OcrText, Timestamp: doc.CreatedAt})
+	}
+
+	// Stage 3: compliance analysis; the results, rule results and action items commit
+	// together and replace those of any earlier analysis
+	if doc.ProcessingStatus == model.DocumentStatusOCRDone {
+		parsedDataJSON, riskScore, err := s.analyzeCompliance(ctx, doc)
+		if err != nil {
+			return err
+		}
+		analyzed := *doc
+		analyzed.ParsedData = datatypes.JSON(parsedDataJSON)
+		analyzed.RiskScore = riskScore
+
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			if err := s.updateDocument(tx, doc.ID, map[string]interface{}{
+				"parsed_data":       analyzed.ParsedData,
+				"risk_score":        riskScore,
+				"processing_status": model.DocumentStatusAnalyzed,
+				"processing_error":  "",
+			}); err != nil {
+				return fmt.Errorf("failed to save compliance results: %w", err)
+			}
+			if err := s.clearAnalysis(tx, doc.ID); err != nil {
+				return fmt.Errorf("failed to clear previous analysis: %w", err)
+			}
+			if err := s.CreateActionItems(tx, analyzed); err != nil {
+				return fmt.Errorf("failed to create action items: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		*doc = analyzed
+		doc.ProcessingStatus = model.DocumentStatusAnalyzed
+	}
+
+	return nil
+}
+
+// compensationStack collects the undo steps of completed side effects
+type compensationStack []compensation
+
+// compensation undoes one side effect
+type compensation struct {
+	name string
+	undo func(ctx context.Context) error
+}
+
+// push records an undo step
+func (c *compensationStack) push(name string, undo func(ctx context.Context) error) {
+	*c = append(*c, compensation{name: name, undo: undo})
+}
+
+// pushBlobDelete records that the blob under key must be removed on failure
+func (c *compensationStack) pushBlobDelete(blobs BlobStore, key string) {
+	c.push("delete blob "+key, func(ctx context.Context) error { return blobs.Delete(ctx, key) })
+}
+
+// pushSearchDelete records that the search entry of documentID must be removed on failure
+func (c *compensationStack) pushSearchDelete(search SearchIndex, documentID string) {
+	c.push("delete search entry "+documentID, func(ctx context.Context) error { return search.Delete(ctx, documentID) })
+}
+
+// run undoes every recorded side effect, most recent first. It runs all
+// steps even when some fail and returns their combined errors.
+func (c compensationStack) run(ctx context.Context) error {
+	var errs []error
+	for i := len(c) - 1; i >= 0; i-- {
+		if err := c[i].undo(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c[i].name, err))
+			continue
+		}
+		log.Printf("Compensated: %s", c[i].name)
+	}
+	return errors.Join(errs...)
+}
+
+// clearAnalysis removes the rule results and open action items of a previous
+// analysis; completed action items are kept as history
+func (s *DocumentService) clearAnalysis(tx *gorm.DB, documentID string) error {
+	if err := tx.Where("document_id = ?", documentID).Delete(&model.DocumentRuleResult{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("document_id = ? AND status = ?", documentID, "pending").Delete(&model.ActionItem{}).Error
+}
+
+// readBlob reads a stored original file into memory
+func (s *DocumentService) readBlob(ctx context.Context, key string) ([]byte, error) {
+	body, _, err := s.blobs.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from storage: %w", key, err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from storage: %w", key, err)
+	}
+	return data, nil
+}
+
+// analyzeCompliance checks the text against every rule in scope and returns
+// the per-rule results as JSON together with the risk score. Rules whose scope
+// excludes the document are reported as not_applicable and do not count
+// towards the score. Each result records the version of the rule definition
+// that was evaluated.
+func (s *DocumentService) analyzeCompliance(ctx context.Context, doc *model.Document) ([]byte, float64, error) {
+	var pages []model.DocumentPage
+	if doc.ID != "" {
+		if err := s.db.Select("page_number", "start_offset", "end_offset").Where("document_id = ?", doc.ID).Order("start_offset").Find(&pages).Error; err != nil {
+			return nil, 0, fmt.Errorf("failed to fetch document pages: %w", err)
+		}
+	}
+
+	// Fetch all rules to build complete parsed_data
+	allRules, err := s.loadComplianceRules()
+	if err != nil {
+		log.Printf("ERROR fetching all rules from database: %v", err)
+		return nil, 0, fmt.Errorf("failed to fetch rules from database: %w", err)
+	}
+	analyzedAt := time.Now()
+	versions, err := s.currentRuleVersions(s.db, allRules)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Evaluate every rule in scope with the evaluator for its type
+	applicableRules, notApplicable := filterRulesByScope(documentProfile(doc.OcrText, doc.Tags), allRules)
+	outcomes, err := s.evaluateRules(ctx, RuleEvaluationRequest{Text: doc.OcrText, Title: doc.Title, PageCount: len(pages)}, applicableRules)
+	if err != nil {
+		log.Printf("ERROR evaluating compliance rules: %v", err)
+		return nil, 0, err
+	}
+	for ruleID, outcome := range notApplicable {
+		outcomes[ruleID] = outcome
+	}
+
+	// Generate parsed_data for all rules
+	complianceResults := []map[string]interface{}{}
+	for _, rule := range allRules {
+		version := versions[rule.ID]
+		outcome := outcomes[rule.ID]
+		engine := outcome.Engine
+		if engine == "" {
+			engine = EngineLocal
+		}
+		// Provenance: what judged the rule, with which prompt and at what cost
+		result := map[string]interface{}{
+			"rule_id":         rule.ID,
+			"rule_version_id": version.ID,
+			"rule_version":    version.Version,
+			"rule_name":       rule.Name,
+			"rule_type":       normalizeRuleType(rule.Type),
+			"severity":        rule.Severity,
+			"status":          outcome.Status,
+			"explanation":     outcome.Explanation,
+			"engine":          engine,
+			"latency_ms":      outcome.Latency.Milliseconds(),
+			"evaluated_at":    analyzedAt.UTC(),
+		}
+		if outcome.Model != "" {
+			result["model"] = outcome.Model
+			result["prompt_version"] = outcome.PromptVersion
+		}
+		if outcome.SharedUsage != nil {
+			result["shared_request_usage"] = outcome.SharedUsage
+			result["rules_sharing_usage"] = outcome.UsageSharedBy
+		}
+		if len(outcome.Spans) > 0 {
+			result["spans"] = outcome.Spans
+		}
+		result["evidence"] = buildEvidence(rule, outcome, doc.OcrText, pages)
+		for key, value := range outcome.Details {
+			if _, taken := result[key]; !taken {
+				result[key] = value
+			}
+		}
+		complianceResults = append(complianceResults, result)
+	}
+
+	// Calculate risk score
+	riskScore := s.CalculateRiskScore(complianceResults, applicableRules)
+	log.Printf("Calculated Risk Score: %f", riskScore)
+
+	parsedDataJSON, err := json.Marshal(complianceResults)
+	if err != nil {
+		log.Printf("ERROR marshaling compliance results: %v", err)
+		return nil, 0, fmt.Errorf("failed to marshal compliance results: %w", err)
+	}
+	return parsedDataJSON, riskScore, nil
+}
+
+// updateDocument applies updates to a document row and bumps updated_at
+func (s *DocumentService) updateDocument(db *gorm.DB, documentID string, updates map[string]interface{}) error {
+	updates["updated_at"] = time.Now()
+	return db.Model(&model.Document{}).Where("id = ?", documentID).Updates(updates).Error
+}
+
+// GetDocumentStatus returns the processing status of a document
+func (s *DocumentService) GetDocumentStatus(documentID string) (*model.Document, error) {
+	var doc model.Document
+	err := s.db.Select("id", "title", "processing_status", "processing_error", "risk_score", "created_at", "updated_at").
+		First(&doc, "id = ?", documentID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		log.Printf("[GetDocumentStatus] Error fetching document %s: %v", documentID, err)
+		return nil, err
+	}
+	return &doc, nil
+}
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/textproto"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// memoryFile is an in-memory multipart.File
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+// newUpload builds the file and header the upload handler passes to the service
+func newUpload(filename string, content []byte) (multipart.File, *multipart.FileHeader) {
+	header := &multipart.FileHeader{
+		Filename: filename,
+		Size:     int64(len(content)),
+		Header:   textproto.MIMEHeader{"Content-Type": []string{"text/plain"}},
+	}
+	return memoryFile{bytes.NewReader(content)}, header
+}
+
+// newPipelineService wires a DocumentService to SQLite, local storage and fakes
+func newPipelineService(t *testing.T, ocr OCRProvider, queueSize int) (*DocumentService, *gorm.DB, *LocalBlobStore) {
+	db := newTestDB(t)
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	llm := NewFakeLLMClient()
+	llm.Responses[LLMCallSiteRuleDetection] = `{"violated_rules": ["NDA Check"]}`
+
+	for _, rule := range []model.ComplianceRule{
+		{ID: "rule-1", Name: "NDA Check", Severity: "High"},
+		{ID: "rule-2", Name: "Signature Check", Severity: "Low"},
+	} {
+		require.NoError(t, db.Create(&rule).Error)
+		// Version 1, as the rule versions migration records for existing rules
+		require.NoError(t, db.Create(&model.ComplianceRuleVersion{RuleID: rule.ID, Version: 1, Name: rule.Name, Type: model.RuleTypeLLMJudged, Severity: rule.Severity, EffectiveFrom: rule.CreatedAt}).Error)
+	}
+
+	return &DocumentService{
+		blobs:   blobs,
+		search:  NewPostgresSearchIndex(db),
+		ocr:     ocr,
+		llm:     llm,
+		db:      db,
+		jobs:    make(chan processingJob, queueSize),
+		workers: 1,
+	}, db, blobs
+}
+
+// loadDocument reads a document back from the database
+func loadDocument(t *testing.T, db *gorm.DB, id string) model.Document {
+	var doc model.Document
+	require.NoError(t, db.First(&doc, "id = ?", id).Error)
+	return doc
+}
+
+func TestEnqueueDocument_ProcessesAllStages(t *testing.T) {
+	ctx := context.Background()
+	s, db, blobs := newPipelineService(t, &stubOCRProvider{name: "local", text: "This agreement has no NDA."}, 1)
+
+	doc, err := s.EnqueueDocument(newUpload("contract.txt", []byte("This agreement has no NDA.")))
+	require.NoError(t, err)
+	assert.Equal(t, model.DocumentStatusQueued, doc.ProcessingStatus)
+	assert.Equal(t, model.DocumentStatusQueued, loadDocument(t, db, doc.ID).ProcessingStatus)
+
+	s.processDocument(ctx, <-s.jobs)
+
+	stored := loadDocument(t, db, doc.ID)
+	assert.Equal(t, model.DocumentStatusAnalyzed, stored.ProcessingStatus)
+	assert.Empty(t, stored.ProcessingError)
+	assert.Equal(t, "This agreement has no NDA.", stored.OcrText)
+	assert.Equal(t, documentBlobKey(doc.ID, "contract.txt"), stored.StorageKey)
+	assert.Greater(t, stored.RiskScore, 0.0)
+
+	_, _, err = blobs.Get(ctx, stored.StorageKey)
+	assert.NoError(t, err)
+
+	var actions []model.ActionItem
+	require.NoError(t, db.Find(&actions, "document_id = ?", doc.ID).Error)
+	require.Len(t, actions, 1)
+	assert.Equal(t, "rule-1", actions[0].RuleID)
+}
+
+func TestAnalyzeCompliance_RecordsProvenance(t *testing.T) {
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: "This agreement has no NDA."}, 1)
+	require.NoError(t, s.AddComplianceRule(context.Background(), &model.ComplianceRule{Name: "Term", Type: model.RuleTypeRegexMustMatch, Pattern: `term of`, Severity: SeverityLow}))
+
+	doc, err := s.EnqueueDocument(newUpload("contract.txt", []byte("This agreement has no NDA.")))
+	require.NoError(t, err)
+	s.processDocument(context.Background(), <-s.jobs)
+
+	var results []map[string]interface{}
+	require.NoError(t, json.Unmarshal(loadDocument(t, db, doc.ID).ParsedData, &results))
+	byName := map[string]map[string]interface{}{}
+	for _, result := range results {
+		byName[result["rule_name"].(string)] = result
+		assert.Contains(t, result, "latency_ms")
+		assert.NotEmpty(t, result["evaluated_at"])
+		assert.Equal(t, 1.0, result["rule_version"])
+	}
+
+	judged := byName["NDA Check"]
+	assert.Equal(t, EngineLLM, judged["engine"])
+	assert.Equal(t, "llama-3.3-70b-versatile", judged["model"])
+	assert.Equal(t, PromptVersionRuleDetection, judged["prompt_version"])
+	require.IsType(t, map[string]interface{}{}, judged["shared_request_usage"])
+	assert.Positive(t, judged["shared_request_usage"].(map[string]interface{})["total_tokens"])
+	assert.Equal(t, 2.0, judged["rules_sharing_usage"], "one request judged both llm_judged rules")
+	assert.Equal(t, judged["shared_request_usage"], byName["Signature Check"]["shared_request_usage"])
+
+	regex := byName["Term"]
+	assert.Equal(t, model.RuleTypeRegexMustMatch, regex["engine"])
+	assert.NotContains(t, regex, "model")
+	assert.NotContains(t, regex, "shared_request_usage")
+
+	// Stored results keep the same provenance for audits, whatever their status
+	var stored []model.DocumentRuleResult
+	require.NoError(t, db.Find(&stored, "document_id = ?", doc.ID).Error)
+	require.Len(t, stored, len(results))
+	for _, row := range stored {
+		var details map[string]interface{}
+		require.NoError(t, json.Unmarshal(row.Details, &details))
+		want := byName[details["rule_name"].(string)]
+		assert.Equal(t, want["status"], row.Status)
+		for _, field := range []string{"engine", "model", "prompt_version", "rule_version", "latency_ms", "shared_request_usage", "rules_sharing_usage", "evaluated_at"} {
+			assert.Equal(t, want[field], details[field], field)
+		}
+	}
+}
+
+func TestEnqueueDocument_RecordsFailure(t *testing.T) {
+	ctx := context.Background()
+	s, db, blobs := newPipelineService(t, &stubOCRProvider{name: "local", err: errors.New("no text layer")}, 1)
+
+	doc, err := s.EnqueueDocument(newUpload("scan.pdf", []byte("%PDF-1.4")))
+	require.NoError(t, err)
+	s.processDocument(ctx, <-s.jobs)
+
+	stored := loadDocument(t, db, doc.ID)
+	assert.Equal(t, model.DocumentStatusFailed, stored.ProcessingStatus)
+	assert.Contains(t, stored.ProcessingError, "no text layer")
+	assert.Empty(t, stored.StorageKey)
+
+	_, _, err = blobs.Get(ctx, documentBlobKey(doc.ID, "scan.pdf"))
+	assert.ErrorIs(t, err, ErrBlobNotFound, "the orphaned upload is deleted")
+
+	status, err := s.GetDocumentStatus(doc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.DocumentStatusFailed, status.ProcessingStatus)
+
+	_, err = s.GetDocumentStatus("missing")
+	assert.ErrorIs(t, err, ErrDocumentNotFound)
+}
+
+func TestEnqueueDocument_QueueFull(t *testing.T) {
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: "text"}, 1)
+
+	_, err := s.EnqueueDocument(newUpload("first.txt", []byte("first")))
+	require.NoError(t, err)
+	_, err = s.EnqueueDocument(newUpload("second.txt", []byte("second")))
+	assert.ErrorIs(t, err, ErrProcessingQueueFull)
+
+	var count int64
+	require.NoError(t, db.Model(&model.Document{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count, "a document that could not be queued is not kept")
+}
+
+func TestResumeProcessing(t *testing.T) {
+	ctx := context.Background()
+	ocr := &stubOCRProvider{name: "local", text: "Signed by both parties."}
+	s, db, blobs := newPipelineService(t, ocr, 10)
+
+	key := documentBlobKey("doc-uploaded", "nda.txt")
+	require.NoError(t, blobs.Put(ctx, key, []byte("Signed by both parties."), "text/plain"))
+	require.NoError(t, db.Create(&model.Document{ID: "doc-uploaded", Title: "nda", FileType: "txt", StorageKey: key, ProcessingStatus: model.DocumentStatusUploaded}).Error)
+	require.NoError(t, db.Create(&model.Document{ID: "doc-queued", Title: "lost", FileType: "txt", ProcessingStatus: model.DocumentStatusQueued}).Error)
+	require.NoError(t, db.Create(&model.Document{ID: "doc-done", Title: "done", FileType: "txt", ProcessingStatus: model.DocumentStatusAnalyzed}).Error)
+
+	require.NoError(t, s.ResumeProcessing(ctx))
+	require.Len(t, s.jobs, 2)
+	for len(s.jobs) > 0 {
+		s.processDocument(ctx, <-s.jobs)
+	}
+
+	assert.Equal(t, model.DocumentStatusAnalyzed, loadDocument(t, db, "doc-uploaded").ProcessingStatus)
+	assert.Equal(t, 1, ocr.calls)
+
+	lost := loadDocument(t, db, "doc-queued")
+	assert.Equal(t, model.DocumentStatusFailed, lost.ProcessingStatus)
+	assert.Contains(t, lost.ProcessingError, "upload it again")
+}
+
+// blockingBlobStore holds every Put until release is closed or the context is done
+type blockingBlobStore struct {
+	BlobStore
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBlobStore) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	b.started <- struct{}{}
+	select {
+	case <-b.release:
+		return b.BlobStore.Put(ctx, key, body, contentType)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestStopWorkers(t *testing.T) {
+	s, db, blobs := newPipelineService(t, &stubOCRProvider{name: "local", text: "This agreement has no NDA."}, 2)
+	blocking := &blockingBlobStore{BlobStore: blobs, started: make(chan struct{}, 2), release: make(chan struct{})}
+	s.blobs = blocking
+
+	// Jobs in progress finish before the workers stop
+	s.StartWorkers(context.Background())
+	finished, err := s.EnqueueDocument(newUpload("contract.txt", []byte("This agreement has no NDA.")))
+	require.NoError(t, err)
+	<-blocking.started
+	stopped := make(chan error)
+	go func() { stopped <- s.StopWorkers(context.Background()) }()
+	close(blocking.release)
+	require.NoError(t, <-stopped)
+	assert.Equal(t, model.DocumentStatusAnalyzed, loadDocument(t, db, finished.ID).ProcessingStatus)
+
+	// Past the deadline they are cancelled and keep their stage for the next start
+	blocking.release = make(chan struct{})
+	s.StartWorkers(context.Background())
+	interrupted, err := s.EnqueueDocument(newUpload("lease.txt", []byte("This agreement has no NDA.")))
+	require.NoError(t, err)
+	<-blocking.started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, s.StopWorkers(ctx), context.Canceled)
+
+	stored := loadDocument(t, db, interrupted.ID)
+	assert.Equal(t, model.DocumentStatusQueued, stored.ProcessingStatus)
+	assert.Empty(t, stored.ProcessingError)
+}
+
+// recordingSearchIndex remembers which documents were indexed and deleted
+type recordingSearchIndex struct {
+	indexed []string
+	deleted []string
+}
+
+func (r *recordingSearchIndex) Name() string { return "recording" }
+
+func (r *recordingSearchIndex) Index(ctx context.Context, doc SearchDocument) error {
+	r.indexed = append(r.indexed, doc.ID)
+	return nil
+}
+
+func (r *recordingSearchIndex) Delete(ctx context.Context, id string) error {
+	r.deleted = append(r.deleted, id)
+	return nil
+}
+
+func (r *recordingSearchIndex) Search(ctx context.Context, query string) ([]map[string]interface{}, error) {
+	return nil, nil
+}
+
+// IDs returns the indexed IDs that have not been deleted since
+func (r *recordingSearchIndex) IDs(ctx context.Context) ([]string, error) {
+	var ids []string
+	for _, id := range r.indexed {
+		if !contains(r.deleted, id) && !contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func TestProcessDocument_RollsBackAndCompensates(t *testing.T) {
+	ctx := context.Background()
+	s, db, blobs := newPipelineService(t, &stubOCRProvider{name: "local", text: "No NDA here."}, 1)
+	search := &recordingSearchIndex{}
+	s.search = search
+
+	// Fail the first action item insert, after the document update and before the rule result
+	require.NoError(t, db.Callback().Create().Before("gorm:create").Register("fail_action_items", func(tx *gorm.DB) {
+		if tx.Statement.Table == "action_items" {
+			tx.AddError(errors.New("action_items unavailable"))
+		}
+	}))
+
+	doc, err := s.EnqueueDocument(newUpload("contract.txt", []byte("No NDA here.")))
+	require.NoError(t, err)
+	s.processDocument(ctx, <-s.jobs)
+
+	stored := loadDocument(t, db, doc.ID)
+	assert.Equal(t, model.DocumentStatusFailed, stored.ProcessingStatus)
+	assert.Contains(t, stored.ProcessingError, "action_items unavailable")
+	assert.Empty(t, stored.ParsedData, "compliance results are rolled back")
+	assert.Equal(t, "No NDA here.", stored.OcrText, "the extracted text is kept for reprocessing")
+
+	for _, table := range []string{"action_items", "document_rule_results"} {
+		var count int64
+		require.NoError(t, db.Table(table).Where("document_id = ?", doc.ID).Count(&count).Error)
+		assert.Zero(t, count, table)
+	}
+
+	_, _, err = blobs.Get(ctx, documentBlobKey(doc.ID, "contract.txt"))
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+	assert.Equal(t, []string{doc.ID}, search.indexed)
+	assert.Equal(t, []string{doc.ID}, search.deleted)
+
+	// Once the cause is fixed the failed document can be analyzed again
+	require.NoError(t, db.Callback().Create().Remove("fail_action_items"))
+	_, err = s.ReprocessDocument(doc.ID)
+	require.NoError(t, err)
+	s.processDocument(ctx, <-s.jobs)
+	assert.Equal(t, model.DocumentStatusAnalyzed, loadDocument(t, db, doc.ID).ProcessingStatus)
+	assert.Equal(t, []string{doc.ID, doc.ID}, search.indexed, "the search entry is restored")
+}
+
+func TestCompensationStack_RunsAllInReverse(t *testing.T) {
+	var order []string
+	var undo compensationStack
+	undo.push("first", func(ctx context.Context) error { order = append(order, "first"); return nil })
+	undo.push("second", func(ctx context.Context) error { order = append(order, "second"); return errors.New("boom") })
+	undo.push("third", func(ctx context.Context) error { order = append(order, "third"); return nil })
+
+	err := undo.run(context.Background())
+	assert.Equal(t, []string{"third", "second", "first"}, order)
+	assert.EqualError(t, err, "second: boom")
+}
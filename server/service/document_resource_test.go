@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// analyzedDocument uploads and fully processes a document
+func analyzedDocument(t *testing.T, s *DocumentService, filename, text string) *model.Document {
+	doc, err := s.EnqueueDocument(newUpload(filename, []byte(text)))
+	require.NoError(t, err)
+	s.processDocument(context.Background(), <-s.jobs)
+	return doc
+}
+
+func TestGetAndUpdateDocument(t *testing.T) {
+	ctx := context.Background()
+	s, _, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: "No NDA here."}, 1)
+	search := &recordingSearchIndex{}
+	s.search = search
+	doc := analyzedDocument(t, s, "contract.txt", "No NDA here.")
+
+	details, err := s.GetDocument(doc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "contract", details["title"])
+	assert.Equal(t, model.DocumentStatusAnalyzed, details["processing_status"])
+	assert.Len(t, details["action_items"], 1)
+	assert.Len(t, details["rule_results"], 2, "passed rules are recorded too")
+
+	updated, err := s.UpdateDocumentTitle(ctx, doc.ID, "  Master Services Agreement ")
+	require.NoError(t, err)
+	assert.Equal(t, "Master Services Agreement", updated.Title)
+	assert.Len(t, search.indexed, 2, "renaming refreshes the search entry")
+
+	_, err = s.UpdateDocumentTitle(ctx, doc.ID, " ")
+	assert.ErrorIs(t, err, ErrEmptyTitle)
+	_, err = s.GetDocument("missing")
+	assert.ErrorIs(t, err, ErrDocumentNotFound)
+}
+
+func TestDeleteDocument_Cascades(t *testing.T) {
+	ctx := context.Background()
+	s, db, blobs := newPipelineService(t, &stubOCRProvider{name: "local", text: "No NDA here."}, 1)
+	search := &recordingSearchIndex{}
+	s.search = search
+	doc := analyzedDocument(t, s, "contract.txt", "No NDA here.")
+	storageKey := loadDocument(t, db, doc.ID).StorageKey
+
+	require.NoError(t, s.DeleteDocument(ctx, doc.ID))
+
+	for _, table := range []string{"documents", "action_items", "document_rule_results", "document_pages"} {
+		var count int64
+		require.NoError(t, db.Table(table).Count(&count).Error)
+		assert.Zero(t, count, table)
+	}
+	_, _, err := blobs.Get(ctx, storageKey)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+	assert.Equal(t, []string{doc.ID}, search.deleted)
+
+	assert.ErrorIs(t, s.DeleteDocument(ctx, doc.ID), ErrDocumentNotFound)
+}
+
+func TestDeleteDocument_RefusesWhileProcessing(t *testing.T) {
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: "text"}, 1)
+	doc, err := s.EnqueueDocument(newUpload("contract.txt", []byte("text")))
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.DeleteDocument(context.Background(), doc.ID), ErrDocumentBusy)
+	assert.Equal(t, model.DocumentStatusQueued, loadDocument(t, db, doc.ID).ProcessingStatus)
+}
+
+func TestReprocessDocument(t *testing.T) {
+	ctx := context.Background()
+	ocr := &stubOCRProvider{name: "local", text: "No NDA here."}
+	s, db, _ := newPipelineService(t, ocr, 1)
+	doc := analyzedDocument(t, s, "contract.txt", "No NDA here.")
+
+	// Complete the first action item, then change which rule the LLM reports
+	require.NoError(t, db.Model(&model.ActionItem{}).Where("document_id = ?", doc.ID).Update("status", "completed").Error)
+	s.llm.(*FakeLLMClient).Responses[LLMCallSiteRuleDetection] = `{"violated_rules": ["Signature Check"]}`
+
+	queued, err := s.ReprocessDocument(doc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.DocumentStatusOCRDone, queued.ProcessingStatus)
+	_, err = s.ReprocessDocument(doc.ID)
+	assert.ErrorIs(t, err, ErrDocumentBusy)
+
+	s.processDocument(ctx, <-s.jobs)
+	assert.Equal(t, model.DocumentStatusAnalyzed, loadDocument(t, db, doc.ID).ProcessingStatus)
+	assert.Equal(t, 1, ocr.calls, "the extracted text is reused")
+
+	var actions []model.ActionItem
+	require.NoError(t, db.Order("status").Find(&actions, "document_id = ?", doc.ID).Error)
+	require.Len(t, actions, 2)
+	assert.Equal(t, "rule-1", actions[0].RuleID, "completed items are kept")
+	assert.Equal(t, "rule-2", actions[1].RuleID)
+
+	var results []model.DocumentRuleResult
+	require.NoError(t, db.Order("rule_id").Find(&results, "document_id = ?", doc.ID).Error)
+	require.Len(t, results, 2, "the previous results are replaced")
+	assert.Equal(t, RuleStatusPass, results[0].Status)
+	assert.Equal(t, RuleStatusFail, results[1].Status)
+}
+
+func TestReprocessDocument_ClaimsOnce(t *testing.T) {
+	s, _, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: "No NDA here."}, 10)
+	doc := analyzedDocument(t, s, "contract.txt", "No NDA here.")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = s.ReprocessDocument(doc.ID)
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.ErrorIs(t, err, ErrDocumentBusy)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+	assert.Len(t, s.jobs, 1)
+	assert.ErrorIs(t, s.DeleteDocument(context.Background(), doc.ID), ErrDocumentBusy)
+}
+
+func TestReprocessDocument_FailureKeepsDocument(t *testing.T) {
+	ctx := context.Background()
+	s, db, blobs := newPipelineService(t, &stubOCRProvider{name: "local", text: "No NDA here."}, 1)
+	doc := analyzedDocument(t, s, "contract.txt", "No NDA here.")
+	storageKey := loadDocument(t, db, doc.ID).StorageKey
+
+	// Make the analysis fail by dropping the rules table
+	require.NoError(t, db.Exec("DROP TABLE compliance_rules").Error)
+	_, err := s.ReprocessDocument(doc.ID)
+	require.NoError(t, err)
+	s.processDocument(ctx, <-s.jobs)
+
+	stored := loadDocument(t, db, doc.ID)
+	assert.Equal(t, model.DocumentStatusFailed, stored.ProcessingStatus)
+	assert.Equal(t, storageKey, stored.StorageKey)
+	assert.NotEmpty(t, stored.OcrText)
+	_, _, err = blobs.Get(ctx, storageKey)
+	assert.NoError(t, err, "the original file survives a failed reprocess")
+}
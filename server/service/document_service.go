@@ -1,219 +1,158 @@
 package services
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"mime/multipart"
-	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Itish41/LegalEagle/config"
 	model "github.com/Itish41/LegalEagle/models"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/elastic/go-elasticsearch/v8"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
+// LowOCRConfidenceThreshold is the page confidence below which the dashboard flags a page
+const LowOCRConfidenceThreshold = 0.6
+
 // DocumentService handles document processing logic
 type DocumentService struct {
-	s3Client *s3.S3
-	esClient *elasticsearch.Client
-	db       *gorm.DB
+	blobs  BlobStore
+	search SearchIndex
+	ocr    OCRProvider
+	llm    LLMClient
+	smtp   config.SMTPConfig
+	db     *gorm.DB
+
+	// jobs feeds the processing workers; workers is the size of the pool.
+	jobs    chan processingJob
+	workers int
+	// stopWorkers stops the pool taking new jobs and cancelJobs aborts the
+	// jobs in progress; workersDone waits for the workers to return.
+	stopWorkers context.CancelFunc
+	cancelJobs  context.CancelFunc
+	workersDone sync.WaitGroup
+
+	// evaluators checks rules by type; see ruleEvaluators.
+	evaluators     *ruleEvaluatorRegistry
+	evaluatorsOnce sync.Once
 }
 
-// NewDocumentService initializes the service with an S3 client and Elasticsearch client
-func NewDocumentService(db *gorm.DB) (*DocumentService, error) {
-	region := os.Getenv("SUPABASE_REGION")
-	endpoint := os.Getenv("SUPABASE_S3_ENDPOINT")
-	accessKey := os.Getenv("SUPABASE_ACCESS_KEY")
-	secretKey := os.Getenv("SUPABASE_SECRET_KEY")
-
-	if region == "" || endpoint == "" || accessKey == "" || secretKey == "" {
-		return nil, fmt.Errorf("missing required S3 configuration environment variables")
-	}
-
-	sess, err := session.NewSession(&aws.Config{
-		Region:           aws.String(region),
-		Endpoint:         aws.String(endpoint),
-		DisableSSL:       aws.Bool(false), // Changed to false for most cloud providers
-		Credentials:      credentials.NewStaticCredentials(accessKey, secretKey, ""),
-		S3ForcePathStyle: aws.Bool(true),
-	})
+// NewDocumentService initializes the service from the loaded configuration
+func NewDocumentService(db *gorm.DB, cfg *config.Config) (*DocumentService, error) {
+	blobs, err := NewBlobStore(cfg.Storage)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AWS session: %w", err)
-	}
-
-	// Initialize Elasticsearch client with Elastic Cloud configuration
-	esURL := "https://9599cea5e64e4db2b21dbee49e7ca79e.asia-south1.gcp.elastic-cloud.com:443"
-	esAPIKey := os.Getenv("ELASTICSEARCH_API_KEY") // Set this to your encoded API key
-	var esClient *elasticsearch.Client
-
-	if esURL != "" && esAPIKey != "" {
-		esConfig := elasticsearch.Config{
-			Addresses: []string{esURL},
-			APIKey:    esAPIKey,
-		}
-		esClient, err = elasticsearch.NewClient(esConfig)
-		if err != nil {
-			log.Printf("Warning: Failed to create Elasticsearch client: %v", err)
-		}
-	} else {
-		log.Println("Warning: ELASTICSEARCH_API_KEY is not set. Elasticsearch client will not be initialized.")
+		return nil, fmt.Errorf("failed to configure blob storage: %w", err)
 	}
 
-	return &DocumentService{s3Client: s3.New(sess), esClient: esClient, db: db}, nil
-}
-
-// UploadAndProcessDocument uploads the file to Supabase S3 and processes it with OCR.space
-func (s *DocumentService) UploadAndProcessDocument(file multipart.File, header *multipart.FileHeader) (string, string, string, string, float64, error) {
-	log.Println("Starting UploadAndProcessDocument")
-	log.Printf("File details: Name=%s, Size=%d", header.Filename, header.Size)
-
-	// Step 1: Upload file to Supabase S3
-	fileBytes, err := io.ReadAll(file)
+	searchIndex, err := NewSearchIndex(cfg.Search, db)
 	if err != nil {
-		log.Printf("ERROR reading file: %v", err)
-		return "", "", "", "", 0.0, fmt.Errorf("failed to read file: %w", err)
+		return nil, fmt.Errorf("failed to configure search index: %w", err)
 	}
+	log.Printf("Using search backend: %s", searchIndex.Name())
 
-	fileID := fmt.Sprintf("%d-%s", time.Now().Unix(), header.Filename)
-	bucket := os.Getenv("SUPABASE_BUCKET")
-	if bucket == "" {
-		log.Println("SUPABASE_BUCKET environment variable is not set")
-		return "", "", "", "", 0.0, fmt.Errorf("bucket name not configured")
-	}
-
-	uploadInput := &s3.PutObjectInput{
-		Bucket:      aws.String(bucket),
-		Key:         aws.String(fileID),
-		Body:        bytes.NewReader(fileBytes),
-		ACL:         aws.String("public-read"),
-		ContentType: aws.String(header.Header.Get("Content-Type")),
+	ocrProvider, err := NewOCRProvider(cfg.OCR.Provider, cfg.OCR.OCRSpaceAPIKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure OCR provider: %w", err)
 	}
+	log.Printf("Using OCR provider: %s", ocrProvider.Name())
 
-	_, err = s.s3Client.PutObject(uploadInput)
+	llmClient, err := NewLLMClient(LLMClientConfig{
+		Provider:     cfg.LLM.Provider,
+		BaseURL:      cfg.LLM.BaseURL,
+		APIKey:       cfg.LLM.APIKey,
+		Models:       cfg.LLM.Models,
+		DefaultModel: cfg.LLM.DefaultModel,
+	})
 	if err != nil {
-		log.Printf("S3 upload error: %v", err)
-		return "", "", "", "", 0.0, fmt.Errorf("failed to upload file to S3: %w", err)
+		return nil, fmt.Errorf("failed to configure LLM client: %w", err)
 	}
 
-	fileURL := fmt.Sprintf("%s/object/public/%s/%s", os.Getenv("SUPABASE_S3_URL"), bucket, fileID)
-	log.Printf("File stored at: %s", fileURL)
-
-	// Step 2: Process with OCR.space
-	apiKey := os.Getenv("OCR_SPACE_API_KEY")
-	if apiKey == "" {
-		log.Println("OCR_SPACE_API_KEY environment variable is not set")
-		return "", "", "", "", 0.0, fmt.Errorf("OCR API key not configured")
+	evaluators := newRuleEvaluatorRegistry()
+	if cfg.Policy.Dir != "" {
+		policies, err := NewRegoEvaluator(cfg.Policy.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Rego policies: %w", err)
+		}
+		evaluators.register(model.RuleTypeRego, policies)
+		log.Printf("Using Rego policy bundles: %v", policies.BundleNames())
 	}
 
-	ocrText, err := processWithOCRSpace(fileBytes, header.Filename)
-	if err != nil {
-		log.Printf("ERROR in OCR processing: %v", err)
-		return "", "", "", "", 0.0, fmt.Errorf("failed to process OCR with OCR.space: %w", err)
-	}
-	log.Printf("OCR Text extracted: %s", ocrText)
+	evaluators.register(model.RuleTypeLLMJudged, llmJudgedEvaluator{
+		local:    NewLocalAnalyzer(cfg.Fallback.KeywordRatio, cfg.Fallback.MinPatternMatches),
+		chunking: NewChunkOptions(cfg.LLM.ChunkMaxChars, cfg.LLM.ChunkConcurrency),
+	})
 
-	// Step 3: Index in Elasticsearch
-	err = s.indexDocument(fileID, fileURL, ocrText)
+	modules, err := NewWASMEvaluator(blobs, cfg.WASM.MemoryLimitMB, cfg.WASM.Timeout)
 	if err != nil {
-		log.Printf("Elasticsearch indexing error: %v", err)
-		return "", "", "", "", 0.0, fmt.Errorf("failed Merkel to index document in Elasticsearch: %w", err)
+		return nil, fmt.Errorf("failed to set up the WASM rule sandbox: %w", err)
 	}
-	log.Printf("Document indexed successfully with ID: %s", fileID)
+	evaluators.register(model.RuleTypeWASM, modules)
 
-	// Step 4: Compliance Analysis
-	// Determine violated rules using Groq
-	violatedRuleNames, err := s.DetermineApplicableRules(ocrText)
-	if err != nil {
-		log.Printf("ERROR determining violated rules: %v", err)
-		return "", "", "", "", 0.0, err
+	if !cfg.SMTP.Enabled() {
+		log.Println("Warning: SMTP is not configured. Action item assignments will not send emails.")
 	}
-	log.Printf("Violated Rules: %v", violatedRuleNames)
 
-	// Fetch all rules to build complete parsed_data
-	allRules, err := s.GetAllComplianceRules()
-	if err != nil {
-		log.Printf("ERROR fetching all rules from database: %v", err)
-		return "", "", "", "", 0.0, fmt.Errorf("failed to fetch rules from database: %w", err)
-	}
-	log.Printf("Fetched %d rules from database", len(allRules))
+	return &DocumentService{
+		blobs:      blobs,
+		search:     searchIndex,
+		ocr:        ocrProvider,
+		llm:        llmClient,
+		smtp:       cfg.SMTP,
+		db:         db,
+		jobs:       make(chan processingJob, cfg.Processing.QueueSize),
+		workers:    cfg.Processing.Workers,
+		evaluators: evaluators,
+	}, nil
+}
 
-	// Generate parsed_data for all rules
-	var complianceResults []map[string]interface{}
-	ruleMap := make(map[string]model.ComplianceRule)
-	for _, rule := range allRules {
-		ruleMap[rule.Name] = rule
-		result := map[string]interface{}{
-			"rule_name":   rule.Name,
-			"severity":    rule.Severity,
-			"status":      "pass",
-			"explanation": fmt.Sprintf("The document complies with the '%s' rule.", rule.Name),
-		}
-		if contains(violatedRuleNames, rule.Name) {
-			result["status"] = "fail"
-			result["explanation"] = fmt.Sprintf("The document violates the '%s' rule: does not meet the required pattern '%s'.", rule.Name, rule.Pattern)
-		}
-		complianceResults = append(complianceResults, result)
-		log.Printf("Compliance result for %s: %+v", rule.Name, result)
-	}
+// SetOCRProvider replaces the OCR provider used for new uploads
+func (s *DocumentService) SetOCRProvider(provider OCRProvider) {
+	s.ocr = provider
+}
 
-	// Calculate risk score
-	riskScore := s.CalculateRiskScore(complianceResults, allRules)
-	log.Printf("Calculated Risk Score: %f", riskScore)
+// SetLLMClient replaces the LLM client used for compliance analysis
+func (s *DocumentService) SetLLMClient(client LLMClient) {
+	s.llm = client
+}
 
-	// Marshal compliance results
-	parsedDataJSON, err := json.Marshal(complianceResults)
-	if err != nil {
-		log.Printf("ERROR marshaling compliance results: %v", err)
-		return "", "", "", "", 0.0, fmt.Errorf("failed to marshal compliance results: %w", err)
+// LLMUsage returns the token usage per call site when the LLM client tracks it
+func (s *DocumentService) LLMUsage() map[string]LLMUsage {
+	if managed, ok := s.llm.(*ManagedLLMClient); ok {
+		return managed.Usage()
 	}
-	log.Printf("Compliance Results JSON: %s", string(parsedDataJSON))
+	return map[string]LLMUsage{}
+}
 
-	// Step 5: Save to database with compliance results
-	fileName := filepath.Base(fileURL)
-	fileType := filepath.Ext(fileName)
-	if fileType != "" {
-		fileType = fileType[1:] // Remove the leading dot
+// saveDocumentPages stores the per-page OCR text of a document
+func (s *DocumentService) saveDocumentPages(db *gorm.DB, documentID string, pages []OCRPage) error {
+	if len(pages) == 0 {
+		return nil
 	}
-	title := strings.TrimSuffix(fileName, fileType)
 
-	doc := model.Document{
-		Title:       title,
-		FileType:    fileType,
-		OriginalURL: fileURL,
-		OcrText:     ocrText,
-		ParsedData:  datatypes.JSON(parsedDataJSON),
-		RiskScore:   riskScore,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+	rows := make([]model.DocumentPage, 0, len(pages))
+	for _, page := range pages {
+		rows = append(rows, model.DocumentPage{
+			DocumentID:  documentID,
+			PageNumber:  page.Number,
+			Text:        page.Text,
+			Confidence:  page.Confidence,
+			StartOffset: page.StartOffset,
+			EndOffset:   page.EndOffset,
+			CreatedAt:   time.Now(),
+		})
 	}
-	if err := s.db.Create(&doc).Error; err != nil {
-		log.Printf("ERROR saving document to database: %v", err)
-		return "", "", "", "", 0.0, fmt.Errorf("failed to save to database: %w", err)
+	if err := db.Create(&rows).Error; err != nil {
+		return err
 	}
-	log.Printf("Document saved to database successfully with ID: %s", doc.ID)
-
-	// Step 6: Create Action Items and Document Rule Results
-	err = s.CreateActionItems(doc)
-	if err != nil {
-		log.Printf("Error creating action items: %v", err)
-		return "", "", "", "", 0.0, fmt.Errorf("failed to create action items: %w", err)
-	}
-	log.Printf("Action items processed for document %s", doc.ID)
-
-	return ocrText, fileID, fileURL, string(parsedDataJSON), riskScore, nil
+	log.Printf("Saved %d pages for document %s", len(rows), documentID)
+	return nil
 }
 
 // Helper function to check if a slice contains a string
@@ -226,267 +165,37 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
-// SearchDocuments searches for documents in Elasticsearch
+// SearchDocuments runs a full-text search on the configured search backend
 func (s *DocumentService) SearchDocuments(query string) ([]map[string]interface{}, error) {
-	// Validate Elasticsearch client
-	if s.esClient == nil {
-		return nil, fmt.Errorf("elasticsearch client is not initialized")
-	}
-
-	// Prepare the Elasticsearch query
-	searchQuery := map[string]interface{}{
-		"query": map[string]interface{}{
-			"multi_match": map[string]interface{}{
-				"query":  query,
-				"fields": []string{"ocr_text", "file_id"}, // Search these fields
-			},
-		},
-	}
-	body, err := json.Marshal(searchQuery)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal search query: %w", err)
-	}
-
-	// Execute the search
-	res, err := s.esClient.Search(
-		s.esClient.Search.WithContext(context.Background()),
-		s.esClient.Search.WithIndex("documents"),
-		s.esClient.Search.WithBody(bytes.NewReader(body)),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("search request failed: %w", err)
-	}
-	defer res.Body.Close()
-
-	if res.IsError() {
-		return nil, fmt.Errorf("elasticsearch search failed: %s", res.String())
-	}
-
-	// Parse the response
-	var result map[string]interface{}
-	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode search response: %w", err)
-	}
-
-	// Safely extract hits
-	hitsMap, ok := result["hits"].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid hits structure in search response")
-	}
-
-	hitsArray, ok := hitsMap["hits"].([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid hits array in search response")
-	}
-
-	var documents []map[string]interface{}
-	for _, hit := range hitsArray {
-		hitMap, ok := hit.(map[string]interface{})
-		if !ok {
-			continue // Skip invalid hits
-		}
-
-		source, ok := hitMap["_source"].(map[string]interface{})
-		if !ok {
-			continue // Skip hits without a valid source
-		}
-
-		documents = append(documents, source)
-	}
-
-	return documents, nil
+	return s.search.Search(context.Background(), query)
 }
 
-// processWithOCRSpace sends the file to OCR.space and returns the extracted text
-func processWithOCRSpace(fileBytes []byte, filename string) (string, error) {
-	// Trim whitespace and validate API key
-	apiKey := strings.TrimSpace(os.Getenv("OCR_SPACE_API_KEY"))
-	if apiKey == "" {
-		return "", fmt.Errorf("OCR.space API key is not set")
-	}
-
-	// Additional validation for API key
-	if len(apiKey) < 10 {
-		return "", fmt.Errorf("invalid OCR.space API key format")
-	}
-
-	// Log API key (be careful in production!)
-	log.Printf("Using OCR.space API Key (first 4 chars): %s", apiKey[:4])
-	log.Printf("Full API Key Length: %d", len(apiKey))
-
-	// Determine file type based on filename extension
-	fileExt := strings.ToLower(filepath.Ext(filename))
-	var fileType string
-	switch fileExt {
-	case ".pdf":
-		fileType = "PDF"
-	case ".png":
-		fileType = "PNG"
-	case ".jpg", ".jpeg":
-		fileType = "JPG"
-	case ".gif":
-		fileType = "GIF"
-	case ".tiff", ".tif":
-		fileType = "TIFF"
-	default:
-		fileType = "PDF" // Default to PDF if unknown
-		log.Printf("Unknown file type for %s, defaulting to PDF", filename)
-	}
-
-	// Construct endpoint URL with API key
-	endpoint := "https://api.ocr.space/parse/image"
-
-	// Prepare multipart form
-	var b bytes.Buffer
-	w := multipart.NewWriter(&b)
-
-	// Add form fields
-	if err := w.WriteField("apikey", apiKey); err != nil {
-		return "", fmt.Errorf("failed to write apikey field: %w", err)
-	}
-	if err := w.WriteField("language", "eng"); err != nil {
-		return "", fmt.Errorf("failed to write language field: %w", err)
-	}
-	if err := w.WriteField("isOverlayRequired", "false"); err != nil {
-		return "", fmt.Errorf("failed to write isOverlayRequired field: %w", err)
-	}
-	if err := w.WriteField("filetype", fileType); err != nil {
-		return "", fmt.Errorf("failed to write filetype field: %w", err)
-	}
-
-	// Add file
-	fw, err := w.CreateFormFile("file", filename)
-	if err != nil {
-		return "", fmt.Errorf("failed to create form file: %w", err)
-	}
-	_, err = fw.Write(fileBytes)
-	if err != nil {
-		return "", fmt.Errorf("failed to write file bytes: %w", err)
-	}
-	w.Close()
-
-	// Create request
-	req, err := http.NewRequest("POST", endpoint, &b)
-	if err != nil {
-		return "", fmt.Errorf("failed to create OCR request: %w", err)
-	}
-	req.Header.Set("Content-Type", w.FormDataContentType())
-
-	// Log request details for debugging
-	log.Printf("OCR Request Content-Type: %s", req.Header.Get("Content-Type"))
-	log.Printf("OCR Request Endpoint: %s", endpoint)
-	log.Printf("OCR File Type: %s", fileType)
-
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("OCR request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Read the raw response body for debugging
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	// Log the raw response for debugging
-	log.Printf("OCR Response Status: %s", resp.Status)
-	log.Printf("OCR Response Body: %s", string(bodyBytes))
-
-	// Try to parse the response
-	var result map[string]interface{}
-	err = json.Unmarshal(bodyBytes, &result)
-	if err != nil {
-		// If it's a plain text error message, return it as an error
-		errorMsg := string(bodyBytes)
-		log.Printf("OCR API Error (JSON Unmarshal): %s", errorMsg)
-		return "", fmt.Errorf("OCR API error: %s", errorMsg)
-	}
-
-	// Check for error in OCR.space response
-	if errorMessage, ok := result["ErrorMessage"].(string); ok && errorMessage != "" {
-		log.Printf("OCR.space Error Message: %s", errorMessage)
-		return "", fmt.Errorf("OCR.space error: %s", errorMessage)
-	}
-
-	// Extract parsed results
-	parsedResults, ok := result["ParsedResults"].([]interface{})
-	if !ok || len(parsedResults) == 0 {
-		log.Println("No OCR results found in response")
-		return "", fmt.Errorf("no OCR results found in response")
-	}
-
-	// Extract parsed text
-	firstResult, ok := parsedResults[0].(map[string]interface{})
-	if !ok {
-		log.Println("Invalid parsed results format")
-		return "", fmt.Errorf("invalid parsed results format")
-	}
-
-	parsedText, ok := firstResult["ParsedText"].(string)
-	if !ok {
-		log.Println("Failed to extract ParsedText")
-		return "", fmt.Errorf("failed to extract ParsedText from OCR response")
-	}
-
-	log.Printf("OCR Text extracted successfully: %d characters", len(parsedText))
-	return parsedText, nil
-}
-
-// indexDocument indexes the document in Elasticsearch
-func (s *DocumentService) indexDocument(fileID, fileURL, ocrText string) error {
-	// Skip indexing if Elasticsearch client is not initialized
-	if s.esClient == nil {
-		log.Println("Elasticsearch client not initialized. Skipping indexing.")
-		return nil
-	}
-
-	doc := map[string]interface{}{
-		"file_id":   fileID,
-		"file_url":  fileURL,
-		"ocr_text":  ocrText,
-		"timestamp": time.Now().UTC(),
-	}
-
-	body, err := json.Marshal(doc)
-	if err != nil {
-		return fmt.Errorf("failed to marshal document for indexing: %w", err)
-	}
-
-	res, err := s.esClient.Index(
-		"documents",
-		bytes.NewReader(body),
-		s.esClient.Index.WithDocumentID(fileID),
-		s.esClient.Index.WithContext(context.Background()),
-	)
-	if err != nil {
-		log.Printf("Elasticsearch indexing error: %v", err)
-		return nil // Don't break the upload process
+// indexDocument adds the document to the search index. Failures are logged
+// but do not break the upload; reconciliation can reindex the document later.
+func (s *DocumentService) indexDocument(doc SearchDocument) {
+	if err := s.search.Index(context.Background(), doc); err != nil {
+		log.Printf("Search indexing error: %v", err)
+		return // Don't break the upload process
 	}
-	defer res.Body.Close()
 
-	if res.IsError() {
-		log.Printf("Elasticsearch indexing failed: %s", res.String())
-		return nil // Don't break the upload process
-	}
-
-	log.Println("Document successfully indexed in Elasticsearch")
-	return nil
+	log.Printf("Document successfully indexed in %s", s.search.Name())
 }
 
 // processDocumentCompliance processes compliance for a single document
 func (s *DocumentService) processDocumentCompliance(doc model.Document) (map[string]interface{}, error) {
 	// Create a map representation of the document
 	docMap := map[string]interface{}{
-		"id":           doc.ID,
-		"title":        doc.Title,
-		"file_type":    doc.FileType,
-		"original_url": doc.OriginalURL,
-		"ocr_text":     doc.OcrText,
-		"risk_score":   doc.RiskScore,
-		"parsed_data":  doc.ParsedData,
+		"id":                doc.ID,
+		"title":             doc.Title,
+		"file_type":         doc.FileType,
+		"original_url":      doc.OriginalURL,
+		"tags":              DocumentTags(doc.Tags),
+		"ocr_text":          doc.OcrText,
+		"ocr_provider":      doc.OcrProvider,
+		"risk_score":        doc.RiskScore,
+		"parsed_data":       doc.ParsedData,
+		"processing_status": doc.ProcessingStatus,
+		"processing_error":  doc.ProcessingError,
 	}
 
 	// If no OCR text, return the document map without compliance processing
@@ -494,15 +203,8 @@ func (s *DocumentService) processDocumentCompliance(doc model.Document) (map[str
 		return docMap, nil
 	}
 
-	// Determine applicable rules (use context to cache or optimize)
-	applicableRuleNames, err := s.DetermineApplicableRules(doc.OcrText)
-	if err != nil || len(applicableRuleNames) == 0 {
-		return docMap, err
-	}
-
-	// If no parsed data, return with rule information
+	// Not analyzed yet
 	if len(doc.ParsedData) == 0 {
-		docMap["applicable_rules"] = applicableRuleNames
 		return docMap, nil
 	}
 
@@ -513,6 +215,15 @@ func (s *DocumentService) processDocumentCompliance(doc model.Document) (map[str
 		return docMap, err
 	}
 
+	// The rules the document was found to violate when it was analyzed
+	applicableRuleNames := []string{}
+	for _, result := range complianceResults {
+		name, _ := result["rule_name"].(string)
+		if status, _ := result["status"].(string); status == RuleStatusFail && name != "" {
+			applicableRuleNames = append(applicableRuleNames, name)
+		}
+	}
+
 	// Quick compliance status determination
 	overallStatus := "pass"
 	processedComplianceDetails := make([]map[string]interface{}, 0, len(complianceResults))
@@ -548,7 +259,13 @@ func (s *DocumentService) processDocumentCompliance(doc model.Document) (map[str
 		processedComplianceDetails = append(processedComplianceDetails, result)
 
 		// Determine status efficiently
-		if status, ok := result["status"].(string); !ok || status != "pass" {
+		switch status, _ := result["status"].(string); status {
+		case RuleStatusPass, RuleStatusNotApplicable:
+		case RuleStatusIndeterminate:
+			if overallStatus != "fail" {
+				overallStatus = RuleStatusIndeterminate
+			}
+		default:
 			overallStatus = "fail"
 		}
 	}
@@ -582,6 +299,17 @@ func (s *DocumentService) GetAllDocuments() ([]map[string]interface{}, error) {
 
 	log.Printf("GetAllDocuments: Retrieved %d documents", len(documents))
 
+	// Load page numbers and confidences (without text) for all documents at once
+	var pages []model.DocumentPage
+	if err := s.db.Select("document_id", "page_number", "confidence").Order("page_number").Find(&pages).Error; err != nil {
+		log.Printf("GetAllDocuments: Error fetching document pages: %v", err)
+		return nil, fmt.Errorf("failed to fetch document pages: %w", err)
+	}
+	pagesByDocument := make(map[string][]model.DocumentPage)
+	for _, page := range pages {
+		pagesByDocument[page.DocumentID] = append(pagesByDocument[page.DocumentID], page)
+	}
+
 	// Process documents and add compliance information
 	processedDocuments := make([]map[string]interface{}, 0, len(documents))
 	for _, doc := range documents {
@@ -590,6 +318,17 @@ func (s *DocumentService) GetAllDocuments() ([]map[string]interface{}, error) {
 			log.Printf("Error processing document %s: %v", doc.ID, err)
 			continue
 		}
+
+		// Flag pages whose OCR confidence is too low to trust
+		lowConfidencePages := []int{}
+		for _, page := range pagesByDocument[doc.ID] {
+			if page.Confidence < LowOCRConfidenceThreshold {
+				lowConfidencePages = append(lowConfidencePages, page.PageNumber)
+			}
+		}
+		processedDoc["page_count"] = len(pagesByDocument[doc.ID])
+		processedDoc["low_confidence_pages"] = lowConfidencePages
+
 		processedDocuments = append(processedDocuments, processedDoc)
 	}
 
@@ -598,3 +337,275 @@ func (s *DocumentService) GetAllDocuments() ([]map[string]interface{}, error) {
 
 	return processedDocuments, nil
 }
+
+// ErrDocumentNotFound is returned when no document exists with the requested ID
+var ErrDocumentNotFound = errors.New("document not found")
+
+// DownloadURLTTL is how long a presigned download URL stays valid
+const DownloadURLTTL = 5 * time.Minute
+
+// documentDownloadURL is the API path that serves a document's original file
+func documentDownloadURL(documentID string) string {
+	return fmt.Sprintf("/api/documents/%s/download", documentID)
+}
+
+// DocumentDownload is either a presigned URL or an open stream of the original file
+type DocumentDownload struct {
+	// URL is set when the client should be redirected to a presigned URL.
+	URL string
+	// Body is set when the file is streamed through the API; the caller closes it.
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64
+	Filename    string
+}
+
+// GetDocumentDownload returns a short-lived URL for a document's original file,
+// or a stream of the file when presigning is unsupported or stream is true.
+func (s *DocumentService) GetDocumentDownload(ctx context.Context, documentID string, stream bool) (*DocumentDownload, error) {
+	var doc model.Document
+	if err := s.db.Select("id", "title", "file_type", "original_url", "storage_key").First(&doc, "id = ?", documentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDocumentNotFound
+		}
+		log.Printf("[GetDocumentDownload] Error fetching document %s: %v", documentID, err)
+		return nil, err
+	}
+
+	// Documents uploaded before blob keys existed only have their public URL
+	if doc.StorageKey == "" {
+		if strings.HasPrefix(doc.OriginalURL, "http") {
+			return &DocumentDownload{URL: doc.OriginalURL}, nil
+		}
+		return nil, ErrBlobNotFound
+	}
+
+	if !stream {
+		url, err := s.blobs.PresignGet(ctx, doc.StorageKey, DownloadURLTTL)
+		if err == nil {
+			return &DocumentDownload{URL: url}, nil
+		}
+		if !errors.Is(err, ErrPresignNotSupported) {
+			log.Printf("[GetDocumentDownload] Presign failed for %s, streaming instead: %v", doc.StorageKey, err)
+		}
+	}
+
+	body, info, err := s.blobs.Get(ctx, doc.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	filename := doc.Title
+	if doc.FileType != "" {
+		filename += "." + doc.FileType
+	}
+	return &DocumentDownload{Body: body, ContentType: info.ContentType, Size: info.Size, Filename: filename}, nil
+}
+
+var (
+	// ErrDocumentBusy is returned when a document cannot be changed while it is being processed
+	ErrDocumentBusy = errors.New("document is still being processed")
+	// ErrNothingToReprocess is returned for documents whose upload failed before text was extracted
+	ErrNothingToReprocess = errors.New("document has no extracted text to analyze; upload it again")
+	// ErrEmptyTitle is returned when a document is renamed to a blank title
+	ErrEmptyTitle = errors.New("title must not be empty")
+)
+
+// findDocument loads a document by ID, mapping a missing row to ErrDocumentNotFound
+func (s *DocumentService) findDocument(db *gorm.DB, documentID string) (*model.Document, error) {
+	var doc model.Document
+	err := db.First(&doc, "id = ?", documentID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch document %s: %w", documentID, err)
+	}
+	return &doc, nil
+}
+
+// GetDocument returns one document with its pages summary, rule results and action items
+func (s *DocumentService) GetDocument(documentID string) (map[string]interface{}, error) {
+	doc, err := s.findDocument(s.db, documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []model.DocumentPage
+	if err := s.db.Select("page_number", "confidence").Where("document_id = ?", doc.ID).Order("page_number").Find(&pages).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch document pages: %w", err)
+	}
+	lowConfidencePages := []int{}
+	for _, page := range pages {
+		if page.Confidence < LowOCRConfidenceThreshold {
+			lowConfidencePages = append(lowConfidencePages, page.PageNumber)
+		}
+	}
+
+	var results []model.DocumentRuleResult
+	if err := s.db.Where("document_id = ?", doc.ID).Find(&results).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch rule results: %w", err)
+	}
+	var actions []model.ActionItem
+	if err := s.db.Where("document_id = ?", doc.ID).Order("created_at").Find(&actions).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch action items: %w", err)
+	}
+
+	return map[string]interface{}{
+		"id":                   doc.ID,
+		"title":                doc.Title,
+		"file_type":            doc.FileType,
+		"original_url":         doc.OriginalURL,
+		"tags":                 DocumentTags(doc.Tags),
+		"ocr_text":             doc.OcrText,
+		"ocr_provider":         doc.OcrProvider,
+		"risk_score":           doc.RiskScore,
+		"parsed_data":          doc.ParsedData,
+		"processing_status":    doc.ProcessingStatus,
+		"processing_error":     doc.ProcessingError,
+		"page_count":           len(pages),
+		"low_confidence_pages": lowConfidencePages,
+		"rule_results":         results,
+		"action_items":         actions,
+		"created_at":           doc.CreatedAt,
+		"updated_at":           doc.UpdatedAt,
+	}, nil
+}
+
+// UpdateDocumentTitle renames a document and refreshes its search entry
+func (s *DocumentService) UpdateDocumentTitle(ctx context.Context, documentID, title string) (*model.Document, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return nil, ErrEmptyTitle
+	}
+
+	doc, err := s.findDocument(s.db, documentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.updateDocument(s.db, doc.ID, map[string]interface{}{"title": title}); err != nil {
+		return nil, fmt.Errorf("failed to update document: %w", err)
+	}
+	doc.Title = title
+	doc.UpdatedAt = time.Now()
+
+	if doc.ProcessingStatus == model.DocumentStatusAnalyzed {
+		s.indexDocument(SearchDocument{ID: doc.ID, Title: doc.Title, FileURL: doc.OriginalURL, OcrText: doc.OcrText, Timestamp: doc.CreatedAt})
+	}
+	return doc, nil
+}
+
+// UpdateDocumentTags replaces a document's tags. Rule scopes see the new tags
+// the next time the document is analyzed.
+func (s *DocumentService) UpdateDocumentTags(ctx context.Context, documentID string, tags []string) (*model.Document, error) {
+	doc, err := s.findDocument(s.db, documentID)
+	if err != nil {
+		return nil, err
+	}
+	tagsJSON, err := json.Marshal(normalizeTags(tags))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tags: %w", err)
+	}
+	if err := s.updateDocument(s.db, doc.ID, map[string]interface{}{"tags": datatypes.JSON(tagsJSON)}); err != nil {
+		return nil, fmt.Errorf("failed to update document: %w", err)
+	}
+	doc.Tags = datatypes.JSON(tagsJSON)
+	doc.UpdatedAt = time.Now()
+	return doc, nil
+}
+
+// DeleteDocument removes a document with its pages, rule results and action
+// items, then its original file and search entry. Leftovers from a failed
+// blob or index delete are reported by Reconcile.
+func (s *DocumentService) DeleteDocument(ctx context.Context, documentID string) error {
+	var doc *model.Document
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if doc, err = s.findDocument(tx, documentID); err != nil {
+			return err
+		}
+		if isProcessing(*doc) {
+			return ErrDocumentBusy
+		}
+		// Only delete the row if no reprocess claimed it since it was read
+		deleted := tx.Where("id = ? AND processing_status NOT IN ?", doc.ID, unfinishedStatuses).Delete(&model.Document{})
+		if deleted.Error != nil {
+			return deleted.Error
+		}
+		if deleted.RowsAffected == 0 {
+			return ErrDocumentBusy
+		}
+		for _, table := range []interface{}{&model.ActionItem{}, &model.DocumentRuleResult{}, &model.DocumentPage{}} {
+			if err := tx.Where("document_id = ?", doc.ID).Delete(table).Error; err != nil {
+				return fmt.Errorf("failed to delete document data: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if doc.StorageKey != "" {
+		if err := s.blobs.Delete(ctx, doc.StorageKey); err != nil {
+			log.Printf("[DeleteDocument] Error deleting blob %s: %v", doc.StorageKey, err)
+		}
+	}
+	if err := s.search.Delete(ctx, doc.ID); err != nil {
+		log.Printf("[DeleteDocument] Error deleting search entry %s: %v", doc.ID, err)
+	}
+	log.Printf("[DeleteDocument] Document %s deleted", doc.ID)
+	return nil
+}
+
+// ReprocessDocument queues a document for a fresh compliance analysis, e.g.
+// after the rules changed or its analysis failed. The extracted text is reused.
+func (s *DocumentService) ReprocessDocument(documentID string) (*model.Document, error) {
+	doc, err := s.findDocument(s.db, documentID)
+	if err != nil {
+		return nil, err
+	}
+	if isProcessing(*doc) {
+		return nil, ErrDocumentBusy
+	}
+	if doc.OcrText == "" {
+		return nil, ErrNothingToReprocess
+	}
+
+	// Claim the document in one statement so concurrent reprocess and delete calls cannot both proceed
+	previousStatus := doc.ProcessingStatus
+	claim := s.db.Model(&model.Document{}).
+		Where("id = ? AND processing_status NOT IN ?", doc.ID, unfinishedStatuses).
+		Updates(map[string]interface{}{"processing_status": model.DocumentStatusOCRDone, "updated_at": time.Now()})
+	if claim.Error != nil {
+		return nil, fmt.Errorf("failed to update document: %w", claim.Error)
+	}
+	if claim.RowsAffected == 0 {
+		return nil, s.unclaimableError(doc.ID)
+	}
+	doc.ProcessingStatus = model.DocumentStatusOCRDone
+
+	// A failed upload lost its search entry; a failed reprocess removes it again
+	if previousStatus == model.DocumentStatusFailed {
+		s.indexDocument(SearchDocument{ID: doc.ID, Title: doc.Title, FileURL: doc.OriginalURL, OcrText: doc.OcrText, Timestamp: doc.CreatedAt})
+	}
+
+	select {
+	case s.jobs <- processingJob{documentID: doc.ID}:
+		log.Printf("Document %s queued for reprocessing", doc.ID)
+		return doc, nil
+	default:
+		if err := s.updateDocument(s.db, doc.ID, map[string]interface{}{"processing_status": previousStatus}); err != nil {
+			log.Printf("[ReprocessDocument] Error restoring status of document %s: %v", doc.ID, err)
+		}
+		return nil, ErrProcessingQueueFull
+	}
+}
+
+// unclaimableError explains why a document could not be claimed: it is gone or
+// another call started processing it
+func (s *DocumentService) unclaimableError(documentID string) error {
+	if _, err := s.findDocument(s.db, documentID); err != nil {
+		return err
+	}
+	return ErrDocumentBusy
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	model "github.com/Itish41/LegalEagle/models"
+)
+
+// Evidence kinds
+const (
+	// EvidenceMatched points at text in the document that the outcome rests on
+	EvidenceMatched = "matched"
+	// EvidenceMissing describes a clause the rule looked for and did not find
+	EvidenceMissing = "missing"
+)
+
+// evidenceContext is how many bytes of text around a match a quote includes
+const evidenceContext = 60
+
+// Evidence shows a reviewer the passage behind a rule outcome. Matched evidence
+// is located by character offsets into the document's ocr_text and the page
+// they fall on; missing evidence only names the clause the rule expected.
+// Unlike TextSpan, whose byte offsets are only used inside the service, the
+// offsets count Unicode characters so clients can use them directly.
+type Evidence struct {
+	Kind  string `json:"kind"`
+	Text  string `json:"text"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Page  int    `json:"page,omitempty"`
+	Quote string `json:"quote,omitempty"`
+}
+
+// RuleEvidence is the evidence for one rule's result on a document
+type RuleEvidence struct {
+	RuleID      string     `json:"rule_id"`
+	RuleName    string     `json:"rule_name"`
+	Status      string     `json:"status"`
+	Severity    string     `json:"severity"`
+	Explanation string     `json:"explanation"`
+	Evidence    []Evidence `json:"evidence"`
+}
+
+// pageAt returns the number of the page holding offset, or 0 when the
+// document has no pages covering it
+func pageAt(pages []model.DocumentPage, offset int) int {
+	i := sort.Search(len(pages), func(i int) bool { return pages[i].EndOffset > offset })
+	if i < len(pages) && pages[i].StartOffset <= offset {
+		return pages[i].PageNumber
+	}
+	return 0
+}
+
+// evidenceQuote returns the text around [start, end), cut on word boundaries
+// and with whitespace collapsed, so it reads as a short quote
+func evidenceQuote(text string, start, end int) string {
+	from := max(0, start-evidenceContext)
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from--
+	}
+	to := min(len(text), end+evidenceContext)
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to++
+	}
+	if from > 0 {
+		if i := strings.IndexAny(text[from:start], " \n\t"); i >= 0 {
+			from += i + 1
+		}
+	}
+	if to < len(text) {
+		if i := strings.LastIndexAny(text[end:to], " \n\t"); i >= 0 {
+			to = end + i
+		}
+	}
+
+	quote := strings.Join(strings.Fields(text[from:to]), " ")
+	if from > 0 {
+		quote = "..." + quote
+	}
+	if to < len(text) {
+		quote += "..."
+	}
+	return quote
+}
+
+// missingClause describes what a rule expected to find
+func missingClause(rule model.ComplianceRule) string {
+	if description := strings.TrimSpace(rule.Description); description != "" {
+		return description
+	}
+	if pattern := strings.TrimSpace(rule.Pattern); pattern != "" {
+		return pattern
+	}
+	return rule.Name
+}
+
+// buildEvidence turns an outcome's spans into evidence located in the text and
+// its pages. A failure with nothing to point at records the missing clause.
+func buildEvidence(rule model.ComplianceRule, outcome RuleOutcome, text string, pages []model.DocumentPage) []Evidence {
+	evidence := []Evidence{}
+	for _, span := range outcome.Spans {
+		if span.Start < 0 || span.End < span.Start || span.End > len(text) {
+			continue
+		}
+		start := utf8.RuneCountInString(text[:span.Start])
+		evidence = append(evidence, Evidence{
+			Kind:  EvidenceMatched,
+			Text:  span.Text,
+			Start: start,
+			End:   start + utf8.RuneCountInString(text[span.Start:span.End]),
+			Page:  pageAt(pages, span.Start),
+			Quote: evidenceQuote(text, span.Start, span.End),
+		})
+	}
+	if len(evidence) == 0 && outcome.Status == RuleStatusFail {
+		evidence = append(evidence, Evidence{Kind: EvidenceMissing, Text: missingClause(rule)})
+	}
+	return evidence
+}
+
+// storedResult is the part of a rule result that its evidence is read from
+type storedResult struct {
+	RuleID      string      `json:"rule_id"`
+	RuleName    string      `json:"rule_name"`
+	Status      string      `json:"status"`
+	Severity    string      `json:"severity"`
+	Explanation string      `json:"explanation"`
+	Evidence    *[]Evidence `json:"evidence"`
+	Spans       []TextSpan  `json:"spans"`
+}
+
+// GetDocumentEvidence returns the evidence behind each of a document's rule
+// results, read from its stored results. Rules analyzed before every result
+// was stored are taken from parsed_data, and results analyzed before evidence
+// was recorded fall back to their spans.
+func (s *DocumentService) GetDocumentEvidence(documentID string) ([]RuleEvidence, error) {
+	doc, err := s.findDocument(s.db, documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []model.DocumentRuleResult
+	if err := s.db.Where("document_id = ?", doc.ID).Order("created_at").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch rule results: %w", err)
+	}
+	results := make([]storedResult, 0, len(rows))
+	stored := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		var result storedResult
+		if err := json.Unmarshal(row.Details, &result); err != nil {
+			return nil, fmt.Errorf("failed to read rule result %s: %w", row.ID, err)
+		}
+		result.RuleID = row.RuleID
+		result.Status = row.Status
+		results = append(results, result)
+		stored[row.RuleID] = true
+	}
+	if len(doc.ParsedData) > 0 {
+		var parsed []storedResult
+		if err := json.Unmarshal(doc.ParsedData, &parsed); err != nil {
+			return nil, fmt.Errorf("failed to read compliance results: %w", err)
+		}
+		for _, result := range parsed {
+			if !stored[result.RuleID] {
+				results = append(results, result)
+			}
+		}
+	}
+	if len(results) == 0 {
+		return []RuleEvidence{}, nil
+	}
+
+	var pages []model.DocumentPage
+	if err := s.db.Select("page_number", "start_offset", "end_offset").Where("document_id = ?", doc.ID).Order("start_offset").Find(&pages).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch document pages: %w", err)
+	}
+
+	evidence := make([]RuleEvidence, 0, len(results))
+	for _, result := range results {
+		item := RuleEvidence{
+			RuleID:      result.RuleID,
+			RuleName:    result.RuleName,
+			Status:      result.Status,
+			Severity:    result.Severity,
+			Explanation: result.Explanation,
+		}
+		if result.Evidence != nil {
+			item.Evidence = *result.Evidence
+		} else {
+			item.Evidence = buildEvidence(model.ComplianceRule{Name: result.RuleName}, RuleOutcome{Status: result.Status, Spans: result.Spans}, doc.OcrText, pages)
+		}
+		evidence = append(evidence, item)
+	}
+	return evidence, nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pagedOCRProvider returns one page per entry
+type pagedOCRProvider struct {
+	pages []string
+}
+
+func (p *pagedOCRProvider) Name() string { return "local" }
+
+func (p *pagedOCRProvider) ExtractText(fileBytes []byte, filename string) (*OCRResult, error) {
+	return newOCRResult("local", p.pages, make([]float64, len(p.pages))), nil
+}
+
+func TestEvidenceQuote(t *testing.T) {
+	text := strings.Repeat("lorem ipsum ", 10) + "Either party may terminate this Agreement for convenience." + strings.Repeat(" dolor sit", 10)
+	start := strings.Index(text, "terminate")
+	quote := evidenceQuote(text, start, start+len("terminate"))
+	assert.True(t, strings.HasPrefix(quote, "...ipsum "), quote)
+	assert.True(t, strings.HasSuffix(quote, " dolor..."), quote)
+	assert.Contains(t, quote, "Either party may terminate this Agreement for convenience.")
+
+	assert.Equal(t, "Short text.", evidenceQuote("Short\n text.", 0, 5), "whitespace is collapsed and nothing is cut")
+}
+
+func TestGetDocumentEvidence(t *testing.T) {
+	pages := []string{
+		"MUTUAL NON-DISCLOSURE AGREEMENT. The parties – Acme Société and Müller GmbH – agree to protect Confidential Information.",
+		"Either party may terminate this Agreement at any time. Signed by both parties.",
+	}
+	s, db, _ := newPipelineService(t, &pagedOCRProvider{pages: pages}, 1)
+	for _, rule := range []*model.ComplianceRule{
+		{Name: "No Termination For Convenience", Type: model.RuleTypeRegexMustNotMatch, Pattern: `terminate this agreement at any time`, Severity: SeverityHigh},
+		{Name: "Governing Law", Type: model.RuleTypeRegexMustMatch, Pattern: `governed by the laws of`, Description: "A governing law clause.", Severity: SeverityMedium},
+	} {
+		require.NoError(t, s.AddComplianceRule(context.Background(), rule))
+	}
+
+	doc, err := s.EnqueueDocument(newUpload("nda.txt", []byte("nda")))
+	require.NoError(t, err)
+	s.processDocument(context.Background(), <-s.jobs)
+	text := loadDocument(t, db, doc.ID).OcrText
+
+	results, err := s.GetDocumentEvidence(doc.ID)
+	require.NoError(t, err)
+	byName := map[string]RuleEvidence{}
+	for _, result := range results {
+		byName[result.RuleName] = result
+	}
+
+	termination := byName["No Termination For Convenience"]
+	assert.Equal(t, RuleStatusFail, termination.Status)
+	require.Len(t, termination.Evidence, 1)
+	matched := termination.Evidence[0]
+	assert.Equal(t, EvidenceMatched, matched.Kind)
+	assert.Equal(t, 2, matched.Page)
+	assert.Equal(t, "terminate this Agreement at any time", string([]rune(text)[matched.Start:matched.End]), "offsets count characters, not bytes")
+	assert.Contains(t, matched.Quote, "Either party may terminate")
+
+	governingLaw := byName["Governing Law"]
+	assert.Equal(t, []Evidence{{Kind: EvidenceMissing, Text: "A governing law clause."}}, governingLaw.Evidence)
+
+	judged := byName["NDA Check"]
+	assert.Equal(t, RuleStatusFail, judged.Status)
+	assert.Equal(t, EvidenceMissing, judged.Evidence[0].Kind, "the LLM's verdict names the rule when it has nothing to point at")
+	assert.Empty(t, byName["Signature Check"].Evidence)
+
+	// Every stored result keeps its evidence, and the evidence is served from them
+	var stored model.DocumentRuleResult
+	require.NoError(t, db.Where("document_id = ? AND rule_id = ?", doc.ID, byName["Signature Check"].RuleID).First(&stored).Error)
+	var details struct {
+		Evidence *[]Evidence `json:"evidence"`
+	}
+	require.NoError(t, json.Unmarshal(stored.Details, &details))
+	require.NotNil(t, details.Evidence, "passed results store their evidence too")
+	require.NoError(t, db.Model(&model.Document{}).Where("id = ?", doc.ID).Update("parsed_data", `[]`).Error)
+	fromRows, err := s.GetDocumentEvidence(doc.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, results, fromRows)
+
+	// Results analyzed before evidence was recorded are located from their spans
+	require.NoError(t, db.Where("document_id = ?", doc.ID).Delete(&model.DocumentRuleResult{}).Error)
+	require.NoError(t, db.Model(&model.Document{}).Where("id = ?", doc.ID).Update("parsed_data",
+		`[{"rule_id": "r", "rule_name": "Old", "status": "pass", "spans": [{"start": 0, "end": 6, "text": "MUTUAL"}]}]`).Error)
+	results, err = s.GetDocumentEvidence(doc.ID)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Len(t, results[0].Evidence, 1)
+	assert.Equal(t, 1, results[0].Evidence[0].Page)
+
+	_, err = s.GetDocumentEvidence("00000000-0000-0000-0000-000000000000")
+	assert.ErrorIs(t, err, ErrDocumentNotFound)
+}
@@ -0,0 +1,536 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LLM call sites; each one can be routed to its own model
+const (
+	LLMCallSiteRuleDetection      = "rule_detection"
+	LLMCallSiteBatchRuleDetection = "batch_rule_detection"
+	LLMCallSiteRuleCompliance     = "rule_compliance"
+)
+
+// LLM provider names accepted by LLM_PROVIDER
+const (
+	LLMProviderGroq   = "groq"
+	LLMProviderOpenAI = "openai"
+	LLMProviderOllama = "ollama"
+	LLMProviderFake   = "fake"
+)
+
+// defaultLLMModels are the models each call site used before they were configurable
+var defaultLLMModels = map[string]string{
+	LLMCallSiteRuleDetection:      "llama-3.3-70b-versatile",
+	LLMCallSiteBatchRuleDetection: "llama-3.3-70b-versatile",
+	LLMCallSiteRuleCompliance:     "mixtral-8x7b-32768",
+}
+
+// ErrLLMNotConfigured is returned when no credentials are available for the LLM provider
+var ErrLLMNotConfigured = errors.New("LLM client is not configured")
+
+// LLMMessage is a single chat message
+type LLMMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// LLMRequest is a provider-agnostic chat completion request
+type LLMRequest struct {
+	// CallSite names the caller; it selects the model and groups token usage.
+	CallSite string
+	// Model overrides the call site's model when set.
+	Model       string
+	Messages    []LLMMessage
+	Temperature float64
+	MaxTokens   int
+	// JSONResponse asks the provider to return a JSON object.
+	JSONResponse bool
+	// Schema, when set, asks the provider for a JSON object following it.
+	Schema *LLMSchema
+	// Timeout bounds each attempt; zero uses the client default.
+	Timeout time.Duration
+}
+
+// LLMSchema is a named JSON schema the reply must follow
+type LLMSchema struct {
+	Name   string
+	Schema map[string]interface{}
+}
+
+// LLMUsage counts the tokens consumed by one or more requests
+type LLMUsage struct {
+	Requests         int `json:"requests"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// LLMResponse is the model's reply to an LLMRequest
+type LLMResponse struct {
+	Content string
+	Model   string
+	Usage   LLMUsage
+	Latency time.Duration
+}
+
+// LLMClient sends chat completion requests to a model provider
+type LLMClient interface {
+	Complete(ctx context.Context, req LLMRequest) (*LLMResponse, error)
+}
+
+// LLMStatusError is returned when the provider answers with a non-200 status
+type LLMStatusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *LLMStatusError) Error() string {
+	return fmt.Sprintf("non-200 status code: %d, response: %s", e.StatusCode, e.Body)
+}
+
+// retryable reports whether the request may succeed if sent again
+func (e *LLMStatusError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// LLMClientConfig selects and configures the LLM provider
+type LLMClientConfig struct {
+	Provider string
+	BaseURL  string
+	APIKey   string
+	// Models maps call sites to model names; DefaultModel covers the rest.
+	Models       map[string]string
+	DefaultModel string
+	MaxRetries   int
+	BaseBackoff  time.Duration
+	Timeout      time.Duration
+}
+
+// NewLLMClient builds the configured provider client wrapped with retries,
+// model selection and token accounting.
+func NewLLMClient(cfg LLMClientConfig) (*ManagedLLMClient, error) {
+	var client LLMClient
+	switch strings.ToLower(cfg.Provider) {
+	case "", LLMProviderGroq:
+		baseURL := cfg.BaseURL
+		if baseURL == "" {
+			baseURL = "https://api.groq.com/openai/v1"
+		}
+		client = NewOpenAICompatibleClient(baseURL, cfg.APIKey)
+	case LLMProviderOpenAI:
+		baseURL := cfg.BaseURL
+		if baseURL == "" {
+			baseURL = "https://api.openai.com/v1"
+		}
+		client = NewOpenAICompatibleClient(baseURL, cfg.APIKey)
+	case LLMProviderOllama:
+		baseURL := cfg.BaseURL
+		if baseURL == "" {
+			baseURL = "http://localhost:11434"
+		}
+		client = NewOllamaClient(baseURL)
+	case LLMProviderFake:
+		client = NewFakeLLMClient()
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", cfg.Provider)
+	}
+
+	managed := NewManagedLLMClient(client)
+	for callSite, model := range cfg.Models {
+		if model != "" {
+			managed.Models[callSite] = model
+		}
+	}
+	managed.DefaultModel = cfg.DefaultModel
+	if cfg.MaxRetries > 0 {
+		managed.MaxRetries = cfg.MaxRetries
+	}
+	if cfg.BaseBackoff > 0 {
+		managed.BaseBackoff = cfg.BaseBackoff
+	}
+	if cfg.Timeout > 0 {
+		managed.Timeout = cfg.Timeout
+	}
+	return managed, nil
+}
+
+// ManagedLLMClient wraps a provider client with the behaviour every call site
+// shares: model selection, per-attempt timeouts, retry with exponential
+// backoff and token accounting.
+type ManagedLLMClient struct {
+	client LLMClient
+
+	// Models maps call sites to model names.
+	Models map[string]string
+	// DefaultModel is used for call sites without an entry in Models.
+	DefaultModel string
+	MaxRetries   int
+	BaseBackoff  time.Duration
+	Timeout      time.Duration
+
+	mu    sync.Mutex
+	usage map[string]LLMUsage
+	sleep func(context.Context, time.Duration) error
+}
+
+// NewManagedLLMClient wraps client with the default models and retry policy
+func NewManagedLLMClient(client LLMClient) *ManagedLLMClient {
+	models := make(map[string]string, len(defaultLLMModels))
+	for callSite, model := range defaultLLMModels {
+		models[callSite] = model
+	}
+	return &ManagedLLMClient{
+		client:      client,
+		Models:      models,
+		MaxRetries:  3,
+		BaseBackoff: 2 * time.Second,
+		Timeout:     30 * time.Second,
+		usage:       make(map[string]LLMUsage),
+		sleep:       sleepContext,
+	}
+}
+
+// sleepContext waits for d, returning early with the context's error when it is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ModelFor returns the model used for a call site
+func (c *ManagedLLMClient) ModelFor(callSite string) string {
+	if model, ok := c.Models[callSite]; ok && model != "" {
+		return model
+	}
+	if c.DefaultModel != "" {
+		return c.DefaultModel
+	}
+	return defaultLLMModels[LLMCallSiteRuleDetection]
+}
+
+// Complete sends the request, retrying transient failures
+func (c *ManagedLLMClient) Complete(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	if req.Model == "" {
+		req.Model = c.ModelFor(req.CallSite)
+	}
+	timeout := req.Timeout
+	if timeout == 0 {
+		timeout = c.Timeout
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < c.MaxRetries; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		resp, err := c.client.Complete(attemptCtx, req)
+		cancel()
+		if err == nil {
+			resp.Latency = time.Since(start)
+			if resp.Model == "" {
+				resp.Model = req.Model
+			}
+			c.recordUsage(req.CallSite, resp.Usage)
+			log.Printf("LLM %s call to %s completed in %v (%d tokens)", req.CallSite, resp.Model, resp.Latency, resp.Usage.TotalTokens)
+			return resp, nil
+		}
+		lastErr = err
+
+		var statusErr *LLMStatusError
+		if errors.Is(err, ErrLLMNotConfigured) || (errors.As(err, &statusErr) && !statusErr.retryable()) {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("LLM %s request attempt %d failed: %v", req.CallSite, attempt+1, err)
+
+		if attempt < c.MaxRetries-1 {
+			wait := c.BaseBackoff * time.Duration(math.Pow(2, float64(attempt)))
+			if statusErr != nil && statusErr.RetryAfter > wait {
+				wait = statusErr.RetryAfter
+			}
+			log.Printf("Retrying in %v...", wait)
+			if err := c.sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("LLM request failed after %d attempts: %w", c.MaxRetries, lastErr)
+}
+
+// recordUsage adds one request's token usage to its call site's totals
+func (c *ManagedLLMClient) recordUsage(callSite string, usage LLMUsage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := c.usage[callSite]
+	total.Requests++
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+	c.usage[callSite] = total
+}
+
+// Usage returns the token usage accumulated per call site
+func (c *ManagedLLMClient) Usage() map[string]LLMUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := make(map[string]LLMUsage, len(c.usage))
+	for callSite, usage := range c.usage {
+		snapshot[callSite] = usage
+	}
+	return snapshot
+}
+
+// OpenAICompatibleClient talks to any /chat/completions API (Groq, OpenAI, vLLM, ...)
+type OpenAICompatibleClient struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+// NewOpenAICompatibleClient creates a client for the API at baseURL
+func NewOpenAICompatibleClient(baseURL, apiKey string) *OpenAICompatibleClient {
+	return &OpenAICompatibleClient{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		APIKey:  apiKey,
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				MaxIdleConns:        10,
+				IdleConnTimeout:     60 * time.Second,
+				DisableCompression:  true,
+				TLSHandshakeTimeout: 15 * time.Second,
+			},
+		},
+	}
+}
+
+// Complete sends one chat completion request
+func (c *OpenAICompatibleClient) Complete(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	if c.APIKey == "" {
+		return nil, fmt.Errorf("%w: API key is not set", ErrLLMNotConfigured)
+	}
+
+	payload := map[string]interface{}{
+		"model":       req.Model,
+		"messages":    req.Messages,
+		"temperature": req.Temperature,
+	}
+	if req.MaxTokens > 0 {
+		payload["max_tokens"] = req.MaxTokens
+	}
+	if req.Schema != nil {
+		payload["response_format"] = map[string]interface{}{
+			"type":        "json_schema",
+			"json_schema": map[string]interface{}{"name": req.Schema.Name, "schema": req.Schema.Schema},
+		}
+	} else if req.JSONResponse {
+		payload["response_format"] = map[string]string{"type": "json_object"}
+	}
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request body: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/chat/completions", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create LLM request: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+c.APIKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send LLM request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LLM response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		statusErr := &LLMStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			statusErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, statusErr
+	}
+
+	var result struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+			TotalTokens      int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse LLM response structure: %w", err)
+	}
+	if len(result.Choices) == 0 {
+		return nil, fmt.Errorf("LLM response contained no choices")
+	}
+
+	return &LLMResponse{
+		Content: result.Choices[0].Message.Content,
+		Model:   result.Model,
+		Usage: LLMUsage{
+			PromptTokens:     result.Usage.PromptTokens,
+			CompletionTokens: result.Usage.CompletionTokens,
+			TotalTokens:      result.Usage.TotalTokens,
+		},
+	}, nil
+}
+
+// OllamaClient talks to a local Ollama server's /api/chat endpoint
+type OllamaClient struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewOllamaClient creates a client for the Ollama server at baseURL
+func NewOllamaClient(baseURL string) *OllamaClient {
+	return &OllamaClient{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: &http.Client{}}
+}
+
+// Complete sends one non-streaming chat request
+func (c *OllamaClient) Complete(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	options := map[string]interface{}{"temperature": req.Temperature}
+	if req.MaxTokens > 0 {
+		options["num_predict"] = req.MaxTokens
+	}
+	payload := map[string]interface{}{
+		"model":    req.Model,
+		"messages": req.Messages,
+		"stream":   false,
+		"options":  options,
+	}
+	if req.Schema != nil {
+		payload["format"] = req.Schema.Schema
+	} else if req.JSONResponse {
+		payload["format"] = "json"
+	}
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request body: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/api/chat", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Ollama request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send Ollama request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Ollama response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &LLMStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var result struct {
+		Model   string `json:"model"`
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		PromptEvalCount int `json:"prompt_eval_count"`
+		EvalCount       int `json:"eval_count"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse Ollama response: %w", err)
+	}
+
+	return &LLMResponse{
+		Content: result.Message.Content,
+		Model:   result.Model,
+		Usage: LLMUsage{
+			PromptTokens:     result.PromptEvalCount,
+			CompletionTokens: result.EvalCount,
+			TotalTokens:      result.PromptEvalCount + result.EvalCount,
+		},
+	}, nil
+}
+
+// FakeLLMClient returns canned replies without any network access. It is
+// deterministic, so it suits tests and fully offline runs.
+type FakeLLMClient struct {
+	// Responses maps call sites to the content returned for them.
+	Responses map[string]string
+	// Handler, when set, computes the reply instead of Responses.
+	Handler func(req LLMRequest) (string, error)
+
+	mu       sync.Mutex
+	Requests []LLMRequest
+}
+
+// NewFakeLLMClient creates a fake client that answers "{}" to everything
+func NewFakeLLMClient() *FakeLLMClient {
+	return &FakeLLMClient{Responses: make(map[string]string)}
+}
+
+// Complete records the request and returns the canned reply
+func (c *FakeLLMClient) Complete(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	c.mu.Lock()
+	c.Requests = append(c.Requests, req)
+	c.mu.Unlock()
+
+	content := "{}"
+	if c.Handler != nil {
+		var err error
+		if content, err = c.Handler(req); err != nil {
+			return nil, err
+		}
+	} else if reply, ok := c.Responses[req.CallSite]; ok {
+		content = reply
+	}
+
+	// Approximate token counts by whitespace-separated words
+	promptTokens := 0
+	for _, msg := range req.Messages {
+		promptTokens += len(strings.Fields(msg.Content))
+	}
+	completionTokens := len(strings.Fields(content))
+	model := req.Model
+	if model == "" {
+		model = defaultLLMModels[req.CallSite]
+	}
+
+	return &LLMResponse{
+		Content: content,
+		Model:   model,
+		Usage: LLMUsage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	}, nil
+}
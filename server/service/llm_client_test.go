@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestManagedClient wraps client without real sleeping between retries
+func newTestManagedClient(client LLMClient) (*ManagedLLMClient, *[]time.Duration) {
+	managed := NewManagedLLMClient(client)
+	var waits []time.Duration
+	managed.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	return managed, &waits
+}
+
+func TestManagedLLMClient_RetriesRateLimits(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		var payload map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		assert.Equal(t, "llama-3.3-70b-versatile", payload["model"])
+		assert.Equal(t, map[string]interface{}{"type": "json_object"}, payload["response_format"])
+
+		w.Write([]byte(`{"model": "llama-3.3-70b-versatile", "choices": [{"message": {"content": "{\"violated_rules\": []}"}}],
+			"usage": {"prompt_tokens": 12, "completion_tokens": 5, "total_tokens": 17}}`))
+	}))
+	defer server.Close()
+
+	managed, waits := newTestManagedClient(NewOpenAICompatibleClient(server.URL, "test-key"))
+
+	resp, err := managed.Complete(context.Background(), LLMRequest{
+		CallSite:     LLMCallSiteRuleDetection,
+		Messages:     []LLMMessage{{Role: "user", Content: "hello"}},
+		JSONResponse: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `{"violated_rules": []}`, resp.Content)
+	assert.Equal(t, int32(3), calls)
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second}, *waits)
+	assert.Equal(t, LLMUsage{Requests: 1, PromptTokens: 12, CompletionTokens: 5, TotalTokens: 17}, managed.Usage()[LLMCallSiteRuleDetection])
+}
+
+func TestManagedLLMClient_DoesNotRetryClientErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	managed, _ := newTestManagedClient(NewOpenAICompatibleClient(server.URL, "test-key"))
+
+	_, err := managed.Complete(context.Background(), LLMRequest{CallSite: LLMCallSiteRuleCompliance})
+	var statusErr *LLMStatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusBadRequest, statusErr.StatusCode)
+	assert.Equal(t, int32(1), calls)
+}
+
+func TestManagedLLMClient_BackoffStopsOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	managed := NewManagedLLMClient(NewOpenAICompatibleClient(server.URL, "test-key"))
+	managed.BaseBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := managed.Complete(ctx, LLMRequest{CallSite: LLMCallSiteRuleDetection})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second, "the backoff does not outlive the request")
+}
+
+func TestManagedLLMClient_NotConfigured(t *testing.T) {
+	managed, waits := newTestManagedClient(NewOpenAICompatibleClient("http://127.0.0.1:1", ""))
+
+	_, err := managed.Complete(context.Background(), LLMRequest{CallSite: LLMCallSiteRuleDetection})
+	assert.ErrorIs(t, err, ErrLLMNotConfigured)
+	assert.Empty(t, *waits)
+}
+
+func TestManagedLLMClient_ModelSelection(t *testing.T) {
+	fake := NewFakeLLMClient()
+	managed, err := NewLLMClient(LLMClientConfig{
+		Provider: LLMProviderFake,
+		Models:   map[string]string{LLMCallSiteRuleCompliance: "local-judge"},
+	})
+	require.NoError(t, err)
+	managed.client = fake
+
+	for _, callSite := range []string{LLMCallSiteRuleDetection, LLMCallSiteRuleCompliance} {
+		_, err := managed.Complete(context.Background(), LLMRequest{CallSite: callSite})
+		require.NoError(t, err)
+	}
+	_, err = managed.Complete(context.Background(), LLMRequest{CallSite: LLMCallSiteRuleDetection, Model: "override"})
+	require.NoError(t, err)
+
+	require.Len(t, fake.Requests, 3)
+	assert.Equal(t, "llama-3.3-70b-versatile", fake.Requests[0].Model)
+	assert.Equal(t, "local-judge", fake.Requests[1].Model)
+	assert.Equal(t, "override", fake.Requests[2].Model)
+}
+
+func TestOpenAICompatibleClient_SendsSchema(t *testing.T) {
+	schema := &LLMSchema{Name: "verdict", Schema: map[string]interface{}{"type": "object"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		assert.Equal(t, map[string]interface{}{
+			"type":        "json_schema",
+			"json_schema": map[string]interface{}{"name": "verdict", "schema": map[string]interface{}{"type": "object"}},
+		}, payload["response_format"])
+		w.Write([]byte(`{"model": "m", "choices": [{"message": {"content": "{}"}}]}`))
+	}))
+	defer server.Close()
+
+	_, err := NewOpenAICompatibleClient(server.URL, "test-key").Complete(context.Background(), LLMRequest{Model: "m", JSONResponse: true, Schema: schema})
+	require.NoError(t, err)
+}
+
+func TestOllamaClient_Complete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/chat", r.URL.Path)
+
+		var payload map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		assert.Equal(t, false, payload["stream"])
+		assert.Equal(t, "json", payload["format"])
+
+		w.Write([]byte(`{"model": "llama3", "message": {"role": "assistant", "content": "{\"status\": \"pass\"}"},
+			"prompt_eval_count": 20, "eval_count": 4}`))
+	}))
+	defer server.Close()
+
+	resp, err := NewOllamaClient(server.URL).Complete(context.Background(), LLMRequest{Model: "llama3", JSONResponse: true})
+	require.NoError(t, err)
+	assert.Equal(t, `{"status": "pass"}`, resp.Content)
+	assert.Equal(t, 24, resp.Usage.TotalTokens)
+}
+
+func TestFakeLLMClient_Deterministic(t *testing.T) {
+	fake := NewFakeLLMClient()
+	fake.Responses[LLMCallSiteRuleDetection] = `{"violated_rules": ["NDA Check"]}`
+
+	req := LLMRequest{CallSite: LLMCallSiteRuleDetection, Messages: []LLMMessage{{Role: "user", Content: "check this text"}}}
+	first, err := fake.Complete(context.Background(), req)
+	require.NoError(t, err)
+	second, err := fake.Complete(context.Background(), req)
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, 3, first.Usage.PromptTokens)
+
+	other, err := fake.Complete(context.Background(), LLMRequest{CallSite: LLMCallSiteRuleCompliance})
+	require.NoError(t, err)
+	assert.Equal(t, "{}", other.Content)
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// ErrInvalidLLMOutput is returned when the LLM's reply still does not follow
+// the expected schema after the repair attempts
+var ErrInvalidLLMOutput = errors.New("LLM reply did not match the expected schema")
+
+// llmRepairAttempts bounds how often an invalid reply is sent back to be fixed
+const llmRepairAttempts = 2
+
+// decodeStrict decodes exactly one JSON object into out, rejecting unknown fields
+func decodeStrict(content string, out interface{}) error {
+	dec := json.NewDecoder(strings.NewReader(content))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(out); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid JSON: unexpected content after the object")
+	}
+	return nil
+}
+
+// completeStructured sends req asking for a reply that follows schema, decodes
+// it strictly into a T and checks it with validate. An invalid reply is sent
+// back with what is wrong, at most llmRepairAttempts times, before giving up
+// with ErrInvalidLLMOutput. The response returned, also on that error, holds
+// the last reply and the usage of every attempt.
+func completeStructured[T any](ctx context.Context, llm LLMClient, req LLMRequest, schema *LLMSchema, validate func(T) error) (T, *LLMResponse, error) {
+	var reply T
+	schemaJSON, err := json.Marshal(schema.Schema)
+	if err != nil {
+		return reply, nil, fmt.Errorf("failed to encode %s schema: %w", schema.Name, err)
+	}
+	req.Schema = schema
+	req.Messages = append([]LLMMessage(nil), req.Messages...)
+
+	var total LLMResponse
+	var problem error
+	for attempt := 0; attempt <= llmRepairAttempts; attempt++ {
+		resp, err := llm.Complete(ctx, req)
+		if err != nil {
+			return reply, nil, err
+		}
+		total.Content, total.Model = resp.Content, resp.Model
+		total.Latency += resp.Latency
+		total.Usage.Requests++
+		total.Usage.PromptTokens += resp.Usage.PromptTokens
+		total.Usage.CompletionTokens += resp.Usage.CompletionTokens
+		total.Usage.TotalTokens += resp.Usage.TotalTokens
+
+		var candidate T
+		problem = decodeStrict(resp.Content, &candidate)
+		if problem == nil {
+			problem = validate(candidate)
+		}
+		if problem == nil {
+			return candidate, &total, nil
+		}
+		log.Printf("[completeStructured] Invalid %s reply (attempt %d of %d): %v", schema.Name, attempt+1, llmRepairAttempts+1, problem)
+
+		req.Messages = append(req.Messages,
+			LLMMessage{Role: "assistant", Content: resp.Content},
+			LLMMessage{Role: "user", Content: fmt.Sprintf("That reply is invalid: %v. Reply again with only a JSON object that follows this schema: %s", problem, schemaJSON)},
+		)
+	}
+	return reply, &total, fmt.Errorf("%w after %d attempts: %v", ErrInvalidLLMOutput, llmRepairAttempts+1, problem)
+}
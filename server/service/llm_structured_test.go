@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedLLM replies with each reply in turn, repeating the last one
+func scriptedLLM(replies ...string) *FakeLLMClient {
+	llm := NewFakeLLMClient()
+	calls := 0
+	llm.Handler = func(req LLMRequest) (string, error) {
+		reply := replies[min(calls, len(replies)-1)]
+		calls++
+		return reply, nil
+	}
+	return llm
+}
+
+func TestCompleteStructured(t *testing.T) {
+	names := []string{"NDA Check", "Signature Check"}
+	request := LLMRequest{CallSite: LLMCallSiteRuleDetection, Messages: []LLMMessage{{Role: "user", Content: "judge this"}}}
+
+	t.Run("repairs invalid replies", func(t *testing.T) {
+		llm := scriptedLLM("```json\n{}\n```", `{"violated_rules": "NDA Check"}`, `{"violated_rules": ["NDA Check", "Ghost"]}`)
+		reply, resp, err := completeStructured(context.Background(), llm, request, ruleDetectionSchema(names), validateRuleDetectionReply)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"NDA Check", "Ghost"}, reply.ViolatedRules, "unknown names are left for the caller to drop")
+		assert.Equal(t, 3, resp.Usage.Requests)
+
+		require.Len(t, llm.Requests, 3)
+		assert.Equal(t, "rule_detection", llm.Requests[0].Schema.Name)
+		assert.Len(t, llm.Requests[0].Messages, 1, "the caller's messages are not modified")
+		repair := llm.Requests[2].Messages
+		require.Len(t, repair, 5)
+		assert.Equal(t, `{"violated_rules": "NDA Check"}`, repair[3].Content)
+		assert.Contains(t, repair[4].Content, "cannot unmarshal string")
+		assert.Contains(t, repair[4].Content, `"enum":["NDA Check","Signature Check"]`)
+	})
+
+	t.Run("gives up after the repair attempts", func(t *testing.T) {
+		llm := scriptedLLM(`{"violated": []}`)
+		_, resp, err := completeStructured(context.Background(), llm, request, ruleDetectionSchema(names), validateRuleDetectionReply)
+		assert.ErrorIs(t, err, ErrInvalidLLMOutput)
+		assert.ErrorContains(t, err, `unknown field "violated"`)
+		assert.Equal(t, llmRepairAttempts+1, resp.Usage.Requests)
+		assert.Len(t, llm.Requests, llmRepairAttempts+1)
+	})
+
+	t.Run("does not repair failed requests", func(t *testing.T) {
+		llm := NewFakeLLMClient()
+		llm.Handler = func(req LLMRequest) (string, error) { return "", errors.New("unavailable") }
+		_, _, err := completeStructured(context.Background(), llm, request, ruleDetectionSchema(names), validateRuleDetectionReply)
+		assert.EqualError(t, err, "unavailable")
+		assert.Len(t, llm.Requests, 1)
+	})
+
+	for _, content := range []string{`{"violated_rules": []} {}`, `{"violated_rules": [""]}`, `null`} {
+		_, _, err := completeStructured(context.Background(), scriptedLLM(content), request, ruleDetectionSchema(names), validateRuleDetectionReply)
+		assert.ErrorIs(t, err, ErrInvalidLLMOutput, content)
+	}
+}
+
+func TestCheckRuleCompliance_ValidatesReply(t *testing.T) {
+	s, _, _ := newPipelineService(t, &stubOCRProvider{name: "local"}, 1)
+	rule := model.ComplianceRule{ID: "r", Name: "Term", Type: model.RuleTypeRegexMustMatch, Pattern: `term of`}
+
+	s.llm = scriptedLLM(`{"status": "fail", "confidence_score": 85, "explanation": "No term is given.", "non_compliance_details": {"Term": "missing"}}`)
+	result, err := s.CheckRuleCompliance(context.Background(), "This Agreement starts today.", rule)
+	require.NoError(t, err)
+	assert.Equal(t, RuleStatusFail, result["status"])
+	assert.Equal(t, 85.0, result["confidence_score"])
+	assert.Equal(t, map[string]interface{}{"Term": "missing"}, result["non_compliance_details"])
+
+	// An unknown status used to become a failure
+	s.llm = scriptedLLM(`{"status": "partial_pass", "confidence_score": 40, "explanation": "Partly."}`)
+	result, err = s.CheckRuleCompliance(context.Background(), "This Agreement starts today.", rule)
+	require.NoError(t, err)
+	assert.Equal(t, RuleStatusIndeterminate, result["status"])
+	assert.Contains(t, result["explanation"], `status must be "pass" or "fail", got "partial_pass"`)
+
+	// The check stops with the request that asked for it
+	s.llm = NewOpenAICompatibleClient("http://127.0.0.1:1", "test-key")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.CheckRuleCompliance(ctx, "This Agreement starts today.", rule)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestAnalyzeCompliance_InvalidRepliesAreIndeterminate(t *testing.T) {
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: "This agreement has no NDA."}, 1)
+	s.llm = scriptedLLM(`{"violated": ["NDA Check"]}`)
+
+	doc, err := s.EnqueueDocument(newUpload("contract.txt", []byte("This agreement has no NDA.")))
+	require.NoError(t, err)
+	s.processDocument(context.Background(), <-s.jobs)
+
+	stored := loadDocument(t, db, doc.ID)
+	var results []map[string]interface{}
+	require.NoError(t, json.Unmarshal(stored.ParsedData, &results))
+	require.Len(t, results, 2)
+	for _, result := range results {
+		assert.Equal(t, RuleStatusIndeterminate, result["status"], result["rule_name"])
+		assert.Equal(t, EngineLLM, result["engine"])
+		assert.Contains(t, result["explanation"], "did not match the expected schema")
+		assert.Equal(t, float64(llmRepairAttempts+1), result["shared_request_usage"].(map[string]interface{})["requests"])
+		assert.Equal(t, 2.0, result["rules_sharing_usage"], "both rules share the same requests")
+	}
+	assert.Zero(t, stored.RiskScore, "indeterminate results are not counted as failures")
+
+	var rows []model.DocumentRuleResult
+	require.NoError(t, db.Find(&rows, "document_id = ?", doc.ID).Error)
+	require.Len(t, rows, 2)
+	for _, row := range rows {
+		var details map[string]interface{}
+		require.NoError(t, json.Unmarshal(row.Details, &details))
+		assert.Equal(t, RuleStatusIndeterminate, row.Status)
+		assert.Equal(t, EngineLLM, details["engine"], "indeterminate results keep their provenance")
+		assert.NotEmpty(t, details["evaluated_at"])
+	}
+
+	var actions []model.ActionItem
+	require.NoError(t, db.Find(&actions, "document_id = ?", doc.ID).Error)
+	require.Len(t, actions, 2, "indeterminate results need a manual review")
+	for _, action := range actions {
+		assert.Contains(t, action.Description, "Review")
+		assert.Contains(t, action.Description, "could not be evaluated")
+	}
+
+	summary, err := s.processDocumentCompliance(stored)
+	require.NoError(t, err)
+	assert.Equal(t, RuleStatusIndeterminate, summary["compliance_status"])
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	model "github.com/Itish41/LegalEagle/models"
+)
+
+// Engines that can produce a rule outcome
+const (
+	// EngineLLM marks outcomes judged by the LLM
+	EngineLLM = "llm"
+	// EngineLocal marks outcomes computed without consulting the LLM
+	EngineLocal = "local"
+	// EngineScope marks rules skipped because the document is out of their scope
+	EngineScope = "scope"
+)
+
+// Reasons for judging an llm_judged rule locally, given in its explanation
+const (
+	localReasonLLMUnavailable = "the LLM is unavailable"
+	localReasonInitialCheck   = "it is the initial check before the LLM's verdict"
+)
+
+// Thresholds used when the configuration does not set them
+const (
+	defaultFallbackKeywordRatio      = 0.5
+	defaultFallbackMinPatternMatches = 1
+)
+
+// LocalAnalyzer judges llm_judged rules without the LLM, from each rule's own
+// pattern and params.keywords. A rule passes when its pattern matches often
+// enough and enough of its keywords appear. A rule with neither, or with
+// params or a pattern that cannot be used, is indeterminate so a person
+// looks at it.
+type LocalAnalyzer struct {
+	// KeywordRatio is the share of keywords that must appear unless a rule sets params.min_matches
+	KeywordRatio float64
+	// MinPatternMatches is how often the pattern must match unless a rule sets params.min_pattern_matches
+	MinPatternMatches int
+}
+
+// localAnalyzerParams are the settings an llm_judged rule may give the local analyzer
+type localAnalyzerParams struct {
+	Keywords          []string `json:"keywords"`
+	MinMatches        int      `json:"min_matches"`
+	MinPatternMatches int      `json:"min_pattern_matches"`
+}
+
+// NewLocalAnalyzer returns an analyzer with the given thresholds, using the
+// defaults for values that are not positive
+func NewLocalAnalyzer(keywordRatio float64, minPatternMatches int) LocalAnalyzer {
+	if keywordRatio <= 0 || keywordRatio > 1 {
+		keywordRatio = defaultFallbackKeywordRatio
+	}
+	if minPatternMatches < 1 {
+		minPatternMatches = defaultFallbackMinPatternMatches
+	}
+	return LocalAnalyzer{KeywordRatio: keywordRatio, MinPatternMatches: minPatternMatches}
+}
+
+// parseLocalAnalyzerParams reads and checks an llm_judged rule's params
+func parseLocalAnalyzerParams(rule model.ComplianceRule) (localAnalyzerParams, error) {
+	var params localAnalyzerParams
+	if canonicalParams(rule.Params) != "" {
+		if err := json.Unmarshal(rule.Params, &params); err != nil {
+			return params, fmt.Errorf("invalid params: %w", err)
+		}
+	}
+	keywords := params.Keywords[:0]
+	for _, keyword := range params.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	params.Keywords = keywords
+	if params.MinMatches < 0 || params.MinMatches > len(params.Keywords) {
+		return params, fmt.Errorf("min_matches must be between 0 and the number of keywords (%d)", len(params.Keywords))
+	}
+	if params.MinPatternMatches < 0 {
+		return params, fmt.Errorf("min_pattern_matches must not be negative")
+	}
+	return params, nil
+}
+
+// requiredKeywords is how many of n keywords must appear
+func (a LocalAnalyzer) requiredKeywords(params localAnalyzerParams) int {
+	if params.MinMatches > 0 {
+		return params.MinMatches
+	}
+	return max(1, int(math.Ceil(a.KeywordRatio*float64(len(params.Keywords)))))
+}
+
+// Analyze judges one rule against the text; reason says why the rule is
+// checked locally. Only a passing rule records the spans it relied on, since
+// partial matches say nothing about why a rule failed.
+func (a LocalAnalyzer) Analyze(rule model.ComplianceRule, text, reason string) RuleOutcome {
+	outcome := RuleOutcome{Status: RuleStatusIndeterminate, Engine: EngineLocal}
+	params, err := parseLocalAnalyzerParams(rule)
+	if err != nil {
+		outcome.Explanation = fmt.Sprintf("Checked locally because %s, but the '%s' rule could not be checked: %v.", reason, rule.Name, err)
+		return outcome
+	}
+	hasPattern := strings.TrimSpace(rule.Pattern) != ""
+	if !hasPattern && len(params.Keywords) == 0 {
+		outcome.Explanation = fmt.Sprintf("Checked locally because %s, but the '%s' rule has no pattern or keywords to check.", reason, rule.Name)
+		return outcome
+	}
+
+	passed := true
+	var findings []string
+	if hasPattern {
+		re, err := compileRulePattern(rule)
+		if err != nil {
+			outcome.Explanation = fmt.Sprintf("Checked locally because %s, but the '%s' rule could not be checked: %v.", reason, rule.Name, err)
+			return outcome
+		}
+		needed := params.MinPatternMatches
+		if needed == 0 {
+			needed = a.MinPatternMatches
+		}
+		matches := len(re.FindAllStringIndex(text, -1))
+		passed = passed && matches >= needed
+		outcome.Spans = append(outcome.Spans, matchSpans(re, text)...)
+		findings = append(findings, fmt.Sprintf("the pattern '%s' matched %d times (%d needed)", rule.Pattern, matches, needed))
+	}
+	if len(params.Keywords) > 0 {
+		var found []string
+		for _, keyword := range params.Keywords {
+			re := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(keyword) + `\b`)
+			if loc := re.FindStringIndex(text); loc != nil {
+				found = append(found, keyword)
+				if len(outcome.Spans) < maxSpans {
+					outcome.Spans = append(outcome.Spans, TextSpan{Start: loc[0], End: loc[1], Text: text[loc[0]:loc[1]]})
+				}
+			}
+		}
+		needed := a.requiredKeywords(params)
+		passed = passed && len(found) >= needed
+		finding := fmt.Sprintf("%d of %d keywords were found (%d needed)", len(found), len(params.Keywords), needed)
+		if len(found) > 0 {
+			finding += ": " + strings.Join(found, ", ")
+		}
+		findings = append(findings, finding)
+	}
+
+	if passed {
+		outcome.Status = RuleStatusPass
+	} else {
+		outcome.Status = RuleStatusFail
+		outcome.Spans = nil
+	}
+	outcome.Explanation = fmt.Sprintf("Checked locally because %s: %s.", reason, strings.Join(findings, "; "))
+	return outcome
+}
+
+// AnalyzeAll judges each rule against the text, keyed by rule ID
+func (a LocalAnalyzer) AnalyzeAll(text string, rules []model.ComplianceRule, reason string) map[string]RuleOutcome {
+	outcomes := make(map[string]RuleOutcome, len(rules))
+	for _, rule := range rules {
+		outcomes[rule.ID] = a.Analyze(rule, text, reason)
+	}
+	return outcomes
+}
+
+// localAnalyzer returns the analyzer this service's llm_judged evaluator falls back to
+func (s *DocumentService) localAnalyzer() LocalAnalyzer {
+	if evaluator, ok := s.ruleEvaluatorFor(model.RuleTypeLLMJudged); ok {
+		if judged, ok := evaluator.(llmJudgedEvaluator); ok {
+			return judged.local
+		}
+	}
+	return NewLocalAnalyzer(0, 0)
+}
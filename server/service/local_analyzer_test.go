@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+func TestLocalAnalyzer_Analyze(t *testing.T) {
+	text := "The Recipient shall keep the Confidential Information secret. Confidential Information excludes public data. This Agreement is signed by both parties."
+	tests := []struct {
+		name     string
+		analyzer LocalAnalyzer
+		pattern  string
+		params   string
+		want     string
+		explain  string
+	}{
+		{name: "pattern matches", analyzer: NewLocalAnalyzer(0, 0), pattern: `confidential information`, want: RuleStatusPass, explain: "matched 2 times (1 needed)"},
+		{name: "pattern below threshold", analyzer: NewLocalAnalyzer(0, 3), pattern: `confidential information`, want: RuleStatusFail, explain: "matched 2 times (3 needed)"},
+		{name: "rule overrides pattern threshold", analyzer: NewLocalAnalyzer(0, 3), pattern: `confidential information`, params: `{"min_pattern_matches": 2}`, want: RuleStatusPass},
+		{name: "keyword ratio met", analyzer: NewLocalAnalyzer(0.5, 0), params: `{"keywords": ["signed", "witness"]}`, want: RuleStatusPass, explain: "1 of 2 keywords were found (1 needed): signed"},
+		{name: "keyword ratio missed", analyzer: NewLocalAnalyzer(1, 0), params: `{"keywords": ["signed", "witness"]}`, want: RuleStatusFail, explain: "(2 needed)"},
+		{name: "min_matches overrides ratio", analyzer: NewLocalAnalyzer(1, 0), params: `{"keywords": ["signed", "witness"], "min_matches": 1}`, want: RuleStatusPass},
+		{name: "keywords match whole words", analyzer: NewLocalAnalyzer(0, 0), params: `{"keywords": ["sign"]}`, want: RuleStatusFail},
+		{name: "pattern and keywords must both pass", analyzer: NewLocalAnalyzer(0, 0), pattern: `confidential`, params: `{"keywords": ["witness"]}`, want: RuleStatusFail},
+		{name: "nothing to check", analyzer: NewLocalAnalyzer(0, 0), want: RuleStatusIndeterminate, explain: "no pattern or keywords"},
+		{name: "bad params", analyzer: NewLocalAnalyzer(0, 0), params: `{"keywords": ["signed"], "min_matches": 2}`, want: RuleStatusIndeterminate, explain: "min_matches must be between 0 and the number of keywords (1)"},
+		{name: "invalid pattern", analyzer: NewLocalAnalyzer(0, 0), pattern: `signed (by`, want: RuleStatusIndeterminate, explain: "could not be checked"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := model.ComplianceRule{ID: "r", Name: "Check", Type: model.RuleTypeLLMJudged, Pattern: tt.pattern}
+			if tt.params != "" {
+				rule.Params = datatypes.JSON(tt.params)
+			}
+			outcome := tt.analyzer.Analyze(rule, text, localReasonLLMUnavailable)
+			assert.Equal(t, tt.want, outcome.Status)
+			assert.Equal(t, EngineLocal, outcome.Engine)
+			assert.Contains(t, outcome.Explanation, tt.explain)
+			if tt.want == RuleStatusPass {
+				assert.NotEmpty(t, outcome.Spans, "a local pass points at the text it relied on")
+			} else {
+				assert.Empty(t, outcome.Spans, "partial matches are not evidence of a failure")
+			}
+		})
+	}
+
+	outcome := NewLocalAnalyzer(0, 0).Analyze(model.ComplianceRule{Name: "Check", Pattern: `signed`}, text, localReasonInitialCheck)
+	assert.Contains(t, outcome.Explanation, "Checked locally because it is the initial check")
+	assert.NotContains(t, outcome.Explanation, "unavailable")
+}
+
+func TestAnalyzeCompliance_FallsBackToLocalAnalyzer(t *testing.T) {
+	text := "This Non-Disclosure Agreement is signed by both parties."
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: text}, 1)
+	s.llm.(*FakeLLMClient).Handler = func(req LLMRequest) (string, error) {
+		if req.CallSite == LLMCallSiteRuleDetection {
+			return "", errors.New("llm unavailable")
+		}
+		return "{}", nil
+	}
+	require.NoError(t, db.Model(&model.ComplianceRule{}).Where("id = ?", "rule-1").
+		Update("pattern", `non-disclosure`).Error)
+	require.NoError(t, db.Model(&model.ComplianceRule{}).Where("id = ?", "rule-2").
+		Update("params", datatypes.JSON(`{"keywords": ["signature", "witnessed"]}`)).Error)
+
+	doc, err := s.EnqueueDocument(newUpload("nda.txt", []byte(text)))
+	require.NoError(t, err)
+	s.processDocument(context.Background(), <-s.jobs)
+
+	var results []map[string]interface{}
+	require.NoError(t, json.Unmarshal(loadDocument(t, db, doc.ID).ParsedData, &results))
+	require.Len(t, results, 2)
+	statuses := map[string]string{}
+	for _, result := range results {
+		statuses[result["rule_name"].(string)] = result["status"].(string)
+		assert.Equal(t, EngineLocal, result["engine"])
+		assert.Contains(t, result["explanation"], "Checked locally")
+	}
+	assert.Equal(t, map[string]string{"NDA Check": RuleStatusPass, "Signature Check": RuleStatusFail}, statuses,
+		"each rule is judged by its own pattern and keywords rather than a shared keyword list")
+}
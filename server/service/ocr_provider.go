@@ -0,0 +1,493 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// ErrNoTextLayer is returned when a file carries no embedded text that can be
+// extracted locally and has to go through image OCR instead.
+var ErrNoTextLayer = errors.New("no embedded text layer found")
+
+// OCRPage is the text of a single page and how confident the provider is in it.
+type OCRPage struct {
+	// Number is the 1-based page number.
+	Number int
+	// Text is the text of this page only.
+	Text string
+	// Confidence is between 0 and 1; text layers are always 1.
+	Confidence float64
+	// StartOffset and EndOffset locate the page inside OCRResult.Text.
+	StartOffset int
+	EndOffset   int
+}
+
+// OCRResult is the text extracted from an uploaded file.
+type OCRResult struct {
+	// Text is the full extracted text with pages separated by pageSeparator.
+	Text string
+	// Pages holds the per-page text in page order.
+	Pages []OCRPage
+	// Provider is the name of the provider that produced Text.
+	Provider string
+}
+
+// pageSeparator is placed between pages when they are joined into one text
+const pageSeparator = "\n\n"
+
+// newOCRResult joins per-page texts into a single result and records the
+// offset of each page in the joined text.
+func newOCRResult(provider string, pageTexts []string, confidences []float64) *OCRResult {
+	result := &OCRResult{Provider: provider, Pages: make([]OCRPage, 0, len(pageTexts))}
+	var sb strings.Builder
+	for i, text := range pageTexts {
+		if i > 0 {
+			sb.WriteString(pageSeparator)
+		}
+		start := sb.Len()
+		sb.WriteString(text)
+		result.Pages = append(result.Pages, OCRPage{
+			Number:      i + 1,
+			Text:        text,
+			Confidence:  confidences[i],
+			StartOffset: start,
+			EndOffset:   sb.Len(),
+		})
+	}
+	result.Text = sb.String()
+	return result
+}
+
+// LowConfidencePages returns the numbers of pages whose confidence is below threshold
+func (r *OCRResult) LowConfidencePages(threshold float64) []int {
+	var pages []int
+	for _, page := range r.Pages {
+		if page.Confidence < threshold {
+			pages = append(pages, page.Number)
+		}
+	}
+	return pages
+}
+
+// OCRProvider extracts text from an uploaded file.
+type OCRProvider interface {
+	// Name identifies the provider; it is recorded on the document.
+	Name() string
+	// ExtractText returns the text contained in fileBytes.
+	ExtractText(fileBytes []byte, filename string) (*OCRResult, error)
+}
+
+// OCR provider names
+const (
+	OCRProviderLocal    = "local"
+	OCRProviderOCRSpace = "ocrspace"
+)
+
+// NewOCRProvider builds the OCR provider selected by mode.
+// "local" and "ocrspace" select a single backend; anything else (the default)
+// extracts embedded text locally and falls back to OCR.space when a key is set.
+func NewOCRProvider(mode, ocrSpaceAPIKey string) (OCRProvider, error) {
+	local := NewLocalTextProvider()
+	switch mode {
+	case OCRProviderLocal:
+		return local, nil
+	case OCRProviderOCRSpace:
+		if ocrSpaceAPIKey == "" {
+			return nil, fmt.Errorf("OCR provider is %q but no OCR.space API key is set", mode)
+		}
+		return NewOCRSpaceProvider(ocrSpaceAPIKey), nil
+	}
+
+	if ocrSpaceAPIKey == "" {
+		log.Println("Warning: no OCR.space API key is set. Only files with an embedded text layer can be processed.")
+		return local, nil
+	}
+	return &FallbackOCRProvider{Primary: local, Fallback: NewOCRSpaceProvider(ocrSpaceAPIKey)}, nil
+}
+
+// FallbackOCRProvider tries Primary first and only calls Fallback when Primary
+// cannot produce any text.
+type FallbackOCRProvider struct {
+	Primary  OCRProvider
+	Fallback OCRProvider
+}
+
+// Name returns the names of both providers in the order they are tried
+func (p *FallbackOCRProvider) Name() string {
+	return p.Primary.Name() + "+" + p.Fallback.Name()
+}
+
+// ExtractText returns the primary provider's text, falling back when it fails
+func (p *FallbackOCRProvider) ExtractText(fileBytes []byte, filename string) (*OCRResult, error) {
+	result, err := p.Primary.ExtractText(fileBytes, filename)
+	if err == nil {
+		return result, nil
+	}
+	if errors.Is(err, ErrNoTextLayer) {
+		log.Printf("No text layer in %s, falling back to %s", filename, p.Fallback.Name())
+	} else {
+		log.Printf("%s failed for %s (%v), falling back to %s", p.Primary.Name(), filename, err, p.Fallback.Name())
+	}
+	return p.Fallback.ExtractText(fileBytes, filename)
+}
+
+// LocalTextProvider pulls the embedded text out of born-digital files without
+// any network access. Scanned images and image-only PDFs yield ErrNoTextLayer.
+type LocalTextProvider struct {
+	// MinChars is the minimum number of non-space characters for a text layer
+	// to count as present; scanned PDFs often carry a few stray glyphs.
+	MinChars int
+}
+
+// NewLocalTextProvider creates a local provider with default thresholds
+func NewLocalTextProvider() *LocalTextProvider {
+	return &LocalTextProvider{MinChars: 20}
+}
+
+// Name returns the provider name
+func (p *LocalTextProvider) Name() string {
+	return OCRProviderLocal
+}
+
+// ExtractText extracts the text layer from PDF, DOCX and plain-text files
+func (p *LocalTextProvider) ExtractText(fileBytes []byte, filename string) (*OCRResult, error) {
+	var (
+		pages []string
+		err   error
+	)
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".pdf":
+		pages, err = extractPDFText(fileBytes)
+	case ".docx":
+		var text string
+		text, err = extractDOCXText(fileBytes)
+		pages = []string{text}
+	case ".txt", ".text", ".md", ".csv", ".json", ".xml", ".html", ".htm":
+		if !utf8.Valid(fileBytes) {
+			return nil, fmt.Errorf("%s is not valid UTF-8 text", filename)
+		}
+		// Form feeds are the conventional page break in plain text
+		pages = strings.Split(string(fileBytes), "\f")
+	default:
+		return nil, ErrNoTextLayer
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if countNonSpace(strings.Join(pages, "")) < p.MinChars {
+		return nil, ErrNoTextLayer
+	}
+
+	// A text layer is exact; pages without one are likely scanned images
+	confidences := make([]float64, len(pages))
+	for i, page := range pages {
+		if countNonSpace(page) > 0 {
+			confidences[i] = 1.0
+		}
+	}
+	result := newOCRResult(p.Name(), pages, confidences)
+	log.Printf("Local text extracted from %s: %d pages, %d characters", filename, len(pages), len(result.Text))
+	return result, nil
+}
+
+// extractPDFText reads the text layer of every page in a PDF
+func extractPDFText(fileBytes []byte) (pages []string, err error) {
+	// The PDF parser panics on some malformed input
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to parse PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(fileBytes), int64(len(fileBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF: %w", err)
+	}
+
+	pages = make([]string, 0, reader.NumPage())
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			pages = append(pages, "")
+			continue
+		}
+		pageText, err := page.GetPlainText(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read text of page %d: %w", i, err)
+		}
+		pages = append(pages, pageText)
+	}
+	return pages, nil
+}
+
+// extractDOCXText reads the paragraphs of word/document.xml in a DOCX file
+func extractDOCXText(fileBytes []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(fileBytes), int64(len(fileBytes)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open DOCX: %w", err)
+	}
+
+	for _, f := range zr.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", fmt.Errorf("failed to open DOCX body: %w", err)
+		}
+		defer rc.Close()
+
+		var sb strings.Builder
+		decoder := xml.NewDecoder(rc)
+		inText := false
+		for {
+			tok, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", fmt.Errorf("failed to parse DOCX body: %w", err)
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				switch t.Name.Local {
+				case "t":
+					inText = true
+				case "tab":
+					sb.WriteString("\t")
+				case "br":
+					sb.WriteString("\n")
+				}
+			case xml.EndElement:
+				switch t.Name.Local {
+				case "t":
+					inText = false
+				case "p":
+					sb.WriteString("\n")
+				}
+			case xml.CharData:
+				if inText {
+					sb.Write(t)
+				}
+			}
+		}
+		return sb.String(), nil
+	}
+	return "", ErrNoTextLayer
+}
+
+// countNonSpace counts the characters in s that are not whitespace
+func countNonSpace(s string) int {
+	return len(strings.Join(strings.Fields(s), ""))
+}
+
+// OCRSpaceProvider sends files to the api.ocr.space image OCR service
+type OCRSpaceProvider struct {
+	APIKey   string
+	Endpoint string
+	Client   *http.Client
+}
+
+// NewOCRSpaceProvider creates an OCR.space client with the given API key
+func NewOCRSpaceProvider(apiKey string) *OCRSpaceProvider {
+	return &OCRSpaceProvider{
+		APIKey:   strings.TrimSpace(apiKey),
+		Endpoint: "https://api.ocr.space/parse/image",
+		Client:   &http.Client{Timeout: 90 * time.Second},
+	}
+}
+
+// Name returns the provider name
+func (p *OCRSpaceProvider) Name() string {
+	return OCRProviderOCRSpace
+}
+
+// ExtractText sends the file to OCR.space and returns the extracted text
+func (p *OCRSpaceProvider) ExtractText(fileBytes []byte, filename string) (*OCRResult, error) {
+	apiKey := p.APIKey
+	if apiKey == "" {
+		return nil, fmt.Errorf("OCR.space API key is not set")
+	}
+
+	// Additional validation for API key
+	if len(apiKey) < 10 {
+		return nil, fmt.Errorf("invalid OCR.space API key format")
+	}
+
+	log.Printf("Using OCR.space API Key (first 4 chars): %s", apiKey[:4])
+
+	// Determine file type based on filename extension
+	fileExt := strings.ToLower(filepath.Ext(filename))
+	var fileType string
+	switch fileExt {
+	case ".pdf":
+		fileType = "PDF"
+	case ".png":
+		fileType = "PNG"
+	case ".jpg", ".jpeg":
+		fileType = "JPG"
+	case ".gif":
+		fileType = "GIF"
+	case ".tiff", ".tif":
+		fileType = "TIFF"
+	default:
+		fileType = "PDF" // Default to PDF if unknown
+		log.Printf("Unknown file type for %s, defaulting to PDF", filename)
+	}
+
+	// Prepare multipart form
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+
+	// Add form fields
+	if err := w.WriteField("apikey", apiKey); err != nil {
+		return nil, fmt.Errorf("failed to write apikey field: %w", err)
+	}
+	if err := w.WriteField("language", "eng"); err != nil {
+		return nil, fmt.Errorf("failed to write language field: %w", err)
+	}
+	if err := w.WriteField("isOverlayRequired", "false"); err != nil {
+		return nil, fmt.Errorf("failed to write isOverlayRequired field: %w", err)
+	}
+	if err := w.WriteField("filetype", fileType); err != nil {
+		return nil, fmt.Errorf("failed to write filetype field: %w", err)
+	}
+
+	// Add file
+	fw, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create form file: %w", err)
+	}
+	_, err = fw.Write(fileBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write file bytes: %w", err)
+	}
+	w.Close()
+
+	// Create request
+	req, err := http.NewRequest("POST", p.Endpoint, &b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCR request: %w", err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	log.Printf("OCR Request Endpoint: %s", p.Endpoint)
+	log.Printf("OCR File Type: %s", fileType)
+
+	// Send request
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OCR request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the raw response body
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// The body holds the document text, so only its size is logged
+	log.Printf("OCR Response Status: %s (%d bytes)", resp.Status, len(bodyBytes))
+
+	// Try to parse the response
+	var result map[string]interface{}
+	err = json.Unmarshal(bodyBytes, &result)
+	if err != nil {
+		// If it's a plain text error message, return it as an error
+		errorMsg := string(bodyBytes)
+		log.Printf("OCR API Error (JSON Unmarshal): %s", errorMsg)
+		return nil, fmt.Errorf("OCR API error: %s", errorMsg)
+	}
+
+	// Check for error in OCR.space response
+	if errorMessage, ok := result["ErrorMessage"].(string); ok && errorMessage != "" {
+		log.Printf("OCR.space Error Message: %s", errorMessage)
+		return nil, fmt.Errorf("OCR.space error: %s", errorMessage)
+	}
+
+	// Extract parsed results
+	parsedResults, ok := result["ParsedResults"].([]interface{})
+	if !ok || len(parsedResults) == 0 {
+		log.Println("No OCR results found in response")
+		return nil, fmt.Errorf("no OCR results found in response")
+	}
+
+	// OCR.space returns one parsed result per page; keep all of them
+	pageTexts := make([]string, 0, len(parsedResults))
+	confidences := make([]float64, 0, len(parsedResults))
+	for i, item := range parsedResults {
+		pageResult, ok := item.(map[string]interface{})
+		if !ok {
+			log.Println("Invalid parsed results format")
+			return nil, fmt.Errorf("invalid parsed results format for page %d", i+1)
+		}
+
+		parsedText, ok := pageResult["ParsedText"].(string)
+		if !ok {
+			log.Printf("Failed to extract ParsedText for page %d", i+1)
+			return nil, fmt.Errorf("failed to extract ParsedText for page %d from OCR response", i+1)
+		}
+
+		// FileParseExitCode 1 means the page parsed successfully
+		confidence := 0.0
+		if exitCode, _ := pageResult["FileParseExitCode"].(float64); exitCode == 1 {
+			confidence = estimateTextConfidence(parsedText)
+		} else {
+			log.Printf("OCR.space could not parse page %d: %v", i+1, pageResult["ErrorMessage"])
+		}
+
+		pageTexts = append(pageTexts, parsedText)
+		confidences = append(confidences, confidence)
+	}
+
+	ocrResult := newOCRResult(p.Name(), pageTexts, confidences)
+	log.Printf("OCR Text extracted successfully: %d pages, %d characters", len(ocrResult.Pages), len(ocrResult.Text))
+	return ocrResult, nil
+}
+
+// estimateTextConfidence scores recognised text between 0 and 1.
+// OCR.space does not report a confidence, so this uses the share of tokens
+// that look like real words; garbled OCR output is full of symbol runs.
+func estimateTextConfidence(text string) float64 {
+	tokens := strings.Fields(text)
+	if len(tokens) == 0 {
+		return 0.0
+	}
+
+	wordLike := 0
+	for _, token := range tokens {
+		token = strings.Trim(token, ".,;:!?()[]\"'")
+		if token == "" {
+			continue
+		}
+		letters, others := 0, 0
+		for _, r := range token {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				letters++
+			} else if r != '-' && r != '/' && r != '$' && r != '%' && r != '&' {
+				others++
+			}
+		}
+		if letters > 0 && others == 0 {
+			wordLike++
+		}
+	}
+	return float64(wordLike) / float64(len(tokens))
+}
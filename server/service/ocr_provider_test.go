@@ -0,0 +1,221 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubOCRProvider returns a fixed result and counts calls
+type stubOCRProvider struct {
+	name  string
+	text  string
+	err   error
+	calls int
+}
+
+func (p *stubOCRProvider) Name() string { return p.name }
+
+func (p *stubOCRProvider) ExtractText(fileBytes []byte, filename string) (*OCRResult, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &OCRResult{Text: p.text, Provider: p.name}, nil
+}
+
+// minimalPDF builds a one-page PDF per entry in pages with a real text layer
+func minimalPDF(pages ...string) []byte {
+	var objects []string
+	kids := ""
+	for i := range pages {
+		kids += fmt.Sprintf("%d 0 R ", 4+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	)
+	for i, text := range pages {
+		content := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 5+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func TestLocalTextProvider(t *testing.T) {
+	provider := NewLocalTextProvider()
+
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		wantText string
+		wantErr  error
+	}{
+		{
+			name:     "Plain text file",
+			filename: "contract.txt",
+			content:  []byte("This Agreement is Confidential and signed by both parties."),
+			wantText: "This Agreement is Confidential and signed by both parties.",
+		},
+		{
+			name:     "PDF with text layer",
+			filename: "contract.pdf",
+			content:  minimalPDF("This Non-Disclosure Agreement is Confidential"),
+			wantText: "This Non-Disclosure Agreement is Confidential",
+		},
+		{
+			name:     "Image has no text layer",
+			filename: "scan.png",
+			content:  []byte{0x89, 'P', 'N', 'G'},
+			wantErr:  ErrNoTextLayer,
+		},
+		{
+			name:     "Text below threshold",
+			filename: "blank.txt",
+			content:  []byte("  \n x \n"),
+			wantErr:  ErrNoTextLayer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := provider.ExtractText(tt.content, tt.filename)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Contains(t, result.Text, tt.wantText)
+			assert.Equal(t, OCRProviderLocal, result.Provider)
+		})
+	}
+}
+
+func TestFallbackOCRProvider(t *testing.T) {
+	tests := []struct {
+		name          string
+		primaryText   string
+		primaryErr    error
+		wantProvider  string
+		wantFallbacks int
+	}{
+		{
+			name:          "Uses text layer when present",
+			primaryText:   "embedded text",
+			wantProvider:  "local",
+			wantFallbacks: 0,
+		},
+		{
+			name:          "Falls back without text layer",
+			primaryErr:    ErrNoTextLayer,
+			wantProvider:  "remote",
+			wantFallbacks: 1,
+		},
+		{
+			name:          "Falls back on local parse error",
+			primaryErr:    errors.New("corrupt file"),
+			wantProvider:  "remote",
+			wantFallbacks: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &stubOCRProvider{name: "local", text: tt.primaryText, err: tt.primaryErr}
+			fallback := &stubOCRProvider{name: "remote", text: "ocr text"}
+			provider := &FallbackOCRProvider{Primary: primary, Fallback: fallback}
+
+			result, err := provider.ExtractText([]byte("data"), "file.pdf")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantProvider, result.Provider)
+			assert.Equal(t, tt.wantFallbacks, fallback.calls)
+			assert.Equal(t, "local+remote", provider.Name())
+		})
+	}
+}
+
+func TestNewOCRProvider(t *testing.T) {
+	provider, err := NewOCRProvider("", "")
+	require.NoError(t, err)
+	assert.Equal(t, OCRProviderLocal, provider.Name())
+
+	provider, err = NewOCRProvider("", "K1234567890")
+	require.NoError(t, err)
+	assert.Equal(t, "local+ocrspace", provider.Name())
+
+	_, err = NewOCRProvider(OCRProviderOCRSpace, "")
+	assert.Error(t, err)
+}
+
+func TestLocalTextProvider_MultiPagePDF(t *testing.T) {
+	content := minimalPDF("Page one of the Confidential agreement", "Page two with the signature block")
+
+	result, err := NewLocalTextProvider().ExtractText(content, "contract.pdf")
+	require.NoError(t, err)
+	require.Len(t, result.Pages, 2)
+
+	for i, page := range result.Pages {
+		assert.Equal(t, i+1, page.Number)
+		assert.Equal(t, 1.0, page.Confidence)
+		assert.Equal(t, page.Text, result.Text[page.StartOffset:page.EndOffset])
+	}
+	assert.Contains(t, result.Pages[1].Text, "signature block")
+	assert.Empty(t, result.LowConfidencePages(LowOCRConfidenceThreshold))
+}
+
+func TestOCRSpaceProvider_AggregatesPages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"ParsedResults": [
+				{"ParsedText": "This Agreement is Confidential", "FileParseExitCode": 1},
+				{"ParsedText": "#@! ~~ %^ ^^", "FileParseExitCode": 1},
+				{"ParsedText": "", "FileParseExitCode": -10, "ErrorMessage": "page failed"}
+			],
+			"IsErroredOnProcessing": false
+		}`))
+	}))
+	defer server.Close()
+
+	provider := NewOCRSpaceProvider("K1234567890")
+	provider.Endpoint = server.URL
+
+	result, err := provider.ExtractText([]byte("%PDF"), "scan.pdf")
+	require.NoError(t, err)
+	require.Len(t, result.Pages, 3)
+	assert.Equal(t, OCRProviderOCRSpace, result.Provider)
+	assert.Equal(t, 1.0, result.Pages[0].Confidence)
+	assert.Equal(t, []int{2, 3}, result.LowConfidencePages(LowOCRConfidenceThreshold))
+	assert.Equal(t, "This Agreement is Confidential\n\n#@! ~~ %^ ^^\n\n", result.Text)
+}
+
+func TestEstimateTextConfidence(t *testing.T) {
+	assert.Equal(t, 0.0, estimateTextConfidence("   "))
+	assert.Equal(t, 1.0, estimateTextConfidence("Payment is due within 30 days."))
+	assert.Less(t, estimateTextConfidence("Pa#y m@nt ~~ d^e"), LowOCRConfidenceThreshold)
+}
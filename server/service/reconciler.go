@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	model "github.com/Itish41/LegalEagle/models"
+)
+
+// Kinds of drift found by Reconcile
+const (
+	// IssueOrphanBlob is a stored file that no document row refers to.
+	IssueOrphanBlob = "orphan_blob"
+	// IssueMissingBlob is a document row whose stored file is gone.
+	IssueMissingBlob = "missing_blob"
+	// IssueOrphanIndexEntry is a search entry without a document row.
+	IssueOrphanIndexEntry = "orphan_index_entry"
+	// IssueMissingIndexEntry is an analyzed document that cannot be found by search.
+	IssueMissingIndexEntry = "missing_index_entry"
+)
+
+// ReconcileIssue is one inconsistency between the database, blob store and search index
+type ReconcileIssue struct {
+	Kind       string `json:"kind"`
+	DocumentID string `json:"document_id,omitempty"`
+	Key        string `json:"key,omitempty"`
+	Detail     string `json:"detail"`
+	Fixed      bool   `json:"fixed"`
+	FixError   string `json:"fix_error,omitempty"`
+}
+
+// ReconcileReport summarizes one reconciliation run
+type ReconcileReport struct {
+	StartedAt    time.Time        `json:"started_at"`
+	FinishedAt   time.Time        `json:"finished_at"`
+	Fix          bool             `json:"fix"`
+	Documents    int              `json:"documents"`
+	Blobs        int              `json:"blobs"`
+	IndexEntries int              `json:"index_entries"`
+	Issues       []ReconcileIssue `json:"issues"`
+}
+
+// Unfixed counts the issues that are still present after the run
+func (r *ReconcileReport) Unfixed() int {
+	count := 0
+	for _, issue := range r.Issues {
+		if !issue.Fixed {
+			count++
+		}
+	}
+	return count
+}
+
+// Reconcile cross-checks the documents table against the blob store and the
+// search index. With fix set, orphaned blobs and index entries are deleted and
+// missing index entries are rebuilt; missing blobs can only be reported.
+// Documents still being processed are skipped.
+func (s *DocumentService) Reconcile(ctx context.Context, fix bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{StartedAt: time.Now(), Fix: fix, Issues: []ReconcileIssue{}}
+
+	// Documents are loaded last so that every blob and index entry listed
+	// belongs to a row that is already visible, even for uploads in progress
+	blobKeys, err := s.blobs.List(ctx, documentBlobPrefix)
+	if err != nil {
+		return nil, err
+	}
+	indexIDs, err := s.search.IDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var docs []model.Document
+	if err := s.db.WithContext(ctx).Select("id", "title", "original_url", "storage_key", "ocr_text", "processing_status", "created_at").Find(&docs).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch documents: %w", err)
+	}
+	report.Documents, report.Blobs, report.IndexEntries = len(docs), len(blobKeys), len(indexIDs)
+
+	docsByID := make(map[string]model.Document, len(docs))
+	storedKeys := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		docsByID[doc.ID] = doc
+		if doc.StorageKey != "" {
+			storedKeys[doc.StorageKey] = true
+		}
+	}
+	blobSet := make(map[string]bool, len(blobKeys))
+	for _, key := range blobKeys {
+		blobSet[key] = true
+	}
+	indexSet := make(map[string]bool, len(indexIDs))
+	for _, id := range indexIDs {
+		indexSet[id] = true
+	}
+
+	// Blobs that no document refers to
+	for _, key := range blobKeys {
+		if storedKeys[key] {
+			continue
+		}
+		id, ok := documentIDFromBlobKey(key)
+		if ok && isProcessing(docsByID[id]) {
+			continue // Uploaded by a worker that has not recorded the key yet
+		}
+		if ok {
+			exists, err := s.documentExists(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				continue // Created since the documents were loaded
+			}
+		}
+		issue := ReconcileIssue{Kind: IssueOrphanBlob, Key: key, Detail: "stored file has no document"}
+		if fix {
+			issue.record(s.blobs.Delete(ctx, key))
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	// Search entries without a document, e.g. entries indexed under the old timestamp-filename IDs
+	for _, id := range indexIDs {
+		if _, ok := docsByID[id]; ok {
+			continue
+		}
+		exists, err := s.documentExists(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			continue
+		}
+		issue := ReconcileIssue{Kind: IssueOrphanIndexEntry, DocumentID: id, Detail: "search entry has no document"}
+		if fix {
+			issue.record(s.search.Delete(ctx, id))
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	for _, doc := range docs {
+		if doc.ProcessingStatus != model.DocumentStatusAnalyzed {
+			continue
+		}
+		if doc.StorageKey != "" && !blobSet[doc.StorageKey] {
+			report.Issues = append(report.Issues, ReconcileIssue{
+				Kind:       IssueMissingBlob,
+				DocumentID: doc.ID,
+				Key:        doc.StorageKey,
+				Detail:     "original file is missing from storage; the document must be uploaded again",
+			})
+		}
+		if !indexSet[doc.ID] {
+			issue := ReconcileIssue{Kind: IssueMissingIndexEntry, DocumentID: doc.ID, Detail: "document is not in the search index"}
+			if fix {
+				issue.record(s.search.Index(ctx, SearchDocument{ID: doc.ID, Title: doc.Title, FileURL: doc.OriginalURL, OcrText: doc.OcrText, Timestamp: doc.CreatedAt}))
+			}
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// record marks the issue fixed, or keeps the reason the fix failed
+func (i *ReconcileIssue) record(err error) {
+	if err != nil {
+		i.FixError = err.Error()
+		return
+	}
+	i.Fixed = true
+}
+
+// documentExists re-reads a document row right before its blob or search
+// entry would be reported as an orphan
+func (s *DocumentService) documentExists(ctx context.Context, id string) (bool, error) {
+	var statuses []string
+	if err := s.db.WithContext(ctx).Model(&model.Document{}).Where("id = ?", id).Pluck("processing_status", &statuses).Error; err != nil {
+		return false, fmt.Errorf("failed to check document %s: %w", id, err)
+	}
+	return len(statuses) > 0, nil
+}
+
+// isProcessing reports whether a worker may still be writing the document's side effects
+func isProcessing(doc model.Document) bool {
+	for _, status := range unfinishedStatuses {
+		if doc.ProcessingStatus == status {
+			return true
+		}
+	}
+	return false
+}
+
+// StartReconciler runs Reconcile every interval until ctx is cancelled and logs each report
+func (s *DocumentService) StartReconciler(ctx context.Context, interval time.Duration, fix bool) {
+	if interval <= 0 {
+		log.Println("Scheduled reconciliation is disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				report, err := s.Reconcile(ctx, fix)
+				if err != nil {
+					log.Printf("[Reconcile] Reconciliation failed: %v", err)
+					continue
+				}
+				log.Printf("[Reconcile] Checked %d documents, %d blobs, %d index entries: %d issues, %d unfixed",
+					report.Documents, report.Blobs, report.IndexEntries, len(report.Issues), report.Unfixed())
+				for _, issue := range report.Issues {
+					log.Printf("[Reconcile] %s document=%s key=%s fixed=%t %s %s", issue.Kind, issue.DocumentID, issue.Key, issue.Fixed, issue.Detail, issue.FixError)
+				}
+			}
+		}
+	}()
+	log.Printf("Scheduled reconciliation every %s (fix=%t)", interval, fix)
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	s, db, blobs := newPipelineService(t, &stubOCRProvider{name: "local", text: "text"}, 1)
+	search := &recordingSearchIndex{}
+	s.search = search
+
+	// A consistent document
+	healthyKey := documentBlobKey("doc-healthy", "a.pdf")
+	require.NoError(t, blobs.Put(ctx, healthyKey, []byte("a"), ""))
+	require.NoError(t, db.Create(&model.Document{ID: "doc-healthy", StorageKey: healthyKey, ProcessingStatus: model.DocumentStatusAnalyzed}).Error)
+	search.indexed = append(search.indexed, "doc-healthy")
+
+	// An analyzed document whose file is gone and which was never indexed
+	require.NoError(t, db.Create(&model.Document{ID: "doc-broken", Title: "broken", StorageKey: documentBlobKey("doc-broken", "b.pdf"), ProcessingStatus: model.DocumentStatusAnalyzed}).Error)
+
+	// A document still being processed whose key is not recorded yet
+	require.NoError(t, blobs.Put(ctx, documentBlobKey("doc-queued", "c.pdf"), []byte("c"), ""))
+	require.NoError(t, db.Create(&model.Document{ID: "doc-queued", ProcessingStatus: model.DocumentStatusQueued}).Error)
+
+	// Orphans: a file without a row and an entry under a legacy timestamp-filename ID
+	orphanKey := documentBlobKey("doc-deleted", "d.pdf")
+	require.NoError(t, blobs.Put(ctx, orphanKey, []byte("d"), ""))
+	search.indexed = append(search.indexed, "1700000000-contract.pdf")
+
+	report, err := s.Reconcile(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Documents)
+	assert.Equal(t, 3, report.Blobs)
+	assert.Equal(t, 2, report.IndexEntries)
+
+	kinds := map[string]string{}
+	for _, issue := range report.Issues {
+		kinds[issue.Kind] = issue.DocumentID + issue.Key
+		assert.False(t, issue.Fixed)
+	}
+	assert.Equal(t, map[string]string{
+		IssueOrphanBlob:        orphanKey,
+		IssueOrphanIndexEntry:  "1700000000-contract.pdf",
+		IssueMissingBlob:       "doc-broken" + documentBlobKey("doc-broken", "b.pdf"),
+		IssueMissingIndexEntry: "doc-broken",
+	}, kinds)
+
+	report, err = s.Reconcile(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Unfixed(), "missing blobs can only be reported")
+
+	_, _, err = blobs.Get(ctx, orphanKey)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+	ids, err := search.IDs(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"doc-healthy", "doc-broken"}, ids)
+
+	report, err = s.Reconcile(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 1)
+	assert.Equal(t, IssueMissingBlob, report.Issues[0].Kind)
+}
+
+func TestDocumentIDFromBlobKey(t *testing.T) {
+	id, ok := documentIDFromBlobKey(documentBlobKey("doc-1", "contract.pdf"))
+	assert.True(t, ok)
+	assert.Equal(t, "doc-1", id)
+
+	_, ok = documentIDFromBlobKey("exports/report.csv")
+	assert.False(t, ok)
+}
+
+// listHookBlobStore runs beforeList once before listing the blobs
+type listHookBlobStore struct {
+	BlobStore
+	beforeList func()
+}
+
+func (b *listHookBlobStore) List(ctx context.Context, prefix string) ([]string, error) {
+	if b.beforeList != nil {
+		b.beforeList()
+		b.beforeList = nil
+	}
+	return b.BlobStore.List(ctx, prefix)
+}
+
+func TestReconcile_KeepsUploadsInProgress(t *testing.T) {
+	ctx := context.Background()
+	s, db, blobs := newPipelineService(t, &stubOCRProvider{name: "local", text: "text"}, 1)
+	s.search = &recordingSearchIndex{}
+
+	// An upload writes its blob and then its row while the reconciler lists blobs
+	uploadKey := documentBlobKey("doc-uploading", "a.pdf")
+	s.blobs = &listHookBlobStore{BlobStore: blobs, beforeList: func() {
+		require.NoError(t, blobs.Put(ctx, uploadKey, []byte("a"), ""))
+		require.NoError(t, db.Create(&model.Document{ID: "doc-uploading", ProcessingStatus: model.DocumentStatusQueued}).Error)
+	}}
+
+	// Another row appears after the documents are loaded
+	lateKey := documentBlobKey("doc-late", "b.pdf")
+	require.NoError(t, blobs.Put(ctx, lateKey, []byte("b"), ""))
+	created := false
+	require.NoError(t, db.Callback().Query().After("gorm:query").Register("create_late_document", func(tx *gorm.DB) {
+		if tx.Statement.Table == "documents" && !created {
+			created = true
+			require.NoError(t, db.Create(&model.Document{ID: "doc-late", ProcessingStatus: model.DocumentStatusAnalyzed, StorageKey: lateKey}).Error)
+		}
+	}))
+
+	report, err := s.Reconcile(ctx, true)
+	require.NoError(t, err)
+	assert.True(t, created)
+	for _, issue := range report.Issues {
+		assert.NotEqual(t, IssueOrphanBlob, issue.Kind, issue.Key)
+	}
+	for _, key := range []string{uploadKey, lateKey} {
+		_, _, err = blobs.Get(ctx, key)
+		assert.NoError(t, err, key)
+	}
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+)
+
+// celCostLimit bounds the work a single expression may do on one document
+const celCostLimit = 1_000_000
+
+// celProgramCacheSize bounds how many compiled expressions are kept. Drafts
+// and dry runs compile expressions that are never saved, so the least
+// recently used are dropped and compiled again if they come back.
+const celProgramCacheSize = 256
+
+// celEvaluator checks rules whose Pattern is a CEL expression over the
+// document's facts. The expression must return a bool; true means the document
+// complies. Available variables:
+//
+//	text           string               the OCR text
+//	title          string               the document title
+//	document_type  string               nda, employment, lease, services, purchase, license or other
+//	page_count     int                  number of extracted pages
+//	entities       map(string, dyn)     dates, amounts, emails and, when found, governing_law and payment_terms_days
+//	rules          map(string, string)  outcomes ("pass", "fail", "indeterminate" or "not_applicable") of the non-expression rules, by rule name
+type celEvaluator struct {
+	env      *cel.Env
+	programs *celProgramCache
+}
+
+// celProgramCache keeps the most recently used compiled expressions
+type celProgramCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List               // most recently used first
+	entries map[string]*list.Element // expression -> element holding a celCacheEntry
+}
+
+// celCacheEntry is one compiled expression in a celProgramCache
+type celCacheEntry struct {
+	expression string
+	program    cel.Program
+}
+
+// newCELProgramCache returns a cache holding at most size programs
+func newCELProgramCache(size int) *celProgramCache {
+	return &celProgramCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get returns the program compiled for expression, if it is cached
+func (c *celProgramCache) get(expression string) (cel.Program, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[expression]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(celCacheEntry).program, true
+}
+
+// add caches a program, dropping the least recently used one when full
+func (c *celProgramCache) add(expression string, program cel.Program) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[expression]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[expression] = c.order.PushFront(celCacheEntry{expression: expression, program: program})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(celCacheEntry).expression)
+	}
+}
+
+// len returns how many programs are cached
+func (c *celProgramCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// newCELEvaluator declares the fact variables expressions can use
+func newCELEvaluator() *celEvaluator {
+	env, err := cel.NewEnv(
+		cel.Variable("text", cel.StringType),
+		cel.Variable("title", cel.StringType),
+		cel.Variable("document_type", cel.StringType),
+		cel.Variable("page_count", cel.IntType),
+		cel.Variable("entities", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("rules", cel.MapType(cel.StringType, cel.StringType)),
+	)
+	if err != nil {
+		panic(fmt.Sprintf("invalid CEL environment: %v", err))
+	}
+	return &celEvaluator{env: env, programs: newCELProgramCache(celProgramCacheSize)}
+}
+
+// ReadsRuleOutcomes makes expression rules run after the rules they may refer to
+func (e *celEvaluator) ReadsRuleOutcomes() bool { return true }
+
+// ValidateRule compiles the rule's expression and checks that it returns a bool
+func (e *celEvaluator) ValidateRule(rule model.ComplianceRule) error {
+	_, err := e.program(rule.Pattern)
+	return err
+}
+
+// program compiles an expression, reusing the result while it stays cached
+func (e *celEvaluator) program(expression string) (cel.Program, error) {
+	if cached, ok := e.programs.get(expression); ok {
+		return cached, nil
+	}
+	if expression == "" {
+		return nil, errors.New("expression is empty")
+	}
+
+	ast, issues := e.env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression: %w", issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("expression must return a bool, not %s", ast.OutputType())
+	}
+	program, err := e.env.Program(ast, cel.CostLimit(celCostLimit), cel.InterruptCheckFrequency(100))
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %w", err)
+	}
+	e.programs.add(expression, program)
+	return program, nil
+}
+
+// Evaluate runs each rule's expression against the facts of the document
+func (e *celEvaluator) Evaluate(ctx context.Context, req RuleEvaluationRequest) (map[string]RuleOutcome, error) {
+	facts := documentFacts(req)
+	outcomes := make(map[string]RuleOutcome, len(req.Rules))
+	for _, rule := range req.Rules {
+		outcomes[rule.ID] = e.evaluate(ctx, rule, facts)
+	}
+	return outcomes, nil
+}
+
+// evaluate runs one rule; an expression that cannot be evaluated is indeterminate, with the reason
+func (e *celEvaluator) evaluate(ctx context.Context, rule model.ComplianceRule, facts map[string]interface{}) RuleOutcome {
+	program, err := e.program(rule.Pattern)
+	if err != nil {
+		return RuleOutcome{Status: RuleStatusIndeterminate, Explanation: fmt.Sprintf("Rule '%s' could not be evaluated: %v", rule.Name, err)}
+	}
+
+	value, _, err := program.ContextEval(ctx, facts)
+	if err != nil {
+		// Missing keys, e.g. entities.payment_terms_days in a document without payment terms
+		return RuleOutcome{Status: RuleStatusIndeterminate, Explanation: fmt.Sprintf("Rule '%s' could not be evaluated against this document: %v", rule.Name, err)}
+	}
+	if value == types.True {
+		return RuleOutcome{Status: RuleStatusPass, Explanation: fmt.Sprintf("The document satisfies the condition of the '%s' rule.", rule.Name)}
+	}
+	return RuleOutcome{Status: RuleStatusFail, Explanation: fmt.Sprintf("The document does not satisfy the condition of the '%s' rule: %s", rule.Name, rule.Pattern)}
+}
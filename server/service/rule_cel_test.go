@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const serviceAgreement = `MASTER SERVICES AGREEMENT
+
+The Service Provider shall deliver the deliverables described in each statement of work.
+The Client shall pay each invoice within forty-five (45) days of receipt. Fees are $12,500.00 per month.
+
+This Agreement shall be governed by the laws of the State of New York.
+Disputes shall be settled by binding arbitration.
+
+Notices: legal@example.com. Effective 1 March 2024.`
+
+func TestExtractEntities(t *testing.T) {
+	entities := extractEntities(serviceAgreement)
+	assert.Equal(t, "State of New York", entities["governing_law"])
+	assert.Equal(t, int64(45), entities["payment_terms_days"])
+	assert.Equal(t, []string{"$12,500.00"}, entities["amounts"])
+	assert.Equal(t, []string{"legal@example.com"}, entities["emails"])
+	assert.Equal(t, []string{"1 March 2024"}, entities["dates"])
+
+	assert.Equal(t, int64(30), extractEntities("Payment terms: Net 30.")["payment_terms_days"])
+	assert.NotContains(t, extractEntities(sampleContract), "payment_terms_days")
+
+	assert.Equal(t, DocumentTypeServices, classifyDocument(serviceAgreement))
+	assert.Equal(t, DocumentTypeNDA, classifyDocument(sampleContract))
+	assert.Equal(t, DocumentTypeOther, classifyDocument("Meeting notes"))
+}
+
+func TestCELEvaluator(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantStatus string
+		wantInText string
+	}{
+		{name: "payment terms within limit", expression: `has(entities.payment_terms_days) && entities.payment_terms_days <= 60`, wantStatus: RuleStatusPass},
+		{name: "payment terms over limit", expression: `entities.payment_terms_days <= 30`, wantStatus: RuleStatusFail, wantInText: "does not satisfy"},
+		{name: "foreign law requires arbitration", expression: `!has(entities.governing_law) || entities.governing_law == "India" || text.matches("(?i)arbitration")`, wantStatus: RuleStatusPass},
+		{name: "document facts", expression: `document_type == "services" && page_count == 2 && title == "msa"`, wantStatus: RuleStatusPass},
+		{name: "other rule outcomes", expression: `rules["Signature Present"] == "pass"`, wantStatus: RuleStatusFail},
+		{name: "missing key", expression: `entities.non_compete_months < 12`, wantStatus: RuleStatusIndeterminate, wantInText: "no such key"},
+	}
+
+	s := &DocumentService{llm: NewFakeLLMClient()}
+	signature := model.ComplianceRule{ID: "signature", Name: "Signature Present", Type: model.RuleTypeRegexMustMatch, Pattern: `signed by`}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := model.ComplianceRule{ID: "cel", Name: tt.name, Type: model.RuleTypeCEL, Pattern: tt.expression}
+			// The expression rule comes first to show it still sees the regex rule's outcome
+			outcomes, err := s.evaluateRules(context.Background(),
+				RuleEvaluationRequest{Text: serviceAgreement, Title: "msa", PageCount: 2},
+				[]model.ComplianceRule{rule, signature})
+			require.NoError(t, err)
+
+			outcome := outcomes["cel"]
+			assert.Equal(t, tt.wantStatus, outcome.Status, outcome.Explanation)
+			assert.Contains(t, outcome.Explanation, tt.wantInText)
+		})
+	}
+}
+
+func TestCELEvaluator_BoundsProgramCache(t *testing.T) {
+	e := newCELEvaluator()
+	e.programs = newCELProgramCache(2)
+	for _, expression := range []string{`page_count == 1`, `page_count == 2`, `page_count == 1`, `page_count == 3`} {
+		require.NoError(t, e.ValidateRule(model.ComplianceRule{Pattern: expression}))
+	}
+	assert.Equal(t, 2, e.programs.len())
+	_, ok := e.programs.get(`page_count == 1`)
+	assert.True(t, ok, "recently used expressions stay cached")
+	_, ok = e.programs.get(`page_count == 2`)
+	assert.False(t, ok, "the least recently used expression is dropped")
+}
+
+func TestAddComplianceRule_ValidatesCELExpressions(t *testing.T) {
+	s, _, _ := newPipelineService(t, &stubOCRProvider{name: "local"}, 1)
+
+	for _, expression := range []string{``, `entities.payment_terms_days <=`, `page_count + 1`, `unknown_fact == 1`} {
+		err := s.AddComplianceRule(context.Background(), &model.ComplianceRule{Name: "Payment Terms", Type: model.RuleTypeCEL, Pattern: expression})
+		assert.ErrorIs(t, err, ErrInvalidRule, expression)
+	}
+
+	rule := &model.ComplianceRule{Name: "Payment Terms", Type: model.RuleTypeCEL, Pattern: `entities.payment_terms_days <= 60`}
+	require.NoError(t, s.AddComplianceRule(context.Background(), rule))
+
+	_, err := s.UpdateComplianceRule(context.Background(), rule.ID, model.ComplianceRule{Name: "Payment Terms", Type: model.RuleTypeCEL, Pattern: `payment_terms_days <= 60`}, false)
+	assert.ErrorIs(t, err, ErrInvalidRule)
+}
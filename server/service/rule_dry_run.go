@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"gorm.io/gorm"
+)
+
+// maxRuleTestDocuments caps the documents one dry run may evaluate
+const maxRuleTestDocuments = 50
+
+// dryRunRuleID stands in for the ID of a rule that has not been saved
+const dryRunRuleID = "dry-run"
+
+var (
+	// ErrRuleTestInputRequired is returned for a dry run without text or documents
+	ErrRuleTestInputRequired = errors.New("text or document_ids is required")
+	// ErrTooManyRuleTestDocuments is returned when a dry run names too many documents
+	ErrTooManyRuleTestDocuments = fmt.Errorf("at most %d documents can be tested at once", maxRuleTestDocuments)
+)
+
+// RuleTestRequest is a proposed rule and the texts to try it on
+type RuleTestRequest struct {
+	Rule        model.ComplianceRule `json:"rule"`
+	Text        string               `json:"text"`
+	DocumentIDs []string             `json:"document_ids"`
+}
+
+// RuleTestResult is the outcome of a proposed rule on one text or document
+type RuleTestResult struct {
+	DocumentID  string     `json:"document_id,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	Status      string     `json:"status"`
+	Explanation string     `json:"explanation"`
+	Spans       []TextSpan `json:"spans,omitempty"`
+}
+
+// RuleTestCaseResult compares a test case's expected outcome with the actual one
+type RuleTestCaseResult struct {
+	Name        string     `json:"name,omitempty"`
+	Expect      string     `json:"expect"`
+	Status      string     `json:"status"`
+	Passed      bool       `json:"passed"`
+	Explanation string     `json:"explanation"`
+	Spans       []TextSpan `json:"spans,omitempty"`
+}
+
+// RuleTestReport is the result of a dry run
+type RuleTestReport struct {
+	Results   []RuleTestResult     `json:"results"`
+	TestCases []RuleTestCaseResult `json:"test_cases,omitempty"`
+	Warnings  []model.RuleIssue    `json:"warnings,omitempty"`
+}
+
+// RuleTestFailedError is returned when a rule is saved with a definition that
+// does not produce the outcomes its test cases expect
+type RuleTestFailedError struct {
+	RuleName string
+	Failures []RuleTestCaseResult
+}
+
+// Error summarizes how many test cases failed
+func (e *RuleTestFailedError) Error() string {
+	return fmt.Sprintf("rule %q fails %d of its test cases", e.RuleName, len(e.Failures))
+}
+
+// TestComplianceRule evaluates a proposed rule against the supplied text and
+// documents without saving anything, and runs the rule's test cases. Documents
+// outside the rule's scope are reported as not_applicable; the text and test
+// cases are checked regardless of scope.
+func (s *DocumentService) TestComplianceRule(ctx context.Context, req RuleTestRequest) (*RuleTestReport, error) {
+	if strings.TrimSpace(req.Text) == "" && len(req.DocumentIDs) == 0 {
+		return nil, ErrRuleTestInputRequired
+	}
+	if len(req.DocumentIDs) > maxRuleTestDocuments {
+		return nil, ErrTooManyRuleTestDocuments
+	}
+	rule := req.Rule
+	rule.Type = normalizeRuleType(rule.Type)
+	warnings, err := s.checkRuleDefinition(rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRuleTestCases(rule.TestCases); err != nil {
+		return nil, err
+	}
+
+	var docs []model.Document
+	if len(req.DocumentIDs) > 0 {
+		if err := s.db.Select("id", "title", "ocr_text", "tags").Where("id IN ?", req.DocumentIDs).Find(&docs).Error; err != nil {
+			return nil, fmt.Errorf("failed to load documents: %w", err)
+		}
+		requested := make(map[string]bool, len(req.DocumentIDs))
+		for _, id := range req.DocumentIDs {
+			requested[id] = true
+		}
+		if len(docs) < len(requested) {
+			return nil, ErrDocumentNotFound
+		}
+	}
+
+	scope, err := parseRuleScope(rule.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RuleTestReport{Results: []RuleTestResult{}, Warnings: warnings}
+	if strings.TrimSpace(req.Text) != "" {
+		outcome, err := s.dryRunRule(ctx, rule, RuleEvaluationRequest{Text: req.Text})
+		if err != nil {
+			return nil, err
+		}
+		report.Results = append(report.Results, RuleTestResult{Status: outcome.Status, Explanation: outcome.Explanation, Spans: outcome.Spans})
+	}
+	for _, doc := range docs {
+		result := RuleTestResult{DocumentID: doc.ID, Title: doc.Title}
+		if strings.TrimSpace(doc.OcrText) == "" {
+			// Nothing is known about a document still being processed
+			result.Status = RuleStatusIndeterminate
+			result.Explanation = "The document has no extracted text yet, so the rule could not be checked against it."
+			report.Results = append(report.Results, result)
+			continue
+		}
+		if ok, reason := scope.appliesTo(documentProfile(doc.OcrText, doc.Tags)); !ok {
+			result.Status = RuleStatusNotApplicable
+			result.Explanation = fmt.Sprintf("The rule does not apply: %s.", reason)
+			report.Results = append(report.Results, result)
+			continue
+		}
+		var pageCount int64
+		if err := s.db.Model(&model.DocumentPage{}).Where("document_id = ?", doc.ID).Count(&pageCount).Error; err != nil {
+			return nil, fmt.Errorf("failed to count document pages: %w", err)
+		}
+		outcome, err := s.dryRunRule(ctx, rule, RuleEvaluationRequest{Text: doc.OcrText, Title: doc.Title, PageCount: int(pageCount)})
+		if err != nil {
+			return nil, err
+		}
+		result.Status, result.Explanation, result.Spans = outcome.Status, outcome.Explanation, outcome.Spans
+		report.Results = append(report.Results, result)
+	}
+
+	results, err := s.runRuleTestCases(ctx, rule, rule.TestCases)
+	if err != nil {
+		return nil, err
+	}
+	report.TestCases = results
+	log.Printf("[TestComplianceRule] Tested rule %q on %d texts and %d test cases", rule.Name, len(report.Results), len(results))
+	return report, nil
+}
+
+// dryRunRule evaluates one rule on its own, whether or not it has been saved
+func (s *DocumentService) dryRunRule(ctx context.Context, rule model.ComplianceRule, doc RuleEvaluationRequest) (RuleOutcome, error) {
+	if rule.ID == "" {
+		rule.ID = dryRunRuleID
+	}
+	outcomes, err := s.evaluateRules(ctx, doc, []model.ComplianceRule{rule})
+	if err != nil {
+		return RuleOutcome{}, err
+	}
+	return outcomes[rule.ID], nil
+}
+
+// checkRuleTestCases rejects test cases without text or with an unknown expectation
+func checkRuleTestCases(cases []model.ComplianceRuleTestCase) error {
+	for i, testCase := range cases {
+		label := testCase.Name
+		if label == "" {
+			label = fmt.Sprintf("#%d", i+1)
+		}
+		if strings.TrimSpace(testCase.Text) == "" {
+			return fmt.Errorf("%w: test case %s has no text", ErrInvalidRule, label)
+		}
+		if testCase.Expect != RuleStatusPass && testCase.Expect != RuleStatusFail {
+			return fmt.Errorf("%w: test case %s expects %q, want %q or %q", ErrInvalidRule, label, testCase.Expect, RuleStatusPass, RuleStatusFail)
+		}
+	}
+	return nil
+}
+
+// runRuleTestCases evaluates the rule on each test case's text
+func (s *DocumentService) runRuleTestCases(ctx context.Context, rule model.ComplianceRule, cases []model.ComplianceRuleTestCase) ([]RuleTestCaseResult, error) {
+	results := make([]RuleTestCaseResult, 0, len(cases))
+	for _, testCase := range cases {
+		outcome, err := s.dryRunRule(ctx, rule, RuleEvaluationRequest{Text: testCase.Text})
+		if err != nil {
+			return nil, fmt.Errorf("failed to run test case %q: %w", testCase.Name, err)
+		}
+		results = append(results, RuleTestCaseResult{
+			Name:        testCase.Name,
+			Expect:      testCase.Expect,
+			Status:      outcome.Status,
+			Passed:      outcome.Status == testCase.Expect,
+			Explanation: outcome.Explanation,
+			Spans:       outcome.Spans,
+		})
+	}
+	return results, nil
+}
+
+// checkRuleAgainstTestCases runs the test cases and fails with the ones whose
+// expectation the rule does not meet
+func (s *DocumentService) checkRuleAgainstTestCases(ctx context.Context, rule model.ComplianceRule, cases []model.ComplianceRuleTestCase) error {
+	if len(cases) == 0 {
+		return nil
+	}
+	results, err := s.runRuleTestCases(ctx, rule, cases)
+	if err != nil {
+		return err
+	}
+	var failures []RuleTestCaseResult
+	for _, result := range results {
+		if !result.Passed {
+			failures = append(failures, result)
+		}
+	}
+	if len(failures) > 0 {
+		return &RuleTestFailedError{RuleName: rule.Name, Failures: failures}
+	}
+	return nil
+}
+
+// testRuleChange runs the test cases a new or edited rule must pass. They may
+// call the LLM, so this runs before the transaction that saves the rule rather
+// than holding it open. id is empty for a new rule; an edit without test cases
+// is tested against the stored ones. It returns the cases it ran.
+func (s *DocumentService) testRuleChange(ctx context.Context, id string, input model.ComplianceRule) ([]model.ComplianceRuleTestCase, error) {
+	rule := input
+	rule.Name = strings.TrimSpace(rule.Name)
+	rule.Type = normalizeRuleType(rule.Type)
+	rule.Severity = normalizeSeverity(rule.Severity)
+	cases := input.TestCases
+	if cases == nil && id != "" {
+		if err := s.db.Where("rule_id = ?", id).Order("created_at").Find(&cases).Error; err != nil {
+			return nil, fmt.Errorf("failed to load test cases: %w", err)
+		}
+	} else if err := checkRuleTestCases(cases); err != nil {
+		return nil, err
+	}
+	// An invalid definition would only make every test case fail
+	if _, err := s.checkRuleDefinition(rule); err != nil {
+		return nil, err
+	}
+	if err := s.checkRuleAgainstTestCases(ctx, rule, cases); err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
+
+// retestRule runs cases within the save unless they are the ones the rule was
+// already tested against, e.g. when the stored cases changed in the meantime
+func (s *DocumentService) retestRule(ctx context.Context, rule model.ComplianceRule, cases, tested []model.ComplianceRuleTestCase) error {
+	if sameTestCases(cases, tested) {
+		return nil
+	}
+	return s.checkRuleAgainstTestCases(ctx, rule, cases)
+}
+
+// replaceRuleTestCases stores cases as the rule's complete set of test cases
+func (s *DocumentService) replaceRuleTestCases(tx *gorm.DB, ruleID string, cases []model.ComplianceRuleTestCase) ([]model.ComplianceRuleTestCase, error) {
+	if err := tx.Where("rule_id = ?", ruleID).Delete(&model.ComplianceRuleTestCase{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to clear test cases: %w", err)
+	}
+	stored := make([]model.ComplianceRuleTestCase, len(cases))
+	for i, testCase := range cases {
+		stored[i] = model.ComplianceRuleTestCase{RuleID: ruleID, Name: strings.TrimSpace(testCase.Name), Text: testCase.Text, Expect: testCase.Expect}
+	}
+	if len(stored) == 0 {
+		return stored, nil
+	}
+	if err := tx.Create(&stored).Error; err != nil {
+		return nil, fmt.Errorf("failed to save test cases: %w", err)
+	}
+	return stored, nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestComplianceRule(t *testing.T) {
+	ctx := context.Background()
+	ocr := &stubOCRProvider{name: "local", text: "The arbitration clause is binding. Arbitration takes place in London."}
+	s, db, _ := newPipelineService(t, ocr, 1)
+	withClause := analyzedDocument(t, s, "msa.txt", ocr.text)
+	ocr.text = "Disputes go to the courts of New York."
+	withoutClause := analyzedDocument(t, s, "nda.txt", ocr.text)
+
+	rule := model.ComplianceRule{
+		Name:    "Arbitration Clause",
+		Type:    model.RuleTypeRegexMustMatch,
+		Pattern: `arbitration`,
+		TestCases: []model.ComplianceRuleTestCase{
+			{Name: "mentions arbitration", Text: "Any dispute goes to arbitration.", Expect: RuleStatusPass},
+			{Name: "wrongly expects a pass", Text: "Courts only.", Expect: RuleStatusPass},
+		},
+	}
+	report, err := s.TestComplianceRule(ctx, RuleTestRequest{
+		Rule:        rule,
+		Text:        "No dispute resolution clause.",
+		DocumentIDs: []string{withClause.ID, withoutClause.ID},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, report.Results, 3)
+	assert.Equal(t, RuleStatusFail, report.Results[0].Status)
+	assert.Empty(t, report.Results[0].DocumentID)
+	byDocument := map[string]RuleTestResult{}
+	for _, result := range report.Results[1:] {
+		byDocument[result.DocumentID] = result
+	}
+	assert.Equal(t, RuleStatusPass, byDocument[withClause.ID].Status)
+	assert.Equal(t, "msa", byDocument[withClause.ID].Title)
+	require.Len(t, byDocument[withClause.ID].Spans, 2)
+	assert.Equal(t, "Arbitration", byDocument[withClause.ID].Spans[1].Text)
+	assert.Equal(t, RuleStatusFail, byDocument[withoutClause.ID].Status)
+
+	require.Len(t, report.TestCases, 2)
+	assert.True(t, report.TestCases[0].Passed)
+	assert.False(t, report.TestCases[1].Passed)
+	assert.Equal(t, RuleStatusFail, report.TestCases[1].Status)
+
+	var count int64
+	require.NoError(t, db.Model(&model.ComplianceRule{}).Where("name = ?", rule.Name).Count(&count).Error)
+	assert.Zero(t, count, "a dry run saves nothing")
+
+	_, err = s.TestComplianceRule(ctx, RuleTestRequest{Rule: rule})
+	assert.ErrorIs(t, err, ErrRuleTestInputRequired)
+	_, err = s.TestComplianceRule(ctx, RuleTestRequest{Rule: rule, DocumentIDs: []string{withClause.ID, "missing"}})
+	assert.ErrorIs(t, err, ErrDocumentNotFound)
+
+	queued := model.Document{ID: "doc-queued", Title: "lease", ProcessingStatus: model.DocumentStatusQueued}
+	require.NoError(t, db.Create(&queued).Error)
+	report, err = s.TestComplianceRule(ctx, RuleTestRequest{Rule: rule, DocumentIDs: []string{queued.ID}})
+	require.NoError(t, err)
+	require.Len(t, report.Results, 1)
+	assert.Equal(t, RuleStatusIndeterminate, report.Results[0].Status, "a document without text is not a failure")
+	assert.Contains(t, report.Results[0].Explanation, "no extracted text yet")
+
+	_, err = s.TestComplianceRule(ctx, RuleTestRequest{Rule: model.ComplianceRule{Type: model.RuleTypeCEL, Pattern: `page_count +`}, Text: "x"})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+}
+
+func TestRuleTestCases_RunWhenRuleIsSaved(t *testing.T) {
+	s, _, _ := newPipelineService(t, &stubOCRProvider{name: "local"}, 1)
+	positive := model.ComplianceRuleTestCase{Name: "has a term", Text: "This Agreement has a term of one year.", Expect: RuleStatusPass}
+	negative := model.ComplianceRuleTestCase{Name: "no term", Text: "This Agreement continues indefinitely.", Expect: RuleStatusFail}
+
+	broken := &model.ComplianceRule{Name: "Fixed Term", Type: model.RuleTypeRegexMustMatch, Pattern: `agreement`,
+		TestCases: []model.ComplianceRuleTestCase{positive, negative}}
+	var failed *RuleTestFailedError
+	require.ErrorAs(t, s.AddComplianceRule(context.Background(), broken), &failed)
+	require.Len(t, failed.Failures, 1)
+	assert.Equal(t, "no term", failed.Failures[0].Name)
+
+	assert.ErrorIs(t, s.AddComplianceRule(context.Background(), &model.ComplianceRule{Name: "Fixed Term", Type: model.RuleTypeRegexMustMatch, Pattern: `term`,
+		TestCases: []model.ComplianceRuleTestCase{{Text: "x", Expect: "maybe"}}}), ErrInvalidRule)
+
+	rule := &model.ComplianceRule{Name: "Fixed Term", Type: model.RuleTypeRegexMustMatch, Pattern: `term of`,
+		TestCases: []model.ComplianceRuleTestCase{positive, negative}}
+	require.NoError(t, s.AddComplianceRule(context.Background(), rule))
+	stored, err := s.GetComplianceRule(rule.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.TestCases, 2)
+	assert.Equal(t, rule.ID, stored.TestCases[0].RuleID)
+
+	// Editing the definition runs the stored cases
+	_, err = s.UpdateComplianceRule(context.Background(), rule.ID, model.ComplianceRule{Name: "Fixed Term", Type: model.RuleTypeRegexMustMatch, Pattern: `agreement`}, false)
+	require.ErrorAs(t, err, &failed)
+	stored, err = s.GetComplianceRule(rule.ID)
+	require.NoError(t, err)
+	assert.Equal(t, `term of`, stored.Pattern, "a failing edit is not saved")
+
+	// Test cases sent with the edit replace the stored ones
+	updated, err := s.UpdateComplianceRule(context.Background(), rule.ID, model.ComplianceRule{Name: "Fixed Term", Type: model.RuleTypeRegexMustMatch, Pattern: `agreement`,
+		TestCases: []model.ComplianceRuleTestCase{{Text: "This Agreement continues.", Expect: RuleStatusPass}}}, false)
+	require.NoError(t, err)
+	assert.Len(t, updated.TestCases, 1)
+	stored, err = s.GetComplianceRule(rule.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.TestCases, 1)
+	assert.Equal(t, "This Agreement continues.", stored.TestCases[0].Text)
+}
+
+func TestRuleTestCases_UseTheRequestContext(t *testing.T) {
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local"}, 1)
+	s.llm = NewOpenAICompatibleClient("http://127.0.0.1:1", "test-key")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rule := &model.ComplianceRule{Name: "Fairness", Type: model.RuleTypeLLMJudged,
+		TestCases: []model.ComplianceRuleTestCase{{Name: "fair", Text: "Both parties may terminate.", Expect: RuleStatusPass}}}
+	assert.ErrorIs(t, s.AddComplianceRule(ctx, rule), context.Canceled)
+
+	var count int64
+	require.NoError(t, db.Model(&model.ComplianceRule{}).Where("name = ?", "Fairness").Count(&count).Error)
+	assert.Zero(t, count)
+}
@@ -0,0 +1,562 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	model "github.com/Itish41/LegalEagle/models"
+)
+
+// Outcomes of evaluating a rule
+const (
+	RuleStatusPass = "pass"
+	RuleStatusFail = "fail"
+	// RuleStatusNotApplicable is reported for rules whose scope excludes the document
+	RuleStatusNotApplicable = "not_applicable"
+	// RuleStatusIndeterminate is reported when a rule could not be evaluated, e.g. an
+	// unusable LLM reply or a policy that errors on the document
+	RuleStatusIndeterminate = "indeterminate"
+)
+
+var (
+	// ErrUnknownRuleType is returned for a rule type without a registered evaluator
+	ErrUnknownRuleType = errors.New("unknown rule type")
+	// ErrInvalidRule is returned when a rule's definition cannot be evaluated
+	ErrInvalidRule = errors.New("invalid rule definition")
+)
+
+// TextSpan locates evidence for an outcome as byte offsets into the evaluated text
+type TextSpan struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// RuleOutcome is the verdict of one rule on one document
+type RuleOutcome struct {
+	Status      string     `json:"status"`
+	Explanation string     `json:"explanation"`
+	Spans       []TextSpan `json:"spans,omitempty"`
+	// Engine names what judged the rule: EngineLLM, EngineLocal when the LLM was
+	// unavailable, EngineScope for rules that do not apply, or the rule type for
+	// the other evaluators
+	Engine string `json:"engine,omitempty"`
+	// Model, PromptVersion and SharedUsage describe the LLM requests behind the
+	// outcome, if any. The requests judge every llm_judged rule of a document at
+	// once, so SharedUsage is their whole cost, shared by UsageSharedBy rules;
+	// count it once per document rather than once per rule.
+	Model         string    `json:"model,omitempty"`
+	PromptVersion string    `json:"prompt_version,omitempty"`
+	SharedUsage   *LLMUsage `json:"shared_request_usage,omitempty"`
+	UsageSharedBy int       `json:"rules_sharing_usage,omitempty"`
+	// Latency is how long the evaluation took
+	Latency time.Duration `json:"-"`
+	// Details holds evaluator specific evidence, such as the confidence_score and
+	// non_compliance_details of a custom module, stored alongside the result
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// RuleEvaluationRequest is a batch of rules of one type to check against a document's text
+type RuleEvaluationRequest struct {
+	Text  string
+	Rules []model.ComplianceRule
+	// Title and PageCount describe the document when known
+	Title     string
+	PageCount int
+	// Outcomes holds, by rule name, the outcomes of rules evaluated earlier in the
+	// same run. It is only filled for evaluators implementing RuleOutcomeReader.
+	Outcomes map[string]RuleOutcome
+	// LLM is available to evaluators that need a model to judge the text
+	LLM LLMClient
+}
+
+// RuleEvaluator checks rules of one type. Evaluators receive every rule of
+// their type at once so remote checks can be batched; the outcomes are keyed by
+// rule ID. Problems with a single rule's definition are reported in its outcome,
+// errors are reserved for failures of the whole batch.
+type RuleEvaluator interface {
+	Evaluate(ctx context.Context, req RuleEvaluationRequest) (map[string]RuleOutcome, error)
+}
+
+// RuleDefinitionValidator is implemented by evaluators that can reject a rule
+// definition when it is saved rather than when a document is analyzed
+type RuleDefinitionValidator interface {
+	ValidateRule(rule model.ComplianceRule) error
+}
+
+// RuleOutcomeReader is implemented by evaluators whose rules refer to the
+// outcomes of other rules. They run after all other evaluators.
+type RuleOutcomeReader interface {
+	ReadsRuleOutcomes() bool
+}
+
+// ruleEvaluatorRegistry maps rule types to the evaluators that check them.
+// Each DocumentService has its own, so services configured differently, e.g.
+// with other policy bundles or LLM settings, do not replace each other's.
+type ruleEvaluatorRegistry struct {
+	mu         sync.RWMutex
+	evaluators map[string]RuleEvaluator
+}
+
+// newRuleEvaluatorRegistry returns a registry of the built-in evaluators; Rego
+// and WASM rules stay disabled until evaluators for them are registered
+func newRuleEvaluatorRegistry() *ruleEvaluatorRegistry {
+	return &ruleEvaluatorRegistry{evaluators: map[string]RuleEvaluator{
+		model.RuleTypeRegexMustMatch:    localRuleEvaluator(evaluateRegexMustMatch),
+		model.RuleTypeRegexMustNotMatch: localRuleEvaluator(evaluateRegexMustNotMatch),
+		model.RuleTypeRequiredKeywords:  localRuleEvaluator(evaluateRequiredKeywords),
+		model.RuleTypeRequiredSection:   localRuleEvaluator(evaluateRequiredSection),
+		model.RuleTypeCEL:               newCELEvaluator(),
+		model.RuleTypeRego:              &RegoEvaluator{},
+		model.RuleTypeWASM:              &WASMEvaluator{},
+		model.RuleTypeLLMJudged:         llmJudgedEvaluator{local: NewLocalAnalyzer(0, 0), chunking: NewChunkOptions(0, 0)},
+	}}
+}
+
+// register makes an evaluator available for a rule type, replacing any existing one
+func (r *ruleEvaluatorRegistry) register(ruleType string, evaluator RuleEvaluator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evaluators[ruleType] = evaluator
+}
+
+// lookup returns the evaluator registered for a rule type
+func (r *ruleEvaluatorRegistry) lookup(ruleType string) (RuleEvaluator, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	evaluator, ok := r.evaluators[ruleType]
+	return evaluator, ok
+}
+
+// RegisterRuleEvaluator makes an evaluator available to this service for a
+// rule type, replacing any existing one
+func (s *DocumentService) RegisterRuleEvaluator(ruleType string, evaluator RuleEvaluator) {
+	s.ruleEvaluators().register(ruleType, evaluator)
+}
+
+// ruleEvaluators returns the service's evaluator registry, starting from the
+// built-in evaluators the first time it is needed
+func (s *DocumentService) ruleEvaluators() *ruleEvaluatorRegistry {
+	s.evaluatorsOnce.Do(func() {
+		if s.evaluators == nil {
+			s.evaluators = newRuleEvaluatorRegistry()
+		}
+	})
+	return s.evaluators
+}
+
+// ruleEvaluatorFor returns the evaluator this service uses for a rule type
+func (s *DocumentService) ruleEvaluatorFor(ruleType string) (RuleEvaluator, bool) {
+	return s.ruleEvaluators().lookup(ruleType)
+}
+
+// normalizeRuleType trims a rule type and applies the default
+func normalizeRuleType(ruleType string) string {
+	ruleType = strings.ToLower(strings.TrimSpace(ruleType))
+	if ruleType == "" {
+		return model.RuleTypeLLMJudged
+	}
+	return ruleType
+}
+
+// readsRuleOutcomes reports whether an evaluator must run after the others
+func readsRuleOutcomes(evaluator RuleEvaluator) bool {
+	reader, ok := evaluator.(RuleOutcomeReader)
+	return ok && reader.ReadsRuleOutcomes()
+}
+
+// evaluateRules runs every rule through the evaluator for its type and returns
+// the outcomes keyed by rule ID. Evaluators that read other rules' outcomes run
+// last and see the outcomes of everything evaluated before them.
+func (s *DocumentService) evaluateRules(ctx context.Context, doc RuleEvaluationRequest, rules []model.ComplianceRule) (map[string]RuleOutcome, error) {
+	byType := make(map[string][]model.ComplianceRule)
+	var types, dependentTypes []string
+	for _, rule := range rules {
+		ruleType := normalizeRuleType(rule.Type)
+		if _, seen := byType[ruleType]; !seen {
+			if evaluator, ok := s.ruleEvaluatorFor(ruleType); ok && readsRuleOutcomes(evaluator) {
+				dependentTypes = append(dependentTypes, ruleType)
+			} else {
+				types = append(types, ruleType)
+			}
+		}
+		byType[ruleType] = append(byType[ruleType], rule)
+	}
+
+	outcomes := make(map[string]RuleOutcome, len(rules))
+	byName := make(map[string]RuleOutcome, len(rules))
+	for _, ruleType := range append(types, dependentTypes...) {
+		batch := byType[ruleType]
+		evaluator, ok := s.ruleEvaluatorFor(ruleType)
+		if !ok {
+			for _, rule := range batch {
+				outcomes[rule.ID] = RuleOutcome{Status: RuleStatusIndeterminate, Explanation: fmt.Sprintf("Rule '%s' could not be evaluated: %v %q.", rule.Name, ErrUnknownRuleType, ruleType)}
+				byName[rule.Name] = outcomes[rule.ID]
+			}
+			continue
+		}
+
+		req := doc
+		req.Rules = batch
+		req.LLM = s.llm
+		req.Outcomes = nil
+		if readsRuleOutcomes(evaluator) {
+			req.Outcomes = byName
+		}
+		start := time.Now()
+		results, err := evaluator.Evaluate(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate %s rules: %w", ruleType, err)
+		}
+		elapsed := time.Since(start)
+		for _, rule := range batch {
+			outcome, ok := results[rule.ID]
+			if !ok {
+				outcome = RuleOutcome{Status: RuleStatusIndeterminate, Explanation: fmt.Sprintf("Rule '%s' was not evaluated by the %s evaluator.", rule.Name, ruleType)}
+			}
+			if outcome.Engine == "" {
+				outcome.Engine = ruleType
+			}
+			if outcome.Latency == 0 {
+				outcome.Latency = elapsed
+			}
+			outcomes[rule.ID] = outcome
+			byName[rule.Name] = outcome
+		}
+	}
+	return outcomes, nil
+}
+
+// localRuleEvaluator adapts a per-rule check that needs only the text
+type localRuleEvaluator func(rule model.ComplianceRule, text string) (RuleOutcome, error)
+
+// ValidateRule reports definition errors, which local checks return for any text
+func (f localRuleEvaluator) ValidateRule(rule model.ComplianceRule) error {
+	_, err := f(rule, "")
+	return err
+}
+
+// Evaluate checks each rule in turn; a rule that cannot be checked is indeterminate, with the reason
+func (f localRuleEvaluator) Evaluate(ctx context.Context, req RuleEvaluationRequest) (map[string]RuleOutcome, error) {
+	outcomes := make(map[string]RuleOutcome, len(req.Rules))
+	for _, rule := range req.Rules {
+		start := time.Now()
+		outcome, err := f(rule, req.Text)
+		outcome.Latency = time.Since(start)
+		if err != nil {
+			log.Printf("[RuleEvaluator] Rule %s is misconfigured: %v", rule.Name, err)
+			outcome = RuleOutcome{Status: RuleStatusIndeterminate, Explanation: fmt.Sprintf("Rule '%s' could not be evaluated: %v", rule.Name, err), Latency: outcome.Latency}
+		}
+		outcomes[rule.ID] = outcome
+	}
+	return outcomes, nil
+}
+
+// maxSpans caps the evidence recorded for one outcome
+const maxSpans = 5
+
+// compileRulePattern compiles a rule's Pattern, matching case-insensitively
+func compileRulePattern(rule model.ComplianceRule) (*regexp.Regexp, error) {
+	if strings.TrimSpace(rule.Pattern) == "" {
+		return nil, errors.New("pattern is empty")
+	}
+	re, err := regexp.Compile("(?i)" + rule.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return re, nil
+}
+
+// matchSpans returns up to maxSpans matches of re in text
+func matchSpans(re *regexp.Regexp, text string) []TextSpan {
+	var spans []TextSpan
+	for _, loc := range re.FindAllStringIndex(text, maxSpans) {
+		spans = append(spans, TextSpan{Start: loc[0], End: loc[1], Text: text[loc[0]:loc[1]]})
+	}
+	return spans
+}
+
+// evaluateRegexMustMatch passes when the rule's pattern occurs in the text
+func evaluateRegexMustMatch(rule model.ComplianceRule, text string) (RuleOutcome, error) {
+	re, err := compileRulePattern(rule)
+	if err != nil {
+		return RuleOutcome{}, err
+	}
+	if spans := matchSpans(re, text); len(spans) > 0 {
+		return RuleOutcome{Status: RuleStatusPass, Explanation: fmt.Sprintf("The document contains the required pattern '%s'.", rule.Pattern), Spans: spans}, nil
+	}
+	return RuleOutcome{Status: RuleStatusFail, Explanation: fmt.Sprintf("The document does not contain the required pattern '%s'.", rule.Pattern)}, nil
+}
+
+// evaluateRegexMustNotMatch fails when the rule's pattern occurs in the text
+func evaluateRegexMustNotMatch(rule model.ComplianceRule, text string) (RuleOutcome, error) {
+	re, err := compileRulePattern(rule)
+	if err != nil {
+		return RuleOutcome{}, err
+	}
+	if spans := matchSpans(re, text); len(spans) > 0 {
+		return RuleOutcome{Status: RuleStatusFail, Explanation: fmt.Sprintf("The document contains the prohibited pattern '%s'.", rule.Pattern), Spans: spans}, nil
+	}
+	return RuleOutcome{Status: RuleStatusPass, Explanation: fmt.Sprintf("The document does not contain the prohibited pattern '%s'.", rule.Pattern)}, nil
+}
+
+// keywordParams configures a required_keywords rule. Without keywords, Pattern
+// is read as a comma-separated list; MinMatches defaults to all keywords.
+type keywordParams struct {
+	Keywords   []string `json:"keywords"`
+	MinMatches int      `json:"min_matches"`
+}
+
+// parseKeywordParams reads and checks a required_keywords rule's settings
+func parseKeywordParams(rule model.ComplianceRule) (keywordParams, error) {
+	var params keywordParams
+	if len(rule.Params) > 0 {
+		if err := json.Unmarshal(rule.Params, &params); err != nil {
+			return params, fmt.Errorf("invalid params: %w", err)
+		}
+	}
+	if len(params.Keywords) == 0 {
+		params.Keywords = strings.Split(rule.Pattern, ",")
+	}
+
+	keywords := params.Keywords[:0]
+	for _, keyword := range params.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	params.Keywords = keywords
+	if len(params.Keywords) == 0 {
+		return params, errors.New("no keywords configured")
+	}
+
+	if params.MinMatches == 0 {
+		params.MinMatches = len(params.Keywords)
+	}
+	if params.MinMatches < 0 || params.MinMatches > len(params.Keywords) {
+		return params, fmt.Errorf("min_matches must be between 1 and %d", len(params.Keywords))
+	}
+	return params, nil
+}
+
+// evaluateRequiredKeywords passes when at least MinMatches of the keywords appear as whole words
+func evaluateRequiredKeywords(rule model.ComplianceRule, text string) (RuleOutcome, error) {
+	params, err := parseKeywordParams(rule)
+	if err != nil {
+		return RuleOutcome{}, err
+	}
+
+	var found, missing []string
+	var spans []TextSpan
+	for _, keyword := range params.Keywords {
+		re := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(keyword) + `\b`)
+		if loc := re.FindStringIndex(text); loc != nil {
+			found = append(found, keyword)
+			spans = append(spans, TextSpan{Start: loc[0], End: loc[1], Text: text[loc[0]:loc[1]]})
+		} else {
+			missing = append(missing, keyword)
+		}
+	}
+
+	if len(found) >= params.MinMatches {
+		return RuleOutcome{Status: RuleStatusPass, Explanation: fmt.Sprintf("The document mentions %d of %d required keywords: %s.", len(found), len(params.Keywords), strings.Join(found, ", ")), Spans: spans}, nil
+	}
+	return RuleOutcome{
+		Status:      RuleStatusFail,
+		Explanation: fmt.Sprintf("The document mentions %d of the %d required keywords; missing: %s.", len(found), params.MinMatches, strings.Join(missing, ", ")),
+		Spans:       spans,
+	}, nil
+}
+
+// sectionParams configures a required_section rule whose Pattern matches the section heading
+type sectionParams struct {
+	MinWords int `json:"min_words"`
+}
+
+// maxHeadingWords bounds how long a line can be and still count as a heading
+const maxHeadingWords = 12
+
+// sectionNumbering matches the numbering that may precede a heading, e.g. "4.", "IV)", "Section 2:"
+var sectionNumbering = regexp.MustCompile(`(?i)^(?:(?:section|article|clause)\s+)?(?:(?:\d+(?:\.\d+)*[.):]?|(?:[ivxlc]+|[a-z])[.):])\s+)?`)
+
+// evaluateRequiredSection passes when a heading matching Pattern is followed by
+// at least MinWords words of content
+func evaluateRequiredSection(rule model.ComplianceRule, text string) (RuleOutcome, error) {
+	params := sectionParams{MinWords: 1}
+	if len(rule.Params) > 0 {
+		if err := json.Unmarshal(rule.Params, &params); err != nil {
+			return RuleOutcome{}, fmt.Errorf("invalid params: %w", err)
+		}
+	}
+	if strings.TrimSpace(rule.Pattern) == "" {
+		return RuleOutcome{}, errors.New("pattern is empty")
+	}
+	heading, err := regexp.Compile(`(?i)^(?:` + rule.Pattern + `)\s*:?$`)
+	if err != nil {
+		return RuleOutcome{}, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	offset := 0
+	var emptySection *TextSpan
+	for i, line := range lines {
+		start := offset
+		offset += len(line)
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || len(strings.Fields(trimmed)) > maxHeadingWords {
+			continue
+		}
+		title := strings.TrimSpace(sectionNumbering.ReplaceAllString(trimmed, ""))
+		if !heading.MatchString(title) && !heading.MatchString(trimmed) {
+			continue
+		}
+
+		headingStart := start + strings.Index(line, trimmed)
+		span := TextSpan{Start: headingStart, End: headingStart + len(trimmed), Text: trimmed}
+		if words := sectionWordCount(lines[i+1:]); words >= params.MinWords {
+			return RuleOutcome{Status: RuleStatusPass, Explanation: fmt.Sprintf("The document has a '%s' section with %d words.", trimmed, words), Spans: []TextSpan{span}}, nil
+		}
+		if emptySection == nil {
+			emptySection = &span
+		}
+	}
+
+	if emptySection != nil {
+		return RuleOutcome{
+			Status:      RuleStatusFail,
+			Explanation: fmt.Sprintf("The '%s' section has fewer than %d words of content.", emptySection.Text, params.MinWords),
+			Spans:       []TextSpan{*emptySection},
+		}, nil
+	}
+	return RuleOutcome{Status: RuleStatusFail, Explanation: fmt.Sprintf("The document has no section headed '%s'.", rule.Pattern)}, nil
+}
+
+// sectionWordCount counts the words of the paragraph following a heading,
+// skipping blank lines directly after it and stopping at the next heading
+func sectionWordCount(lines []string) int {
+	words := 0
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			if words > 0 {
+				break
+			}
+			continue
+		}
+		if looksLikeHeading(line) {
+			break
+		}
+		words += len(fields)
+	}
+	return words
+}
+
+// looksLikeHeading reports whether a line is a short numbered or all-caps title
+func looksLikeHeading(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || len(strings.Fields(trimmed)) > maxHeadingWords {
+		return false
+	}
+	if sectionNumbering.FindString(trimmed) != "" {
+		return true
+	}
+	return strings.ToUpper(trimmed) == trimmed && strings.ToLower(trimmed) != trimmed
+}
+
+// llmJudgedEvaluator asks the LLM which of the rules the document violates, one
+// request per chunk of the document, and judges them locally when the LLM
+// cannot be used
+type llmJudgedEvaluator struct {
+	local    LocalAnalyzer
+	chunking ChunkOptions
+}
+
+// ValidateRule checks the pattern and params used when the LLM is unavailable
+func (llmJudgedEvaluator) ValidateRule(rule model.ComplianceRule) error {
+	if _, err := parseLocalAnalyzerParams(rule); err != nil {
+		return err
+	}
+	if strings.TrimSpace(rule.Pattern) == "" {
+		return nil
+	}
+	_, err := compileRulePattern(rule)
+	return err
+}
+
+// Evaluate sends all rules to the LLM with each chunk of the document, falling
+// back to the local analyzer when the LLM cannot be used. Rules the LLM's
+// replies leave undecided are indeterminate.
+func (e llmJudgedEvaluator) Evaluate(ctx context.Context, req RuleEvaluationRequest) (map[string]RuleOutcome, error) {
+	start := time.Now()
+	chunks := splitIntoChunks(req.Text, e.chunking.MaxChars)
+	if len(chunks) > 1 {
+		log.Printf("[llmJudgedEvaluator] Judging %d rules in %d parts", len(req.Rules), len(chunks))
+	}
+	detection, err := detectViolatedRulesInChunks(ctx, req.LLM, groqRateLimiter, chunks, req.Rules, e.chunking.Concurrency)
+	if err != nil {
+		// A cancelled analysis is resumed later rather than judged locally
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("[llmJudgedEvaluator] Falling back to local checks: %v", err)
+		return e.local.AnalyzeAll(req.Text, req.Rules, localReasonLLMUnavailable), nil
+	}
+	latency := time.Since(start)
+
+	outcomes := make(map[string]RuleOutcome, len(req.Rules))
+	for _, rule := range req.Rules {
+		outcome := RuleOutcome{
+			Status:        RuleStatusPass,
+			Explanation:   fmt.Sprintf("The document complies with the '%s' rule.", rule.Name),
+			Engine:        EngineLLM,
+			Model:         detection.Model,
+			PromptVersion: PromptVersionRuleDetection,
+			SharedUsage:   &detection.Usage,
+			UsageSharedBy: len(req.Rules),
+			Latency:       latency,
+		}
+		switch {
+		case contains(detection.Violated, rule.Name):
+			outcome.Status = RuleStatusFail
+			outcome.Explanation = fmt.Sprintf("The document violates the '%s' rule, which requires: %s.", rule.Name, strings.TrimSuffix(missingClause(rule), "."))
+		case detection.Invalid != nil:
+			// A part the LLM could not judge may hold the violation
+			outcome.Status = RuleStatusIndeterminate
+			outcome.Explanation = fmt.Sprintf("The '%s' rule could not be judged: %v.", rule.Name, detection.Invalid)
+		}
+		// Point at the passages the LLM quoted. Pattern matches support a pass,
+		// but say nothing about why a rule failed.
+		spans := detection.Spans[rule.Name]
+		if outcome.Status == RuleStatusPass {
+			if re, err := compileRulePattern(rule); err == nil {
+				spans = append(spans, matchSpans(re, req.Text)...)
+			}
+		}
+		outcome.Spans = uniqueSpans(spans)
+		outcomes[rule.ID] = outcome
+	}
+	return outcomes, nil
+}
+
+// uniqueSpans drops repeated spans, keeping at most maxSpans
+func uniqueSpans(spans []TextSpan) []TextSpan {
+	var unique []TextSpan
+	for _, span := range spans {
+		if len(unique) == maxSpans {
+			break
+		}
+		if !slices.ContainsFunc(unique, func(seen TextSpan) bool { return seen.Start == span.Start && seen.End == span.End }) {
+			unique = append(unique, span)
+		}
+	}
+	return unique
+}
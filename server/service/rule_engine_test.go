@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+const sampleContract = `MUTUAL NON-DISCLOSURE AGREEMENT
+
+1. Confidentiality
+Each party shall keep the other party's information confidential.
+
+2. Governing Law
+
+3. Termination
+Either party may terminate this agreement with thirty days written notice.
+
+Signed by both parties on 1 March 2024.`
+
+func TestRuleEvaluators(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       model.ComplianceRule
+		wantStatus string
+		wantSpan   string
+		wantInText string
+	}{
+		{
+			name:       "regex must match passes",
+			rule:       model.ComplianceRule{Type: model.RuleTypeRegexMustMatch, Pattern: `signed by .* on`},
+			wantStatus: RuleStatusPass,
+			wantSpan:   "Signed by both parties on",
+		},
+		{
+			name:       "regex must match fails",
+			rule:       model.ComplianceRule{Type: model.RuleTypeRegexMustMatch, Pattern: `indemnif`},
+			wantStatus: RuleStatusFail,
+		},
+		{
+			name:       "regex must not match fails with evidence",
+			rule:       model.ComplianceRule{Type: model.RuleTypeRegexMustNotMatch, Pattern: `thirty days`},
+			wantStatus: RuleStatusFail,
+			wantSpan:   "thirty days",
+		},
+		{
+			name:       "invalid pattern is indeterminate with the reason",
+			rule:       model.ComplianceRule{Name: "Broken", Type: model.RuleTypeRegexMustMatch, Pattern: `(`},
+			wantStatus: RuleStatusIndeterminate,
+			wantInText: "invalid pattern",
+		},
+		{
+			name:       "keywords from pattern must all appear",
+			rule:       model.ComplianceRule{Type: model.RuleTypeRequiredKeywords, Pattern: "confidential, terminate, arbitration"},
+			wantStatus: RuleStatusFail,
+			wantInText: "missing: arbitration",
+		},
+		{
+			name: "keywords meet the threshold",
+			rule: model.ComplianceRule{
+				Type:   model.RuleTypeRequiredKeywords,
+				Params: datatypes.JSON(`{"keywords": ["confidential", "terminate", "arbitration"], "min_matches": 2}`),
+			},
+			wantStatus: RuleStatusPass,
+			wantSpan:   "confidential",
+		},
+		{
+			name:       "keywords match whole words only",
+			rule:       model.ComplianceRule{Type: model.RuleTypeRequiredKeywords, Pattern: "term"},
+			wantStatus: RuleStatusFail,
+		},
+		{
+			name:       "threshold above keyword count",
+			rule:       model.ComplianceRule{Type: model.RuleTypeRequiredKeywords, Pattern: "notice", Params: datatypes.JSON(`{"min_matches": 3}`)},
+			wantStatus: RuleStatusIndeterminate,
+			wantInText: "min_matches must be between 1 and 1",
+		},
+		{
+			name:       "numbered section with content",
+			rule:       model.ComplianceRule{Type: model.RuleTypeRequiredSection, Pattern: "termination"},
+			wantStatus: RuleStatusPass,
+			wantSpan:   "3. Termination",
+		},
+		{
+			name:       "section without content",
+			rule:       model.ComplianceRule{Type: model.RuleTypeRequiredSection, Pattern: "governing law"},
+			wantStatus: RuleStatusFail,
+			wantSpan:   "2. Governing Law",
+		},
+		{
+			name:       "section with too few words",
+			rule:       model.ComplianceRule{Type: model.RuleTypeRequiredSection, Pattern: "confidentiality", Params: datatypes.JSON(`{"min_words": 20}`)},
+			wantStatus: RuleStatusFail,
+			wantInText: "fewer than 20 words",
+		},
+		{
+			name:       "missing section",
+			rule:       model.ComplianceRule{Type: model.RuleTypeRequiredSection, Pattern: "payment terms"},
+			wantStatus: RuleStatusFail,
+			wantInText: "no section headed",
+		},
+		{
+			name:       "mention in a sentence is not a heading",
+			rule:       model.ComplianceRule{Type: model.RuleTypeRequiredSection, Pattern: "agreement"},
+			wantStatus: RuleStatusFail,
+		},
+	}
+
+	s := &DocumentService{llm: NewFakeLLMClient()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.ID = "rule"
+			outcomes, err := s.evaluateRules(context.Background(), RuleEvaluationRequest{Text: sampleContract}, []model.ComplianceRule{tt.rule})
+			require.NoError(t, err)
+			outcome := outcomes["rule"]
+
+			assert.Equal(t, tt.wantStatus, outcome.Status, outcome.Explanation)
+			if tt.wantInText != "" {
+				assert.Contains(t, outcome.Explanation, tt.wantInText)
+			}
+			if tt.wantSpan != "" {
+				require.NotEmpty(t, outcome.Spans)
+				span := outcome.Spans[0]
+				assert.Equal(t, tt.wantSpan, span.Text)
+				assert.Equal(t, tt.wantSpan, sampleContract[span.Start:span.End])
+			}
+		})
+	}
+}
+
+func TestEvaluateRules_LLMJudgesOnlyItsRules(t *testing.T) {
+	llm := NewFakeLLMClient()
+	llm.Responses[LLMCallSiteRuleDetection] = `{"violated_rules": ["Data Protection", "Termination Notice"]}`
+	s := &DocumentService{llm: llm}
+
+	rules := []model.ComplianceRule{
+		{ID: "llm-1", Name: "Data Protection"},
+		{ID: "llm-2", Name: "Signature Check", Type: model.RuleTypeLLMJudged},
+		{ID: "local-1", Name: "Termination Notice", Type: model.RuleTypeRequiredSection, Pattern: "termination"},
+		{ID: "unknown", Name: "Custom", Type: "cobol"},
+	}
+	outcomes, err := s.evaluateRules(context.Background(), RuleEvaluationRequest{Text: sampleContract}, rules)
+	require.NoError(t, err)
+
+	assert.Equal(t, RuleStatusFail, outcomes["llm-1"].Status)
+	assert.Equal(t, RuleStatusPass, outcomes["llm-2"].Status)
+	assert.Equal(t, RuleStatusPass, outcomes["local-1"].Status, "the LLM's verdict does not override local rules")
+	assert.Equal(t, RuleStatusIndeterminate, outcomes["unknown"].Status)
+	assert.Contains(t, outcomes["unknown"].Explanation, "unknown rule type")
+
+	require.Len(t, llm.Requests, 1, "LLM-judged rules are batched into one request")
+	prompt := llm.Requests[0].Messages[0].Content
+	assert.Contains(t, prompt, "Data Protection")
+	assert.NotContains(t, prompt, "Termination Notice")
+}
+
+func TestRegisterRuleEvaluator_IsPerService(t *testing.T) {
+	first := &DocumentService{llm: NewFakeLLMClient()}
+	second := &DocumentService{llm: NewFakeLLMClient()}
+	first.RegisterRuleEvaluator(model.RuleTypeLLMJudged, llmJudgedEvaluator{local: NewLocalAnalyzer(1, 3), chunking: NewChunkOptions(0, 0)})
+
+	assert.Equal(t, 3, first.localAnalyzer().MinPatternMatches)
+	assert.Equal(t, NewLocalAnalyzer(0, 0), second.localAnalyzer(), "other services keep their own evaluators")
+}
+
+func TestEvaluateRules_LLMFallback(t *testing.T) {
+	llm := NewFakeLLMClient()
+	llm.Handler = func(req LLMRequest) (string, error) { return "", errors.New("provider down") }
+	s := &DocumentService{llm: llm}
+
+	outcomes, err := s.evaluateRules(context.Background(), RuleEvaluationRequest{Text: sampleContract}, []model.ComplianceRule{
+		{ID: "with-pattern", Name: "Signature", Pattern: `signed by`},
+		{ID: "without-pattern", Name: "Fairness"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, RuleStatusPass, outcomes["with-pattern"].Status)
+	assert.Equal(t, RuleStatusIndeterminate, outcomes["without-pattern"].Status)
+	assert.Contains(t, outcomes["without-pattern"].Explanation, "LLM is unavailable")
+}
+
+func TestEvaluateRules_LLMJudgedSpans(t *testing.T) {
+	llm := NewFakeLLMClient()
+	llm.Handler = func(req LLMRequest) (string, error) {
+		return `{"violated_rules": ["Confidentiality"], "evidence": {"Confidentiality": "may terminate this agreement"}}`, nil
+	}
+	s := &DocumentService{llm: llm}
+
+	outcomes, err := s.evaluateRules(context.Background(), RuleEvaluationRequest{Text: sampleContract}, []model.ComplianceRule{
+		{ID: "failed", Name: "Confidentiality", Pattern: `confidential`},
+		{ID: "passed", Name: "Signature", Pattern: `signed by`},
+	})
+	require.NoError(t, err)
+	failed := outcomes["failed"]
+	assert.Equal(t, RuleStatusFail, failed.Status)
+	require.Len(t, failed.Spans, 1, "pattern matches are not evidence of a failure")
+	assert.Equal(t, "may terminate this agreement", failed.Spans[0].Text)
+	passed := outcomes["passed"]
+	assert.Equal(t, RuleStatusPass, passed.Status)
+	require.Len(t, passed.Spans, 1)
+	assert.Equal(t, "Signed by", passed.Spans[0].Text)
+}
+
+func TestAnalyzeCompliance_RecordsRuleTypeAndSpans(t *testing.T) {
+	s, _, _ := newPipelineService(t, &stubOCRProvider{name: "local"}, 1)
+	require.NoError(t, s.AddComplianceRule(context.Background(), &model.ComplianceRule{Name: "Termination Clause", Type: "Required_Section", Pattern: "termination", Severity: "Medium"}))
+	assert.ErrorIs(t, s.AddComplianceRule(context.Background(), &model.ComplianceRule{Name: "Custom", Type: "cobol"}), ErrUnknownRuleType)
+
+	parsed, _, err := s.analyzeCompliance(context.Background(), &model.Document{OcrText: sampleContract})
+	require.NoError(t, err)
+
+	var results []map[string]interface{}
+	require.NoError(t, json.Unmarshal(parsed, &results))
+	require.Len(t, results, 3)
+	byName := map[string]map[string]interface{}{}
+	for _, result := range results {
+		byName[result["rule_name"].(string)] = result
+	}
+
+	assert.Equal(t, model.RuleTypeLLMJudged, byName["NDA Check"]["rule_type"])
+	assert.Equal(t, RuleStatusFail, byName["NDA Check"]["status"])
+	assert.Equal(t, model.RuleTypeRequiredSection, byName["Termination Clause"]["rule_type"])
+	assert.Equal(t, RuleStatusPass, byName["Termination Clause"]["status"])
+	assert.NotEmpty(t, byName["Termination Clause"]["spans"])
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	model "github.com/Itish41/LegalEagle/models"
+)
+
+// Levels of a rule issue
+const (
+	RuleIssueError   = "error"
+	RuleIssueWarning = "warning"
+)
+
+// Rule severities, from least to most important
+const (
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// broadPatternProbes are unrelated texts a specific pattern should not match all of
+var broadPatternProbes = []string{
+	"The quick brown fox jumps over the lazy dog.",
+	"Lunch is served at noon in room 4.",
+	"12345",
+}
+
+// commonKeywords appear in nearly every contract and say nothing on their own
+var commonKeywords = map[string]bool{
+	"a": true, "an": true, "and": true, "or": true, "the": true, "of": true, "to": true, "in": true,
+	"shall": true, "agreement": true, "party": true, "parties": true, "contract": true,
+}
+
+// RuleValidationError lists the problems that prevent a rule from being saved
+type RuleValidationError struct {
+	Issues []model.RuleIssue
+}
+
+// Error joins the issue messages
+func (e *RuleValidationError) Error() string {
+	messages := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		messages[i] = issue.Message
+	}
+	return fmt.Sprintf("%v: %s", ErrInvalidRule, strings.Join(messages, "; "))
+}
+
+// Unwrap makes the error match ErrInvalidRule, and ErrUnknownRuleType when the type is unknown
+func (e *RuleValidationError) Unwrap() []error {
+	errs := []error{ErrInvalidRule}
+	for _, issue := range e.Issues {
+		if issue.Code == "unknown_type" {
+			errs = append(errs, ErrUnknownRuleType)
+		}
+	}
+	return errs
+}
+
+// RuleLintResult lists the issues found in one stored rule
+type RuleLintResult struct {
+	RuleID   string            `json:"rule_id"`
+	RuleName string            `json:"rule_name"`
+	Issues   []model.RuleIssue `json:"issues"`
+}
+
+// RuleLintReport is the result of auditing every active rule
+type RuleLintReport struct {
+	Checked  int              `json:"checked"`
+	Errors   int              `json:"errors"`
+	Warnings int              `json:"warnings"`
+	Rules    []RuleLintResult `json:"rules"`
+}
+
+// normalizeSeverity trims and lowercases a severity, defaulting to medium
+func normalizeSeverity(severity string) string {
+	severity = strings.ToLower(strings.TrimSpace(severity))
+	if severity == "" {
+		return SeverityMedium
+	}
+	return severity
+}
+
+// checkRuleDefinition rejects rules with error level issues and returns the warnings
+func (s *DocumentService) checkRuleDefinition(rule model.ComplianceRule) ([]model.RuleIssue, error) {
+	var errs, warnings []model.RuleIssue
+	for _, issue := range s.lintRule(rule) {
+		if issue.Level == RuleIssueError {
+			errs = append(errs, issue)
+		} else {
+			warnings = append(warnings, issue)
+		}
+	}
+	if len(errs) > 0 {
+		return warnings, &RuleValidationError{Issues: errs}
+	}
+	return warnings, nil
+}
+
+// lintRule checks a rule's severity, type and definition, and warns about
+// patterns that match almost any document
+func (s *DocumentService) lintRule(rule model.ComplianceRule) []model.RuleIssue {
+	var issues []model.RuleIssue
+	add := func(field, code, level, format string, args ...interface{}) {
+		issues = append(issues, model.RuleIssue{Field: field, Code: code, Level: level, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch normalizeSeverity(rule.Severity) {
+	case SeverityLow, SeverityMedium, SeverityHigh:
+	default:
+		add("severity", "invalid_severity", RuleIssueError, "severity %q must be %s, %s or %s", rule.Severity, SeverityLow, SeverityMedium, SeverityHigh)
+	}
+
+	if _, err := parseRuleScope(rule.Scope); err != nil {
+		add("scope", "invalid_scope", RuleIssueError, "%v", err)
+	}
+
+	ruleType := normalizeRuleType(rule.Type)
+	evaluator, ok := s.ruleEvaluatorFor(ruleType)
+	if !ok {
+		add("type", "unknown_type", RuleIssueError, "%v %q", ErrUnknownRuleType, ruleType)
+		return issues
+	}
+	if validator, ok := evaluator.(RuleDefinitionValidator); ok {
+		if err := validator.ValidateRule(rule); err != nil {
+			add("pattern", "invalid_definition", RuleIssueError, "%v", err)
+			return issues
+		}
+	}
+
+	switch ruleType {
+	case model.RuleTypeRegexMustMatch, model.RuleTypeRegexMustNotMatch, model.RuleTypeRequiredSection, model.RuleTypeLLMJudged:
+		if strings.TrimSpace(rule.Pattern) == "" {
+			break
+		}
+		re, err := compileRulePattern(rule)
+		if err != nil {
+			break
+		}
+		if re.MatchString("") {
+			add("pattern", "matches_empty", RuleIssueWarning, "pattern %q matches empty text, so it matches every document", rule.Pattern)
+		} else if matchesAll(re, broadPatternProbes) {
+			add("pattern", "too_broad", RuleIssueWarning, "pattern %q matches unrelated text such as %q, so it matches almost any document", rule.Pattern, broadPatternProbes[0])
+		} else if literal, ok := literalPattern(rule.Pattern); ok && len([]rune(literal)) < 3 {
+			add("pattern", "too_short", RuleIssueWarning, "pattern %q is shorter than 3 characters and will match inside unrelated words", rule.Pattern)
+		}
+	case model.RuleTypeRequiredKeywords:
+		params, err := parseKeywordParams(rule)
+		if err != nil {
+			break
+		}
+		for _, keyword := range params.Keywords {
+			if commonKeywords[strings.ToLower(keyword)] || len([]rune(keyword)) < 3 {
+				add("params.keywords", "common_keyword", RuleIssueWarning, "keyword %q appears in almost every contract", keyword)
+			}
+		}
+	}
+	return issues
+}
+
+// matchesAll reports whether re matches every text
+func matchesAll(re *regexp.Regexp, texts []string) bool {
+	for _, text := range texts {
+		if !re.MatchString(text) {
+			return false
+		}
+	}
+	return true
+}
+
+// literalPattern returns the text a pattern matches when it has no regex operators
+func literalPattern(pattern string) (string, bool) {
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil || parsed.Op != syntax.OpLiteral {
+		return "", false
+	}
+	return string(parsed.Rune), true
+}
+
+// LintComplianceRules audits every active rule the way rules are checked when saved
+func (s *DocumentService) LintComplianceRules() (*RuleLintReport, error) {
+	var rules []model.ComplianceRule
+	if err := s.db.Order("name").Find(&rules).Error; err != nil {
+		log.Printf("[LintComplianceRules] Error fetching rules: %v", err)
+		return nil, fmt.Errorf("failed to fetch rules: %w", err)
+	}
+
+	report := &RuleLintReport{Checked: len(rules), Rules: []RuleLintResult{}}
+	for _, rule := range rules {
+		issues := s.lintRule(rule)
+		if strings.TrimSpace(rule.Name) == "" {
+			issues = append(issues, model.RuleIssue{Field: "name", Code: "required", Level: RuleIssueError, Message: ErrRuleNameRequired.Error()})
+		}
+		if len(issues) == 0 {
+			continue
+		}
+		for _, issue := range issues {
+			if issue.Level == RuleIssueError {
+				report.Errors++
+			} else {
+				report.Warnings++
+			}
+		}
+		report.Rules = append(report.Rules, RuleLintResult{RuleID: rule.ID, RuleName: rule.Name, Issues: issues})
+	}
+	log.Printf("[LintComplianceRules] Checked %d rules: %d errors, %d warnings", report.Checked, report.Errors, report.Warnings)
+	return report, nil
+}
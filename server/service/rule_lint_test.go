@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+func TestLintRule(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      model.ComplianceRule
+		wantCodes []string
+	}{
+		{name: "specific pattern", rule: model.ComplianceRule{Type: model.RuleTypeRegexMustMatch, Pattern: `governed by the laws of`, Severity: "High"}},
+		{name: "llm judged without pattern", rule: model.ComplianceRule{Description: "Has an NDA"}},
+		{name: "invalid regex", rule: model.ComplianceRule{Type: model.RuleTypeRegexMustNotMatch, Pattern: `non-compete (`}, wantCodes: []string{"invalid_definition"}},
+		{name: "invalid regex on llm judged rule", rule: model.ComplianceRule{Pattern: `*NDA*`}, wantCodes: []string{"invalid_definition"}},
+		{name: "invalid severity", rule: model.ComplianceRule{Pattern: `NDA`, Severity: "critical"}, wantCodes: []string{"invalid_severity"}},
+		{name: "unknown type", rule: model.ComplianceRule{Type: "cobol"}, wantCodes: []string{"unknown_type"}},
+		{name: "matches empty text", rule: model.ComplianceRule{Type: model.RuleTypeRegexMustMatch, Pattern: `(signature)?`}, wantCodes: []string{"matches_empty"}},
+		{name: "matches anything", rule: model.ComplianceRule{Type: model.RuleTypeRegexMustNotMatch, Pattern: `\w+`}, wantCodes: []string{"too_broad"}},
+		{name: "short literal", rule: model.ComplianceRule{Type: model.RuleTypeRegexMustMatch, Pattern: `is`}, wantCodes: []string{"too_short"}},
+		{
+			name:      "common keywords",
+			rule:      model.ComplianceRule{Type: model.RuleTypeRequiredKeywords, Params: datatypes.JSON(`{"keywords": ["indemnify", "the", "Agreement"]}`)},
+			wantCodes: []string{"common_keyword", "common_keyword"},
+		},
+		{name: "no keywords", rule: model.ComplianceRule{Type: model.RuleTypeRequiredKeywords}, wantCodes: []string{"invalid_definition"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var codes []string
+			for _, issue := range (&DocumentService{}).lintRule(tt.rule) {
+				codes = append(codes, issue.Code)
+				assert.NotEmpty(t, issue.Message)
+			}
+			assert.Equal(t, tt.wantCodes, codes)
+		})
+	}
+}
+
+func TestAddComplianceRule_ValidatesDefinition(t *testing.T) {
+	s, _, _ := newPipelineService(t, &stubOCRProvider{name: "local"}, 1)
+
+	err := s.AddComplianceRule(context.Background(), &model.ComplianceRule{Name: "Non-compete", Type: model.RuleTypeRegexMustNotMatch, Pattern: `non-compete (`, Severity: "urgent"})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+	var invalid *RuleValidationError
+	require.True(t, errors.As(err, &invalid))
+	require.Len(t, invalid.Issues, 2)
+	assert.Equal(t, "severity", invalid.Issues[0].Field)
+	assert.Equal(t, "pattern", invalid.Issues[1].Field)
+	assert.Contains(t, invalid.Issues[1].Message, "missing closing )")
+
+	rule := &model.ComplianceRule{Name: "Non-compete", Type: model.RuleTypeRegexMustNotMatch, Pattern: `.*`, Severity: " High "}
+	require.NoError(t, s.AddComplianceRule(context.Background(), rule), "warnings do not prevent saving")
+	assert.Equal(t, SeverityHigh, rule.Severity)
+	require.Len(t, rule.Warnings, 1)
+	assert.Equal(t, "matches_empty", rule.Warnings[0].Code)
+
+	updated, err := s.UpdateComplianceRule(context.Background(), rule.ID, model.ComplianceRule{Name: "Non-compete", Type: model.RuleTypeRegexMustNotMatch, Pattern: `non-compete`}, false)
+	require.NoError(t, err)
+	assert.Empty(t, updated.Warnings)
+	assert.Equal(t, SeverityMedium, updated.Severity, "severity defaults to medium")
+
+	_, err = s.UpdateComplianceRule(context.Background(), rule.ID, model.ComplianceRule{Name: "Non-compete", Type: model.RuleTypeRegexMustNotMatch, Pattern: `[a-`}, false)
+	assert.ErrorIs(t, err, ErrInvalidRule)
+}
+
+func TestLintComplianceRules(t *testing.T) {
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local"}, 1)
+	// Rules saved before validation existed can hold anything
+	require.NoError(t, db.Exec(`INSERT INTO compliance_rules (id, name, type, pattern, severity) VALUES
+		('legacy-1', 'Legacy Regex', 'regex_must_match', '(unclosed', 'severe'),
+		('legacy-2', 'Legacy Broad', 'regex_must_not_match', '.+', 'low')`).Error)
+
+	report, err := s.LintComplianceRules()
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Checked)
+	assert.Equal(t, 2, report.Errors)
+	assert.Equal(t, 1, report.Warnings)
+	require.Len(t, report.Rules, 2)
+	assert.Equal(t, "Legacy Broad", report.Rules[0].RuleName)
+	assert.Equal(t, "too_broad", report.Rules[0].Issues[0].Code)
+	assert.Equal(t, "legacy-1", report.Rules[1].RuleID)
+	assert.Len(t, report.Rules[1].Issues, 2)
+}
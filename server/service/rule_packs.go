@@ -0,0 +1,479 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"gopkg.in/yaml.v3"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// RulePackSchemaVersion is the version of the rule pack format written by ExportRulePack
+const RulePackSchemaVersion = 1
+
+// Ways ImportRulePack handles a rule whose name already exists
+const (
+	// RuleImportMerge keeps the existing rule and reports the conflict
+	RuleImportMerge = "merge"
+	// RuleImportReplace overwrites the existing rule with the pack's definition
+	RuleImportReplace = "replace"
+)
+
+// Formats a rule pack can be encoded in
+const (
+	RulePackFormatYAML = "yaml"
+	RulePackFormatJSON = "json"
+)
+
+var (
+	// ErrInvalidRulePack is returned for packs that cannot be read or imported as a whole
+	ErrInvalidRulePack = errors.New("invalid rule pack")
+	// ErrRulePackRejected is returned when some rules of a pack are invalid; nothing is imported
+	ErrRulePackRejected = errors.New("rule pack contains invalid rules")
+	// ErrRulePackNotFound is returned for an unknown starter pack
+	ErrRulePackNotFound = errors.New("rule pack not found")
+
+	// errRuleImportDryRun rolls back a dry run's transaction
+	errRuleImportDryRun = errors.New("dry run")
+)
+
+// starterPacks are the rule packs shipped with the server
+//
+//go:embed rulepacks/*.yaml
+var starterPacks embed.FS
+
+// RulePack is a portable set of compliance rules
+type RulePack struct {
+	SchemaVersion int            `json:"schema_version" yaml:"schema_version"`
+	Name          string         `json:"name,omitempty" yaml:"name,omitempty"`
+	Description   string         `json:"description,omitempty" yaml:"description,omitempty"`
+	Rules         []RulePackRule `json:"rules" yaml:"rules"`
+}
+
+// RulePackRule is one rule's definition in a pack
+type RulePackRule struct {
+	Name        string                 `json:"name" yaml:"name"`
+	Description string                 `json:"description,omitempty" yaml:"description,omitempty"`
+	Type        string                 `json:"type" yaml:"type"`
+	Pattern     string                 `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Params      map[string]interface{} `json:"params,omitempty" yaml:"params,omitempty"`
+	Severity    string                 `json:"severity" yaml:"severity"`
+	Scope       *RuleScope             `json:"scope,omitempty" yaml:"scope,omitempty"`
+	TestCases   []RulePackTestCase     `json:"test_cases,omitempty" yaml:"test_cases,omitempty"`
+}
+
+// RulePackTestCase is a stored test case in a pack
+type RulePackTestCase struct {
+	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
+	Text   string `json:"text" yaml:"text"`
+	Expect string `json:"expect" yaml:"expect"`
+}
+
+// RulePackSummary describes a starter pack
+type RulePackSummary struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Rules       int    `json:"rules"`
+}
+
+// RuleImportOptions controls ImportRulePack
+type RuleImportOptions struct {
+	Mode   string
+	DryRun bool
+}
+
+// RuleImportConflict is a pack rule whose name matches an existing rule with a different definition
+type RuleImportConflict struct {
+	Name       string            `json:"name"`
+	RuleID     string            `json:"rule_id"`
+	Changes    []RuleFieldChange `json:"changes"`
+	Resolution string            `json:"resolution"`
+}
+
+// RuleImportProblem is a pack rule that cannot be saved
+type RuleImportProblem struct {
+	Name            string               `json:"name"`
+	Error           string               `json:"error"`
+	Issues          []model.RuleIssue    `json:"issues,omitempty"`
+	FailedTestCases []RuleTestCaseResult `json:"failed_test_cases,omitempty"`
+}
+
+// RuleImportReport describes what an import did, or would do in a dry run
+type RuleImportReport struct {
+	Pack      string               `json:"pack,omitempty"`
+	Mode      string               `json:"mode"`
+	DryRun    bool                 `json:"dry_run"`
+	Created   []string             `json:"created"`
+	Replaced  []string             `json:"replaced"`
+	Unchanged []string             `json:"unchanged"`
+	Conflicts []RuleImportConflict `json:"conflicts"`
+	Invalid   []RuleImportProblem  `json:"invalid,omitempty"`
+}
+
+// ParseRulePack reads a pack from JSON or YAML, rejecting unknown fields
+func ParseRulePack(data []byte) (*RulePack, error) {
+	var pack RulePack
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&pack); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRulePack, err)
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(trimmed))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&pack); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRulePack, err)
+		}
+	}
+	if err := pack.check(); err != nil {
+		return nil, err
+	}
+	return &pack, nil
+}
+
+// EncodeRulePack writes a pack as YAML or JSON
+func EncodeRulePack(pack *RulePack, format string) ([]byte, error) {
+	switch format {
+	case RulePackFormatJSON:
+		return json.MarshalIndent(pack, "", "  ")
+	case RulePackFormatYAML, "":
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(pack); err != nil {
+			return nil, fmt.Errorf("failed to encode rule pack: %w", err)
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, fmt.Errorf("failed to encode rule pack: %w", err)
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q, want %s or %s", ErrInvalidRulePack, format, RulePackFormatYAML, RulePackFormatJSON)
+	}
+}
+
+// check rejects packs of another schema version and packs that name a rule twice
+func (p *RulePack) check() error {
+	if p.SchemaVersion != RulePackSchemaVersion {
+		return fmt.Errorf("%w: unsupported schema_version %d, want %d", ErrInvalidRulePack, p.SchemaVersion, RulePackSchemaVersion)
+	}
+	seen := make(map[string]bool, len(p.Rules))
+	for i, rule := range p.Rules {
+		name := strings.ToLower(strings.TrimSpace(rule.Name))
+		if name == "" {
+			return fmt.Errorf("%w: rule #%d has no name", ErrInvalidRulePack, i+1)
+		}
+		if seen[name] {
+			return fmt.Errorf("%w: rule %q appears more than once", ErrInvalidRulePack, rule.Name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// packRule converts a stored rule to its pack form
+func packRule(rule model.ComplianceRule) (RulePackRule, error) {
+	packed := RulePackRule{
+		Name:        rule.Name,
+		Description: rule.Description,
+		Type:        normalizeRuleType(rule.Type),
+		Pattern:     rule.Pattern,
+		Severity:    rule.Severity,
+	}
+	if canonicalParams(rule.Params) != "" {
+		if err := json.Unmarshal(rule.Params, &packed.Params); err != nil {
+			return packed, fmt.Errorf("rule %q has invalid params: %w", rule.Name, err)
+		}
+	}
+	if canonicalParams(rule.Scope) != "" {
+		if err := json.Unmarshal(rule.Scope, &packed.Scope); err != nil {
+			return packed, fmt.Errorf("rule %q has an invalid scope: %w", rule.Name, err)
+		}
+	}
+	for _, testCase := range rule.TestCases {
+		packed.TestCases = append(packed.TestCases, RulePackTestCase{Name: testCase.Name, Text: testCase.Text, Expect: testCase.Expect})
+	}
+	return packed, nil
+}
+
+// complianceRule converts a pack rule to the model. The returned rule always
+// has a non-nil TestCases slice, so saving it replaces existing test cases.
+func (r RulePackRule) complianceRule() (model.ComplianceRule, error) {
+	rule := model.ComplianceRule{
+		Name:        strings.TrimSpace(r.Name),
+		Description: r.Description,
+		Type:        normalizeRuleType(r.Type),
+		Pattern:     r.Pattern,
+		Severity:    normalizeSeverity(r.Severity),
+		TestCases:   []model.ComplianceRuleTestCase{},
+	}
+	if len(r.Params) > 0 {
+		params, err := json.Marshal(r.Params)
+		if err != nil {
+			return rule, fmt.Errorf("%w: rule %q has invalid params: %v", ErrInvalidRulePack, r.Name, err)
+		}
+		rule.Params = datatypes.JSON(params)
+	}
+	scope, err := encodeRuleScope(r.Scope)
+	if err != nil {
+		return rule, fmt.Errorf("%w: rule %q: %v", ErrInvalidRulePack, r.Name, err)
+	}
+	rule.Scope = scope
+	for _, testCase := range r.TestCases {
+		rule.TestCases = append(rule.TestCases, model.ComplianceRuleTestCase{Name: testCase.Name, Text: testCase.Text, Expect: testCase.Expect})
+	}
+	return rule, nil
+}
+
+// sameTestCases reports whether two rules carry the same test cases in the same order
+func sameTestCases(a, b []model.ComplianceRuleTestCase) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Text != b[i].Text || a[i].Expect != b[i].Expect {
+			return false
+		}
+	}
+	return true
+}
+
+// ExportRulePack returns every active rule, with its test cases, as a pack
+func (s *DocumentService) ExportRulePack() (*RulePack, error) {
+	var rules []model.ComplianceRule
+	err := s.db.Preload("TestCases", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}).Order("name").Find(&rules).Error
+	if err != nil {
+		log.Printf("[ExportRulePack] Error fetching rules: %v", err)
+		return nil, fmt.Errorf("failed to fetch rules: %w", err)
+	}
+
+	pack := &RulePack{SchemaVersion: RulePackSchemaVersion, Name: "exported-rules", Rules: []RulePackRule{}}
+	for _, rule := range rules {
+		packed, err := packRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		pack.Rules = append(pack.Rules, packed)
+	}
+	return pack, nil
+}
+
+// packRuleTest is the outcome of testing a pack rule before the import
+type packRuleTest struct {
+	cases []model.ComplianceRuleTestCase
+	err   error
+}
+
+// ImportRulePack creates the pack's rules. A rule whose name matches an
+// existing rule with a different definition is a conflict: merge keeps the
+// existing rule, replace overwrites it. Every rule is validated and must pass
+// its test cases; if any rule is invalid nothing is imported. A dry run reports
+// the same outcome without saving anything.
+func (s *DocumentService) ImportRulePack(ctx context.Context, pack *RulePack, opts RuleImportOptions) (*RuleImportReport, error) {
+	if opts.Mode == "" {
+		opts.Mode = RuleImportMerge
+	}
+	if opts.Mode != RuleImportMerge && opts.Mode != RuleImportReplace {
+		return nil, fmt.Errorf("%w: unknown import mode %q, want %s or %s", ErrInvalidRulePack, opts.Mode, RuleImportMerge, RuleImportReplace)
+	}
+	if err := pack.check(); err != nil {
+		return nil, err
+	}
+
+	report := &RuleImportReport{
+		Pack:      pack.Name,
+		Mode:      opts.Mode,
+		DryRun:    opts.DryRun,
+		Created:   []string{},
+		Replaced:  []string{},
+		Unchanged: []string{},
+		Conflicts: []RuleImportConflict{},
+	}
+	tested, err := s.testPackRules(ctx, pack, opts.Mode)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for _, packed := range pack.Rules {
+			if err := s.importRule(ctx, tx, packed, opts.Mode, tested, report); err != nil {
+				return err
+			}
+		}
+		if len(report.Invalid) > 0 {
+			return ErrRulePackRejected
+		}
+		if opts.DryRun {
+			return errRuleImportDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, ErrRulePackRejected) {
+		return report, err
+	}
+	if err != nil && !errors.Is(err, errRuleImportDryRun) {
+		log.Printf("[ImportRulePack] Error importing pack %q: %v", pack.Name, err)
+		return nil, err
+	}
+	log.Printf("[ImportRulePack] Pack %q (%s, dry run %t): %d created, %d replaced, %d unchanged, %d conflicts",
+		pack.Name, opts.Mode, opts.DryRun, len(report.Created), len(report.Replaced), len(report.Unchanged), len(report.Conflicts))
+	return report, nil
+}
+
+// findRuleByName returns the active rule with name, ignoring case, with its test cases
+func findRuleByName(db *gorm.DB, name string) (model.ComplianceRule, error) {
+	var rule model.ComplianceRule
+	err := db.Preload("TestCases", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}).Where("lower(name) = lower(?)", name).First(&rule).Error
+	return rule, err
+}
+
+// importChanges lists how a pack rule differs from the existing rule of that name
+func importChanges(existing, rule model.ComplianceRule) []RuleFieldChange {
+	// Rules saved before severities were normalized should not look changed
+	current := existing
+	current.Type = normalizeRuleType(current.Type)
+	current.Severity = normalizeSeverity(current.Severity)
+	changes := ruleFieldChanges(current, rule)
+	if !sameTestCases(existing.TestCases, rule.TestCases) {
+		changes = append(changes, RuleFieldChange{
+			Field: "test_cases",
+			From:  fmt.Sprintf("%d test cases", len(existing.TestCases)),
+			To:    fmt.Sprintf("%d test cases", len(rule.TestCases)),
+		})
+	}
+	return changes
+}
+
+// testPackRules runs the test cases of the pack rules the import would save,
+// by lower-cased name. They may call the LLM, so this runs before the import's
+// transaction opens; importRule tests again any rule that changed meanwhile.
+func (s *DocumentService) testPackRules(ctx context.Context, pack *RulePack, mode string) (map[string]packRuleTest, error) {
+	tested := make(map[string]packRuleTest, len(pack.Rules))
+	for _, packed := range pack.Rules {
+		rule, err := packed.complianceRule()
+		if err != nil {
+			return nil, err
+		}
+		existing, err := findRuleByName(s.db, rule.Name)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+		case err != nil:
+			return nil, fmt.Errorf("failed to look up rule %q: %w", rule.Name, err)
+		case mode != RuleImportReplace || len(importChanges(existing, rule)) == 0:
+			continue
+		}
+		cases, err := s.testRuleChange(ctx, "", rule)
+		tested[strings.ToLower(rule.Name)] = packRuleTest{cases: cases, err: err}
+	}
+	return tested, nil
+}
+
+// importRule creates or replaces one pack rule within tx and records the
+// outcome; invalid rules are recorded rather than returned as errors
+func (s *DocumentService) importRule(ctx context.Context, tx *gorm.DB, packed RulePackRule, mode string, tested map[string]packRuleTest, report *RuleImportReport) error {
+	rule, err := packed.complianceRule()
+	if err != nil {
+		return err
+	}
+	test := tested[strings.ToLower(rule.Name)]
+
+	existing, err := findRuleByName(tx, rule.Name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if test.err != nil {
+			return recordImportProblem(report, rule.Name, test.err)
+		}
+		if err := s.createComplianceRule(ctx, tx, &rule, test.cases); err != nil {
+			return recordImportProblem(report, rule.Name, err)
+		}
+		report.Created = append(report.Created, rule.Name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up rule %q: %w", rule.Name, err)
+	}
+
+	changes := importChanges(existing, rule)
+	if len(changes) == 0 {
+		report.Unchanged = append(report.Unchanged, rule.Name)
+		return nil
+	}
+
+	conflict := RuleImportConflict{Name: rule.Name, RuleID: existing.ID, Changes: changes, Resolution: "kept existing"}
+	if mode == RuleImportReplace {
+		if test.err != nil {
+			return recordImportProblem(report, rule.Name, test.err)
+		}
+		// The names only differ in case, so confirming cannot orphan action items
+		if _, err := s.updateComplianceRule(ctx, tx, existing.ID, rule, true, test.cases); err != nil {
+			return recordImportProblem(report, rule.Name, err)
+		}
+		conflict.Resolution = "replaced"
+		report.Replaced = append(report.Replaced, rule.Name)
+	}
+	report.Conflicts = append(report.Conflicts, conflict)
+	return nil
+}
+
+// recordImportProblem adds a rule the pack cannot save to the report; other
+// errors are returned to abort the import
+func recordImportProblem(report *RuleImportReport, name string, err error) error {
+	var invalid *RuleValidationError
+	var failed *RuleTestFailedError
+	problem := RuleImportProblem{Name: name, Error: err.Error()}
+	switch {
+	case errors.As(err, &invalid):
+		problem.Issues = invalid.Issues
+	case errors.As(err, &failed):
+		problem.FailedTestCases = failed.Failures
+	case errors.Is(err, ErrInvalidRule), errors.Is(err, ErrRuleNameRequired):
+	default:
+		return err
+	}
+	report.Invalid = append(report.Invalid, problem)
+	return nil
+}
+
+// StarterRulePacks lists the packs shipped with the server
+func StarterRulePacks() ([]RulePackSummary, error) {
+	files, err := starterPacks.ReadDir("rulepacks")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list starter packs: %w", err)
+	}
+	summaries := make([]RulePackSummary, 0, len(files))
+	for _, file := range files {
+		pack, err := StarterRulePack(strings.TrimSuffix(file.Name(), path.Ext(file.Name())))
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, RulePackSummary{Name: pack.Name, Description: pack.Description, Rules: len(pack.Rules)})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries, nil
+}
+
+// StarterRulePack loads a shipped pack by name, e.g. nda-basics
+func StarterRulePack(name string) (*RulePack, error) {
+	if name == "" || strings.ContainsAny(name, `/\.`) {
+		return nil, fmt.Errorf("%w: %q", ErrRulePackNotFound, name)
+	}
+	data, err := starterPacks.ReadFile("rulepacks/" + name + ".yaml")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrRulePackNotFound, name)
+	}
+	return ParseRulePack(data)
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRulePack(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "yaml", data: "schema_version: 1\nrules:\n  - name: Term\n    type: regex_must_match\n    pattern: term of\n"},
+		{name: "json", data: `{"schema_version": 1, "rules": [{"name": "Term", "type": "regex_must_match", "pattern": "term of"}]}`},
+		{name: "unknown field", data: "schema_version: 1\nrules:\n  - name: Term\n    regex: term of\n", wantErr: "field regex not found"},
+		{name: "unknown json field", data: `{"schema_version": 1, "rules": [], "author": "me"}`, wantErr: "unknown field"},
+		{name: "missing version", data: "rules: []\n", wantErr: "unsupported schema_version 0"},
+		{name: "newer version", data: "schema_version: 2\nrules: []\n", wantErr: "unsupported schema_version 2"},
+		{name: "duplicate names", data: "schema_version: 1\nrules:\n  - name: Term\n  - name: term\n", wantErr: `rule "term" appears more than once`},
+		{name: "unnamed rule", data: "schema_version: 1\nrules:\n  - pattern: term of\n", wantErr: "rule #1 has no name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pack, err := ParseRulePack([]byte(tt.data))
+			if tt.wantErr != "" {
+				assert.ErrorIs(t, err, ErrInvalidRulePack)
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, pack.Rules, 1)
+			assert.Equal(t, "term of", pack.Rules[0].Pattern)
+		})
+	}
+}
+
+func TestEncodeRulePack_RoundTrips(t *testing.T) {
+	pack := &RulePack{SchemaVersion: RulePackSchemaVersion, Name: "keywords", Rules: []RulePackRule{{
+		Name:      "Indemnity",
+		Type:      model.RuleTypeRequiredKeywords,
+		Params:    map[string]interface{}{"keywords": []interface{}{"indemnify", "hold harmless"}, "min_matches": 1},
+		Severity:  SeverityHigh,
+		TestCases: []RulePackTestCase{{Text: "Supplier shall indemnify Customer.", Expect: RuleStatusPass}},
+	}}}
+	for _, format := range []string{RulePackFormatYAML, RulePackFormatJSON} {
+		data, err := EncodeRulePack(pack, format)
+		require.NoError(t, err)
+		parsed, err := ParseRulePack(data)
+		require.NoError(t, err, string(data))
+		rule, err := parsed.Rules[0].complianceRule()
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"keywords": ["indemnify", "hold harmless"], "min_matches": 1}`, string(rule.Params), format)
+		assert.Equal(t, pack.Rules[0].TestCases, parsed.Rules[0].TestCases, format)
+	}
+	_, err := EncodeRulePack(pack, "xml")
+	assert.ErrorIs(t, err, ErrInvalidRulePack)
+}
+
+func TestStarterRulePacks_Import(t *testing.T) {
+	summaries, err := StarterRulePacks()
+	require.NoError(t, err)
+	require.Len(t, summaries, 2)
+	assert.Equal(t, "data-protection-basics", summaries[0].Name)
+	assert.Equal(t, "nda-basics", summaries[1].Name)
+
+	for _, summary := range summaries {
+		t.Run(summary.Name, func(t *testing.T) {
+			s, _, _ := newPipelineService(t, &stubOCRProvider{name: "local"}, 1)
+			pack, err := StarterRulePack(summary.Name)
+			require.NoError(t, err)
+			report, err := s.ImportRulePack(context.Background(), pack, RuleImportOptions{})
+			require.NoError(t, err, "every starter rule must be valid and pass its test cases")
+			assert.Len(t, report.Created, summary.Rules)
+			assert.Empty(t, report.Conflicts)
+		})
+	}
+
+	_, err = StarterRulePack("../rule_packs")
+	assert.ErrorIs(t, err, ErrRulePackNotFound)
+	_, err = StarterRulePack("hr-basics")
+	assert.ErrorIs(t, err, ErrRulePackNotFound)
+}
+
+func TestImportRulePack(t *testing.T) {
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local"}, 1)
+	countRules := func() int64 {
+		var count int64
+		require.NoError(t, db.Model(&model.ComplianceRule{}).Count(&count).Error)
+		return count
+	}
+	pack := &RulePack{SchemaVersion: RulePackSchemaVersion, Name: "team", Rules: []RulePackRule{
+		{Name: "nda check", Type: model.RuleTypeRegexMustMatch, Pattern: `non-disclosure`, Severity: "High"},
+		{Name: "Governing Law", Type: model.RuleTypeRegexMustMatch, Pattern: `governed by the laws of`, Severity: "low",
+			TestCases: []RulePackTestCase{{Text: "This Agreement is governed by the laws of Ohio.", Expect: RuleStatusPass}}},
+	}}
+
+	report, err := s.ImportRulePack(context.Background(), pack, RuleImportOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, RuleImportMerge, report.Mode)
+	assert.Equal(t, []string{"Governing Law"}, report.Created)
+	require.Len(t, report.Conflicts, 1)
+	assert.Equal(t, "rule-1", report.Conflicts[0].RuleID)
+	assert.Equal(t, "kept existing", report.Conflicts[0].Resolution)
+	var fields []string
+	for _, change := range report.Conflicts[0].Changes {
+		fields = append(fields, change.Field)
+	}
+	assert.Equal(t, []string{"name", "type", "pattern"}, fields)
+	assert.Equal(t, int64(2), countRules(), "a dry run saves nothing")
+
+	report, err = s.ImportRulePack(context.Background(), pack, RuleImportOptions{Mode: RuleImportMerge})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Governing Law"}, report.Created)
+	assert.Equal(t, int64(3), countRules())
+	stored, err := s.GetComplianceRule("rule-1")
+	require.NoError(t, err)
+	assert.Equal(t, "NDA Check", stored.Name, "merge keeps the existing rule")
+
+	report, err = s.ImportRulePack(context.Background(), pack, RuleImportOptions{Mode: RuleImportReplace})
+	require.NoError(t, err)
+	assert.Empty(t, report.Created)
+	assert.Equal(t, []string{"Governing Law"}, report.Unchanged)
+	assert.Equal(t, []string{"nda check"}, report.Replaced)
+	stored, err = s.GetComplianceRule("rule-1")
+	require.NoError(t, err)
+	assert.Equal(t, `non-disclosure`, stored.Pattern)
+	assert.Equal(t, SeverityHigh, stored.Severity)
+
+	broken := &RulePack{SchemaVersion: RulePackSchemaVersion, Rules: []RulePackRule{
+		{Name: "Payment Terms", Type: model.RuleTypeRegexMustMatch, Pattern: `net 30`},
+		{Name: "Bad Regex", Type: model.RuleTypeRegexMustMatch, Pattern: `net (`},
+		{Name: "Wrong Case", Type: model.RuleTypeRegexMustMatch, Pattern: `late fee`,
+			TestCases: []RulePackTestCase{{Name: "no fee", Text: "No late fee applies.", Expect: RuleStatusFail}}},
+	}}
+	report, err = s.ImportRulePack(context.Background(), broken, RuleImportOptions{})
+	assert.ErrorIs(t, err, ErrRulePackRejected)
+	require.Len(t, report.Invalid, 2)
+	assert.Equal(t, "invalid_definition", report.Invalid[0].Issues[0].Code)
+	assert.Equal(t, "no fee", report.Invalid[1].FailedTestCases[0].Name)
+	assert.Equal(t, int64(3), countRules(), "nothing is imported when a rule is invalid")
+
+	_, err = s.ImportRulePack(context.Background(), pack, RuleImportOptions{Mode: "overwrite"})
+	assert.ErrorIs(t, err, ErrInvalidRulePack)
+}
+
+func TestExportRulePack_ReimportsUnchanged(t *testing.T) {
+	s, _, _ := newPipelineService(t, &stubOCRProvider{name: "local"}, 1)
+	require.NoError(t, s.AddComplianceRule(context.Background(), &model.ComplianceRule{Name: "Fixed Term", Type: model.RuleTypeRegexMustMatch, Pattern: `term of`,
+		TestCases: []model.ComplianceRuleTestCase{{Name: "has a term", Text: "A term of one year.", Expect: RuleStatusPass}}}))
+
+	pack, err := s.ExportRulePack()
+	require.NoError(t, err)
+	require.Len(t, pack.Rules, 3)
+	assert.Equal(t, "Fixed Term", pack.Rules[0].Name)
+	assert.Len(t, pack.Rules[0].TestCases, 1)
+
+	data, err := EncodeRulePack(pack, RulePackFormatYAML)
+	require.NoError(t, err)
+	parsed, err := ParseRulePack(data)
+	require.NoError(t, err)
+	report, err := s.ImportRulePack(context.Background(), parsed, RuleImportOptions{Mode: RuleImportReplace})
+	require.NoError(t, err)
+	assert.Len(t, report.Unchanged, 3)
+	assert.Empty(t, report.Conflicts)
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/open-policy-agent/opa/v1/loader"
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+// regoEvalTimeout bounds the evaluation of one policy decision
+const regoEvalTimeout = 5 * time.Second
+
+// errNoPolicies is returned for Rego rules when no bundles are loaded
+var errNoPolicies = errors.New("no Rego policies are loaded (set POLICY_DIR)")
+
+// RegoEvaluator checks rules whose Pattern names a Rego decision, e.g.
+// data.acme.contracts.deny. Decisions are evaluated in-process against the same
+// facts CEL rules see, passed as input: input.text, input.title,
+// input.document_type, input.page_count, input.entities and input.rules (the
+// outcomes of the other rules by name). A decision may be
+//
+//   - a bool: true means the document complies
+//   - a set or array of violation messages: empty means the document complies
+//   - an object with "allow" (bool) and optionally "message" (string), or with
+//     "deny" (set or array of messages)
+type RegoEvaluator struct {
+	bundles  map[string]*bundle.Bundle
+	prepared sync.Map // decision -> rego.PreparedEvalQuery
+}
+
+// NewRegoEvaluator loads every bundle in dir: each subdirectory and each
+// .tar.gz file is one bundle. All bundles are compiled together, so their
+// roots must not overlap.
+func NewRegoEvaluator(dir string) (*RegoEvaluator, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy directory: %w", err)
+	}
+
+	e := &RegoEvaluator{bundles: make(map[string]*bundle.Bundle)}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && !strings.HasSuffix(name, ".tar.gz") {
+			continue
+		}
+		b, err := loader.NewFileLoader().AsBundle(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load policy bundle %s: %w", name, err)
+		}
+		e.bundles[name] = b
+		log.Printf("Loaded policy bundle %s with %d modules", name, len(b.Modules))
+	}
+	if len(e.bundles) == 0 {
+		return nil, fmt.Errorf("no policy bundles found in %s", dir)
+	}
+
+	// Compile everything once so broken or conflicting bundles fail at startup
+	if _, err := e.prepare(context.Background(), "data"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ReadsRuleOutcomes makes Rego rules run after the rules their input refers to
+func (e *RegoEvaluator) ReadsRuleOutcomes() bool { return true }
+
+// BundleNames lists the loaded bundles
+func (e *RegoEvaluator) BundleNames() []string {
+	names := make([]string, 0, len(e.bundles))
+	for name := range e.bundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateRule checks that the rule names a decision defined by a loaded policy
+func (e *RegoEvaluator) ValidateRule(rule model.ComplianceRule) error {
+	ref, err := e.decisionRef(rule.Pattern)
+	if err != nil {
+		return err
+	}
+	for _, b := range e.bundles {
+		for _, module := range b.Modules {
+			for _, r := range module.Parsed.Rules {
+				path := r.Path()
+				if path.HasPrefix(ref) || ref.HasPrefix(path.GroundPrefix()) {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("no loaded policy defines %s", ref)
+}
+
+// decisionRef parses a decision name such as data.acme.contracts.deny
+func (e *RegoEvaluator) decisionRef(decision string) (ast.Ref, error) {
+	if e == nil || len(e.bundles) == 0 {
+		return nil, errNoPolicies
+	}
+	decision = strings.TrimSpace(decision)
+	if decision == "" {
+		return nil, errors.New("policy decision is empty")
+	}
+	ref, err := ast.ParseRef(decision)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy decision %q: %w", decision, err)
+	}
+	if !ref[0].Equal(ast.DefaultRootDocument) {
+		return nil, fmt.Errorf("policy decision %q must start with data.", decision)
+	}
+	return ref, nil
+}
+
+// prepare compiles a query against all bundles once and caches it
+func (e *RegoEvaluator) prepare(ctx context.Context, decision string) (rego.PreparedEvalQuery, error) {
+	if cached, ok := e.prepared.Load(decision); ok {
+		return cached.(rego.PreparedEvalQuery), nil
+	}
+
+	options := []func(*rego.Rego){rego.Query(decision), rego.StrictBuiltinErrors(true)}
+	for _, name := range e.BundleNames() {
+		options = append(options, rego.ParsedBundle(name, e.bundles[name]))
+	}
+	query, err := rego.New(options...).PrepareForEval(ctx)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, fmt.Errorf("failed to compile policies: %w", err)
+	}
+	e.prepared.Store(decision, query)
+	return query, nil
+}
+
+// Evaluate evaluates each rule's decision against the document's facts
+func (e *RegoEvaluator) Evaluate(ctx context.Context, req RuleEvaluationRequest) (map[string]RuleOutcome, error) {
+	input := documentFacts(req)
+	outcomes := make(map[string]RuleOutcome, len(req.Rules))
+	for _, rule := range req.Rules {
+		outcome, err := e.evaluate(ctx, rule, input)
+		if err != nil {
+			log.Printf("[RegoEvaluator] Rule %s could not be evaluated: %v", rule.Name, err)
+			outcome = RuleOutcome{Status: RuleStatusIndeterminate, Explanation: fmt.Sprintf("Rule '%s' could not be evaluated: %v", rule.Name, err)}
+		}
+		outcomes[rule.ID] = outcome
+	}
+	return outcomes, nil
+}
+
+// evaluate runs one decision and interprets its value
+func (e *RegoEvaluator) evaluate(ctx context.Context, rule model.ComplianceRule, input map[string]interface{}) (RuleOutcome, error) {
+	ref, err := e.decisionRef(rule.Pattern)
+	if err != nil {
+		return RuleOutcome{}, err
+	}
+	query, err := e.prepare(ctx, ref.String())
+	if err != nil {
+		return RuleOutcome{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, regoEvalTimeout)
+	defer cancel()
+	results, err := query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return RuleOutcome{}, fmt.Errorf("policy evaluation failed: %w", err)
+	}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return RuleOutcome{}, fmt.Errorf("policy decision %s is undefined for this document", ref)
+	}
+	return regoOutcome(rule, results[0].Expressions[0].Value)
+}
+
+// regoOutcome turns a decision value into a pass or fail outcome
+func regoOutcome(rule model.ComplianceRule, value interface{}) (RuleOutcome, error) {
+	pass := RuleOutcome{Status: RuleStatusPass, Explanation: fmt.Sprintf("The document complies with the '%s' policy.", rule.Name)}
+
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return pass, nil
+		}
+		return RuleOutcome{Status: RuleStatusFail, Explanation: fmt.Sprintf("The document violates the '%s' policy.", rule.Name)}, nil
+	case []interface{}:
+		if len(v) == 0 {
+			return pass, nil
+		}
+		return RuleOutcome{Status: RuleStatusFail, Explanation: fmt.Sprintf("The document violates the '%s' policy: %s", rule.Name, strings.Join(regoMessages(v), "; "))}, nil
+	case map[string]interface{}:
+		if deny, ok := v["deny"].([]interface{}); ok {
+			return regoOutcome(rule, deny)
+		}
+		allow, ok := v["allow"].(bool)
+		if !ok {
+			return RuleOutcome{}, errors.New(`policy decision object needs an "allow" bool or a "deny" list`)
+		}
+		outcome, _ := regoOutcome(rule, allow)
+		if message, ok := v["message"].(string); ok && message != "" {
+			outcome.Explanation = message
+		}
+		return outcome, nil
+	default:
+		return RuleOutcome{}, fmt.Errorf("unsupported policy decision value %v", value)
+	}
+}
+
+// regoMessages reads violation messages, which may be strings or objects with a msg or message field
+func regoMessages(values []interface{}) []string {
+	messages := make([]string, 0, len(values))
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			messages = append(messages, v)
+		case map[string]interface{}:
+			if msg, ok := v["msg"].(string); ok {
+				messages = append(messages, msg)
+			} else if msg, ok := v["message"].(string); ok {
+				messages = append(messages, msg)
+			} else {
+				messages = append(messages, fmt.Sprint(v))
+			}
+		default:
+			messages = append(messages, fmt.Sprint(v))
+		}
+	}
+	sort.Strings(messages)
+	return messages
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const contractPolicy = `package acme.contracts
+
+foreign_law if {
+	input.entities.governing_law
+	not startswith(input.entities.governing_law, "State of New York")
+}
+
+deny contains "foreign governing law requires an arbitration clause" if {
+	foreign_law
+	not regex.match("(?i)arbitration", input.text)
+}
+
+deny contains msg if {
+	input.entities.payment_terms_days > 60
+	msg := sprintf("payment terms of %d days exceed 60", [input.entities.payment_terms_days])
+}
+
+signed := {"allow": input.rules["Signature Present"] == "pass", "message": "signature rule outcome"}
+
+is_services := input.document_type == "services"
+`
+
+// writePolicyDir lays out a policy directory with one bundle per entry
+func writePolicyDir(t *testing.T, bundles map[string]string) string {
+	dir := t.TempDir()
+	for name, policy := range bundles {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, name), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name, "policy.rego"), []byte(policy), 0o644))
+	}
+	return dir
+}
+
+func TestRegoEvaluator(t *testing.T) {
+	policies, err := NewRegoEvaluator(writePolicyDir(t, map[string]string{"contracts": contractPolicy}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"contracts"}, policies.BundleNames())
+
+	tests := []struct {
+		name       string
+		text       string
+		decision   string
+		wantStatus string
+		wantInText string
+	}{
+		{name: "deny set empty", text: serviceAgreement, decision: "data.acme.contracts.deny", wantStatus: RuleStatusPass},
+		{
+			name:       "deny set with violations",
+			text:       "Payment is due within ninety (90) days. This Agreement is governed by the laws of England.",
+			decision:   "data.acme.contracts.deny",
+			wantStatus: RuleStatusFail,
+			wantInText: "foreign governing law requires an arbitration clause; payment terms of 90 days exceed 60",
+		},
+		{name: "bool decision", text: serviceAgreement, decision: "data.acme.contracts.is_services", wantStatus: RuleStatusPass},
+		{name: "object decision reads other outcomes", text: serviceAgreement, decision: "data.acme.contracts.signed", wantStatus: RuleStatusFail, wantInText: "signature rule outcome"},
+		{name: "undefined decision", text: "No governing law.", decision: "data.acme.contracts.foreign_law", wantStatus: RuleStatusIndeterminate, wantInText: "undefined"},
+	}
+
+	s := &DocumentService{llm: NewFakeLLMClient()}
+	s.RegisterRuleEvaluator(model.RuleTypeRego, policies)
+	signature := model.ComplianceRule{ID: "signature", Name: "Signature Present", Type: model.RuleTypeRegexMustMatch, Pattern: `signed by`}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := model.ComplianceRule{ID: "rego", Name: tt.name, Type: model.RuleTypeRego, Pattern: tt.decision}
+			require.NoError(t, policies.ValidateRule(rule))
+
+			outcomes, err := s.evaluateRules(context.Background(), RuleEvaluationRequest{Text: tt.text}, []model.ComplianceRule{rule, signature})
+			require.NoError(t, err)
+			outcome := outcomes["rego"]
+			assert.Equal(t, tt.wantStatus, outcome.Status, outcome.Explanation)
+			assert.Contains(t, outcome.Explanation, tt.wantInText)
+		})
+	}
+
+	for _, decision := range []string{"", "acme.contracts.deny", "data.acme.other.deny", "data.acme.contracts["} {
+		assert.Error(t, policies.ValidateRule(model.ComplianceRule{Pattern: decision}), decision)
+	}
+}
+
+func TestNewRegoEvaluator_RejectsBrokenBundles(t *testing.T) {
+	_, err := NewRegoEvaluator(writePolicyDir(t, map[string]string{"broken": "package acme\n\ndeny contains"}))
+	assert.ErrorContains(t, err, "broken")
+
+	_, err = NewRegoEvaluator(t.TempDir())
+	assert.ErrorContains(t, err, "no policy bundles")
+
+	assert.ErrorIs(t, (&RegoEvaluator{}).ValidateRule(model.ComplianceRule{Pattern: "data.acme.deny"}), errNoPolicies)
+}
+
+func TestRegoDecisions_AreStoredAndScored(t *testing.T) {
+	policies, err := NewRegoEvaluator(writePolicyDir(t, map[string]string{"contracts": contractPolicy}))
+	require.NoError(t, err)
+
+	text := "Payment is due within ninety (90) days. Governed by the laws of England."
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: text}, 1)
+	s.RegisterRuleEvaluator(model.RuleTypeRego, policies)
+	rule := &model.ComplianceRule{Name: "Contract Policy", Type: model.RuleTypeRego, Pattern: "data.acme.contracts.deny", Severity: "high"}
+	require.NoError(t, s.AddComplianceRule(context.Background(), rule))
+
+	doc := analyzedDocument(t, s, "contract.txt", text)
+	stored := loadDocument(t, db, doc.ID)
+	assert.Equal(t, model.DocumentStatusAnalyzed, stored.ProcessingStatus)
+	assert.GreaterOrEqual(t, stored.RiskScore, 3.0, "the failed high severity policy adds to the risk score")
+
+	var result model.DocumentRuleResult
+	require.NoError(t, db.First(&result, "document_id = ? AND rule_id = ?", doc.ID, rule.ID).Error)
+	assert.Equal(t, RuleStatusFail, result.Status)
+	assert.Contains(t, string(result.Details), "payment terms of 90 days exceed 60")
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"gorm.io/datatypes"
+)
+
+// documentTypes are the values a scope's document_types may list
+var documentTypes = []string{
+	DocumentTypeNDA, DocumentTypeEmployment, DocumentTypeLease, DocumentTypeServices,
+	DocumentTypePurchase, DocumentTypeLicense, DocumentTypeOther,
+}
+
+// RuleScope limits a rule to documents of some types, governed by some
+// jurisdictions or carrying some tags. Each listed dimension must match; an
+// empty list places no limit on that dimension.
+type RuleScope struct {
+	DocumentTypes []string `json:"document_types,omitempty" yaml:"document_types,omitempty"`
+	Jurisdictions []string `json:"jurisdictions,omitempty" yaml:"jurisdictions,omitempty"`
+	Tags          []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// DocumentProfile is what rule scopes are matched against
+type DocumentProfile struct {
+	DocumentType string   `json:"document_type"`
+	Jurisdiction string   `json:"jurisdiction,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+}
+
+// parseRuleScope reads a rule's scope, lowercasing its entries
+func parseRuleScope(raw datatypes.JSON) (RuleScope, error) {
+	var scope RuleScope
+	if canonicalParams(raw) == "" {
+		return scope, nil
+	}
+	if err := json.Unmarshal(raw, &scope); err != nil {
+		return scope, fmt.Errorf("invalid scope: %w", err)
+	}
+	for _, list := range []*[]string{&scope.DocumentTypes, &scope.Jurisdictions, &scope.Tags} {
+		values := (*list)[:0]
+		for _, value := range *list {
+			value = strings.ToLower(strings.TrimSpace(value))
+			if value == "" {
+				return scope, fmt.Errorf("invalid scope: entries must not be empty")
+			}
+			values = append(values, value)
+		}
+		*list = values
+	}
+	for _, docType := range scope.DocumentTypes {
+		if !contains(documentTypes, docType) {
+			return scope, fmt.Errorf("invalid scope: unknown document type %q, want one of %s", docType, strings.Join(documentTypes, ", "))
+		}
+	}
+	return scope, nil
+}
+
+// encodeRuleScope stores a scope on a rule; an empty scope is stored as none
+func encodeRuleScope(scope *RuleScope) (datatypes.JSON, error) {
+	if scope == nil || (len(scope.DocumentTypes) == 0 && len(scope.Jurisdictions) == 0 && len(scope.Tags) == 0) {
+		return nil, nil
+	}
+	raw, err := json.Marshal(scope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scope: %w", err)
+	}
+	return datatypes.JSON(raw), nil
+}
+
+// documentProfile classifies a document's text and reads its governing law
+func documentProfile(text string, tags datatypes.JSON) DocumentProfile {
+	profile := DocumentProfile{DocumentType: classifyDocument(text), Tags: DocumentTags(tags)}
+	if m := governingLawPattern.FindStringSubmatch(text); m != nil {
+		profile.Jurisdiction = strings.TrimSpace(m[1])
+	}
+	return profile
+}
+
+// DocumentTags reads a document's stored tags, ignoring unreadable values
+func DocumentTags(raw datatypes.JSON) []string {
+	var tags []string
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &tags); err != nil {
+			log.Printf("[DocumentTags] Ignoring unreadable tags %s: %v", raw, err)
+			return nil
+		}
+	}
+	return normalizeTags(tags)
+}
+
+// normalizeTags trims, lowercases and de-duplicates tags
+func normalizeTags(tags []string) []string {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// jurisdictionMatches reports whether a scope jurisdiction such as "delaware"
+// names the document's governing law, e.g. "State of Delaware"
+func jurisdictionMatches(scoped, governingLaw string) bool {
+	words := " " + strings.Join(strings.Fields(strings.ToLower(governingLaw)), " ") + " "
+	return strings.Contains(words, " "+strings.Join(strings.Fields(scoped), " ")+" ")
+}
+
+// appliesTo reports whether a document falls within the scope, and if not, why
+func (scope RuleScope) appliesTo(profile DocumentProfile) (bool, string) {
+	if len(scope.DocumentTypes) > 0 && !contains(scope.DocumentTypes, profile.DocumentType) {
+		return false, fmt.Sprintf("it covers %s documents and this is classified as %s", strings.Join(scope.DocumentTypes, ", "), profile.DocumentType)
+	}
+	if len(scope.Jurisdictions) > 0 {
+		if profile.Jurisdiction == "" {
+			return false, fmt.Sprintf("it covers documents governed by the laws of %s and no governing law was found", strings.Join(scope.Jurisdictions, ", "))
+		}
+		matched := false
+		for _, jurisdiction := range scope.Jurisdictions {
+			matched = matched || jurisdictionMatches(jurisdiction, profile.Jurisdiction)
+		}
+		if !matched {
+			return false, fmt.Sprintf("it covers documents governed by the laws of %s and this one is governed by the laws of %s", strings.Join(scope.Jurisdictions, ", "), profile.Jurisdiction)
+		}
+	}
+	if len(scope.Tags) > 0 {
+		matched := false
+		for _, tag := range scope.Tags {
+			matched = matched || contains(profile.Tags, tag)
+		}
+		if !matched {
+			return false, fmt.Sprintf("it covers documents tagged %s", strings.Join(scope.Tags, ", "))
+		}
+	}
+	return true, ""
+}
+
+// filterRulesByScope splits the rules into those whose scope includes the
+// document and those that do not apply, returning the not_applicable outcome
+// of the latter keyed by rule ID. A rule whose scope cannot be read applies,
+// so a bad scope never hides a failure.
+func filterRulesByScope(profile DocumentProfile, rules []model.ComplianceRule) ([]model.ComplianceRule, map[string]RuleOutcome) {
+	applicable := make([]model.ComplianceRule, 0, len(rules))
+	notApplicable := make(map[string]RuleOutcome)
+	for _, rule := range rules {
+		scope, err := parseRuleScope(rule.Scope)
+		if err != nil {
+			log.Printf("[filterRulesByScope] Rule %s has an unreadable scope, applying it: %v", rule.Name, err)
+			applicable = append(applicable, rule)
+			continue
+		}
+		if ok, reason := scope.appliesTo(profile); !ok {
+			notApplicable[rule.ID] = RuleOutcome{
+				Status:      RuleStatusNotApplicable,
+				Engine:      EngineScope,
+				Explanation: fmt.Sprintf("The '%s' rule does not apply: %s.", rule.Name, reason),
+			}
+			continue
+		}
+		applicable = append(applicable, rule)
+	}
+	return applicable, notApplicable
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+func TestRuleScope_AppliesTo(t *testing.T) {
+	nda := DocumentProfile{DocumentType: DocumentTypeNDA, Jurisdiction: "State of Delaware", Tags: []string{"vendor"}}
+	tests := []struct {
+		name    string
+		scope   string
+		profile DocumentProfile
+		want    bool
+		reason  string
+	}{
+		{name: "no scope", scope: ``, profile: nda, want: true},
+		{name: "empty scope", scope: `{}`, profile: nda, want: true},
+		{name: "matching type", scope: `{"document_types": ["NDA", "employment"]}`, profile: nda, want: true},
+		{name: "other type", scope: `{"document_types": ["employment"]}`, profile: nda, reason: "it covers employment documents and this is classified as nda"},
+		{name: "matching jurisdiction", scope: `{"jurisdictions": ["Delaware"]}`, profile: nda, want: true},
+		{name: "other jurisdiction", scope: `{"jurisdictions": ["California"]}`, profile: nda, reason: "governed by the laws of State of Delaware"},
+		{name: "partial word is no match", scope: `{"jurisdictions": ["ware"]}`, profile: nda},
+		{name: "no governing law", scope: `{"jurisdictions": ["delaware"]}`, profile: DocumentProfile{DocumentType: DocumentTypeNDA}, reason: "no governing law was found"},
+		{name: "matching tag", scope: `{"tags": ["hr", "Vendor"]}`, profile: nda, want: true},
+		{name: "missing tag", scope: `{"tags": ["hr"]}`, profile: nda, reason: "it covers documents tagged hr"},
+		{name: "every dimension must match", scope: `{"document_types": ["nda"], "tags": ["hr"]}`, profile: nda},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, err := parseRuleScope(datatypes.JSON(tt.scope))
+			require.NoError(t, err)
+			ok, reason := scope.appliesTo(tt.profile)
+			assert.Equal(t, tt.want, ok)
+			assert.Contains(t, reason, tt.reason)
+		})
+	}
+
+	_, err := parseRuleScope(datatypes.JSON(`{"document_types": ["memo"]}`))
+	assert.ErrorContains(t, err, `unknown document type "memo"`)
+	_, err = parseRuleScope(datatypes.JSON(`{"tags": [" "]}`))
+	assert.ErrorContains(t, err, "entries must not be empty")
+}
+
+func TestAddComplianceRule_ValidatesScope(t *testing.T) {
+	s, _, _ := newPipelineService(t, &stubOCRProvider{name: "local"}, 1)
+	err := s.AddComplianceRule(context.Background(), &model.ComplianceRule{Name: "Salary", Type: model.RuleTypeRegexMustMatch, Pattern: `salary`,
+		Scope: datatypes.JSON(`{"document_types": ["payroll"]}`)})
+	var invalid *RuleValidationError
+	require.ErrorAs(t, err, &invalid)
+	assert.Equal(t, "invalid_scope", invalid.Issues[0].Code)
+
+	rule := &model.ComplianceRule{Name: "Salary", Type: model.RuleTypeRegexMustMatch, Pattern: `salary`,
+		Scope: datatypes.JSON(`{"document_types": ["employment"]}`)}
+	require.NoError(t, s.AddComplianceRule(context.Background(), rule))
+	_, err = s.UpdateComplianceRule(context.Background(), rule.ID, model.ComplianceRule{Name: "Salary", Type: model.RuleTypeRegexMustMatch, Pattern: `salary`,
+		Scope: datatypes.JSON(`{"document_types": ["employment", "services"]}`)}, false)
+	require.NoError(t, err)
+	diff, err := s.DiffRuleVersions(rule.ID, 1, 2)
+	require.NoError(t, err)
+	require.Len(t, diff.Changes, 1, "a scope change is a new version")
+	assert.Equal(t, "scope", diff.Changes[0].Field)
+}
+
+func TestAnalyzeCompliance_SkipsOutOfScopeRules(t *testing.T) {
+	text := "This Non-Disclosure Agreement protects Confidential Information. It is governed by the laws of the State of Delaware."
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: text}, 1)
+	for _, rule := range []*model.ComplianceRule{
+		{Name: "Salary Clause", Type: model.RuleTypeRegexMustMatch, Pattern: `salary`, Severity: SeverityHigh,
+			Scope: datatypes.JSON(`{"document_types": ["employment"]}`)},
+		{Name: "California Notice", Type: model.RuleTypeRegexMustMatch, Pattern: `california`, Severity: SeverityHigh,
+			Scope: datatypes.JSON(`{"jurisdictions": ["California"]}`)},
+		{Name: "Vendor Security", Type: model.RuleTypeRegexMustMatch, Pattern: `security`, Severity: SeverityMedium,
+			Scope: datatypes.JSON(`{"document_types": ["nda"], "tags": ["vendor"]}`)},
+	} {
+		require.NoError(t, s.AddComplianceRule(context.Background(), rule))
+	}
+
+	file, header := newUpload("nda.txt", []byte(text))
+	doc, err := s.EnqueueDocument(file, header, " Vendor", "vendor")
+	require.NoError(t, err)
+	s.processDocument(context.Background(), <-s.jobs)
+	stored := loadDocument(t, db, doc.ID)
+	assert.Equal(t, []string{"vendor"}, DocumentTags(stored.Tags))
+
+	var results []map[string]interface{}
+	require.NoError(t, json.Unmarshal(stored.ParsedData, &results))
+	statuses := map[string]string{}
+	for _, result := range results {
+		statuses[result["rule_name"].(string)] = result["status"].(string)
+		if result["status"] == RuleStatusNotApplicable {
+			assert.Contains(t, result["explanation"], "does not apply")
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"NDA Check":         RuleStatusFail,
+		"Signature Check":   RuleStatusPass,
+		"Salary Clause":     RuleStatusNotApplicable,
+		"California Notice": RuleStatusNotApplicable,
+		"Vendor Security":   RuleStatusFail,
+	}, statuses)
+	assert.Equal(t, 5.0, stored.RiskScore, "only the in-scope failures count: high NDA Check and medium Vendor Security")
+
+	var actions int64
+	require.NoError(t, db.Model(&model.ActionItem{}).Where("document_id = ?", doc.ID).Count(&actions).Error)
+	assert.Equal(t, int64(2), actions, "rules that do not apply raise no action items")
+
+	var notApplicable []model.DocumentRuleResult
+	require.NoError(t, db.Find(&notApplicable, "document_id = ? AND status = ?", doc.ID, RuleStatusNotApplicable).Error)
+	assert.Len(t, notApplicable, 2, "rules that do not apply are still recorded")
+
+	// Without the tag the vendor rule no longer applies
+	_, err = s.UpdateDocumentTags(context.Background(), doc.ID, []string{"hr"})
+	require.NoError(t, err)
+	report, err := s.TestComplianceRule(context.Background(), RuleTestRequest{
+		Rule:        model.ComplianceRule{Type: model.RuleTypeRegexMustMatch, Pattern: `security`, Scope: datatypes.JSON(`{"tags": ["vendor"]}`)},
+		DocumentIDs: []string{doc.ID},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, RuleStatusNotApplicable, report.Results[0].Status)
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrRuleNotFound is returned when no active rule exists with the requested ID
+	ErrRuleNotFound = errors.New("compliance rule not found")
+	// ErrRuleNameRequired is returned when a rule is saved without a name
+	ErrRuleNameRequired = errors.New("rule name is required")
+	// ErrRuleNameTaken is returned when another active rule already uses the name
+	ErrRuleNameTaken = errors.New("a compliance rule with this name already exists")
+)
+
+// RuleInUseError is returned when a change would affect open action items and
+// was not confirmed
+type RuleInUseError struct {
+	RuleName        string
+	OpenActionItems int64
+}
+
+// Error describes how many action items the change affects
+func (e *RuleInUseError) Error() string {
+	return fmt.Sprintf("rule %q has %d open action items; confirm the change to proceed", e.RuleName, e.OpenActionItems)
+}
+
+// checkRuleName rejects empty names and names used by another active rule, ignoring case
+func (s *DocumentService) checkRuleName(db *gorm.DB, name, exceptID string) error {
+	if name == "" {
+		return ErrRuleNameRequired
+	}
+	query := db.Model(&model.ComplianceRule{}).Where("lower(name) = lower(?)", name)
+	if exceptID != "" {
+		query = query.Where("id <> ?", exceptID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check rule name: %w", err)
+	}
+	if count > 0 {
+		return ErrRuleNameTaken
+	}
+	return nil
+}
+
+// openActionItemCount counts the pending action items raised by a rule
+func (s *DocumentService) openActionItemCount(db *gorm.DB, ruleID string) (int64, error) {
+	var count int64
+	err := db.Model(&model.ActionItem{}).Where("rule_id = ? AND status = ?", ruleID, "pending").Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count open action items: %w", err)
+	}
+	return count, nil
+}
+
+// GetComplianceRule returns a rule by ID, including archived rules so old results can be explained
+func (s *DocumentService) GetComplianceRule(id string) (*model.ComplianceRule, error) {
+	var rule model.ComplianceRule
+	err := s.db.Unscoped().Preload("TestCases", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}).First(&rule, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRuleNotFound
+	}
+	if err != nil {
+		log.Printf("[GetComplianceRule] Error fetching rule %s: %v", id, err)
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// UpdateComplianceRule replaces a rule's definition and records it as a new
+// version. Renaming a rule that has open action items requires confirm. When
+// input carries test cases they replace the stored ones; either way the new
+// definition must pass them.
+func (s *DocumentService) UpdateComplianceRule(ctx context.Context, id string, input model.ComplianceRule, confirm bool) (*model.ComplianceRule, error) {
+	var rule *model.ComplianceRule
+	tested, err := s.testRuleChange(ctx, id, input)
+	if err == nil {
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			rule, err = s.updateComplianceRule(ctx, tx, id, input, confirm, tested)
+			return err
+		})
+	}
+	if err != nil {
+		log.Printf("[UpdateComplianceRule] Error updating rule %s: %v", id, err)
+		return nil, err
+	}
+	log.Printf("[UpdateComplianceRule] Rule %s updated", rule.ID)
+	return rule, nil
+}
+
+// updateComplianceRule applies an update within tx. tested are the cases
+// testRuleChange already ran for it.
+func (s *DocumentService) updateComplianceRule(ctx context.Context, tx *gorm.DB, id string, input model.ComplianceRule, confirm bool, tested []model.ComplianceRuleTestCase) (*model.ComplianceRule, error) {
+	var rule model.ComplianceRule
+	if err := tx.First(&rule, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRuleNotFound
+		}
+		return nil, err
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if err := s.checkRuleName(tx, name, rule.ID); err != nil {
+		return nil, err
+	}
+	if name != rule.Name && !confirm {
+		open, err := s.openActionItemCount(tx, rule.ID)
+		if err != nil {
+			return nil, err
+		}
+		if open > 0 {
+			return nil, &RuleInUseError{RuleName: rule.Name, OpenActionItems: open}
+		}
+	}
+
+	previous := rule
+	rule.Name = name
+	rule.Description = input.Description
+	rule.Type = normalizeRuleType(input.Type)
+	rule.Params = input.Params
+	rule.Pattern = input.Pattern
+	rule.Severity = normalizeSeverity(input.Severity)
+	rule.Scope = input.Scope
+	rule.UpdatedAt = time.Now()
+	warnings, err := s.checkRuleDefinition(rule)
+	if err != nil {
+		return nil, err
+	}
+	rule.Warnings = warnings
+	cases := input.TestCases
+	if cases == nil {
+		if err := tx.Where("rule_id = ?", rule.ID).Order("created_at").Find(&cases).Error; err != nil {
+			return nil, fmt.Errorf("failed to load test cases: %w", err)
+		}
+	} else if err := checkRuleTestCases(cases); err != nil {
+		return nil, err
+	}
+	if err := s.retestRule(ctx, rule, cases, tested); err != nil {
+		return nil, err
+	}
+	if err := tx.Save(&rule).Error; err != nil {
+		return nil, err
+	}
+	if input.TestCases != nil {
+		stored, err := s.replaceRuleTestCases(tx, rule.ID, input.TestCases)
+		if err != nil {
+			return nil, err
+		}
+		cases = stored
+	}
+	rule.TestCases = cases
+	if !ruleDefinitionChanged(previous, rule) {
+		return &rule, nil
+	}
+	// Make sure the definition being replaced is on record before adding the new one
+	if _, err := s.ruleVersionsAt(tx, []model.ComplianceRule{previous}, rule.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if _, err := s.recordRuleVersion(tx, rule, rule.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// ArchiveComplianceRule soft-deletes a rule so it no longer applies to new
+// documents while past results keep referring to it. Archiving a rule that has
+// open action items requires confirm.
+func (s *DocumentService) ArchiveComplianceRule(id string, confirm bool) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var rule model.ComplianceRule
+		if err := tx.First(&rule, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRuleNotFound
+			}
+			return err
+		}
+		if !confirm {
+			open, err := s.openActionItemCount(tx, rule.ID)
+			if err != nil {
+				return err
+			}
+			if open > 0 {
+				return &RuleInUseError{RuleName: rule.Name, OpenActionItems: open}
+			}
+		}
+		return tx.Delete(&rule).Error
+	})
+	if err != nil {
+		log.Printf("[ArchiveComplianceRule] Error archiving rule %s: %v", id, err)
+		return err
+	}
+	log.Printf("[ArchiveComplianceRule] Rule %s archived", id)
+	return nil
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddComplianceRule_RejectsDuplicateNames(t *testing.T) {
+	s, _, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: "No NDA here."}, 1)
+
+	err := s.AddComplianceRule(context.Background(), &model.ComplianceRule{Name: "  nda check "})
+	assert.ErrorIs(t, err, ErrRuleNameTaken, "names are compared without case or surrounding spaces")
+
+	err = s.AddComplianceRule(context.Background(), &model.ComplianceRule{Name: " "})
+	assert.ErrorIs(t, err, ErrRuleNameRequired)
+
+	rule := &model.ComplianceRule{Name: "Termination Clause", Severity: "Medium"}
+	require.NoError(t, s.AddComplianceRule(context.Background(), rule))
+	assert.NotEmpty(t, rule.ID)
+}
+
+func TestUpdateComplianceRule(t *testing.T) {
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: "No NDA here."}, 1)
+	doc := analyzedDocument(t, s, "contract.txt", "No NDA here.")
+
+	tests := []struct {
+		name    string
+		id      string
+		input   model.ComplianceRule
+		confirm bool
+		wantErr error
+		inUse   int64
+	}{
+		{name: "missing rule", id: "missing", input: model.ComplianceRule{Name: "Anything"}, wantErr: ErrRuleNotFound},
+		{name: "name taken", id: "rule-2", input: model.ComplianceRule{Name: "NDA CHECK"}, wantErr: ErrRuleNameTaken},
+		{name: "rename with open action items", id: "rule-1", input: model.ComplianceRule{Name: "Confidentiality Check"}, inUse: 1},
+		{name: "same name with open action items", id: "rule-1", input: model.ComplianceRule{Name: "NDA Check", Severity: "Medium"}},
+		{name: "confirmed rename", id: "rule-1", input: model.ComplianceRule{Name: "Confidentiality Check", Severity: "Medium"}, confirm: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := s.UpdateComplianceRule(context.Background(), tt.id, tt.input, tt.confirm)
+			var inUse *RuleInUseError
+			switch {
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			case tt.inUse > 0:
+				require.True(t, errors.As(err, &inUse))
+				assert.Equal(t, tt.inUse, inUse.OpenActionItems)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, tt.input.Name, rule.Name)
+				assert.Equal(t, normalizeSeverity(tt.input.Severity), rule.Severity)
+			}
+		})
+	}
+
+	var items []model.ActionItem
+	require.NoError(t, db.Find(&items, "document_id = ?", doc.ID).Error)
+	require.Len(t, items, 1)
+	assert.Equal(t, "rule-1", items[0].RuleID, "renaming keeps the rule's action items")
+}
+
+func TestArchiveComplianceRule_KeepsResults(t *testing.T) {
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: "No NDA here."}, 1)
+	doc := analyzedDocument(t, s, "contract.txt", "No NDA here.")
+
+	err := s.ArchiveComplianceRule("rule-1", false)
+	var inUse *RuleInUseError
+	require.True(t, errors.As(err, &inUse), "archiving a rule with open action items needs confirmation")
+
+	require.NoError(t, s.ArchiveComplianceRule("rule-1", true))
+	assert.ErrorIs(t, s.ArchiveComplianceRule("rule-1", true), ErrRuleNotFound)
+
+	rules, err := s.GetAllComplianceRules()
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "rule-2", rules[0].ID)
+
+	archived, err := s.GetComplianceRule("rule-1")
+	require.NoError(t, err)
+	assert.True(t, archived.ArchivedAt.Valid)
+
+	var results int64
+	require.NoError(t, db.Table("document_rule_results").Where("document_id = ? AND rule_id = ?", doc.ID, "rule-1").Count(&results).Error)
+	assert.Equal(t, int64(1), results, "results of an archived rule are kept")
+
+	require.NoError(t, s.AddComplianceRule(context.Background(), &model.ComplianceRule{Name: "NDA Check"}), "an archived rule's name can be reused")
+}
+
+func TestRuleVersions_ResultsKeepTheirDefinition(t *testing.T) {
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: "No NDA here."}, 1)
+	doc := analyzedDocument(t, s, "contract.txt", "No NDA here.")
+
+	var first model.DocumentRuleResult
+	require.NoError(t, db.First(&first, "document_id = ? AND rule_id = ?", doc.ID, "rule-1").Error)
+	require.NotEmpty(t, first.RuleVersionID)
+
+	_, err := s.UpdateComplianceRule(context.Background(), "rule-1", model.ComplianceRule{Name: "NDA Check", Pattern: "non-disclosure", Severity: "Medium"}, false)
+	require.NoError(t, err)
+	_, err = s.UpdateComplianceRule(context.Background(), "rule-1", model.ComplianceRule{Name: "NDA Check", Pattern: "non-disclosure", Severity: "Medium"}, false)
+	require.NoError(t, err, "saving an unchanged definition does not add a version")
+
+	versions, err := s.GetRuleVersions("rule-1")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, first.RuleVersionID, versions[0].ID, "the existing result points at the definition that produced it")
+	assert.Equal(t, "High", versions[0].Severity)
+	assert.False(t, versions[1].EffectiveFrom.Before(versions[0].EffectiveFrom))
+
+	second := analyzedDocument(t, s, "amended.txt", "No NDA here.")
+	var result model.DocumentRuleResult
+	require.NoError(t, db.First(&result, "document_id = ? AND rule_id = ?", second.ID, "rule-1").Error)
+	assert.Equal(t, versions[1].ID, result.RuleVersionID)
+
+	diff, err := s.DiffRuleVersions("rule-1", 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []RuleFieldChange{
+		{Field: "pattern", From: "", To: "non-disclosure"},
+		{Field: "severity", From: "High", To: "medium"},
+	}, diff.Changes)
+
+	_, err = s.DiffRuleVersions("rule-1", 1, 3)
+	assert.ErrorIs(t, err, ErrRuleVersionNotFound)
+	_, err = s.GetRuleVersions("missing")
+	assert.ErrorIs(t, err, ErrRuleNotFound)
+}
+
+func TestAddComplianceRule_RecordsFirstVersion(t *testing.T) {
+	s, _, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: "No NDA here."}, 1)
+
+	rule := &model.ComplianceRule{Name: "Governing Law", Pattern: "governed by", Severity: "Low"}
+	require.NoError(t, s.AddComplianceRule(context.Background(), rule))
+
+	versions, err := s.GetRuleVersions(rule.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, 1, versions[0].Version)
+	assert.Equal(t, "governed by", versions[0].Pattern)
+}
+
+func TestAnalysis_DoesNotRecordRuleVersions(t *testing.T) {
+	s, db, _ := newPipelineService(t, &stubOCRProvider{name: "local", text: "No NDA here."}, 1)
+	stale, err := s.GetComplianceRule("rule-1")
+	require.NoError(t, err)
+
+	// Edited after the analysis loaded the rules: the result keeps the definition it evaluated
+	_, err = s.UpdateComplianceRule(context.Background(), "rule-1", model.ComplianceRule{Name: "NDA Check", Pattern: "non-disclosure", Severity: "High"}, false)
+	require.NoError(t, err)
+	versions, err := s.currentRuleVersions(db, []model.ComplianceRule{*stale})
+	require.NoError(t, err)
+	assert.Equal(t, 1, versions["rule-1"].Version)
+
+	// A rule without versions is left unversioned rather than given one by a worker
+	require.NoError(t, db.Create(&model.ComplianceRule{ID: "rule-3", Name: "Legacy", Type: model.RuleTypeRegexMustMatch, Pattern: "nda", Severity: "Low"}).Error)
+	doc := analyzedDocument(t, s, "contract.txt", "No NDA here.")
+
+	var count int64
+	require.NoError(t, db.Model(&model.ComplianceRuleVersion{}).Where("rule_id = ?", "rule-3").Count(&count).Error)
+	assert.Zero(t, count)
+	var result model.DocumentRuleResult
+	require.NoError(t, db.First(&result, "document_id = ? AND rule_id = ?", doc.ID, "rule-3").Error)
+	assert.Empty(t, result.RuleVersionID)
+}
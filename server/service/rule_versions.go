@@ -0,0 +1,225 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// ErrRuleVersionNotFound is returned when a rule has no version with the requested number
+var ErrRuleVersionNotFound = errors.New("compliance rule version not found")
+
+// RuleFieldChange is one field that differs between two rule versions
+type RuleFieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// RuleVersionDiff lists the definition changes between two versions of a rule
+type RuleVersionDiff struct {
+	RuleID  string                      `json:"rule_id"`
+	From    model.ComplianceRuleVersion `json:"from"`
+	To      model.ComplianceRuleVersion `json:"to"`
+	Changes []RuleFieldChange           `json:"changes"`
+}
+
+// ruleDefinitionChanged reports whether any versioned field differs between two rules
+func ruleDefinitionChanged(a, b model.ComplianceRule) bool {
+	return len(ruleFieldChanges(a, b)) > 0
+}
+
+// ruleFieldChanges lists the versioned fields that differ between two rule definitions
+func ruleFieldChanges(from, to model.ComplianceRule) []RuleFieldChange {
+	fields := []struct {
+		name     string
+		from, to string
+	}{
+		{"name", from.Name, to.Name},
+		{"description", from.Description, to.Description},
+		{"type", from.Type, to.Type},
+		{"params", canonicalParams(from.Params), canonicalParams(to.Params)},
+		{"pattern", from.Pattern, to.Pattern},
+		{"severity", from.Severity, to.Severity},
+		{"scope", canonicalParams(from.Scope), canonicalParams(to.Scope)},
+	}
+	changes := []RuleFieldChange{}
+	for _, field := range fields {
+		if field.from != field.to {
+			changes = append(changes, RuleFieldChange{Field: field.name, From: field.from, To: field.to})
+		}
+	}
+	return changes
+}
+
+// canonicalParams re-encodes params so equal settings compare equal regardless
+// of key order and spacing; an empty object counts as no params
+func canonicalParams(params datatypes.JSON) string {
+	var value interface{}
+	if len(params) == 0 || json.Unmarshal(params, &value) != nil {
+		return string(params)
+	}
+	if object, ok := value.(map[string]interface{}); ok && len(object) == 0 {
+		return ""
+	}
+	if value == nil {
+		return ""
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return string(params)
+	}
+	return string(canonical)
+}
+
+// recordRuleVersion stores the rule's current definition as its next version
+func (s *DocumentService) recordRuleVersion(tx *gorm.DB, rule model.ComplianceRule, effectiveFrom time.Time) (*model.ComplianceRuleVersion, error) {
+	var latest int
+	if err := tx.Model(&model.ComplianceRuleVersion{}).Where("rule_id = ?", rule.ID).
+		Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+		return nil, fmt.Errorf("failed to find latest rule version: %w", err)
+	}
+
+	version := model.ComplianceRuleVersion{
+		RuleID:        rule.ID,
+		Version:       latest + 1,
+		Name:          rule.Name,
+		Description:   rule.Description,
+		Type:          rule.Type,
+		Params:        rule.Params,
+		Pattern:       rule.Pattern,
+		Severity:      rule.Severity,
+		Scope:         rule.Scope,
+		EffectiveFrom: effectiveFrom,
+		CreatedAt:     time.Now(),
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return nil, fmt.Errorf("failed to record rule version: %w", err)
+	}
+	log.Printf("Recorded version %d of rule %s", version.Version, rule.ID)
+	return &version, nil
+}
+
+// ruleVersionsAt returns, keyed by rule ID, the version of each rule in effect
+// at the given time. Rules that have never been versioned get their current
+// definition recorded as version 1, effective since the rule was created.
+func (s *DocumentService) ruleVersionsAt(tx *gorm.DB, rules []model.ComplianceRule, at time.Time) (map[string]model.ComplianceRuleVersion, error) {
+	ids := make([]string, len(rules))
+	for i, rule := range rules {
+		ids[i] = rule.ID
+	}
+
+	var versions []model.ComplianceRuleVersion
+	if err := tx.Where("rule_id IN ? AND effective_from <= ?", ids, at).Order("version").Find(&versions).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch rule versions: %w", err)
+	}
+	effective := make(map[string]model.ComplianceRuleVersion, len(rules))
+	for _, version := range versions {
+		effective[version.RuleID] = version // Ordered by version, so the newest wins
+	}
+
+	for _, rule := range rules {
+		if _, ok := effective[rule.ID]; ok {
+			continue
+		}
+		since := at
+		if !rule.CreatedAt.IsZero() && rule.CreatedAt.Before(at) {
+			since = rule.CreatedAt
+		}
+		version, err := s.recordRuleVersion(tx, rule, since)
+		if err != nil {
+			return nil, err
+		}
+		effective[rule.ID] = *version
+	}
+	return effective, nil
+}
+
+// currentRuleVersions returns, keyed by rule ID, the recorded version matching
+// each rule's definition as loaded, or its newest version if the rule changed
+// since. It only reads: versions are recorded when rules are created or
+// updated, so a rule without any version is left out.
+func (s *DocumentService) currentRuleVersions(db *gorm.DB, rules []model.ComplianceRule) (map[string]model.ComplianceRuleVersion, error) {
+	ids := make([]string, len(rules))
+	for i, rule := range rules {
+		ids[i] = rule.ID
+	}
+	var versions []model.ComplianceRuleVersion
+	if err := db.Where("rule_id IN ?", ids).Order("version").Find(&versions).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch rule versions: %w", err)
+	}
+
+	byID := make(map[string]model.ComplianceRule, len(rules))
+	for _, rule := range rules {
+		byID[rule.ID] = rule
+	}
+	current := make(map[string]model.ComplianceRuleVersion, len(rules))
+	matched := make(map[string]bool, len(rules))
+	for _, version := range versions {
+		// Ordered by version, so the newest matching definition wins
+		matches := !ruleDefinitionChanged(versionDefinition(version), byID[version.RuleID])
+		if matches || !matched[version.RuleID] {
+			current[version.RuleID] = version
+			matched[version.RuleID] = matches
+		}
+	}
+	return current, nil
+}
+
+// GetRuleVersions returns the history of a rule, oldest version first
+func (s *DocumentService) GetRuleVersions(ruleID string) ([]model.ComplianceRuleVersion, error) {
+	if _, err := s.GetComplianceRule(ruleID); err != nil {
+		return nil, err
+	}
+
+	var versions []model.ComplianceRuleVersion
+	if err := s.db.Where("rule_id = ?", ruleID).Order("version").Find(&versions).Error; err != nil {
+		log.Printf("[GetRuleVersions] Error fetching versions of rule %s: %v", ruleID, err)
+		return nil, err
+	}
+	return versions, nil
+}
+
+// DiffRuleVersions compares two versions of a rule field by field
+func (s *DocumentService) DiffRuleVersions(ruleID string, from, to int) (*RuleVersionDiff, error) {
+	versions, err := s.GetRuleVersions(ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &RuleVersionDiff{RuleID: ruleID, Changes: []RuleFieldChange{}}
+	var foundFrom, foundTo bool
+	for _, version := range versions {
+		if version.Version == from {
+			diff.From, foundFrom = version, true
+		}
+		if version.Version == to {
+			diff.To, foundTo = version, true
+		}
+	}
+	if !foundFrom || !foundTo {
+		return nil, ErrRuleVersionNotFound
+	}
+
+	diff.Changes = ruleFieldChanges(versionDefinition(diff.From), versionDefinition(diff.To))
+	return diff, nil
+}
+
+// versionDefinition returns the rule definition a version recorded
+func versionDefinition(version model.ComplianceRuleVersion) model.ComplianceRule {
+	return model.ComplianceRule{
+		Name:        version.Name,
+		Description: version.Description,
+		Type:        version.Type,
+		Params:      version.Params,
+		Pattern:     version.Pattern,
+		Severity:    version.Severity,
+		Scope:       version.Scope,
+	}
+}
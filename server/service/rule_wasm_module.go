@@ -0,0 +1,409 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+const (
+	// wasmModulePrefix is where uploaded rule modules are kept in the blob store
+	wasmModulePrefix = "rule-modules/"
+	// wasmDigestPrefix starts the module reference stored in a wasm rule's Pattern
+	wasmDigestPrefix = "sha256:"
+	// MaxWASMModuleSize caps the size of an uploaded rule module
+	MaxWASMModuleSize = 32 << 20
+	// maxWASMOutputSize caps the result a module may return
+	maxWASMOutputSize = 1 << 20
+	// wasmPageSize is the size of one page of WebAssembly linear memory
+	wasmPageSize = 64 << 10
+)
+
+var (
+	// ErrInvalidWASMModule is returned for uploads that are not usable rule modules
+	ErrInvalidWASMModule = errors.New("invalid WASM module")
+	// ErrWASMRulesDisabled is returned when no module store is configured
+	ErrWASMRulesDisabled = errors.New("WASM rules are not enabled")
+
+	// wasmCompilationCache shares compiled code between evaluators
+	wasmCompilationCache = wazero.NewCompilationCache()
+)
+
+// wasmParams are the rule params the host reads; the whole params object is
+// also passed to the module
+type wasmParams struct {
+	TimeoutMS int `json:"timeout_ms"`
+}
+
+// wasmResult is what a module returns, in the shape of CheckRuleCompliance
+type wasmResult struct {
+	Status               string                 `json:"status"`
+	Explanation          string                 `json:"explanation"`
+	ConfidenceScore      *float64               `json:"confidence_score"`
+	NonComplianceDetails map[string]interface{} `json:"non_compliance_details"`
+	Spans                []TextSpan             `json:"spans"`
+}
+
+// WASMEvaluator checks rules implemented by uploaded WebAssembly modules. The
+// rule's Pattern holds the module digest (sha256:<hex>) returned by
+// StoreModule. Each evaluation runs in a fresh instance without file system,
+// network or environment access, with linear memory capped by the configured
+// limit and execution cancelled after the timeout.
+//
+// A module exports its memory and two functions:
+//
+//	alloc(size i32) -> i32             returns a buffer of size bytes for the input
+//	evaluate(ptr i32, len i32) -> i64  reads the input, returns ptr<<32 | len of the output
+//
+// The input is a JSON object with the document facts CEL rules see (text,
+// title, document_type, page_count, entities, rules), plus rule_name, params
+// (the rule's params) and parsed_data (the results of the other rules so far).
+// The output is a JSON object with status ("pass" or "fail"), explanation and
+// optionally confidence_score, non_compliance_details and spans. Modules built
+// for WASI (GOOS=wasip1, wasm32-wasi) are supported as reactors.
+type WASMEvaluator struct {
+	store   BlobStore
+	runtime wazero.Runtime
+	timeout time.Duration
+	// memoryLimit is the most linear memory one instance may use, in bytes
+	memoryLimit uint64
+	modules     sync.Map // digest -> wazero.CompiledModule
+}
+
+// NewWASMEvaluator loads modules from store and runs them within the limits
+func NewWASMEvaluator(store BlobStore, memoryLimitMB int, timeout time.Duration) (*WASMEvaluator, error) {
+	ctx := context.Background()
+	pages := uint32(memoryLimitMB * (1 << 20) / wasmPageSize)
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(pages).
+		WithCloseOnContextDone(true).
+		WithCompilationCache(wasmCompilationCache))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to set up WASI: %w", err)
+	}
+	return &WASMEvaluator{store: store, runtime: runtime, timeout: timeout, memoryLimit: uint64(pages) * wasmPageSize}, nil
+}
+
+// Close releases the runtime and every compiled module
+func (e *WASMEvaluator) Close(ctx context.Context) error {
+	if e.runtime == nil {
+		return nil
+	}
+	return e.runtime.Close(ctx)
+}
+
+// ReadsRuleOutcomes makes module rules run after the rules whose results they receive
+func (e *WASMEvaluator) ReadsRuleOutcomes() bool { return true }
+
+// StoreModule checks that body is a usable rule module, stores it and returns
+// the digest to use as the Pattern of wasm rules
+func (e *WASMEvaluator) StoreModule(ctx context.Context, body []byte) (string, error) {
+	if e.runtime == nil {
+		return "", ErrWASMRulesDisabled
+	}
+	if len(body) > MaxWASMModuleSize {
+		return "", fmt.Errorf("%w: module is larger than %d MB", ErrInvalidWASMModule, MaxWASMModuleSize>>20)
+	}
+	sum := sha256.Sum256(body)
+	digest := wasmDigestPrefix + hex.EncodeToString(sum[:])
+
+	compiled, err := e.compile(ctx, body)
+	if err != nil {
+		return "", err
+	}
+	if err := e.store.Put(ctx, wasmModuleKey(digest), body, "application/wasm"); err != nil {
+		compiled.Close(ctx)
+		return "", fmt.Errorf("failed to store WASM module: %w", err)
+	}
+	if _, loaded := e.modules.LoadOrStore(digest, compiled); loaded {
+		compiled.Close(ctx)
+	}
+	log.Printf("Stored WASM rule module %s (%d bytes)", digest, len(body))
+	return digest, nil
+}
+
+// UploadRuleModule stores a WASM rule module with this service's evaluator and
+// returns its digest
+func (s *DocumentService) UploadRuleModule(ctx context.Context, body []byte) (string, error) {
+	evaluator, _ := s.ruleEvaluatorFor(model.RuleTypeWASM)
+	modules, ok := evaluator.(*WASMEvaluator)
+	if !ok {
+		return "", ErrWASMRulesDisabled
+	}
+	return modules.StoreModule(ctx, body)
+}
+
+// ValidateRule checks that the rule refers to a stored, usable module
+func (e *WASMEvaluator) ValidateRule(rule model.ComplianceRule) error {
+	if e.runtime == nil {
+		return ErrWASMRulesDisabled
+	}
+	var params wasmParams
+	if len(rule.Params) > 0 {
+		if err := json.Unmarshal(rule.Params, &params); err != nil {
+			return fmt.Errorf("invalid params: %w", err)
+		}
+	}
+	if params.TimeoutMS < 0 {
+		return errors.New("params.timeout_ms must not be negative")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := e.module(ctx, rule.Pattern)
+	return err
+}
+
+// wasmModuleKey is the blob key of the module with the given digest
+func wasmModuleKey(digest string) string {
+	return wasmModulePrefix + strings.TrimPrefix(digest, wasmDigestPrefix) + ".wasm"
+}
+
+// module returns the compiled module for a digest, loading it from the store once
+func (e *WASMEvaluator) module(ctx context.Context, digest string) (wazero.CompiledModule, error) {
+	digest = strings.TrimSpace(digest)
+	if cached, ok := e.modules.Load(digest); ok {
+		return cached.(wazero.CompiledModule), nil
+	}
+	hexDigest := strings.TrimPrefix(digest, wasmDigestPrefix)
+	if decoded, err := hex.DecodeString(hexDigest); err != nil || len(decoded) != sha256.Size || !strings.HasPrefix(digest, wasmDigestPrefix) {
+		return nil, fmt.Errorf("pattern must be a module digest like sha256:<64 hex digits>, not %q", digest)
+	}
+
+	reader, _, err := e.store.Get(ctx, wasmModuleKey(digest))
+	if errors.Is(err, ErrBlobNotFound) {
+		return nil, fmt.Errorf("WASM module %s has not been uploaded", digest)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load WASM module %s: %w", digest, err)
+	}
+	defer reader.Close()
+	body, err := io.ReadAll(io.LimitReader(reader, MaxWASMModuleSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load WASM module %s: %w", digest, err)
+	}
+	if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != hexDigest {
+		return nil, fmt.Errorf("stored WASM module %s does not match its digest", digest)
+	}
+
+	compiled, err := e.compile(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	if cached, loaded := e.modules.LoadOrStore(digest, compiled); loaded {
+		compiled.Close(ctx)
+		return cached.(wazero.CompiledModule), nil
+	}
+	return compiled, nil
+}
+
+// compile compiles a module and checks that it implements the rule ABI
+func (e *WASMEvaluator) compile(ctx context.Context, body []byte) (wazero.CompiledModule, error) {
+	compiled, err := e.runtime.CompileModule(ctx, body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWASMModule, err)
+	}
+	if err := checkWASMExports(compiled); err != nil {
+		compiled.Close(ctx)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWASMModule, err)
+	}
+	return compiled, nil
+}
+
+// checkWASMExports verifies the memory, alloc and evaluate exports
+func checkWASMExports(compiled wazero.CompiledModule) error {
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		return errors.New(`module must export its memory as "memory"`)
+	}
+	want := map[string][2][]api.ValueType{
+		"alloc":    {{api.ValueTypeI32}, {api.ValueTypeI32}},
+		"evaluate": {{api.ValueTypeI32, api.ValueTypeI32}, {api.ValueTypeI64}},
+	}
+	functions := compiled.ExportedFunctions()
+	for _, name := range []string{"alloc", "evaluate"} {
+		fn, ok := functions[name]
+		if !ok {
+			return fmt.Errorf("module must export a function %q", name)
+		}
+		if !bytes.Equal(fn.ParamTypes(), want[name][0]) || !bytes.Equal(fn.ResultTypes(), want[name][1]) {
+			return fmt.Errorf("function %q has signature %v -> %v, want %v -> %v", name,
+				valueTypeNames(fn.ParamTypes()), valueTypeNames(fn.ResultTypes()),
+				valueTypeNames(want[name][0]), valueTypeNames(want[name][1]))
+		}
+	}
+	return nil
+}
+
+// valueTypeNames spells out WebAssembly value types for error messages
+func valueTypeNames(types []api.ValueType) []string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = api.ValueTypeName(t)
+	}
+	return names
+}
+
+// Evaluate runs each rule's module against the document
+func (e *WASMEvaluator) Evaluate(ctx context.Context, req RuleEvaluationRequest) (map[string]RuleOutcome, error) {
+	facts := documentFacts(req)
+	names := make([]string, 0, len(req.Outcomes))
+	for name := range req.Outcomes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parsedData := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		parsedData = append(parsedData, map[string]interface{}{
+			"rule_name":   name,
+			"status":      req.Outcomes[name].Status,
+			"explanation": req.Outcomes[name].Explanation,
+		})
+	}
+
+	outcomes := make(map[string]RuleOutcome, len(req.Rules))
+	for _, rule := range req.Rules {
+		outcome, err := e.evaluate(ctx, rule, req.Text, facts, parsedData)
+		if err != nil {
+			log.Printf("[WASMEvaluator] Rule %s could not be evaluated: %v", rule.Name, err)
+			outcome = RuleOutcome{Status: RuleStatusIndeterminate, Explanation: fmt.Sprintf("Rule '%s' could not be evaluated: %v", rule.Name, err)}
+		}
+		outcomes[rule.ID] = outcome
+	}
+	return outcomes, nil
+}
+
+// evaluate runs one rule's module in a fresh, sandboxed instance
+func (e *WASMEvaluator) evaluate(ctx context.Context, rule model.ComplianceRule, text string, facts map[string]interface{}, parsedData []map[string]interface{}) (RuleOutcome, error) {
+	if e.runtime == nil {
+		return RuleOutcome{}, ErrWASMRulesDisabled
+	}
+	compiled, err := e.module(ctx, rule.Pattern)
+	if err != nil {
+		return RuleOutcome{}, err
+	}
+
+	var params wasmParams
+	input := make(map[string]interface{}, len(facts)+3)
+	for key, value := range facts {
+		input[key] = value
+	}
+	input["rule_name"] = rule.Name
+	input["parsed_data"] = parsedData
+	input["params"] = map[string]interface{}{}
+	if len(rule.Params) > 0 {
+		if err := json.Unmarshal(rule.Params, &params); err != nil {
+			return RuleOutcome{}, fmt.Errorf("invalid params: %w", err)
+		}
+		input["params"] = json.RawMessage(rule.Params)
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return RuleOutcome{}, fmt.Errorf("failed to encode module input: %w", err)
+	}
+
+	timeout := e.timeout
+	if limit := time.Duration(params.TimeoutMS) * time.Millisecond; limit > 0 && limit < timeout {
+		timeout = limit
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	output, err := e.call(ctx, compiled, body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return RuleOutcome{}, fmt.Errorf("module exceeded its time limit of %s", timeout)
+		}
+		return RuleOutcome{}, err
+	}
+	return wasmOutcome(rule, text, output)
+}
+
+// call instantiates the module, passes it the input and copies out its result
+func (e *WASMEvaluator) call(ctx context.Context, compiled wazero.CompiledModule, input []byte) ([]byte, error) {
+	// An empty name lets several instances of the same module run at once
+	instance, err := e.runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start module: %w", err)
+	}
+	defer instance.Close(context.Background())
+
+	allocated, err := instance.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("module failed to allocate its input: %w", err)
+	}
+	ptr := uint32(allocated[0])
+	if !instance.Memory().Write(ptr, input) {
+		return nil, fmt.Errorf("module allocated an input buffer outside its memory")
+	}
+
+	returned, err := instance.ExportedFunction("evaluate").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		// Allocators trap when memory cannot grow further; name the cause
+		if uint64(instance.Memory().Size())+wasmPageSize > e.memoryLimit {
+			return nil, fmt.Errorf("module ran out of memory (limit %d MB): %w", e.memoryLimit>>20, err)
+		}
+		return nil, fmt.Errorf("module failed: %w", err)
+	}
+	outPtr, outLen := uint32(returned[0]>>32), uint32(returned[0])
+	if outLen > maxWASMOutputSize {
+		return nil, fmt.Errorf("module returned %d bytes, more than the %d allowed", outLen, maxWASMOutputSize)
+	}
+	output, ok := instance.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("module returned a result outside its memory")
+	}
+	// The view is only valid until the instance is closed
+	return bytes.Clone(output), nil
+}
+
+// wasmOutcome reads a module's result, treating any status but pass as a
+// failure and keeping only spans that lie within the text
+func wasmOutcome(rule model.ComplianceRule, text string, output []byte) (RuleOutcome, error) {
+	var result wasmResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		return RuleOutcome{}, fmt.Errorf("module returned invalid JSON: %w", err)
+	}
+
+	outcome := RuleOutcome{Status: RuleStatusFail, Explanation: result.Explanation}
+	if result.Status == RuleStatusPass {
+		outcome.Status = RuleStatusPass
+	}
+	if outcome.Explanation == "" {
+		outcome.Explanation = fmt.Sprintf("The '%s' module returned %s.", rule.Name, outcome.Status)
+	}
+	for _, span := range result.Spans {
+		if span.Start < 0 || span.End < span.Start || span.End > len(text) || len(outcome.Spans) == maxSpans {
+			continue
+		}
+		span.Text = text[span.Start:span.End]
+		outcome.Spans = append(outcome.Spans, span)
+	}
+
+	if result.ConfidenceScore != nil || len(result.NonComplianceDetails) > 0 {
+		outcome.Details = map[string]interface{}{}
+		if result.ConfidenceScore != nil {
+			outcome.Details["confidence_score"] = *result.ConfidenceScore
+		}
+		if len(result.NonComplianceDetails) > 0 {
+			outcome.Details["non_compliance_details"] = result.NonComplianceDetails
+		}
+	}
+	return outcome, nil
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+const numberedContract = `1. Definitions
+Terms used here have the meaning given in Section 2.
+
+2. Services
+The Provider shall perform the services. Signed by both parties.
+
+3. Term
+This Agreement runs for one year unless terminated under Section 2.`
+
+var (
+	clauseCheckOnce sync.Once
+	clauseCheckWASM []byte
+	clauseCheckErr  error
+)
+
+// clauseCheckModule builds the sample module in testdata/wasm/clausecheck once per run
+func clauseCheckModule(t *testing.T) []byte {
+	t.Helper()
+	clauseCheckOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "clausecheck")
+		if err != nil {
+			clauseCheckErr = err
+			return
+		}
+		defer os.RemoveAll(dir)
+		out := filepath.Join(dir, "clausecheck.wasm")
+		cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", out, "./testdata/wasm/clausecheck")
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			clauseCheckErr = errors.New(string(output))
+			return
+		}
+		clauseCheckWASM, clauseCheckErr = os.ReadFile(out)
+	})
+	if clauseCheckErr != nil {
+		t.Skipf("cannot build the sample WASM module: %v", clauseCheckErr)
+	}
+	return clauseCheckWASM
+}
+
+// newWASMEvaluator creates an evaluator storing modules in a temporary blob store
+func newWASMEvaluator(t *testing.T, timeout time.Duration) *WASMEvaluator {
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+	evaluator, err := NewWASMEvaluator(blobs, 64, timeout)
+	require.NoError(t, err)
+	t.Cleanup(func() { evaluator.Close(context.Background()) })
+	return evaluator
+}
+
+func TestWASMEvaluator(t *testing.T) {
+	modules := newWASMEvaluator(t, 10*time.Second)
+	digest, err := modules.StoreModule(context.Background(), clauseCheckModule(t))
+	require.NoError(t, err)
+	assert.Regexp(t, `^sha256:[0-9a-f]{64}$`, digest)
+
+	tests := []struct {
+		name        string
+		text        string
+		params      string
+		wantStatus  string
+		wantInText  string
+		wantSpans   []string
+		wantDetails bool
+	}{
+		{name: "numbered in order", text: numberedContract, wantStatus: RuleStatusPass, wantInText: "3 clauses are numbered in order"},
+		{
+			name:        "gap and dangling reference",
+			text:        "1. Definitions\nSee Section 4.\n\n3. Term\nOne year.",
+			wantStatus:  RuleStatusFail,
+			wantInText:  "2 numbering problems",
+			wantSpans:   []string{"3", "Section 4"},
+			wantDetails: true,
+		},
+		{name: "reads parsed data", text: "1. Term\nOne year.", params: `{"require_signature": true}`, wantStatus: RuleStatusFail, wantInText: "unsigned document"},
+		{name: "time limit", text: numberedContract, params: `{"spin": true, "timeout_ms": 200}`, wantStatus: RuleStatusIndeterminate, wantInText: "time limit of 200ms"},
+		{name: "memory limit", text: numberedContract, params: `{"hog": true}`, wantStatus: RuleStatusIndeterminate, wantInText: "ran out of memory (limit 64 MB)"},
+	}
+
+	s := &DocumentService{llm: NewFakeLLMClient()}
+	s.RegisterRuleEvaluator(model.RuleTypeWASM, modules)
+	signature := model.ComplianceRule{ID: "signature", Name: "Signature Present", Type: model.RuleTypeRegexMustMatch, Pattern: `signed by`}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := model.ComplianceRule{ID: "wasm", Name: "Clause Numbering", Type: model.RuleTypeWASM, Pattern: digest}
+			if tt.params != "" {
+				rule.Params = datatypes.JSON(tt.params)
+			}
+			require.NoError(t, modules.ValidateRule(rule))
+
+			outcomes, err := s.evaluateRules(context.Background(), RuleEvaluationRequest{Text: tt.text}, []model.ComplianceRule{rule, signature})
+			require.NoError(t, err)
+			outcome := outcomes["wasm"]
+			assert.Equal(t, tt.wantStatus, outcome.Status, outcome.Explanation)
+			assert.Contains(t, outcome.Explanation, tt.wantInText)
+
+			var spans []string
+			for _, span := range outcome.Spans {
+				assert.Equal(t, tt.text[span.Start:span.End], span.Text)
+				spans = append(spans, span.Text)
+			}
+			assert.Equal(t, tt.wantSpans, spans)
+			if tt.wantDetails {
+				assert.Equal(t, 1.0, outcome.Details["confidence_score"])
+				assert.Contains(t, outcome.Details["non_compliance_details"], "problems")
+			}
+		})
+	}
+}
+
+func TestWASMEvaluator_RejectsUnusableModules(t *testing.T) {
+	modules := newWASMEvaluator(t, time.Second)
+	ctx := context.Background()
+
+	_, err := modules.StoreModule(ctx, []byte("not a module"))
+	assert.ErrorIs(t, err, ErrInvalidWASMModule)
+
+	// An empty but valid module lacks the rule exports
+	_, err = modules.StoreModule(ctx, []byte("\x00asm\x01\x00\x00\x00"))
+	assert.ErrorIs(t, err, ErrInvalidWASMModule)
+	assert.ErrorContains(t, err, `"memory"`)
+
+	for _, pattern := range []string{"", "clausecheck.wasm", "sha256:abc", "sha256:" + strings.Repeat("0", 64)} {
+		assert.Error(t, modules.ValidateRule(model.ComplianceRule{Pattern: pattern}), pattern)
+	}
+
+	assert.ErrorIs(t, (&WASMEvaluator{}).ValidateRule(model.ComplianceRule{}), ErrWASMRulesDisabled)
+	_, err = (&DocumentService{}).UploadRuleModule(ctx, clauseCheckModule(t))
+	assert.ErrorIs(t, err, ErrWASMRulesDisabled)
+}
+
+func TestWASMRules_AreStoredWithEvidence(t *testing.T) {
+	text := "1. Definitions\nSee Section 4.\n\n2. Term\nOne year."
+	s, db, blobs := newPipelineService(t, &stubOCRProvider{name: "local", text: text}, 1)
+	modules, err := NewWASMEvaluator(blobs, 64, 10*time.Second)
+	require.NoError(t, err)
+	t.Cleanup(func() { modules.Close(context.Background()) })
+	s.RegisterRuleEvaluator(model.RuleTypeWASM, modules)
+
+	digest, err := s.UploadRuleModule(context.Background(), clauseCheckModule(t))
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.AddComplianceRule(context.Background(), &model.ComplianceRule{Name: "Clause Numbering", Type: model.RuleTypeWASM, Pattern: "sha256:unknown"}), ErrInvalidRule)
+	rule := &model.ComplianceRule{Name: "Clause Numbering", Type: model.RuleTypeWASM, Pattern: digest, Severity: "medium"}
+	require.NoError(t, s.AddComplianceRule(context.Background(), rule))
+
+	doc := analyzedDocument(t, s, "contract.txt", text)
+	stored := loadDocument(t, db, doc.ID)
+	var results []map[string]interface{}
+	require.NoError(t, json.Unmarshal(stored.ParsedData, &results))
+	var found bool
+	for _, result := range results {
+		if result["rule_id"] != rule.ID {
+			continue
+		}
+		found = true
+		assert.Equal(t, RuleStatusFail, result["status"])
+		assert.Equal(t, 1.0, result["confidence_score"])
+		assert.Contains(t, result["non_compliance_details"], "problems")
+		assert.Len(t, result["spans"], 1)
+	}
+	assert.True(t, found, "the module rule has a result")
+}
@@ -0,0 +1,352 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Itish41/LegalEagle/config"
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/elastic/go-elasticsearch/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Search backend names accepted by SEARCH_BACKEND
+const (
+	SearchBackendElasticsearch = "elasticsearch"
+	SearchBackendPostgres      = "postgres"
+)
+
+// searchResultLimit caps the number of hits returned by a search
+const searchResultLimit = 10
+
+// SearchDocument is the searchable view of a document
+type SearchDocument struct {
+	ID        string
+	Title     string
+	FileURL   string
+	OcrText   string
+	Timestamp time.Time
+}
+
+// source is the JSON shape returned for every search hit, whatever the backend
+func (d SearchDocument) source() map[string]interface{} {
+	return map[string]interface{}{
+		"file_id":   d.ID,
+		"title":     d.Title,
+		"file_url":  d.FileURL,
+		"ocr_text":  d.OcrText,
+		"timestamp": d.Timestamp.UTC(),
+	}
+}
+
+// SearchIndex provides full-text search over documents
+type SearchIndex interface {
+	// Name identifies the backend.
+	Name() string
+	// Index adds or replaces a document.
+	Index(ctx context.Context, doc SearchDocument) error
+	// Delete removes a document; deleting a missing document is not an error.
+	Delete(ctx context.Context, id string) error
+	// Search returns the best matching documents for a free-text query.
+	Search(ctx context.Context, query string) ([]map[string]interface{}, error)
+	// IDs returns the IDs of every indexed document.
+	IDs(ctx context.Context) ([]string, error)
+}
+
+// NewSearchIndex builds the configured backend. Without an explicit backend,
+// Elasticsearch is used when an API key is set and Postgres otherwise.
+func NewSearchIndex(cfg config.SearchConfig, db *gorm.DB) (SearchIndex, error) {
+	backend := cfg.Backend
+	if backend == "" {
+		backend = SearchBackendPostgres
+		if cfg.ElasticsearchAPIKey != "" {
+			backend = SearchBackendElasticsearch
+		}
+	}
+
+	switch backend {
+	case SearchBackendElasticsearch:
+		if cfg.ElasticsearchURL == "" || cfg.ElasticsearchAPIKey == "" {
+			return nil, fmt.Errorf("search backend is %q but the Elasticsearch URL or API key is not set", backend)
+		}
+		client, err := elasticsearch.NewClient(elasticsearch.Config{
+			Addresses: []string{cfg.ElasticsearchURL},
+			APIKey:    cfg.ElasticsearchAPIKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Elasticsearch client: %w", err)
+		}
+		return NewElasticsearchIndex(client, cfg.ElasticsearchIndex), nil
+	case SearchBackendPostgres:
+		return NewPostgresSearchIndex(db), nil
+	default:
+		return nil, fmt.Errorf("unknown search backend %q", backend)
+	}
+}
+
+// ElasticsearchIndex stores documents in an Elasticsearch index
+type ElasticsearchIndex struct {
+	client *elasticsearch.Client
+	index  string
+}
+
+// NewElasticsearchIndex uses the given index, "documents" by default
+func NewElasticsearchIndex(client *elasticsearch.Client, index string) *ElasticsearchIndex {
+	if index == "" {
+		index = "documents"
+	}
+	return &ElasticsearchIndex{client: client, index: index}
+}
+
+// Name returns the backend name
+func (e *ElasticsearchIndex) Name() string {
+	return SearchBackendElasticsearch
+}
+
+// Index stores the document under its ID
+func (e *ElasticsearchIndex) Index(ctx context.Context, doc SearchDocument) error {
+	body, err := json.Marshal(doc.source())
+	if err != nil {
+		return fmt.Errorf("failed to marshal document for indexing: %w", err)
+	}
+
+	res, err := e.client.Index(
+		e.index,
+		bytes.NewReader(body),
+		e.client.Index.WithDocumentID(doc.ID),
+		e.client.Index.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("index request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("elasticsearch indexing failed: %s", res.String())
+	}
+	return nil
+}
+
+// Delete removes the document with the given ID
+func (e *ElasticsearchIndex) Delete(ctx context.Context, id string) error {
+	res, err := e.client.Delete(e.index, id, e.client.Delete.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("delete request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != 404 {
+		return fmt.Errorf("elasticsearch delete failed: %s", res.String())
+	}
+	return nil
+}
+
+// Search runs a multi_match query over the title and OCR text
+func (e *ElasticsearchIndex) Search(ctx context.Context, query string) ([]map[string]interface{}, error) {
+	// Prepare the Elasticsearch query
+	searchQuery := map[string]interface{}{
+		"size": searchResultLimit,
+		"query": map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":  query,
+				"fields": []string{"title^2", "ocr_text", "file_id"}, // Search these fields
+			},
+		},
+	}
+	body, err := json.Marshal(searchQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal search query: %w", err)
+	}
+
+	// Execute the search
+	res, err := e.client.Search(
+		e.client.Search.WithContext(ctx),
+		e.client.Search.WithIndex(e.index),
+		e.client.Search.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("search request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("elasticsearch search failed: %s", res.String())
+	}
+
+	// Parse the response
+	var result map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode search response: %w", err)
+	}
+
+	// Safely extract hits
+	hitsMap, ok := result["hits"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid hits structure in search response")
+	}
+
+	hitsArray, ok := hitsMap["hits"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid hits array in search response")
+	}
+
+	documents := []map[string]interface{}{}
+	for _, hit := range hitsArray {
+		hitMap, ok := hit.(map[string]interface{})
+		if !ok {
+			continue // Skip invalid hits
+		}
+
+		source, ok := hitMap["_source"].(map[string]interface{})
+		if !ok {
+			continue // Skip hits without a valid source
+		}
+
+		documents = append(documents, source)
+	}
+
+	return documents, nil
+}
+
+// IDs scrolls through the whole index collecting document IDs
+func (e *ElasticsearchIndex) IDs(ctx context.Context) ([]string, error) {
+	res, err := e.client.Search(
+		e.client.Search.WithContext(ctx),
+		e.client.Search.WithIndex(e.index),
+		e.client.Search.WithBody(strings.NewReader(`{"_source": false, "query": {"match_all": {}}}`)),
+		e.client.Search.WithSize(1000),
+		e.client.Search.WithScroll(time.Minute),
+	)
+
+	var ids []string
+	var scrollID string
+	for {
+		if err != nil {
+			return nil, fmt.Errorf("scroll request failed: %w", err)
+		}
+		if res.StatusCode == 404 {
+			res.Body.Close()
+			return ids, nil // The index has not been created yet
+		}
+		if res.IsError() {
+			res.Body.Close()
+			return nil, fmt.Errorf("elasticsearch scroll failed: %s", res.String())
+		}
+
+		var page struct {
+			ScrollID string `json:"_scroll_id"`
+			Hits     struct {
+				Hits []struct {
+					ID string `json:"_id"`
+				} `json:"hits"`
+			} `json:"hits"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode scroll response: %w", err)
+		}
+
+		scrollID = page.ScrollID
+		if len(page.Hits.Hits) == 0 {
+			break
+		}
+		for _, hit := range page.Hits.Hits {
+			ids = append(ids, hit.ID)
+		}
+
+		res, err = e.client.Scroll(
+			e.client.Scroll.WithContext(ctx),
+			e.client.Scroll.WithScrollID(scrollID),
+			e.client.Scroll.WithScroll(time.Minute),
+		)
+	}
+
+	if scrollID != "" {
+		if res, err := e.client.ClearScroll(e.client.ClearScroll.WithScrollID(scrollID)); err == nil {
+			res.Body.Close()
+		}
+	}
+	return ids, nil
+}
+
+// PostgresSearchIndex searches the documents table through its generated
+// search_vector tsvector column, so there is nothing to index separately.
+type PostgresSearchIndex struct {
+	db *gorm.DB
+}
+
+// NewPostgresSearchIndex searches the documents table in db
+func NewPostgresSearchIndex(db *gorm.DB) *PostgresSearchIndex {
+	return &PostgresSearchIndex{db: db}
+}
+
+// Name returns the backend name
+func (p *PostgresSearchIndex) Name() string {
+	return SearchBackendPostgres
+}
+
+// Index is a no-op; search_vector is maintained by Postgres
+func (p *PostgresSearchIndex) Index(ctx context.Context, doc SearchDocument) error {
+	return nil
+}
+
+// Delete is a no-op; rows leave the index when they leave the table
+func (p *PostgresSearchIndex) Delete(ctx context.Context, id string) error {
+	return nil
+}
+
+// IDs returns the IDs of analyzed documents, the only rows Search returns
+func (p *PostgresSearchIndex) IDs(ctx context.Context) ([]string, error) {
+	var ids []string
+	if err := p.db.WithContext(ctx).Table("documents").Where("processing_status = ?", model.DocumentStatusAnalyzed).Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to list document IDs: %w", err)
+	}
+	return ids, nil
+}
+
+// Search ranks analyzed documents matching the query by title and OCR text
+// relevance; documents still being processed are left out, as they are from
+// the Elasticsearch index
+func (p *PostgresSearchIndex) Search(ctx context.Context, query string) ([]map[string]interface{}, error) {
+	var rows []struct {
+		ID          string
+		Title       string
+		OriginalURL string
+		OcrText     string
+		CreatedAt   time.Time
+	}
+	err := p.db.WithContext(ctx).
+		Table("documents").
+		Select("id, title, original_url, ocr_text, created_at").
+		Where("processing_status = ?", model.DocumentStatusAnalyzed).
+		Where("search_vector @@ websearch_to_tsquery('english', ?)", query).
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "ts_rank(search_vector, websearch_to_tsquery('english', ?)) DESC",
+			Vars: []interface{}{query},
+		}}).
+		Limit(searchResultLimit).
+		Scan(&rows).Error
+	if err != nil {
+		log.Printf("Postgres full-text search error: %v", err)
+		return nil, fmt.Errorf("search request failed: %w", err)
+	}
+
+	documents := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		documents = append(documents, SearchDocument{
+			ID:        row.ID,
+			Title:     row.Title,
+			FileURL:   row.OriginalURL,
+			OcrText:   row.OcrText,
+			Timestamp: row.CreatedAt,
+		}.source())
+	}
+	return documents, nil
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Itish41/LegalEagle/config"
+	model "github.com/Itish41/LegalEagle/models"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// newFakeElasticsearch serves canned responses and records request paths and bodies
+func newFakeElasticsearch(t *testing.T, searchResponse string) (*elasticsearch.Client, *[]string, *[]string) {
+	var paths, bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		bodies = append(bodies, string(body))
+
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"result": "not_found"}`))
+		case http.MethodPut:
+			w.Write([]byte(`{"result": "created"}`))
+		default:
+			w.Write([]byte(searchResponse))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	require.NoError(t, err)
+	return client, &paths, &bodies
+}
+
+func TestElasticsearchIndex(t *testing.T) {
+	ctx := context.Background()
+	client, paths, bodies := newFakeElasticsearch(t, `{"hits": {"hits": [
+		{"_id": "doc-1", "_source": {"file_id": "doc-1", "title": "NDA", "file_url": "/api/documents/doc-1/download", "ocr_text": "Confidential"}},
+		{"_id": "bad"}
+	]}}`)
+	index := NewElasticsearchIndex(client, "")
+
+	doc := SearchDocument{ID: "doc-1", Title: "NDA", FileURL: "/api/documents/doc-1/download", OcrText: "Confidential", Timestamp: time.Now()}
+	require.NoError(t, index.Index(ctx, doc))
+	assert.Equal(t, "PUT /documents/_doc/doc-1", (*paths)[0])
+
+	var indexed map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte((*bodies)[0]), &indexed))
+	assert.Equal(t, "NDA", indexed["title"])
+	assert.Equal(t, "doc-1", indexed["file_id"])
+
+	results, err := index.Search(ctx, "confidential")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "doc-1", results[0]["file_id"])
+	assert.Contains(t, (*bodies)[1], `"title^2"`)
+
+	assert.NoError(t, index.Delete(ctx, "missing"), "deleting a missing document is not an error")
+}
+
+func TestPostgresSearchIndex_OnlyAnalyzedDocuments(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	for id, status := range map[string]string{"doc-done": model.DocumentStatusAnalyzed, "doc-queued": model.DocumentStatusQueued, "doc-failed": model.DocumentStatusFailed} {
+		require.NoError(t, db.Create(&model.Document{ID: id, Title: id, ProcessingStatus: status}).Error)
+	}
+
+	ids, err := NewPostgresSearchIndex(db).IDs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"doc-done"}, ids)
+
+	// SQLite has no full-text search, so check the query Search would run
+	var query string
+	var vars []interface{}
+	require.NoError(t, db.Callback().Row().After("gorm:row").Register("test:capture", func(tx *gorm.DB) {
+		query, vars = tx.Statement.SQL.String(), tx.Statement.Vars
+	}))
+	_, err = NewPostgresSearchIndex(db).Search(ctx, "confidential")
+	assert.Error(t, err, "SQLite cannot run the query")
+	assert.Contains(t, query, "processing_status = ")
+	assert.Contains(t, vars, model.DocumentStatusAnalyzed)
+}
+
+func TestNewSearchIndex(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config.SearchConfig
+		wantBackend string
+		wantErr     bool
+	}{
+		{name: "Postgres without Elasticsearch key", cfg: config.SearchConfig{}, wantBackend: SearchBackendPostgres},
+		{name: "Elasticsearch when key is set", cfg: config.SearchConfig{ElasticsearchURL: "http://localhost:9200", ElasticsearchAPIKey: "key"}, wantBackend: SearchBackendElasticsearch},
+		{name: "Explicit Postgres", cfg: config.SearchConfig{Backend: SearchBackendPostgres, ElasticsearchAPIKey: "key"}, wantBackend: SearchBackendPostgres},
+		{name: "Explicit Elasticsearch without key", cfg: config.SearchConfig{Backend: SearchBackendElasticsearch, ElasticsearchURL: "http://localhost:9200"}, wantErr: true},
+		{name: "Unknown backend", cfg: config.SearchConfig{Backend: "solr"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := NewSearchIndex(tt.cfg, nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBackend, index.Name())
+		})
+	}
+}
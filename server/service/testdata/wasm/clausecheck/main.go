@@ -0,0 +1,132 @@
+//go:build wasip1
+
+// Command clausecheck is a sample WASM rule. It checks that top-level clauses
+// are numbered 1, 2, 3... without gaps and that every "Section N" cross
+// reference points at an existing clause. Build it with
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o clausecheck.wasm
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"unsafe"
+)
+
+var (
+	clauseHeading  = regexp.MustCompile(`(?m)^\s*(\d+)\.\s+\S`)
+	crossReference = regexp.MustCompile(`Section (\d+)`)
+)
+
+// buffers keeps allocations handed to the host reachable
+var buffers = map[uint32][]byte{}
+
+type span struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+type input struct {
+	Text       string            `json:"text"`
+	Rules      map[string]string `json:"rules"`
+	ParsedData []struct {
+		RuleName string `json:"rule_name"`
+		Status   string `json:"status"`
+	} `json:"parsed_data"`
+	Params struct {
+		Spin      bool `json:"spin"`
+		Hog       bool `json:"hog"`
+		Signature bool `json:"require_signature"`
+	} `json:"params"`
+}
+
+type output struct {
+	Status               string                 `json:"status"`
+	Explanation          string                 `json:"explanation"`
+	ConfidenceScore      float64                `json:"confidence_score"`
+	NonComplianceDetails map[string]interface{} `json:"non_compliance_details,omitempty"`
+	Spans                []span                 `json:"spans,omitempty"`
+}
+
+//go:wasmexport alloc
+func alloc(size uint32) uint32 {
+	buf := make([]byte, size)
+	ptr := uint32(uintptr(unsafe.Pointer(unsafe.SliceData(buf))))
+	buffers[ptr] = buf
+	return ptr
+}
+
+//go:wasmexport evaluate
+func evaluate(ptr, length uint32) uint64 {
+	var in input
+	if err := json.Unmarshal(buffers[ptr][:length], &in); err != nil {
+		return respond(output{Status: "fail", Explanation: err.Error()})
+	}
+	delete(buffers, ptr)
+	return respond(check(in))
+}
+
+// respond keeps the encoded output alive and packs its location for the host
+func respond(out output) uint64 {
+	body, _ := json.Marshal(out)
+	ptr := alloc(uint32(len(body)))
+	copy(buffers[ptr], body)
+	return uint64(ptr)<<32 | uint64(len(body))
+}
+
+func check(in input) output {
+	if in.Params.Spin {
+		for {
+		}
+	}
+	if in.Params.Hog {
+		var hoard [][]byte
+		for {
+			hoard = append(hoard, make([]byte, 1<<20))
+		}
+	}
+	if in.Params.Signature {
+		for _, result := range in.ParsedData {
+			if result.RuleName == "Signature Present" && result.Status != "pass" {
+				return output{Status: "fail", Explanation: "clauses cannot be checked on an unsigned document"}
+			}
+		}
+	}
+
+	clauses := map[int]bool{}
+	var problems []string
+	var spans []span
+	expected := 1
+	for _, loc := range clauseHeading.FindAllStringSubmatchIndex(in.Text, -1) {
+		n, _ := strconv.Atoi(in.Text[loc[2]:loc[3]])
+		clauses[n] = true
+		if n != expected {
+			problems = append(problems, fmt.Sprintf("clause %d follows clause %d", n, expected-1))
+			spans = append(spans, span{Start: loc[2], End: loc[3], Text: in.Text[loc[2]:loc[3]]})
+		}
+		expected = n + 1
+	}
+	for _, loc := range crossReference.FindAllStringSubmatchIndex(in.Text, -1) {
+		n, _ := strconv.Atoi(in.Text[loc[2]:loc[3]])
+		if !clauses[n] {
+			problems = append(problems, fmt.Sprintf("Section %d does not exist", n))
+			spans = append(spans, span{Start: loc[0], End: loc[1], Text: in.Text[loc[0]:loc[1]]})
+		}
+	}
+
+	if len(problems) == 0 {
+		return output{Status: "pass", Explanation: fmt.Sprintf("%d clauses are numbered in order and every cross reference resolves", len(clauses)), ConfidenceScore: 1}
+	}
+	return output{
+		Status:               "soft_fail",
+		Explanation:          fmt.Sprintf("%d numbering problems", len(problems)),
+		ConfidenceScore:      1,
+		NonComplianceDetails: map[string]interface{}{"problems": problems},
+		Spans:                spans,
+	}
+}
+
+func main() {}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testSchema mirrors db/migrations closely enough for service tests on SQLite
+var testSchema = []string{
+	`CREATE TABLE documents (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+		title TEXT,
+		file_type TEXT,
+		original_url TEXT,
+		storage_key TEXT,
+		ocr_text TEXT,
+		ocr_provider TEXT,
+		tags TEXT,
+		parsed_data TEXT,
+		risk_score REAL,
+		processing_status TEXT NOT NULL DEFAULT 'queued',
+		processing_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME,
+		updated_at DATETIME
+	)`,
+	`CREATE TABLE document_pages (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+		document_id TEXT REFERENCES documents(id) ON DELETE CASCADE,
+		page_number INTEGER,
+		text TEXT,
+		confidence REAL,
+		start_offset INTEGER,
+		end_offset INTEGER,
+		created_at DATETIME
+	)`,
+	`CREATE TABLE compliance_rules (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+		name TEXT NOT NULL,
+		description TEXT,
+		type TEXT NOT NULL DEFAULT 'llm_judged',
+		params TEXT,
+		pattern TEXT,
+		severity TEXT,
+		scope TEXT,
+		created_at DATETIME,
+		updated_at DATETIME,
+		archived_at DATETIME
+	)`,
+	`CREATE TABLE compliance_rule_versions (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+		rule_id TEXT NOT NULL REFERENCES compliance_rules(id) ON DELETE CASCADE,
+		version INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		description TEXT,
+		type TEXT NOT NULL DEFAULT 'llm_judged',
+		params TEXT,
+		pattern TEXT,
+		severity TEXT,
+		scope TEXT,
+		effective_from DATETIME NOT NULL,
+		created_at DATETIME,
+		UNIQUE (rule_id, version)
+	)`,
+	`CREATE TABLE compliance_rule_test_cases (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+		rule_id TEXT NOT NULL REFERENCES compliance_rules(id) ON DELETE CASCADE,
+		name TEXT,
+		text TEXT NOT NULL,
+		expect TEXT NOT NULL,
+		created_at DATETIME
+	)`,
+	`CREATE TABLE document_rule_results (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+		document_id TEXT REFERENCES documents(id) ON DELETE CASCADE,
+		rule_id TEXT REFERENCES compliance_rules(id) ON DELETE CASCADE,
+		rule_version_id TEXT REFERENCES compliance_rule_versions(id),
+		status TEXT,
+		details TEXT,
+		created_at DATETIME
+	)`,
+	`CREATE TABLE action_items (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+		document_id TEXT REFERENCES documents(id) ON DELETE CASCADE,
+		rule_id TEXT REFERENCES compliance_rules(id) ON DELETE CASCADE,
+		description TEXT NOT NULL,
+		assigned_to TEXT,
+		status TEXT,
+		priority TEXT,
+		due_date DATETIME,
+		created_at DATETIME,
+		updated_at DATETIME
+	)`,
+}
+
+// newTestDB opens a fresh SQLite database with the application schema
+func newTestDB(t *testing.T) *gorm.DB {
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_foreign_keys=on"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	for _, stmt := range testSchema {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}